@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Режимы отображения портала.
+const (
+	modeStandard   = "standard"
+	modeAccessible = "accessible"
+)
+
+// modeCookie хранит выбранный посетителем режим между запросами.
+const modeCookie = "portal_mode"
+
+// renderMode определяет режим отображения: параметр ?mode= имеет приоритет
+// и запоминается в cookie, иначе используется значение из cookie.
+func renderMode(w http.ResponseWriter, r *http.Request) string {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case modeStandard, modeAccessible:
+		http.SetCookie(w, &http.Cookie{
+			Name:     modeCookie,
+			Value:    mode,
+			Path:     "/",
+			MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return mode
+	}
+
+	if c, err := r.Cookie(modeCookie); err == nil && c.Value == modeAccessible {
+		return modeAccessible
+	}
+	return modeStandard
+}
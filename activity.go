@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Активность клиентов: время последнего визита обновляется при каждом
+// новом заказе и отметкой POST /clients/{id}/visit (например, при
+// предъявлении карты без заказа). Отчет GET /clients/inactive?days=N
+// перечисляет клиентов, которые не появлялись N дней, — для кампаний по
+// возвращению. Клиенты без визитов считаются неактивными со дня
+// регистрации.
+
+var (
+	lastSeen   = make(map[int]time.Time) // Время последнего визита по ID клиента
+	lastSeenMu sync.Mutex                // Мьютекс для защиты визитов; берется после clientsMu и ordersMu
+)
+
+// defaultInactiveDays — порог отчета, если ?days= не задан.
+const defaultInactiveDays = 30
+
+func activityPath() string {
+	return filepath.Join(config.DataDir, "activity.json")
+}
+
+// loadActivity читает время последних визитов.
+func loadActivity() error {
+	data, err := os.ReadFile(activityPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lastSeenMu.Lock()
+	defer lastSeenMu.Unlock()
+	if err := json.Unmarshal(data, &lastSeen); err != nil {
+		return fmt.Errorf("разбор %s: %w", activityPath(), err)
+	}
+	return nil
+}
+
+// recordVisitLocked отмечает визит клиента id во время at; более раннее
+// время последний визит не сдвигает. Вызывается под lastSeenMu.
+func recordVisitLocked(id int, at time.Time) (time.Time, error) {
+	prev, ok := lastSeen[id]
+	if ok && !at.After(prev) {
+		return prev, nil
+	}
+	lastSeen[id] = at
+	if err := writeJSONFile(activityPath(), lastSeen); err != nil {
+		if ok {
+			lastSeen[id] = prev
+		} else {
+			delete(lastSeen, id)
+		}
+		return prev, err
+	}
+	return at, nil
+}
+
+// activityOnOrderCreated отмечает визит клиента нового заказа. Ошибку
+// записи только сообщает в лог, чтобы не отменять заказ.
+func activityOnOrderCreated(o Order) {
+	lastSeenMu.Lock()
+	defer lastSeenMu.Unlock()
+	if _, err := recordVisitLocked(o.ClientID, o.CreatedAt); err != nil {
+		logError("Ошибка сохранения визита клиента %d: %v", o.ClientID, err)
+	}
+}
+
+// clientLastSeen возвращает время последнего визита или nil.
+func clientLastSeen(id int) *time.Time {
+	lastSeenMu.Lock()
+	defer lastSeenMu.Unlock()
+	if t, ok := lastSeen[id]; ok {
+		return &t
+	}
+	return nil
+}
+
+// mergeActivityLocked переносит визиты source к target при слиянии.
+// Вызывается под lastSeenMu.
+func mergeActivityLocked(target, source int) {
+	t, ok := lastSeen[source]
+	if !ok {
+		return
+	}
+	delete(lastSeen, source)
+	if t.After(lastSeen[target]) {
+		lastSeen[target] = t
+	}
+	if err := writeJSONFile(activityPath(), lastSeen); err != nil {
+		logError("Ошибка сохранения визитов: %v", err)
+	}
+}
+
+// activityOnClientEvent удаляет визиты окончательно удаленного клиента.
+func activityOnClientEvent(e clientEvent) {
+	if e.Type != eventClientPurged {
+		return
+	}
+	lastSeenMu.Lock()
+	defer lastSeenMu.Unlock()
+	if _, ok := lastSeen[e.Client.ID]; !ok {
+		return
+	}
+	delete(lastSeen, e.Client.ID)
+	if err := writeJSONFile(activityPath(), lastSeen); err != nil {
+		logError("Ошибка сохранения визитов: %v", err)
+	}
+}
+
+// clientVisit — ответ POST /clients/{id}/visit.
+type clientVisit struct {
+	ClientID int       `json:"clientId"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// visitHandler отмечает визит клиента без заказа: POST /clients/{id}/visit.
+func visitHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+
+	// clientsMu держится до записи, чтобы клиента не удалили между проверкой
+	// и сохранением.
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, exists := tenantClientLocked(requestTenant(r), id); !exists || c.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	lastSeenMu.Lock()
+	at, err := recordVisitLocked(id, time.Now())
+	lastSeenMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clientVisit{ClientID: id, LastSeen: at})
+}
+
+// inactiveClient — строка отчета о неактивных клиентах.
+type inactiveClient struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email,omitempty"`
+	FavCoffee    string     `json:"favCoffee,omitempty"`
+	LastSeen     *time.Time `json:"lastSeen,omitempty"` // нет — визитов не было
+	RegisterDate time.Time  `json:"registerDate"`
+	// InactiveDays — дней с последнего визита или регистрации; нет, если
+	// не известно ни то ни другое.
+	InactiveDays int `json:"inactiveDays,omitempty"`
+}
+
+// inactiveClientsHandler перечисляет клиентов без визитов за последние
+// ?days= дней, дольше всех отсутствующих первыми: GET /clients/inactive.
+func inactiveClientsHandler(w http.ResponseWriter, r *http.Request) {
+	days := defaultInactiveDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 3650 {
+			http.Error(w, "days: ожидается число от 1 до 3650", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+
+	list := filterClients(clientFilter{Tenant: requestTenant(r)})
+	out := []inactiveClient{}
+	since := make(map[int]time.Time, len(list))
+	lastSeenMu.Lock()
+	for _, c := range list {
+		seen, ok := lastSeen[c.ID]
+		t := c.RegisterDate
+		if ok {
+			t = seen
+		}
+		if !t.Before(cutoff) {
+			continue
+		}
+		row := inactiveClient{ID: c.ID, Name: c.Name, Email: c.Email, FavCoffee: c.FavCoffee, RegisterDate: c.RegisterDate}
+		if ok {
+			row.LastSeen = &seen
+		}
+		if !t.IsZero() {
+			row.InactiveDays = int(now.Sub(t) / (24 * time.Hour))
+		}
+		since[c.ID] = t
+		out = append(out, row)
+	}
+	lastSeenMu.Unlock()
+
+	sort.SliceStable(out, func(i, j int) bool { return since[out[i].ID].Before(since[out[j].ID]) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Адреса клиента. У клиента может быть несколько адресов с типом — home,
+// work и т. п.; тип у клиента не повторяется и служит ключом подресурса
+// /clients/{id}/addresses/{type}. Первый адрес — основной: его копия лежит
+// в Client.Address, по нему фильтруют по городу и ищут дубли, и его же
+// видят клиенты API, которые еще не знают поля addresses.
+
+// addressHome — тип адреса по умолчанию.
+const addressHome = "home"
+
+const (
+	maxAddresses         = 5  // адресов у одного клиента
+	maxAddressTypeLength = 32 // символов в типе адреса
+)
+
+// GeoPoint — координаты адреса, см. geocode.go.
+type GeoPoint struct {
+	Lat float64 `json:"lat" xml:"lat"`
+	Lon float64 `json:"lon" xml:"lon"`
+}
+
+// sameAddress сообщает, что a и b — один и тот же адрес без учета типа и
+// координат.
+func sameAddress(a, b Address) bool {
+	return a.City == b.City && a.Street == b.Street
+}
+
+// normalizeAddresses проверяет адреса из запроса: тип приводится к нижнему
+// регистру, без типа адрес считается домашним. nil остается nil: при
+// изменении это значит «адреса не менять, кроме основного из address».
+func normalizeAddresses(list []Address) ([]Address, error) {
+	if list == nil {
+		return nil, nil
+	}
+	if len(list) > maxAddresses {
+		return nil, fmt.Errorf("У клиента может быть не больше %d адресов", maxAddresses)
+	}
+	out := make([]Address, 0, len(list))
+	for _, a := range list {
+		a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+		if a.Type == "" {
+			a.Type = addressHome
+		}
+		a.City, a.Street = strings.TrimSpace(a.City), strings.TrimSpace(a.Street)
+		if a.City == "" && a.Street == "" {
+			return nil, fmt.Errorf("Адрес %s: укажите город или улицу", a.Type)
+		}
+		if err := checkAddressType(a.Type); err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(out, func(b Address) bool { return b.Type == a.Type }) {
+			return nil, fmt.Errorf("Адрес с типом %q указан дважды", a.Type)
+		}
+		if p := a.Location; p != nil && (p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180) {
+			return nil, errors.New("Координаты вне диапазона: lat от -90 до 90, lon от -180 до 180")
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// checkAddressType допускает в типе адреса буквы, цифры, дефис и
+// подчеркивание, как в метках.
+func checkAddressType(t string) error {
+	if utf8.RuneCountInString(t) > maxAddressTypeLength {
+		return fmt.Errorf("Неверный тип адреса %q: до %d символов", t, maxAddressTypeLength)
+	}
+	for _, r := range t {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return fmt.Errorf("Неверный тип адреса %q: допустимы буквы, цифры, дефис и подчеркивание", t)
+		}
+	}
+	return nil
+}
+
+// syncAddresses согласует Address и Addresses перед записью клиента; cur —
+// прежняя запись при изменении, nil при создании. Если addresses не
+// передан, основным адресом становится address: так старые клиенты API
+// меняют основной адрес, не стирая остальные. Координаты сохраняются у
+// адресов, которые не изменились.
+func syncAddresses(c *Client, cur *Client) {
+	primary := Address{City: c.Address.City, Street: c.Address.Street}
+	switch {
+	case c.Addresses != nil:
+	case cur != nil && len(cur.Addresses) > 0:
+		c.Addresses = slices.Clone(cur.Addresses)
+		switch {
+		case primary == (Address{}):
+			c.Addresses = c.Addresses[1:]
+		case !sameAddress(c.Addresses[0], primary):
+			c.Addresses[0].City, c.Addresses[0].Street = primary.City, primary.Street
+			c.Addresses[0].Location = c.Address.Location
+		}
+	case primary != (Address{}):
+		a := c.Address
+		if a.Type == "" {
+			a.Type = addressHome
+		}
+		c.Addresses = []Address{a}
+	}
+	if cur != nil {
+		for i, a := range c.Addresses {
+			if a.Location != nil {
+				continue
+			}
+			for _, old := range cur.Addresses {
+				if old.Type == a.Type && sameAddress(old, a) {
+					c.Addresses[i].Location = old.Location
+				}
+			}
+		}
+	}
+	if len(c.Addresses) == 0 {
+		c.Addresses, c.Address = nil, Address{}
+		return
+	}
+	c.Address = c.Addresses[0]
+}
+
+// clientAddressesHandler возвращает адреса клиента, основной первым:
+// GET /clients/{id}/addresses.
+func clientAddressesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	clientsMu.Lock()
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	clientsMu.Unlock()
+	if !exists || c.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	list := c.Addresses
+	if list == nil {
+		list = []Address{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// putAddressHandler добавляет или заменяет адрес с типом из пути:
+// PUT /clients/{id}/addresses/{type}. Новый адрес добавляется последним;
+// первый адрес клиента становится основным.
+func putAddressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	var a Address
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	typ := strings.ToLower(r.PathValue("type"))
+	if a.Type != "" && strings.ToLower(a.Type) != typ {
+		http.Error(w, "type в теле не совпадает с типом в адресе", http.StatusBadRequest)
+		return
+	}
+	a.Type = typ
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	cur, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists || cur.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	list := slices.Clone(cur.Addresses)
+	status := http.StatusOK
+	if i := slices.IndexFunc(list, func(b Address) bool { return b.Type == typ }); i >= 0 {
+		list[i] = a
+	} else {
+		list = append(list, a)
+		status = http.StatusCreated
+	}
+	if list, err = normalizeAddresses(list); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	c := cur
+	c.Addresses = list
+	syncAddresses(&c, &cur)
+	c.Version++
+	clients[id] = c
+	publishClientEvent(eventClientUpdated, c, sourceAPI)
+
+	i := slices.IndexFunc(c.Addresses, func(b Address) bool { return b.Type == typ })
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c.Addresses[i])
+}
+
+// deleteAddressHandler удаляет адрес: DELETE /clients/{id}/addresses/{type}.
+// Если удален основной, основным становится следующий.
+func deleteAddressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	typ := strings.ToLower(r.PathValue("type"))
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	cur, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists || cur.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	i := slices.IndexFunc(cur.Addresses, func(b Address) bool { return b.Type == typ })
+	if i < 0 {
+		http.Error(w, "Адрес не найден", http.StatusNotFound)
+		return
+	}
+	c := cur
+	c.Addresses = slices.Delete(slices.Clone(cur.Addresses), i, i+1)
+	syncAddresses(&c, &cur)
+	c.Version++
+	clients[id] = c
+	publishClientEvent(eventClientUpdated, c, sourceAPI)
+	w.WriteHeader(http.StatusNoContent)
+}
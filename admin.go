@@ -0,0 +1,469 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminCookie хранит JWT пользователя, вошедшего в раздел /admin/. Cookie
+// недоступна скриптам и не отправляется с чужих сайтов (SameSite=Strict);
+// формы дополнительно проверяют заголовок Origin.
+const adminCookie = "admin_token"
+
+// adminPageSize — клиентов на одной странице списка.
+const adminPageSize = 20
+
+// adminNotices — сообщения после успешных действий, передаются в ?done=.
+var adminNotices = map[string]string{
+	"created": "Клиент добавлен",
+	"updated": "Изменения сохранены",
+	"deleted": "Клиент удален",
+}
+
+// adminColumns — столбцы списка в порядке вывода; все сортируемые.
+var adminColumns = []struct {
+	Key   string
+	Label string
+	Cmp   func(a, b Client) int
+}{
+	{"id", "ID", func(a, b Client) int { return cmp.Compare(a.ID, b.ID) }},
+	{"name", "Имя", func(a, b Client) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) }},
+	{"age", "Возраст", func(a, b Client) int { return cmp.Compare(a.currentAge(), b.currentAge()) }},
+	{"favCoffee", "Любимый кофе", func(a, b Client) int { return strings.Compare(a.FavCoffee, b.FavCoffee) }},
+	{"city", "Город", func(a, b Client) int { return strings.Compare(a.Address.City, b.Address.City) }},
+	{"registerDate", "Дата регистрации", func(a, b Client) int { return a.RegisterDate.Compare(b.RegisterDate) }},
+}
+
+// adminColumn — заголовок столбца со ссылкой на сортировку по нему.
+type adminColumn struct {
+	Label  string
+	URL    string
+	Active bool
+	Desc   bool
+}
+
+type adminLoginPage struct {
+	Username string
+	Next     string
+	NeedOTP  bool
+	Error    string
+}
+
+type adminListPage struct {
+	User    principal
+	Notice  string
+	Error   string
+	Name    string
+	Columns []adminColumn
+	Clients []Client
+	Total   int
+	Page    int
+	Pages   int
+	PrevURL string
+	NextURL string
+
+	CanEdit   bool
+	CanDelete bool
+}
+
+type adminFormPage struct {
+	User   principal
+	Client Client
+	New    bool
+	Error  string
+
+	CanDelete bool
+}
+
+// adminPrincipal определяет пользователя по cookie входа. Токен только для
+// настройки 2FA сюда не выдается и не принимается.
+func adminPrincipal(r *http.Request) (principal, bool) {
+	c, err := r.Cookie(adminCookie)
+	if err != nil {
+		return principal{}, false
+	}
+	claims, err := parseJWT(c.Value, jwtSecret)
+	if err != nil || claims.Scope != "" {
+		return principal{}, false
+	}
+	return principal{Kind: principalUser, Name: claims.Subject, Role: claims.Role, Tenant: claims.Tenant}, true
+}
+
+// sameOrigin отклоняет формы, отправленные с другого сайта.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// requireAdminUI пропускает в раздел /admin/ вошедших пользователей с ролью
+// не ниже need; остальных отправляет на форму входа.
+func requireAdminUI(need Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := adminPrincipal(r)
+		if !ok {
+			target := "/admin/login"
+			if r.Method == http.MethodGet {
+				target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+			}
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+		if r.Method != http.MethodGet && !sameOrigin(r) {
+			http.Error(w, "Запрос с чужого сайта отклонен", http.StatusForbidden)
+			return
+		}
+		if !p.Role.Allows(need) {
+			http.Error(w, "Недостаточно прав для выполнения операции: нужна роль "+string(need), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxPrincipal, p)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// adminNext оставляет для перенаправления после входа только адреса
+// внутри раздела, чтобы форма не уводила на чужой сайт.
+func adminNext(next string) string {
+	if !strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "/admin/login") {
+		return "/admin/"
+	}
+	return next
+}
+
+// adminLoginHandler — вход по логину, паролю и, если включена 2FA, коду
+// или коду восстановления.
+// Пользователь, которому 2FA обязательна, сначала настраивает ее через API.
+func adminLoginHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := adminLoginPage{Next: adminNext(r.FormValue("next"))}
+		switch r.Method {
+		case http.MethodGet:
+			renderAdmin(w, r, templates, http.StatusOK, "admin/login.html", page)
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "Запрос с чужого сайта отклонен", http.StatusForbidden)
+			return
+		}
+
+		page.Username = r.PostFormValue("username")
+		user, ok := findUser(page.Username, r.PostFormValue("password"))
+		if !ok {
+			page.Error = "Неверный логин или пароль"
+			renderAdmin(w, r, templates, http.StatusUnauthorized, "admin/login.html", page)
+			return
+		}
+		enrolled, err := checkSecondFactor(user.Username, r.PostFormValue("otp"), r.PostFormValue("recovery"))
+		var locked otpLockedError
+		switch {
+		case errors.As(err, &locked):
+			page.Error, page.NeedOTP = err.Error(), true
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+			renderAdmin(w, r, templates, http.StatusTooManyRequests, "admin/login.html", page)
+			return
+		case errors.Is(err, errOTPRequired), errors.Is(err, errOTPInvalid):
+			page.Error, page.NeedOTP = err.Error(), true
+			renderAdmin(w, r, templates, http.StatusUnauthorized, "admin/login.html", page)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case !enrolled && twoFactorRequired(user.Role):
+			page.Error = "Сначала настройте двухфакторную аутентификацию"
+			renderAdmin(w, r, templates, http.StatusForbidden, "admin/login.html", page)
+			return
+		}
+
+		token, expires, err := issueToken(user, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     adminCookie,
+			Value:    token,
+			Path:     "/admin/",
+			Expires:  expires,
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+	}
+}
+
+// adminLogoutHandler удаляет cookie входа.
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: adminCookie, Path: "/admin/", MaxAge: -1})
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// adminListHandler показывает список клиентов: фильтры — те же параметры,
+// что у GET /getClients, плюс sort, order=desc и page.
+func adminListHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		q := r.URL.Query()
+		page := adminListPage{
+			User:      p,
+			Notice:    adminNotices[q.Get("done")],
+			Name:      q.Get("name"),
+			CanEdit:   p.Role.Allows(RoleEditor),
+			CanDelete: p.Role.Allows(RoleAdmin),
+		}
+
+		filter, err := parseClientFilter(q)
+		if err != nil {
+			page.Error = err.Error()
+		}
+		filter.IncludeDeleted = false
+		filter.Tenant = requestTenant(r)
+		list := filterClients(filter)
+
+		sortKey, desc := q.Get("sort"), q.Get("order") == "desc"
+		for _, col := range adminColumns {
+			active := col.Key == sortKey || (sortKey == "" && col.Key == "id")
+			if active && (col.Key != "id" || desc) {
+				slices.SortStableFunc(list, func(a, b Client) int {
+					if desc {
+						return col.Cmp(b, a)
+					}
+					return col.Cmp(a, b)
+				})
+			}
+			order := "asc"
+			if active && !desc {
+				order = "desc"
+			}
+			page.Columns = append(page.Columns, adminColumn{
+				Label:  col.Label,
+				URL:    adminListURL(q, "sort", col.Key, "order", order, "page", ""),
+				Active: active,
+				Desc:   active && desc,
+			})
+		}
+
+		page.Total = len(list)
+		page.Pages = max(1, (len(list)+adminPageSize-1)/adminPageSize)
+		page.Page, _ = strconv.Atoi(q.Get("page"))
+		page.Page = min(max(page.Page, 1), page.Pages)
+		from := (page.Page - 1) * adminPageSize
+		page.Clients = list[from:min(from+adminPageSize, len(list))]
+		for i := range page.Clients {
+			page.Clients[i] = page.Clients[i].withCurrentAge()
+		}
+		if page.Page > 1 {
+			page.PrevURL = adminListURL(q, "page", strconv.Itoa(page.Page-1))
+		}
+		if page.Page < page.Pages {
+			page.NextURL = adminListURL(q, "page", strconv.Itoa(page.Page+1))
+		}
+
+		renderAdmin(w, r, templates, http.StatusOK, "admin/clients.html", page)
+	}
+}
+
+// adminListURL — адрес списка с измененными параметрами; пустое значение
+// убирает параметр.
+func adminListURL(q url.Values, pairs ...string) string {
+	q = maps.Clone(q)
+	q.Del("done")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			q.Del(pairs[i])
+		} else {
+			q.Set(pairs[i], pairs[i+1])
+		}
+	}
+	if len(q) == 0 {
+		return "/admin/"
+	}
+	return "/admin/?" + q.Encode()
+}
+
+// adminNewClientHandler показывает пустую форму со следующим свободным ID.
+func adminNewClientHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		clientsMu.Lock()
+		// ID общие для всех кофеен.
+		id := 1
+		for existing := range clients {
+			id = max(id, existing+1)
+		}
+		clientsMu.Unlock()
+
+		renderAdmin(w, r, templates, http.StatusOK, "admin/client.html", adminFormPage{
+			User:   p,
+			Client: Client{ID: id},
+			New:    true,
+		})
+	}
+}
+
+// adminCreateClientHandler добавляет клиента из формы.
+func adminCreateClientHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		c, err := clientFromForm(r)
+		page := adminFormPage{User: p, Client: c, New: true}
+		if err != nil {
+			page.Error = err.Error()
+			renderAdmin(w, r, templates, http.StatusBadRequest, "admin/client.html", page)
+			return
+		}
+		c.RegisterDate = time.Now().UTC()
+		c.Tenant = requestTenant(r)
+
+		clientsMu.Lock()
+		_, err = createClientLocked(c, sourceAdmin)
+		clientsMu.Unlock()
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errClientExists) {
+				status = http.StatusConflict
+			}
+			page.Error = err.Error()
+			renderAdmin(w, r, templates, status, "admin/client.html", page)
+			return
+		}
+		http.Redirect(w, r, "/admin/?done=created", http.StatusSeeOther)
+	}
+}
+
+// adminEditClientHandler показывает форму изменения клиента.
+func adminEditClientHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Неверный ID", http.StatusBadRequest)
+			return
+		}
+		clientsMu.Lock()
+		c, exists := tenantClientLocked(requestTenant(r), id)
+		clientsMu.Unlock()
+		if !exists || c.deleted() {
+			http.Error(w, "Клиент не найден", http.StatusNotFound)
+			return
+		}
+
+		renderAdmin(w, r, templates, http.StatusOK, "admin/client.html", adminFormPage{
+			User:      p,
+			Client:    c.withCurrentAge(),
+			CanDelete: p.Role.Allows(RoleAdmin),
+		})
+	}
+}
+
+// adminUpdateClientHandler сохраняет форму изменения. Версия из скрытого
+// поля защищает от перезаписи чужих изменений, как version в PUT.
+func adminUpdateClientHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Неверный ID", http.StatusBadRequest)
+			return
+		}
+		upd, err := clientFromForm(r)
+		upd.ID = id
+		page := adminFormPage{User: p, Client: upd, CanDelete: p.Role.Allows(RoleAdmin)}
+		if err != nil {
+			page.Error = err.Error()
+			renderAdmin(w, r, templates, http.StatusBadRequest, "admin/client.html", page)
+			return
+		}
+
+		clientsMu.Lock()
+		cur, exists := tenantClientLocked(requestTenant(r), id)
+		if exists && !cur.deleted() {
+			_, err = updateClientLocked(id, upd, sourceAdmin)
+		}
+		clientsMu.Unlock()
+		var conflict versionConflictError
+		switch {
+		case !exists || cur.deleted():
+			http.Error(w, "Клиент не найден", http.StatusNotFound)
+		case err != nil && !errors.As(err, &conflict):
+			page.Error = err.Error()
+			renderAdmin(w, r, templates, http.StatusBadRequest, "admin/client.html", page)
+		case err != nil:
+			page.Error = err.Error() + ". Откройте клиента заново, чтобы увидеть изменения."
+			renderAdmin(w, r, templates, http.StatusConflict, "admin/client.html", page)
+		default:
+			http.Redirect(w, r, "/admin/?done=updated", http.StatusSeeOther)
+		}
+	}
+}
+
+// adminDeleteClientHandler мягко удаляет клиента, как DELETE /deleteClient.
+func adminDeleteClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	clientsMu.Lock()
+	deleted := softDeleteLocked(requestTenant(r), id, time.Now(), sourceAdmin)
+	clientsMu.Unlock()
+	if !deleted {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/admin/?done=deleted", http.StatusSeeOther)
+}
+
+// clientFromForm читает поля формы клиента. Дата регистрации в форме не
+// меняется.
+func clientFromForm(r *http.Request) (Client, error) {
+	c := Client{
+		Name:      strings.TrimSpace(r.PostFormValue("name")),
+		FavCoffee: canonicalCoffee(strings.TrimSpace(r.PostFormValue("favCoffee"))),
+		Address: Address{
+			City:   strings.TrimSpace(r.PostFormValue("city")),
+			Street: strings.TrimSpace(r.PostFormValue("street")),
+		},
+		Email:     strings.TrimSpace(r.PostFormValue("email")),
+		BirthDate: strings.TrimSpace(r.PostFormValue("birthDate")),
+	}
+	for _, f := range []struct {
+		field string
+		dst   *int
+	}{{"id", &c.ID}, {"age", &c.Age}, {"version", &c.Version}} {
+		v := strings.TrimSpace(r.PostFormValue(f.field))
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, errors.New(f.field + ": ожидается целое число")
+		}
+		*f.dst = n
+	}
+	return c, nil
+}
+
+// renderAdmin отрисовывает страницу раздела с кодом ответа status.
+func renderAdmin(w http.ResponseWriter, r *http.Request, templates *templateManager, status int, name string, page any) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := templates.renderStatus(w, r, status, name, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyHeader — заголовок, в котором интеграции передают ключ.
+const apiKeyHeader = "X-API-Key"
+
+// APIKey описывает ключ доступа для интеграций. Сам ключ не хранится,
+// только его SHA-256.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	Tenant    string    `json:"tenant,omitempty"` // кофейня ключа; пусто — основная, админ-ключу все через X-Tenant-ID
+	CreatedAt time.Time `json:"createdAt"`
+	hash      [sha256.Size]byte
+}
+
+var (
+	apiKeys   = make(map[string]APIKey) // Ключи по ID
+	apiKeysMu sync.Mutex                // Мьютекс для защиты ключей
+)
+
+var errInvalidAPIKey = errors.New("Неверный API-ключ")
+
+// apiKeyRecord — ключ в файле: вместе с хешем, который в API не отдается.
+type apiKeyRecord struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+func apiKeysPath() string {
+	return filepath.Join(config.DataDir, "apikeys.json")
+}
+
+// loadAPIKeys читает ключи интеграций.
+func loadAPIKeys() error {
+	data, err := os.ReadFile(apiKeysPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []apiKeyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("разбор %s: %w", apiKeysPath(), err)
+	}
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	for _, rec := range records {
+		hash, err := hex.DecodeString(rec.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("разбор %s: неверный хеш ключа %s", apiKeysPath(), rec.ID)
+		}
+		k := rec.APIKey
+		copy(k.hash[:], hash)
+		apiKeys[k.ID] = k
+	}
+	return nil
+}
+
+// saveAPIKeysLocked записывает ключи на диск. Вызывается под apiKeysMu.
+func saveAPIKeysLocked() error {
+	records := make([]apiKeyRecord, 0, len(apiKeys))
+	for _, k := range apiKeys {
+		records = append(records, apiKeyRecord{APIKey: k, Hash: hex.EncodeToString(k.hash[:])})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return writeJSONFile(apiKeysPath(), records)
+}
+
+// newAPIKey создает ключ и возвращает его открытое значение вида "<id>.<secret>".
+// Открытое значение показывается один раз и больше нигде не сохраняется.
+func newAPIKey(name string, role Role, tenant string) (APIKey, string, error) {
+	id := randomHex(8)
+	secret := randomHex(24)
+	plain := id + "." + secret
+
+	k := APIKey{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Tenant:    tenant,
+		CreatedAt: time.Now(),
+		hash:      sha256.Sum256([]byte(plain)),
+	}
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	apiKeys[id] = k
+	if err := saveAPIKeysLocked(); err != nil {
+		// Несохраненный ключ перестал бы работать после перезапуска.
+		delete(apiKeys, id)
+		return APIKey{}, "", err
+	}
+	return k, plain, nil
+}
+
+// lookupAPIKey находит ключ по открытому значению.
+func lookupAPIKey(plain string) (APIKey, error) {
+	id, _, ok := strings.Cut(plain, ".")
+	if !ok {
+		return APIKey{}, errInvalidAPIKey
+	}
+
+	apiKeysMu.Lock()
+	k, exists := apiKeys[id]
+	apiKeysMu.Unlock()
+
+	hash := sha256.Sum256([]byte(plain))
+	if !exists || subtle.ConstantTimeCompare(hash[:], k.hash[:]) != 1 {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return k, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// apiKeysHandler управляет ключами: GET — список, POST — создание,
+// DELETE ?id= — отзыв.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		apiKeysMu.Lock()
+		list := make([]APIKey, 0, len(apiKeys))
+		for _, k := range apiKeys {
+			list = append(list, k)
+		}
+		apiKeysMu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req struct {
+			Name   string `json:"name"`
+			Role   Role   `json:"role"`
+			Tenant string `json:"tenant"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "Не указано имя ключа", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = RoleViewer
+		} else if !req.Role.Valid() {
+			http.Error(w, "Неизвестная роль", http.StatusBadRequest)
+			return
+		}
+
+		if !tenantExists(req.Tenant) {
+			http.Error(w, errTenantUnknown.Error(), http.StatusBadRequest)
+			return
+		}
+
+		k, plain, err := newAPIKey(req.Name, req.Role, req.Tenant)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			APIKey
+			Key string `json:"key"`
+		}{k, plain})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Неверный или отсутствующий ID", http.StatusBadRequest)
+			return
+		}
+
+		apiKeysMu.Lock()
+		defer apiKeysMu.Unlock()
+
+		k, exists := apiKeys[id]
+		if !exists {
+			http.Error(w, "Ключ не найден", http.StatusNotFound)
+			return
+		}
+		delete(apiKeys, id)
+		if err := saveAPIKeysLocked(); err != nil {
+			// Иначе отозванный ключ снова заработал бы после перезапуска.
+			apiKeys[id] = k
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		forgetKeyUsage(id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
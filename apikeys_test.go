@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// TestAPIKeysPersist проверяет, что созданные ключи работают после
+// перезапуска, а отозванные — нет.
+func TestAPIKeysPersist(t *testing.T) {
+	setupTest(t)
+	h := http.HandlerFunc(apiKeysHandler)
+
+	create := func(name string) (id, key string) {
+		t.Helper()
+		w := testRequest(h, http.MethodPost, "/admin/keys", "", `{"name":"`+name+`","role":"editor"}`, nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("создание %s: статус %d: %s", name, w.Code, w.Body)
+		}
+		var resp struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.ID, resp.Key
+	}
+	_, pos := create("pos")
+	crmID, crm := create("crm")
+	if w := testRequest(h, http.MethodDelete, "/admin/keys?id="+crmID, "", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("отзыв: статус %d: %s", w.Code, w.Body)
+	}
+
+	apiKeys = make(map[string]APIKey)
+	if err := loadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	k, err := lookupAPIKey(pos)
+	if err != nil {
+		t.Fatalf("ключ pos после перезапуска: %v", err)
+	}
+	if k.Name != "pos" || k.Role != RoleEditor {
+		t.Errorf("ключ после перезапуска %+v", k)
+	}
+	if _, err := lookupAPIKey(crm); !errors.Is(err, errInvalidAPIKey) {
+		t.Errorf("отозванный ключ после перезапуска: %v", err)
+	}
+}
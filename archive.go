@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Архив окончательно удаленных клиентов — корзина на случай ошибочного
+// удаления, пока мягкое удаление принято не везде. При каждом purge (вручную,
+// по сроку хранения, при слиянии) запись клиента целиком дописывается в
+// archive.jsonl.gz в dataDir до удаления из хранилища; если записать не
+// удалось, клиент не удаляется. Каждая запись — свой член gzip со строкой
+// JSON, так что файл не переписывается при добавлении.
+// Поля с персональными данными шифруются так же, как в снимках хранилища,
+// поэтому ключ, которым зашифрованы записи архива, нельзя убирать, пока они
+// хранятся.
+//
+// GET /admin/archive ищет по архиву, POST /admin/archive/{id}/restore
+// возвращает клиента в хранилище в том виде, в каком его удалили, с
+// источником sourceRestore: цепочка онбординга и уведомления о новом
+// клиенте на него не срабатывают. DELETE
+// /admin/archive/{id} удаляет запись. Заказы, баллы, заметки и аватар
+// стираются вместе с клиентом и в архив не попадают. Записи старше
+// keepDays удаляет задача prune-archive, обезличивание клиента удаляет и
+// его записи.
+
+// ArchiveConfig задает архив окончательно удаленных клиентов.
+type ArchiveConfig struct {
+	Enabled  bool `json:"enabled"`
+	KeepDays int  `json:"keepDays"` // сколько хранить записи; 0 — без срока
+}
+
+func (c ArchiveConfig) validate() error {
+	if c.KeepDays < 0 {
+		return errors.New("archive.keepDays не может быть отрицательным")
+	}
+	return nil
+}
+
+// archivedClient — запись архива.
+type archivedClient struct {
+	ID         string    `json:"id"`
+	Client     Client    `json:"client"` // как перед удалением; в файле — с зашифрованными полями
+	Source     string    `json:"source"` // кто удалил, см. events.go
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+var archiveMu sync.Mutex // Мьютекс для защиты файла архива; берется после clientsMu
+
+func archivePath() string {
+	return filepath.Join(config.DataDir, "archive.jsonl.gz")
+}
+
+// archiveClientLocked дописывает клиента в архив перед окончательным
+// удалением; source — кто удаляет. Ошибка означает, что клиента удалять
+// нельзя. Без archive.enabled и на реплике ничего не делает. Вызывается
+// под clientsMu.
+func archiveClientLocked(c Client, source string) error {
+	if !config.Archive.Enabled || config.Replica.enabled() {
+		return nil
+	}
+	sealed, err := sealClient(c)
+	if err != nil {
+		return fmt.Errorf("архивирование клиента %d: %w", c.ID, err)
+	}
+	entry := archivedClient{ID: randomHex(8), Client: sealed, Source: source, ArchivedAt: time.Now()}
+	if err := appendArchive(entry); err != nil {
+		return fmt.Errorf("архивирование клиента %d: %w", c.ID, err)
+	}
+	return nil
+}
+
+// appendArchive дописывает запись в конец архива. Недописанная запись
+// обрезается, чтобы не испортить следующие.
+func appendArchive(entry archivedClient) error {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if err := os.MkdirAll(config.DataDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(archivePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	err = json.NewEncoder(zw).Encode(entry)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(st.Size())
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readArchiveLocked читает все записи архива, старые первыми; поля
+// остаются зашифрованными. Вызывается под archiveMu.
+func readArchiveLocked() ([]archivedClient, error) {
+	f, err := os.Open(archivePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("разбор %s: %w", archivePath(), err)
+	}
+	var list []archivedClient
+	dec := json.NewDecoder(zr)
+	for {
+		var entry archivedClient
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return list, nil
+		}
+		if err != nil {
+			return list, fmt.Errorf("разбор %s: %w", archivePath(), err)
+		}
+		list = append(list, entry)
+	}
+}
+
+// writeArchiveLocked заменяет архив записями list. Вызывается под archiveMu.
+func writeArchiveLocked(list []archivedClient) error {
+	if len(list) == 0 {
+		err := os.Remove(archivePath())
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	tmp := archivePath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, entry := range list {
+		if err = enc.Encode(entry); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, archivePath())
+}
+
+// removeArchivedLocked удаляет из архива записи, для которых drop
+// возвращает true, и возвращает их число. Вызывается под archiveMu.
+func removeArchivedLocked(drop func(archivedClient) bool) (int, error) {
+	list, err := readArchiveLocked()
+	if err != nil {
+		return 0, err
+	}
+	kept := slices.DeleteFunc(slices.Clone(list), drop)
+	if len(kept) == len(list) {
+		return 0, nil
+	}
+	return len(list) - len(kept), writeArchiveLocked(kept)
+}
+
+// dropArchivedClient удаляет записи архива клиента id при обезличивании.
+func dropArchivedClient(id int) int {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	n, err := removeArchivedLocked(func(e archivedClient) bool { return e.Client.ID == id })
+	if err != nil {
+		logError("Ошибка удаления клиента %d из архива: %v", id, err)
+	}
+	return n
+}
+
+// pruneArchiveJob удаляет записи архива старше archive.keepDays;
+// регистрируется, только если archive.enabled.
+var pruneArchiveJob = &scheduledJob{
+	Name:     "prune-archive",
+	Schedule: "0 5 * * *",
+	Run: func(ctx context.Context) error {
+		days := config.Archive.KeepDays
+		if days == 0 {
+			return nil
+		}
+		cutoff := time.Now().AddDate(0, 0, -days)
+		archiveMu.Lock()
+		defer archiveMu.Unlock()
+		n, err := removeArchivedLocked(func(e archivedClient) bool { return e.ArchivedAt.Before(cutoff) })
+		if n > 0 {
+			logf("Из архива удалено записей старше %d дн.: %d", days, n)
+		}
+		return err
+	},
+}
+
+// archiveHandler ищет по архиву: GET /admin/archive. Параметры: q —
+// подстрока имени или email без учета регистра, clientId, tenant. Новые
+// записи первыми.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	q := strings.ToLower(query.Get("q"))
+	clientID := 0
+	if v := query.Get("clientId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "clientId: ожидается число", http.StatusBadRequest)
+			return
+		}
+		clientID = id
+	}
+	tenant := query.Get("tenant")
+
+	archiveMu.Lock()
+	list, err := readArchiveLocked()
+	archiveMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := []archivedClient{}
+	for _, entry := range slices.Backward(list) {
+		c, err := openClient(entry.Client)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if clientID != 0 && c.ID != clientID || tenant != "" && c.Tenant != tenant {
+			continue
+		}
+		if q != "" && !strings.Contains(strings.ToLower(c.Name), q) && !strings.Contains(strings.ToLower(c.Email), q) {
+			continue
+		}
+		entry.Client = c
+		out = append(out, entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// restoreArchivedHandler возвращает клиента из архива в хранилище:
+// POST /admin/archive/{id}/restore. Мягко удаленный перед удалением клиент
+// остается мягко удаленным, его восстанавливает POST /clients/{id}/restore.
+// Запись из архива удаляется.
+func restoreArchivedHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	list, err := readArchiveLocked()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(list, func(e archivedClient) bool { return e.ID == id })
+	if i < 0 {
+		http.Error(w, "Запись архива не найдена", http.StatusNotFound)
+		return
+	}
+	c, err := openClient(list[i].Client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, exists := clients[c.ID]; exists {
+		http.Error(w, errClientExists.Error(), http.StatusConflict)
+		return
+	}
+	if !tenantExists(c.Tenant) {
+		http.Error(w, errTenantUnknown.Error(), http.StatusConflict)
+		return
+	}
+	if err := writeArchiveLocked(slices.Delete(list, i, i+1)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Version++
+	clients[c.ID] = c
+	publishClientEvent(eventClientCreated, c, sourceRestore)
+	writeResponse(w, r, http.StatusCreated, c)
+}
+
+// deleteArchivedHandler удаляет запись архива: DELETE /admin/archive/{id}.
+func deleteArchivedHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	n, err := removeArchivedLocked(func(e archivedClient) bool { return e.ID == id })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Запись архива не найдена", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
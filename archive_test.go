@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestArchiveRestore проверяет, что клиент попадает в архив при purge и
+// возвращается из него без повторной цепочки онбординга.
+func TestArchiveRestore(t *testing.T) {
+	setupTest(t)
+	config.Archive.Enabled = true
+	config.Onboarding = defaultOnboarding()
+	config.Onboarding.Enabled = true
+	var events []clientEvent
+	prev := clientSubscribers
+	clientSubscribers = []clientSubscriber{onboardingOnClientEvent, func(e clientEvent) { events = append(events, e) }}
+	t.Cleanup(func() { clientSubscribers = prev })
+
+	deletedAt := time.Now().Add(-time.Hour)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 2, DeletedAt: &deletedAt}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /clients/{id}/purge", purgeClientHandler)
+	mux.HandleFunc("GET /admin/archive", archiveHandler)
+	mux.HandleFunc("POST /admin/archive/{id}/restore", restoreArchivedHandler)
+
+	if w := testRequest(mux, http.MethodDelete, "/clients/1/purge", "", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("purge: статус %d: %s", w.Code, w.Body)
+	}
+	if _, exists := clients[1]; exists {
+		t.Fatal("клиент не удален")
+	}
+
+	w := testRequest(mux, http.MethodGet, "/admin/archive?clientId=1", "", "", nil)
+	var list []archivedClient
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Client.Name != "Айгерим" || list[0].Source != sourceAPI {
+		t.Fatalf("архив %+v", list)
+	}
+
+	w = testRequest(mux, http.MethodPost, "/admin/archive/"+list[0].ID+"/restore", "", "", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("восстановление: статус %d: %s", w.Code, w.Body)
+	}
+	if c := clients[1]; c.Name != "Айгерим" || c.Version != 3 || !c.deleted() {
+		t.Errorf("восстановлен %+v", c)
+	}
+	if last := events[len(events)-1]; last.Type != eventClientCreated || last.Source != sourceRestore {
+		t.Errorf("событие %s от %s", last.Type, last.Source)
+	}
+	if _, enrolled := drips[1]; enrolled {
+		t.Error("восстановленный клиент снова попал в цепочку онбординга")
+	}
+	if w := testRequest(mux, http.MethodPost, "/admin/archive/"+list[0].ID+"/restore", "", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("повторное восстановление: статус %d", w.Code)
+	}
+}
+
+// TestPurgeKeepsClientWhenArchiveFails проверяет, что клиент не удаляется,
+// если его не удалось записать в архив.
+func TestPurgeKeepsClientWhenArchiveFails(t *testing.T) {
+	setupTest(t)
+	config.Archive.Enabled = true
+	// dataDir — обычный файл, поэтому архив не создать.
+	config.DataDir = filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(config.DataDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now().Add(-time.Hour)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 2, DeletedAt: &deletedAt}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /clients/{id}/purge", purgeClientHandler)
+
+	if w := testRequest(mux, http.MethodDelete, "/clients/1/purge", "", "", nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("статус %d: %s", w.Code, w.Body)
+	}
+	if _, exists := clients[1]; !exists {
+		t.Error("клиент удален без записи в архив")
+	}
+}
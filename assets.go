@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Статические файлы из static/ под /static/. В шаблонах ссылка строится
+// через {{asset "stylesheets/css.css"}} и получает отпечаток содержимого:
+// /static/stylesheets/css.css?v=<хеш>. Ответ на адрес с актуальным
+// отпечатком кэшируется браузером на год: после правки файла меняется и
+// адрес. Остальные запросы (картинки из CSS, старые отпечатки) кэшируются
+// с проверкой по ETag.
+
+const (
+	assetDir    = "static"
+	assetPrefix = "/static/"
+)
+
+// assetStamp — отпечаток файла и состояние файла, для которого он посчитан.
+type assetStamp struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+var (
+	assetStamps   = make(map[string]assetStamp) // Отпечатки по пути относительно static
+	assetStampsMu sync.Mutex                    // Мьютекс для защиты отпечатков
+)
+
+// assetHash возвращает отпечаток файла name из static. Отпечаток
+// пересчитывается, только если файл изменился.
+func assetHash(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !fs.ValidPath(name) {
+		return "", fs.ErrInvalid
+	}
+	file := assetDir + "/" + name
+	st, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if st.IsDir() {
+		return "", fs.ErrInvalid
+	}
+	assetStampsMu.Lock()
+	s, ok := assetStamps[name]
+	assetStampsMu.Unlock()
+	if ok && s.modTime.Equal(st.ModTime()) && s.size == st.Size() {
+		return s.hash, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	s = assetStamp{modTime: st.ModTime(), size: st.Size(), hash: hex.EncodeToString(h.Sum(nil))[:12]}
+	assetStampsMu.Lock()
+	assetStamps[name] = s
+	assetStampsMu.Unlock()
+	return s.hash, nil
+}
+
+// assetURL — адрес статического файла с отпечатком; функция шаблонов
+// asset. Если файла нет, адрес остается без отпечатка.
+func assetURL(name string) string {
+	u := assetPrefix + strings.TrimPrefix(name, "/")
+	if hash, err := assetHash(name); err == nil {
+		u += "?v=" + hash
+	}
+	return u
+}
+
+// staticHandler отдает файлы из static с заголовками кэширования.
+func staticHandler() http.Handler {
+	files := http.StripPrefix(assetPrefix, http.FileServer(http.Dir(assetDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash, err := assetHash(strings.TrimPrefix(r.URL.Path, assetPrefix))
+		if err != nil {
+			files.ServeHTTP(w, r) // каталоги и 404 — как раньше
+			return
+		}
+		// FileServer сам отвечает 304 по этому ETag.
+		w.Header().Set("ETag", `"`+hash+`"`)
+		if v := r.URL.Query().Get("v"); v == hash {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtHeader — единственный поддерживаемый заголовок токена (HS256).
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// jwtClaims — полезная нагрузка токена.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Scope     string `json:"scope,omitempty"`  // ограничение токена, например scope2FAEnroll
+	Tenant    string `json:"tenant,omitempty"` // кофейня, к которой привязан пользователь
+}
+
+type ctxKey int
+
+const ctxPrincipal ctxKey = iota
+
+// principal — тот, от чьего имени выполняется запрос.
+type principal struct {
+	Kind string // "user" или "apikey"
+	Name string
+	Role Role
+	// Scope копирует ограничение из JWT; пустое означает полный доступ.
+	Scope string
+	// Tenant — кофейня, к которой привязан отправитель; пустая — без привязки.
+	Tenant string
+}
+
+const (
+	principalUser   = "user"
+	principalAPIKey = "apikey"
+)
+
+// principalFrom возвращает аутентифицированного отправителя запроса.
+func principalFrom(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(ctxPrincipal).(principal)
+	return p, ok
+}
+
+var (
+	errTokenMalformed = errors.New("неверный формат токена")
+	errTokenSignature = errors.New("неверная подпись токена")
+	errTokenExpired   = errors.New("срок действия токена истек")
+)
+
+// jwtSecret — ключ подписи токенов, задается в main.
+var jwtSecret []byte
+
+// signJWT выпускает токен с указанными claims.
+func signJWT(claims jwtClaims, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned, secret), nil
+}
+
+// parseJWT проверяет подпись и срок действия токена.
+func parseJWT(token string, secret []byte) (jwtClaims, error) {
+	var claims jwtClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return claims, errTokenMalformed
+	}
+	expected := jwtSignature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return claims, errTokenSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errTokenMalformed
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errTokenMalformed
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errTokenExpired
+	}
+	return claims, nil
+}
+
+func jwtSignature(unsigned string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomSecret генерирует ключ подписи, если он не задан в конфигурации.
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// findUser ищет учетную запись по логину и паролю.
+func findUser(username, password string) (User, bool) {
+	for _, u := range config.Auth.Users {
+		if u.Username == username &&
+			subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1 {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// bearerToken извлекает токен из заголовка Authorization.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return h[len(prefix):], true
+}
+
+// errNoCredentials означает, что запрос не содержит ни JWT, ни API-ключа.
+var errNoCredentials = errors.New("Требуется авторизация")
+
+// authenticate определяет отправителя по JWT или API-ключу.
+func authenticate(r *http.Request) (principal, error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		k, err := lookupAPIKey(key)
+		if err != nil {
+			return principal{}, err
+		}
+		return principal{Kind: principalAPIKey, Name: k.Name, Role: k.Role, Tenant: k.Tenant}, nil
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		return principal{}, errNoCredentials
+	}
+	claims, err := parseJWT(token, jwtSecret)
+	if err != nil {
+		return principal{}, err
+	}
+	return principal{Kind: principalUser, Name: claims.Subject, Role: claims.Role, Scope: claims.Scope, Tenant: claims.Tenant}, nil
+}
+
+// requireAuth пропускает только запросы с действительным JWT или API-ключом.
+// Токен, выданный только для настройки 2FA, здесь не принимается.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(r)
+		if err != nil {
+			if errors.Is(err, errNoCredentials) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if p.Scope == scope2FAEnroll {
+			writeAPIError(w, r, http.StatusForbidden, apiError{
+				Error:   "2fa_enrollment_required",
+				Message: "Требуется настроить двухфакторную аутентификацию",
+				Role:    p.Role,
+			})
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxPrincipal, p)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// loginHandler выдает JWT по логину и паролю. Если у пользователя включена
+// 2FA, нужен также код otp или одноразовый recoveryCode. Пользователь, роли
+// которого 2FA обязательна, но который ее еще не настроил, получает токен,
+// годный только для /auth/2fa/*.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		OTP      string `json:"otp"`
+		Recovery string `json:"recoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := findUser(creds.Username, creds.Password)
+	if !ok {
+		http.Error(w, "Неверный логин или пароль", http.StatusUnauthorized)
+		return
+	}
+
+	enrolled, err := checkSecondFactor(user.Username, creds.OTP, creds.Recovery)
+	var locked otpLockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		writeAPIError(w, r, http.StatusTooManyRequests, apiError{Error: "otp_locked", Message: err.Error()})
+		return
+	case errors.Is(err, errOTPRequired):
+		writeAPIError(w, r, http.StatusUnauthorized, apiError{Error: "otp_required", Message: err.Error()})
+		return
+	case errors.Is(err, errOTPInvalid):
+		writeAPIError(w, r, http.StatusUnauthorized, apiError{Error: "otp_invalid", Message: err.Error()})
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var scope string
+	if !enrolled && twoFactorRequired(user.Role) {
+		scope = scope2FAEnroll
+	}
+
+	token, expires, err := issueToken(user, scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]any{
+		"token":     token,
+		"tokenType": "Bearer",
+		"expiresAt": expires,
+	}
+	if scope != "" {
+		resp["scope"] = scope
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// issueToken выпускает JWT пользователю на auth.tokenTTL.
+func issueToken(user User, scope string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(time.Duration(config.Auth.TokenTTL))
+	token, err := signJWT(jwtClaims{
+		Subject:   user.Username,
+		Role:      user.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+		Scope:     scope,
+		Tenant:    user.Tenant,
+	}, jwtSecret)
+	return token, expires, err
+}
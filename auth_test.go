@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+	sign := func(c jwtClaims) string {
+		token, err := signJWT(c, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(jwtClaims{Subject: "root", Role: RoleAdmin, ExpiresAt: now.Add(time.Hour).Unix()})
+	parts := strings.Split(valid, ".")
+	forged := sign(jwtClaims{Subject: "root", Role: "owner", ExpiresAt: now.Add(time.Hour).Unix()})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"действующий", valid, nil},
+		{"истек", sign(jwtClaims{Subject: "root", Role: RoleAdmin, ExpiresAt: now.Add(-time.Second).Unix()}), errTokenExpired},
+		{"чужой ключ", func() string {
+			token, _ := signJWT(jwtClaims{Subject: "root", Role: RoleAdmin, ExpiresAt: now.Add(time.Hour).Unix()}, []byte("other"))
+			return token
+		}(), errTokenSignature},
+		{"подмена роли", parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2], errTokenSignature},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + ".", errTokenMalformed},
+		{"две части", parts[0] + "." + parts[1], errTokenMalformed},
+		{"пустой", "", errTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := parseJWT(tt.token, secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "root" || claims.Role != RoleAdmin) {
+				t.Errorf("claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role, need Role
+		want       bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleEditor, false},
+		{RoleEditor, RoleViewer, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAdmin, RoleEditor, true},
+		{Role(""), RoleViewer, false},
+		{Role("owner"), RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.need); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, ожидалось %v", tt.role, tt.need, got, tt.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	setupTest(t)
+	h := requireRole(RoleEditor, func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		w.Write([]byte(p.Name))
+	})
+	enroll, err := signJWT(jwtClaims{Subject: "barista", Role: RoleEditor, Scope: scope2FAEnroll,
+		ExpiresAt: time.Now().Add(time.Hour).Unix()}, jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string // заголовок Authorization
+		want   int
+	}{
+		{"без токена", "", http.StatusUnauthorized},
+		{"не Bearer", "Basic cm9vdDpyb290", http.StatusUnauthorized},
+		{"неверный токен", "Bearer abc.def.ghi", http.StatusUnauthorized},
+		{"просмотр", "Bearer " + testToken(t, "guest", RoleViewer, ""), http.StatusForbidden},
+		{"редактор", "Bearer " + testToken(t, "barista", RoleEditor, ""), http.StatusOK},
+		{"администратор", "bearer " + testToken(t, "root", RoleAdmin, ""), http.StatusOK},
+		{"токен для настройки 2FA", "Bearer " + enroll, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["Authorization"] = tt.header
+			}
+			w := testRequest(h, http.MethodPost, "/addClient", "", "", headers)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 без WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	setupTest(t)
+	plain := "k1.secret"
+	apiKeys["k1"] = APIKey{ID: "k1", Name: "pos", Role: RoleEditor, Tenant: "north", hash: sha256.Sum256([]byte(plain))}
+
+	tests := []struct {
+		key     string
+		wantErr error
+	}{
+		{plain, nil},
+		{"k1.other", errInvalidAPIKey},
+		{"k2.secret", errInvalidAPIKey},
+		{"nodot", errInvalidAPIKey},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/getClients", nil)
+		r.Header.Set(apiKeyHeader, tt.key)
+		p, err := authenticate(r)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%q: ошибка %v, ожидалась %v", tt.key, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (p.Kind != principalAPIKey || p.Role != RoleEditor || p.Tenant != "north") {
+			t.Errorf("%q: отправитель %+v", tt.key, p)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // декодер для image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Ограничения загрузки аватара. Размер в пикселях проверяется до
+// декодирования, чтобы маленький файл не развернулся в гигабайты памяти.
+const (
+	maxAvatarSize   = 5 << 20
+	maxAvatarPixels = 25_000_000
+	avatarThumbSize = 256 // сторона миниатюры
+)
+
+// Принимаемые форматы; GIF сохраняется как PNG.
+var avatarTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+var errAvatarNotFound = errors.New("Аватар не найден")
+
+// avatarKey — ключ аватара клиента в хранилище файлов.
+func avatarKey(id int) string {
+	return "avatars/" + strconv.Itoa(id)
+}
+
+// activeClient сообщает, что клиент кофейни tenant есть и не удален.
+func activeClient(tenant string, id int) bool {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	c, exists := tenantClientLocked(tenant, id)
+	return exists && !c.deleted()
+}
+
+// uploadAvatarHandler принимает изображение в поле file формы
+// multipart/form-data и сохраняет его миниатюру.
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	if !activeClient(requestTenant(r), id) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+
+	// Запас сверх maxAvatarSize — на заголовки частей формы.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+64<<10)
+	data, err := readAvatarPart(r)
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig) || (err == nil && len(data) > maxAvatarSize):
+		http.Error(w, fmt.Sprintf("Файл больше %d МБ", maxAvatarSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := makeAvatar(data)
+	if err != nil {
+		status := http.StatusBadRequest
+		if !avatarTypes[http.DetectContentType(data)] {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := blobs.Put(r.Context(), avatarKey(id), a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/clients/"+strconv.Itoa(id)+"/avatar")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readAvatarPart читает поле file, но не больше maxAvatarSize+1 байт.
+func readAvatarPart(r *http.Request) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("Ожидается multipart/form-data с полем file")
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("Не передано поле file")
+		}
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				return nil, err
+			}
+			return nil, errors.New("Ошибка чтения формы")
+		}
+		if part.FormName() == "file" {
+			return io.ReadAll(io.LimitReader(part, maxAvatarSize+1))
+		}
+	}
+}
+
+// makeAvatar проверяет изображение и уменьшает его до миниатюры. JPEG
+// остается JPEG, остальное сохраняется в PNG, чтобы не потерять прозрачность.
+func makeAvatar(data []byte) (Blob, error) {
+	if !avatarTypes[http.DetectContentType(data)] {
+		return Blob{}, errors.New("Поддерживаются изображения JPEG, PNG и GIF")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Blob{}, errors.New("Не удалось прочитать изображение")
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		return Blob{}, fmt.Errorf("Изображение больше %d мегапикселей", maxAvatarPixels/1_000_000)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Blob{}, errors.New("Не удалось прочитать изображение")
+	}
+
+	thumb := thumbnail(img, avatarThumbSize)
+	var buf bytes.Buffer
+	a := Blob{ContentType: "image/png"}
+	if format == "jpeg" {
+		a.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	a.Data = buf.Bytes()
+	return a, err
+}
+
+// thumbnail вписывает изображение в квадрат size×size с сохранением
+// пропорций. Каждый пиксель миниатюры — среднее соответствующего
+// прямоугольника исходного изображения. Меньшие изображения не меняются.
+func thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := range th {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := range tw {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[rgba.PixOffset(x0, sy):rgba.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[o+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// getAvatarHandler отдает аватар клиента. Условные запросы и Range
+// обрабатывает http.ServeContent.
+func getAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	if !activeClient(requestTenant(r), id) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	a, err := blobs.Get(r.Context(), avatarKey(id))
+	if errors.Is(err, errBlobNotFound) {
+		http.Error(w, errAvatarNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(a.Data)
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", a.ModTime, bytes.NewReader(a.Data))
+}
+
+// avatarsOnClientEvent удаляет аватар вместе с окончательно удаленным
+// клиентом. Хранилище может быть сетевым, поэтому удаление идет вне clientsMu.
+func avatarsOnClientEvent(e clientEvent) {
+	if e.Type != eventClientPurged {
+		return
+	}
+	go func(id int) {
+		if err := blobs.Delete(context.Background(), avatarKey(id)); err != nil {
+			logError("Ошибка удаления аватара клиента %d: %v", id, err)
+		}
+	}(e.Client.ID)
+}
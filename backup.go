@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupFormatVersion увеличивается при несовместимых изменениях формата.
+const backupFormatVersion = 1
+
+// Backup — полный снимок хранилища клиентов.
+type Backup struct {
+	FormatVersion int       `json:"formatVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ModifiedAt    time.Time `json:"modifiedAt"` // последнее изменение хранилища на момент снимка
+	Count         int       `json:"count"`
+	Clients       []Client  `json:"clients"`
+}
+
+// restoreResult — итог восстановления.
+type restoreResult struct {
+	Mode      string `json:"mode"`
+	Restored  int    `json:"restored"`  // клиентов из снимка записано в хранилище
+	Replaced  int    `json:"replaced"`  // из них заменили существующих (merge)
+	Removed   int    `json:"removed"`   // удалено клиентов, которых нет в снимке (replace)
+	Remaining int    `json:"remaining"` // клиентов в хранилище после восстановления
+}
+
+// maxRestoreSize ограничивает размер загружаемого снимка.
+const maxRestoreSize = 100 << 20
+
+// takeBackup снимает копию хранилища.
+func takeBackup() Backup {
+	list := sortedClients()
+
+	clientsMu.Lock()
+	modified := clientsModified
+	clientsMu.Unlock()
+
+	return Backup{
+		FormatVersion: backupFormatVersion,
+		CreatedAt:     time.Now(),
+		ModifiedAt:    modified,
+		Count:         len(list),
+		Clients:       list,
+	}
+}
+
+// backupHandler отдает снимок хранилища файлом JSON.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b := takeBackup()
+	filename := "backup-" + b.CreatedAt.Format("20060102-150405") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	json.NewEncoder(w).Encode(b)
+}
+
+// restoreHandler восстанавливает хранилище из снимка. Режим задается
+// обязательным параметром ?mode=: replace заменяет хранилище целиком
+// (клиенты, которых нет в снимке, архивируются и удаляются окончательно),
+// merge добавляет клиентов из снимка, заменяя совпадающих по ID.
+// Снимок применяется целиком или не применяется вовсе.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	mode, ok := restoreMode(w, r)
+	if !ok {
+		return
+	}
+	b, err := parseBackup(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRestoreResult(w, b, mode)
+}
+
+// restoreMode читает обязательный параметр ?mode=.
+func restoreMode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mode := r.URL.Query().Get("mode")
+	if mode != "replace" && mode != "merge" {
+		http.Error(w, "Укажите ?mode=replace или ?mode=merge", http.StatusBadRequest)
+		return "", false
+	}
+	return mode, true
+}
+
+// parseBackup разбирает снимок, проверяет версию его формата и
+// расшифровывает поля клиентов, если снимок сохранен зашифрованным.
+func parseBackup(r io.Reader) (Backup, error) {
+	var b Backup
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return b, errors.New("Ошибка парсинга снимка")
+	}
+	if b.FormatVersion != backupFormatVersion {
+		return b, fmt.Errorf("Неподдерживаемая версия формата снимка: %d", b.FormatVersion)
+	}
+	for i, c := range b.Clients {
+		var err error
+		if b.Clients[i], err = openClient(c); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// writeRestoreResult применяет снимок и отвечает итогом восстановления.
+func writeRestoreResult(w http.ResponseWriter, b Backup, mode string) {
+	res, err := restoreBackup(b, mode)
+	if errors.Is(err, errRestoreArchive) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// errRestoreArchive — снимок не применен: клиентов, которых он удаляет,
+// не удалось записать в архив.
+var errRestoreArchive = errors.New("снимок не применен: ошибка архивирования удаляемых клиентов")
+
+// restoreBackup проверяет снимок и атомарно применяет его к хранилищу.
+func restoreBackup(b Backup, mode string) (restoreResult, error) {
+	res := restoreResult{Mode: mode}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	incoming := make(map[int]Client, len(b.Clients))
+	for i, c := range b.Clients {
+		// В снимках до появления addresses есть только address.
+		syncAddresses(&c, nil)
+		// Меню не проверяется: любимый кофе мог пропасть из меню после
+		// снимка, а без восстановления сервер не запустится.
+		if err := normalizeClient(&c); err != nil {
+			return res, fmt.Errorf("клиент #%d в снимке: %v", i, err)
+		}
+		c.FavCoffee = canonicalCoffee(c.FavCoffee)
+		if !tenantExists(c.Tenant) {
+			return res, fmt.Errorf("клиент #%d в снимке: %v", i, errTenantUnknown)
+		}
+		if _, dup := incoming[c.ID]; dup {
+			return res, fmt.Errorf("клиент #%d в снимке: повторяющийся ID %d", i, c.ID)
+		}
+		incoming[c.ID] = c
+	}
+
+	next := make(map[int]Client, len(clients)+len(incoming))
+	if mode == "merge" {
+		for id, c := range clients {
+			next[id] = c
+		}
+	}
+	for id, c := range incoming {
+		// Версия не должна уменьшаться, иначе старый If-Match снова станет действительным.
+		if cur, exists := clients[id]; exists {
+			c.Version = max(c.Version, cur.Version) + 1
+			if mode == "merge" {
+				res.Replaced++
+			}
+		} else if c.Version < 1 {
+			c.Version = 1
+		}
+		next[id] = c
+	}
+	var removed []Client
+	if mode == "replace" {
+		for id, c := range clients {
+			if _, kept := incoming[id]; !kept {
+				removed = append(removed, c)
+			}
+		}
+		sort.Slice(removed, func(i, j int) bool { return removed[i].ID < removed[j].ID })
+		for _, c := range removed {
+			if err := archiveClientLocked(c, sourceAdmin); err != nil {
+				return res, fmt.Errorf("%w: %v", errRestoreArchive, err)
+			}
+		}
+		res.Removed = len(removed)
+	}
+
+	clients = next
+	for _, c := range removed {
+		publishClientEvent(eventClientPurged, c, sourceAdmin)
+	}
+	rebuildGeoIndexLocked()
+	invalidateListCache()
+	touchClients()
+	resyncReplicas() // о замененных клиентах событий нет: репликам нужен новый snapshot
+	res.Restored = len(incoming)
+	res.Remaining = len(next)
+	return res, nil
+}
+
+// Снимки, сохраненные на сервере, лежат в хранилище файлов под
+// backups/<имя>; имя содержит время снимка, поэтому список по ключам
+// идет от старых к новым.
+const backupPrefix = "backups/"
+
+// storeBackupJob каждую ночь сохраняет снимок в хранилище файлов.
+var storeBackupJob = &scheduledJob{
+	Name:     "backup",
+	Schedule: "0 3 * * *",
+	Run: func(ctx context.Context) error {
+		_, err := storeBackup(ctx)
+		return err
+	},
+}
+
+// backupStoredModified — clientsModified последнего сохраненного снимка.
+// Защищено clientsMu.
+var backupStoredModified time.Time
+
+// storeBackup сохраняет снимок хранилища; key в ответе — имя снимка.
+func storeBackup(ctx context.Context) (BlobInfo, error) {
+	b := takeBackup()
+	for i, c := range b.Clients {
+		var err error
+		if b.Clients[i], err = sealClient(c); err != nil {
+			return BlobInfo{}, err
+		}
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	name := "backup-" + b.CreatedAt.UTC().Format("20060102-150405.000") + ".json"
+	if err := blobs.Put(ctx, backupPrefix+name, Blob{Data: data, ContentType: "application/json"}); err != nil {
+		return BlobInfo{}, err
+	}
+	clientsMu.Lock()
+	if b.ModifiedAt.After(backupStoredModified) {
+		backupStoredModified = b.ModifiedAt
+	}
+	clientsMu.Unlock()
+	return BlobInfo{Key: name, Size: int64(len(data)), ModTime: b.CreatedAt}, nil
+}
+
+// storeBackupHandler сохраняет снимок хранилища в хранилище файлов.
+func storeBackupHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := storeBackup(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/admin/backups/"+info.Key)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// listBackupsHandler перечисляет сохраненные снимки; key — имя снимка.
+func listBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := blobs.List(r.Context(), backupPrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range list {
+		list[i].Key = strings.TrimPrefix(list[i].Key, backupPrefix)
+	}
+	if list == nil {
+		list = []BlobInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// storedBackup читает сохраненный снимок по имени из пути запроса.
+// Если его нет, отвечает ошибкой сам и возвращает false.
+func storedBackup(w http.ResponseWriter, r *http.Request) (Blob, bool) {
+	name := r.PathValue("name")
+	if strings.Contains(name, "/") || checkBlobKey(name) != nil {
+		http.Error(w, "Неверное имя снимка", http.StatusBadRequest)
+		return Blob{}, false
+	}
+	blob, err := blobs.Get(r.Context(), backupPrefix+name)
+	if errors.Is(err, errBlobNotFound) {
+		http.Error(w, "Снимок не найден", http.StatusNotFound)
+		return Blob{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return Blob{}, false
+	}
+	return blob, true
+}
+
+// getBackupHandler отдает сохраненный снимок файлом JSON.
+func getBackupHandler(w http.ResponseWriter, r *http.Request) {
+	blob, ok := storedBackup(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, r.PathValue("name")))
+	w.Write(blob.Data)
+}
+
+// restoreStoredBackupHandler восстанавливает хранилище из сохраненного
+// снимка; режим ?mode= — как у restoreHandler.
+func restoreStoredBackupHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := restoreMode(w, r)
+	if !ok {
+		return
+	}
+	blob, ok := storedBackup(w, r)
+	if !ok {
+		return
+	}
+	b, err := parseBackup(bytes.NewReader(blob.Data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRestoreResult(w, b, mode)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRestoreReplace проверяет, что replace архивирует и окончательно
+// удаляет клиентов, которых нет в снимке, и сообщает об этом подписчикам.
+func TestRestoreReplace(t *testing.T) {
+	setupTest(t)
+	config.Archive.Enabled = true
+	var purged []int
+	prev := clientSubscribers
+	clientSubscribers = []clientSubscriber{func(e clientEvent) {
+		if e.Type == eventClientPurged {
+			purged = append(purged, e.Client.ID)
+		}
+	}}
+	t.Cleanup(func() { clientSubscribers = prev })
+	clients = map[int]Client{
+		1: {ID: 1, Name: "Айгерим", Version: 4},
+		2: {ID: 2, Name: "Данияр", Version: 1},
+		3: {ID: 3, Name: "Сауле", Version: 1},
+	}
+
+	res, err := restoreBackup(Backup{Clients: []Client{{ID: 1, Name: "Айгерим Н.", Version: 2}, {ID: 5, Name: "Ерлан"}}}, "replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Restored != 2 || res.Removed != 2 || res.Remaining != 2 {
+		t.Errorf("итог %+v", res)
+	}
+	if c := clients[1]; c.Name != "Айгерим Н." || c.Version != 5 {
+		t.Errorf("клиент 1: %+v", c)
+	}
+	if c := clients[5]; c.Version != 1 {
+		t.Errorf("клиент 5: версия %d", c.Version)
+	}
+	if len(purged) != 2 || purged[0] != 2 || purged[1] != 3 {
+		t.Errorf("события purged для %v", purged)
+	}
+	list, err := readArchiveLocked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("в архиве %d записей", len(list))
+	}
+}
+
+// TestRestoreRejectsInvalidSnapshot проверяет, что снимок проверяется так
+// же, как новые клиенты, и при ошибке хранилище не меняется.
+func TestRestoreRejectsInvalidSnapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		client Client
+	}{
+		{"неизвестная кофейня", Client{ID: 1, Name: "Айгерим", Tenant: "nowhere"}},
+		{"неверный email", Client{ID: 1, Name: "Айгерим", Email: "aigerim"}},
+		{"пустое имя", Client{ID: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			clients = map[int]Client{2: {ID: 2, Name: "Данияр", Version: 1}}
+			if _, err := restoreBackup(Backup{Clients: []Client{tt.client}}, "replace"); err == nil {
+				t.Fatal("снимок принят")
+			}
+			if _, exists := clients[2]; !exists || len(clients) != 1 {
+				t.Errorf("хранилище изменено: %v", clients)
+			}
+		})
+	}
+}
+
+// TestRestoreKeepsClientsWhenArchiveFails проверяет, что replace не
+// применяется, если удаляемых клиентов не удалось записать в архив.
+func TestRestoreKeepsClientsWhenArchiveFails(t *testing.T) {
+	setupTest(t)
+	config.Archive.Enabled = true
+	config.DataDir = filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(config.DataDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clients = map[int]Client{2: {ID: 2, Name: "Данияр", Version: 1}}
+
+	_, err := restoreBackup(Backup{Clients: []Client{{ID: 1, Name: "Айгерим"}}}, "replace")
+	if !errors.Is(err, errRestoreArchive) {
+		t.Fatalf("ошибка %v", err)
+	}
+	if _, exists := clients[2]; !exists || len(clients) != 1 {
+		t.Errorf("хранилище изменено: %v", clients)
+	}
+}
+
+// TestRestoreCoffeeRemovedFromMenu проверяет, что снимок восстанавливается
+// в пустое хранилище (как при запуске), даже если любимый кофе клиента уже
+// убрали из меню.
+func TestRestoreCoffeeRemovedFromMenu(t *testing.T) {
+	setupTest(t)
+	menu = map[string]MenuItem{coffeeKey("Капучино"): {Name: "Капучино", Available: true}}
+
+	b := Backup{Clients: []Client{{ID: 1, Name: "Айгерим", FavCoffee: " Латте ", Version: 3}}}
+	if _, err := restoreBackup(b, "replace"); err != nil {
+		t.Fatal(err)
+	}
+	if c := clients[1]; c.FavCoffee != canonicalCoffee("Латте") {
+		t.Errorf("любимый кофе %q", c.FavCoffee)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BatchConfig задает темп пакетных пересчетов. Между порциями выдерживается
+// пауза ChunkInterval, чтобы пересчет не отнимал мьютекс у живых запросов.
+type BatchConfig struct {
+	ChunkSize     int      `json:"chunkSize"`
+	ChunkInterval Duration `json:"chunkInterval"`
+}
+
+// batchJob — пересчет, который обходит всех клиентов порциями.
+type batchJob struct {
+	Name    string
+	Process func(ctx context.Context, chunk []Client) error
+}
+
+// Состояния запуска пакетной задачи.
+const (
+	batchRunning  = "running"
+	batchDone     = "done"
+	batchFailed   = "failed"
+	batchCanceled = "canceled"
+)
+
+// batchRun — состояние запуска; сохраняется после каждой порции, чтобы
+// прерванный перезапуском пересчет продолжился с места остановки.
+type batchRun struct {
+	Job        string    `json:"job"`
+	Status     string    `json:"status"`
+	Checkpoint int       `json:"checkpoint"` // ID последнего обработанного клиента
+	Processed  int       `json:"processed"`
+	StartedAt  time.Time `json:"startedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Error      string    `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+var (
+	batchJobs   = make(map[string]*batchJob) // Зарегистрированные задачи
+	batchRuns   = make(map[string]*batchRun) // Последний запуск каждой задачи
+	batchMu     sync.Mutex                   // Мьютекс для защиты запусков
+	batchCtx    = context.Background()       // Родительский контекст запусков
+	batchRunsWG sync.WaitGroup
+)
+
+var (
+	errBatchUnknown = errors.New("задача не найдена")
+	errBatchBusy    = errors.New("задача уже выполняется")
+)
+
+// registerBatchJob добавляет задачу в реестр. Вызывается при инициализации.
+func registerBatchJob(j *batchJob) {
+	batchJobs[j.Name] = j
+}
+
+func batchCheckpointPath() string {
+	return filepath.Join(config.DataDir, "batch_checkpoints.json")
+}
+
+// loadBatchCheckpoints читает сохраненные запуски и возобновляет прерванные.
+func loadBatchCheckpoints(ctx context.Context) error {
+	batchCtx = ctx
+
+	data, err := os.ReadFile(batchCheckpointPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var runs map[string]*batchRun
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return fmt.Errorf("разбор %s: %w", batchCheckpointPath(), err)
+	}
+
+	batchMu.Lock()
+	defer batchMu.Unlock()
+	for name, run := range runs {
+		batchRuns[name] = run
+		if job, ok := batchJobs[name]; ok && run.Status == batchRunning {
+			logf("Возобновление пересчета %s после клиента %d", name, run.Checkpoint)
+			startBatchLocked(job, run)
+		}
+	}
+	return nil
+}
+
+// saveBatchCheckpointsLocked сохраняет состояние запусков. Вызывается под batchMu.
+func saveBatchCheckpointsLocked() {
+	if err := writeJSONFile(batchCheckpointPath(), batchRuns); err != nil {
+		logError("Ошибка сохранения контрольной точки: %v", err)
+	}
+}
+
+// writeJSONFile атомарно записывает v в файл: сначала во временный, затем rename.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// startBatch запускает задачу заново или продолжает с контрольной точки.
+func startBatch(name string, restart bool) (*batchRun, error) {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+
+	job, ok := batchJobs[name]
+	if !ok {
+		return nil, errBatchUnknown
+	}
+	run := batchRuns[name]
+	if run != nil && run.cancel != nil {
+		return nil, errBatchBusy
+	}
+	if run == nil || restart || run.Status == batchDone {
+		run = &batchRun{Job: name, StartedAt: time.Now()}
+		batchRuns[name] = run
+	}
+	startBatchLocked(job, run)
+	return run, nil
+}
+
+func startBatchLocked(job *batchJob, run *batchRun) {
+	ctx, cancel := context.WithCancel(batchCtx)
+	run.cancel = cancel
+	run.Status = batchRunning
+	run.Error = ""
+	run.UpdatedAt = time.Now()
+	saveBatchCheckpointsLocked()
+
+	batchRunsWG.Add(1)
+	go func() {
+		defer batchRunsWG.Done()
+		err := runExclusive(ctx, "batch-"+job.Name, func(ctx context.Context) error {
+			return runBatch(ctx, job, run)
+		})
+
+		batchMu.Lock()
+		defer batchMu.Unlock()
+		run.cancel = nil
+		run.UpdatedAt = time.Now()
+		switch {
+		case err == nil:
+			run.Status = batchDone
+		case errors.Is(err, context.Canceled) && batchCtx.Err() != nil:
+			// Сервер останавливается: оставляем running, чтобы продолжить после старта.
+		case errors.Is(err, context.Canceled):
+			run.Status = batchCanceled
+		default:
+			run.Status = batchFailed
+			run.Error = err.Error()
+		}
+		saveBatchCheckpointsLocked()
+	}()
+}
+
+// runBatch обходит клиентов по возрастанию ID начиная с контрольной точки.
+func runBatch(ctx context.Context, job *batchJob, run *batchRun) error {
+	size := config.Batch.ChunkSize
+	throttle := time.NewTicker(time.Duration(config.Batch.ChunkInterval))
+	defer throttle.Stop()
+
+	batchMu.Lock()
+	after := run.Checkpoint
+	batchMu.Unlock()
+
+	for {
+		chunk := clientsAfter(after, size)
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := job.Process(ctx, chunk); err != nil {
+			return err
+		}
+		after = chunk[len(chunk)-1].ID
+
+		batchMu.Lock()
+		run.Checkpoint = after
+		run.Processed += len(chunk)
+		run.UpdatedAt = time.Now()
+		saveBatchCheckpointsLocked()
+		batchMu.Unlock()
+
+		if len(chunk) < size {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-throttle.C:
+		}
+	}
+}
+
+// clientsAfter возвращает до limit клиентов с ID больше after по возрастанию ID.
+func clientsAfter(after, limit int) []Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	ids := make([]int, 0, len(clients))
+	for id := range clients {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	chunk := make([]Client, len(ids))
+	for i, id := range ids {
+		chunk[i] = clients[id]
+	}
+	return chunk
+}
+
+// batchHandler управляет пересчетами: GET — состояние, POST ?job= — запуск
+// (restart=true начинает сначала), DELETE ?job= — отмена.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("job")
+
+	switch r.Method {
+	case http.MethodGet:
+		batchMu.Lock()
+		type jobState struct {
+			Name    string    `json:"name"`
+			LastRun *batchRun `json:"lastRun,omitempty"`
+		}
+		list := make([]jobState, 0, len(batchJobs))
+		for n := range batchJobs {
+			st := jobState{Name: n}
+			if run, ok := batchRuns[n]; ok {
+				cp := *run
+				st.LastRun = &cp
+			}
+			list = append(list, st)
+		}
+		batchMu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		run, err := startBatch(name, r.URL.Query().Get("restart") == "true")
+		if errors.Is(err, errBatchUnknown) {
+			http.Error(w, "Задача не найдена", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Задача уже выполняется", http.StatusConflict)
+			return
+		}
+		batchMu.Lock()
+		cp := *run
+		batchMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(cp)
+
+	case http.MethodDelete:
+		batchMu.Lock()
+		defer batchMu.Unlock()
+
+		run, ok := batchRuns[name]
+		if !ok || run.cancel == nil {
+			http.Error(w, "Задача не выполняется", http.StatusNotFound)
+			return
+		}
+		run.cancel()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
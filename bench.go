@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Нагрузочный прогон работающего сервера: adv-prog bench. Потоки
+// -concurrency в течение -duration отправляют запросы вперемешку по весам
+// -mix и считают задержки и ошибки по операциям:
+//
+//	get    — GET /clients/{id} своего клиента
+//	list   — GET /getClients?tag=bench, перебор всего хранилища
+//	create — POST /addClient
+//	update — PUT /clients/{id} своего клиента с текущей версией
+//
+// Каждый поток меняет только созданных им клиентов, так что конфликтов
+// версий между потоками нет и ошибки говорят о сервере, а не о прогоне.
+// Клиенты прогона получают метку bench и ID от -id; после прогона они
+// удаляются окончательно (DELETE /clients/{id}/purge, нужна роль admin) и
+// попадают в архив удаленных, если он включен. Ограничение частоты
+// запросов на время прогона можно поднять через /admin/settings.
+
+// benchOps — операции прогона в порядке отчета.
+var benchOps = []string{"get", "list", "create", "update"}
+
+// benchTag — метка клиентов прогона.
+const benchTag = "bench"
+
+// benchOptions — параметры прогона.
+type benchOptions struct {
+	URL         string
+	Token       string
+	APIKey      string
+	Tenant      string
+	Concurrency int
+	Duration    time.Duration
+	Mix         map[string]int
+	Cleanup     bool
+}
+
+// parseBenchMix разбирает веса вида "get=6,list=2,create=1,update=1".
+func parseBenchMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	total := 0
+	for part := range strings.SplitSeq(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(v)
+		if !ok || err != nil || n < 0 || !slices.Contains(benchOps, name) {
+			return nil, fmt.Errorf("mix: ожидается список операции=вес из %s, получено %q", strings.Join(benchOps, ", "), part)
+		}
+		mix[name] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, errors.New("mix: укажите хотя бы одну операцию с положительным весом")
+	}
+	return mix, nil
+}
+
+// benchOpStats — итоги одной операции.
+type benchOpStats struct {
+	Requests int             `json:"requests"`
+	Errors   int             `json:"errors"` // ошибки сети и ответы 4xx и 5xx
+	P50Ms    float64         `json:"p50Ms"`
+	P90Ms    float64         `json:"p90Ms"`
+	P99Ms    float64         `json:"p99Ms"`
+	MaxMs    float64         `json:"maxMs"`
+	latency  []time.Duration // все задержки до подсчета процентилей
+}
+
+// benchReport — итог прогона.
+type benchReport struct {
+	Duration    Duration                `json:"duration"`
+	Concurrency int                     `json:"concurrency"`
+	Requests    int                     `json:"requests"`
+	RPS         float64                 `json:"rps"`
+	ErrorRate   float64                 `json:"errorRate"`
+	Ops         map[string]benchOpStats `json:"ops"`
+	Total       benchOpStats            `json:"total"`
+	Statuses    map[string]int          `json:"statuses"` // по коду ответа; "error" — без ответа
+	Created     int                     `json:"created"`
+	CleanedUp   int                     `json:"cleanedUp"`
+}
+
+// benchClient — клиент, созданный потоком, с последней версией.
+type benchClient struct {
+	ID      int
+	Version int
+}
+
+// benchRun — общее состояние прогона.
+type benchRun struct {
+	opts   benchOptions
+	http   *http.Client
+	nextID int
+	mu     sync.Mutex
+	report benchReport
+	own    []benchClient // созданные всеми потоками, для очистки
+}
+
+// request отправляет запрос и возвращает код ответа и тело.
+func (b *benchRun) request(method, path string, body any) (int, []byte, error) {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, b.opts.URL+path, rd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if b.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.opts.Token)
+	}
+	if b.opts.APIKey != "" {
+		req.Header.Set(apiKeyHeader, b.opts.APIKey)
+	}
+	if b.opts.Tenant != "" {
+		req.Header.Set(tenantHeader, b.opts.Tenant)
+	}
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// newClientID выдает ID следующему клиенту прогона.
+func (b *benchRun) newClientID() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	return b.nextID - 1
+}
+
+// worker отправляет запросы, пока не наступит deadline. Начатый запрос
+// доводится до конца, чтобы не считать ошибкой обрыв по окончании прогона.
+func (b *benchRun) worker(deadline time.Time, seed uint64) (map[string]*benchOpStats, map[string]int, []benchClient) {
+	rng := rand.New(rand.NewPCG(seed, seed>>1|1))
+	total := 0
+	for _, n := range b.opts.Mix {
+		total += n
+	}
+	stats := make(map[string]*benchOpStats, len(benchOps))
+	for _, op := range benchOps {
+		stats[op] = &benchOpStats{}
+	}
+	statuses := make(map[string]int)
+	var own []benchClient
+
+	for time.Now().Before(deadline) {
+		op, n := "", rng.IntN(total)
+		for _, name := range benchOps {
+			if n -= b.opts.Mix[name]; n < 0 {
+				op = name
+				break
+			}
+		}
+		if (op == "get" || op == "update") && len(own) == 0 {
+			op = "create"
+		}
+		i := 0
+		if len(own) > 0 {
+			i = rng.IntN(len(own))
+		}
+
+		var status int
+		var body []byte
+		var err error
+		start := time.Now()
+		switch op {
+		case "get":
+			status, body, err = b.request(http.MethodGet, "/clients/"+strconv.Itoa(own[i].ID), nil)
+		case "list":
+			status, body, err = b.request(http.MethodGet, "/getClients?tag="+benchTag, nil)
+		case "create":
+			id := b.newClientID()
+			c := Client{ID: id, Name: fmt.Sprintf("Bench %d", id), Email: fmt.Sprintf("bench-%d@example.com", id), FavCoffee: "Латте", Tags: []string{benchTag}, RegisterDate: time.Now()}
+			status, body, err = b.request(http.MethodPost, "/addClient", c)
+		case "update":
+			c := Client{ID: own[i].ID, Name: fmt.Sprintf("Bench %d", own[i].ID), FavCoffee: "Капучино", Tags: []string{benchTag}, Version: own[i].Version}
+			status, body, err = b.request(http.MethodPut, "/clients/"+strconv.Itoa(own[i].ID), c)
+		}
+		elapsed := time.Since(start)
+
+		st := stats[op]
+		st.Requests++
+		st.latency = append(st.latency, elapsed)
+		if err != nil {
+			st.Errors++
+			statuses["error"]++
+			continue
+		}
+		statuses[strconv.Itoa(status)]++
+		if status >= 400 {
+			st.Errors++
+			continue
+		}
+		var c Client
+		if (op == "create" || op == "update") && json.Unmarshal(body, &c) == nil {
+			if op == "create" {
+				own = append(own, benchClient{ID: c.ID, Version: c.Version})
+			} else {
+				own[i].Version = c.Version
+			}
+		}
+	}
+	return stats, statuses, own
+}
+
+// finish считает процентили операции.
+func (s *benchOpStats) finish() {
+	if len(s.latency) == 0 {
+		return
+	}
+	slices.Sort(s.latency)
+	at := func(p float64) float64 {
+		i := int(math.Ceil(float64(len(s.latency))*p)) - 1
+		return float64(s.latency[max(i, 0)].Microseconds()) / 1000
+	}
+	s.P50Ms, s.P90Ms, s.P99Ms = at(0.50), at(0.90), at(0.99)
+	s.MaxMs = float64(s.latency[len(s.latency)-1].Microseconds()) / 1000
+}
+
+// run выполняет прогон и очистку.
+func (b *benchRun) run() benchReport {
+	b.report = benchReport{
+		Duration:    Duration(b.opts.Duration),
+		Concurrency: b.opts.Concurrency,
+		Ops:         make(map[string]benchOpStats),
+		Statuses:    make(map[string]int),
+	}
+	merged := make(map[string]*benchOpStats, len(benchOps))
+	for _, op := range benchOps {
+		merged[op] = &benchOpStats{}
+	}
+
+	start := time.Now()
+	deadline := start.Add(b.opts.Duration)
+	var wg sync.WaitGroup
+	for w := range b.opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats, statuses, own := b.worker(deadline, uint64(w+1)*uint64(start.UnixNano()))
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			for op, st := range stats {
+				m := merged[op]
+				m.Requests += st.Requests
+				m.Errors += st.Errors
+				m.latency = append(m.latency, st.latency...)
+			}
+			for code, n := range statuses {
+				b.report.Statuses[code] += n
+			}
+			b.own = append(b.own, own...)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := &b.report.Total
+	for _, op := range benchOps {
+		st := merged[op]
+		if st.Requests == 0 {
+			continue
+		}
+		total.Requests += st.Requests
+		total.Errors += st.Errors
+		total.latency = append(total.latency, st.latency...)
+		st.finish()
+		st.latency = nil
+		b.report.Ops[op] = *st
+	}
+	total.finish()
+	total.latency = nil
+	b.report.Requests = total.Requests
+	b.report.RPS = float64(total.Requests) / elapsed.Seconds()
+	if total.Requests > 0 {
+		b.report.ErrorRate = float64(total.Errors) / float64(total.Requests)
+	}
+	b.report.Created = len(b.own)
+
+	if b.opts.Cleanup {
+		for _, c := range b.own {
+			id := strconv.Itoa(c.ID)
+			if status, _, err := b.request(http.MethodDelete, "/deleteClient?id="+id, nil); err != nil || status >= 300 {
+				continue
+			}
+			if status, _, err := b.request(http.MethodDelete, "/clients/"+id+"/purge", nil); err == nil && status < 300 {
+				b.report.CleanedUp++
+			}
+		}
+	}
+	return b.report
+}
+
+// print выводит отчет таблицей.
+func (r benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Прогон %s, потоков %d: запросов %d (%.1f в секунду), ошибок %.2f%%\n\n",
+		time.Duration(r.Duration), r.Concurrency, r.Requests, r.RPS, r.ErrorRate*100)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "операция\tзапросов\tошибок\tp50, мс\tp90, мс\tp99, мс\tmax, мс\t")
+	row := func(name string, s benchOpStats) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t\n", name, s.Requests, s.Errors, s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs)
+	}
+	for _, op := range benchOps {
+		if s, ok := r.Ops[op]; ok {
+			row(op, s)
+		}
+	}
+	row("всего", r.Total)
+	tw.Flush()
+
+	codes := make([]string, 0, len(r.Statuses))
+	for code := range r.Statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for i, code := range codes {
+		codes[i] = fmt.Sprintf("%s=%d", code, r.Statuses[code])
+	}
+	fmt.Fprintf(w, "\nКоды ответов: %s\n", strings.Join(codes, " "))
+	fmt.Fprintf(w, "Клиентов создано: %d, удалено после прогона: %d\n", r.Created, r.CleanedUp)
+}
+
+// benchLogin получает токен по логину и паролю.
+func benchLogin(b *benchRun, username, password string) (string, error) {
+	status, body, err := b.request(http.MethodPost, "/auth/login", map[string]string{"username": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("вход %s: %d %s", username, status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", err
+	}
+	return out.Token, nil
+}
+
+// benchCommand запускает нагрузочный прогон работающего сервера.
+func benchCommand(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:8090", "адрес сервера")
+	token := fs.String("token", "", "JWT; без него — вход по -user и паролю из BENCH_PASSWORD")
+	user := fs.String("user", "", "пользователь для входа")
+	apiKey := fs.String("api-key", "", "API-ключ вместо JWT")
+	tenant := fs.String("tenant", "", "кофейня, заголовок X-Tenant-ID")
+	concurrency := fs.Int("concurrency", 8, "параллельных потоков")
+	duration := fs.Duration("duration", 30*time.Second, "длительность прогона")
+	mix := fs.String("mix", "get=6,list=2,create=1,update=1", "веса операций get, list, create и update")
+	firstID := fs.Int("id", 0, "ID первого клиента прогона; по умолчанию случайный после 1000000000")
+	timeout := fs.Duration("timeout", 10*time.Second, "время на один запрос")
+	cleanup := fs.Bool("cleanup", true, "удалить клиентов прогона после него")
+	asJSON := fs.Bool("json", false, "отчет в JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 || *duration <= 0 || *timeout <= 0 {
+		return errors.New("concurrency, duration и timeout должны быть положительными")
+	}
+	weights, err := parseBenchMix(*mix)
+	if err != nil {
+		return err
+	}
+	if *firstID == 0 {
+		*firstID = 1_000_000_000 + rand.IntN(1_000_000_000)
+	}
+
+	b := &benchRun{
+		opts: benchOptions{
+			URL: strings.TrimSuffix(*url, "/"), Token: *token, APIKey: *apiKey, Tenant: *tenant,
+			Concurrency: *concurrency, Duration: *duration, Mix: weights, Cleanup: *cleanup,
+		},
+		http: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency, Proxy: http.ProxyFromEnvironment},
+		},
+		nextID: *firstID,
+	}
+	if b.opts.Token == "" && b.opts.APIKey == "" && *user != "" {
+		if b.opts.Token, err = benchLogin(b, *user, os.Getenv("BENCH_PASSWORD")); err != nil {
+			return err
+		}
+	}
+	if status, _, err := b.request(http.MethodGet, "/healthz", nil); err != nil {
+		return fmt.Errorf("сервер %s недоступен: %w", b.opts.URL, err)
+	} else if status != http.StatusOK {
+		return fmt.Errorf("сервер %s: /healthz ответил %d", b.opts.URL, status)
+	}
+
+	fmt.Fprintf(os.Stderr, "Прогон %s: %s, потоков %d, веса %s\n", b.opts.URL, b.opts.Duration, b.opts.Concurrency, *mix)
+	report := b.run()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.print(os.Stdout)
+	return nil
+}
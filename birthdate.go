@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Дата рождения клиента. Раньше хранился только возраст числом, и через год
+// он становился неверным; теперь возраст считается по birthDate при каждом
+// чтении. Поле age по-прежнему принимается: у старых записей без даты
+// рождения оно остается как есть, а POST /clients/birthdates дозаполняет
+// даты пакетом.
+
+// maxClientAge — больше лет клиенту быть не может.
+const maxClientAge = 150
+
+// parseBirthDate разбирает дату рождения в виде ГГГГ-ММ-ДД.
+func parseBirthDate(s string) (time.Time, error) {
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return d, errors.New("birthDate: ожидается дата ГГГГ-ММ-ДД")
+	}
+	return d, nil
+}
+
+// checkBirthDate проверяет дату рождения: пустая допустима, иначе — не в
+// будущем и не дальше maxClientAge лет назад.
+func checkBirthDate(s string) error {
+	if s == "" {
+		return nil
+	}
+	d, err := parseBirthDate(s)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if d.After(now) {
+		return errors.New("birthDate в будущем")
+	}
+	if ageAt(d, now) > maxClientAge {
+		return fmt.Errorf("birthDate: возраст больше %d лет", maxClientAge)
+	}
+	return nil
+}
+
+// ageAt возвращает полных лет на момент now у родившегося birth. Родившиеся
+// 29 февраля в невисокосный год становятся старше 1 марта.
+func ageAt(birth, now time.Time) int {
+	y, m, d := now.Date()
+	age := y - birth.Year()
+	if m < birth.Month() || (m == birth.Month() && d < birth.Day()) {
+		age--
+	}
+	return age
+}
+
+// currentAge возвращает возраст клиента: по дате рождения, если она
+// известна, иначе сохраненный age.
+func (c Client) currentAge() int {
+	if c.BirthDate == "" {
+		return c.Age
+	}
+	d, err := parseBirthDate(c.BirthDate)
+	if err != nil {
+		return c.Age
+	}
+	return ageAt(d, time.Now())
+}
+
+// withCurrentAge возвращает копию клиента с возрастом на сегодня.
+func (c Client) withCurrentAge() Client {
+	c.Age = c.currentAge()
+	return c
+}
+
+// clientJSON — Client без собственного MarshalJSON.
+type clientJSON Client
+
+// MarshalJSON подставляет в age возраст на сегодня. Через JSON клиента
+// кодируют и msgpack, и ETag.
+func (c Client) MarshalJSON() ([]byte, error) {
+	return json.Marshal(clientJSON(c.withCurrentAge()))
+}
+
+// keepBirthDate оставляет дату рождения при замене клиента, если в upd ее
+// нет, а age совпадает с возрастом по ней: так клиенты API, которые еще не
+// знают birthDate, не стирают ее, отправляя прочитанного клиента обратно.
+func keepBirthDate(upd *Client, cur Client) {
+	if upd.BirthDate == "" && cur.BirthDate != "" && upd.Age == cur.currentAge() {
+		upd.BirthDate = cur.BirthDate
+	}
+}
+
+// birthDateItem — элемент POST /clients/birthdates.
+type birthDateItem struct {
+	ID        int    `json:"id"`
+	BirthDate string `json:"birthDate"`
+}
+
+// birthDatesHandler задает даты рождения пакетом: POST /clients/birthdates.
+// Нужен, чтобы перевести старые записи с age на birthDate. Если у клиента
+// еще нет даты рождения, возраст по новой дате должен совпадать с age с
+// точностью до года: age мог устареть, но большее расхождение — ошибка в
+// данных. Режимы — как у POST /clients/batch.
+func birthDatesHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := parseBatchMode(w, r)
+	if !ok {
+		return
+	}
+	list, ok := decodeBatch[birthDateItem](w, r)
+	if !ok {
+		return
+	}
+	tenant := requestTenant(r)
+
+	now := time.Now()
+	res := batchResult{Mode: mode, Items: make([]batchItemResult, len(list))}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	seen := make(map[int]bool, len(list))
+	for i, it := range list {
+		item := batchItemResult{Index: i, ID: it.ID, Status: itemUpdated}
+		c, exists := tenantClientLocked(tenant, it.ID)
+		switch {
+		case !exists || c.deleted():
+			item.Status, item.Error = itemNotFound, "клиент не найден"
+		case seen[it.ID]:
+			item.Status, item.Error = itemFailed, "ID повторяется в пакете"
+		case it.BirthDate == "":
+			item.Status, item.Error = itemFailed, "не указана birthDate"
+		default:
+			if err := checkBirthDate(it.BirthDate); err != nil {
+				item.Status, item.Error = itemFailed, err.Error()
+				break
+			}
+			d, _ := parseBirthDate(it.BirthDate)
+			if age := ageAt(d, now); c.BirthDate == "" && c.Age != 0 && (age < c.Age-1 || age > c.Age+1) {
+				item.Status, item.Error = itemFailed, fmt.Sprintf("возраст по birthDate (%d) не совпадает с age (%d)", age, c.Age)
+			}
+		}
+		seen[it.ID] = true
+		if item.Status != itemUpdated {
+			res.Failed++
+		}
+		res.Items[i] = item
+	}
+
+	if mode == batchModeAtomic && res.Failed > 0 {
+		for i := range res.Items {
+			if res.Items[i].Status == itemUpdated {
+				res.Items[i].Status = itemSkipped
+			}
+		}
+		writeBatchResult(w, res, http.StatusOK)
+		return
+	}
+
+	for i, it := range list {
+		if res.Items[i].Status != itemUpdated {
+			continue
+		}
+		c := clients[it.ID]
+		if c.BirthDate != it.BirthDate {
+			c.BirthDate = it.BirthDate
+			c.Age = c.currentAge()
+			c.Version++
+			clients[it.ID] = c
+			publishClientEvent(eventClientUpdated, c, sourceBatch)
+		}
+		res.Succeeded++
+	}
+	writeBatchResult(w, res, http.StatusOK)
+}
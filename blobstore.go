@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BlobConfig задает хранилище файлов: аватаров, снимков и т. п.
+type BlobConfig struct {
+	Backend string   `json:"backend"` // "local" или "s3"
+	Dir     string   `json:"dir"`     // для local; по умолчанию <dataDir>/blobs
+	S3      S3Config `json:"s3"`
+}
+
+// Хранилища файлов.
+const (
+	blobBackendLocal = "local"
+	blobBackendS3    = "s3"
+)
+
+// Blob — содержимое объекта хранилища.
+type Blob struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time // задается хранилищем при записи
+}
+
+// BlobInfo описывает объект в списке.
+type BlobInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// BlobStore хранит объекты по ключам вида "avatars/12". Ключ — путь через
+// "/" без пустых, "." и ".." частей.
+type BlobStore interface {
+	Put(ctx context.Context, key string, b Blob) error
+	Get(ctx context.Context, key string) (Blob, error) // errBlobNotFound, если объекта нет
+	Delete(ctx context.Context, key string) error      // отсутствие объекта — не ошибка
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+var errBlobNotFound = errors.New("Объект не найден")
+
+// blobs — хранилище файлов, выбирается в main по config.Blobs.Backend.
+var blobs BlobStore
+
+// newBlobStore создает хранилище по настройкам.
+func newBlobStore(cfg BlobConfig) (BlobStore, error) {
+	if cfg.Backend == blobBackendS3 {
+		return newS3BlobStore("blobs", cfg.S3)
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(config.DataDir, "blobs")
+	}
+	return localBlobStore{dir: dir}, nil
+}
+
+// checkBlobKey отклоняет ключи, которые вышли бы за пределы хранилища.
+func checkBlobKey(key string) error {
+	if key == "." || !fs.ValidPath(key) || strings.Contains(key, `\`) {
+		return fmt.Errorf("недопустимый ключ объекта %q", key)
+	}
+	return nil
+}
+
+// localBlobStore хранит объекты файлами <dir>/<ключ>. Тип содержимого
+// не сохраняется: он определяется по расширению ключа или по самим данным.
+type localBlobStore struct {
+	dir string
+}
+
+// localBlobTemp — префикс временных файлов, которые List пропускает.
+const localBlobTemp = ".blob-"
+
+func (l localBlobStore) path(key string) (string, error) {
+	if err := checkBlobKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+func (l localBlobStore) Put(_ context.Context, key string, b Blob) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Запись во временный файл и переименование: читатель не увидит
+	// недописанный объект.
+	f, err := os.CreateTemp(filepath.Dir(p), localBlobTemp+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b.Data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (l localBlobStore) Get(_ context.Context, key string) (Blob, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Blob{}, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Blob{}, errBlobNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return Blob{}, err
+	}
+	ct := mime.TypeByExtension(path.Ext(key))
+	if ct == "" {
+		ct = http.DetectContentType(data)
+	}
+	return Blob{Data: data, ContentType: ct, ModTime: info.ModTime()}, nil
+}
+
+func (l localBlobStore) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l localBlobStore) List(_ context.Context, prefix string) ([]BlobInfo, error) {
+	// Обходится только каталог, в котором лежат ключи с этим префиксом.
+	root := l.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		if err := checkBlobKey(prefix[:i]); err != nil {
+			return nil, err
+		}
+		root = filepath.Join(l.dir, filepath.FromSlash(prefix[:i]))
+	}
+	var list []BlobInfo
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localBlobTemp) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		list = append(list, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(list, func(a, b BlobInfo) int { return strings.Compare(a.Key, b.Key) })
+	return list, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Кэш выборок GET /getClients. Список по фильтру — самый частый и самый
+// дорогой запрос: без кэша каждый перебирает все хранилище. Выборка
+// хранится TTL и сбрасывается целиком при любом событии клиента (events.go),
+// так что после изменения следующий запрос уже видит новые данные. TTL
+// нужен для фильтров по возрасту: он меняется с датой, а не с событиями.
+
+// CacheConfig задает кэш выборок клиентов.
+type CacheConfig struct {
+	Enabled    bool     `json:"enabled"`
+	TTL        Duration `json:"ttl"`
+	MaxEntries int      `json:"maxEntries"` // разных фильтров одновременно
+}
+
+// validate проверяет настройки до запуска сервера.
+func (c CacheConfig) validate() error {
+	if c.Enabled && (c.TTL <= 0 || c.MaxEntries < 1) {
+		return fmt.Errorf("cache: ttl и maxEntries должны быть положительными")
+	}
+	return nil
+}
+
+// cachedList — выборка по одному фильтру. Clients общий для всех запросов
+// и не изменяется.
+type cachedList struct {
+	Clients  map[int]Client
+	Modified time.Time
+	Expires  time.Time
+}
+
+var (
+	listCache   = make(map[string]cachedList) // Выборки по ключу фильтра
+	listCacheMu sync.Mutex                    // Мьютекс для защиты listCache; берется после clientsMu
+)
+
+// cacheStats — счетчики кэша с запуска сервера.
+var cacheStats struct {
+	Hits, Misses, Invalidations, Evictions atomic.Int64
+}
+
+// filterCacheKey — ключ выборки: фильтр целиком, вместе с кофейней.
+func filterCacheKey(f clientFilter) string {
+	key, _ := json.Marshal(f)
+	return string(key)
+}
+
+// cachedClients возвращает выборку по фильтру из кэша.
+func cachedClients(f clientFilter) (cachedList, bool) {
+	if !liveConfig().Cache.Enabled {
+		return cachedList{}, false
+	}
+	key := filterCacheKey(f)
+	listCacheMu.Lock()
+	defer listCacheMu.Unlock()
+	e, ok := listCache[key]
+	if ok && time.Now().After(e.Expires) {
+		delete(listCache, key)
+		cacheStats.Evictions.Add(1)
+		ok = false
+	}
+	if ok {
+		cacheStats.Hits.Add(1)
+	} else {
+		cacheStats.Misses.Add(1)
+	}
+	return e, ok
+}
+
+// cacheClientsLocked запоминает выборку. Вызывается под clientsMu, чтобы
+// событие, сбрасывающее кэш, не пришло между выборкой и записью.
+func cacheClientsLocked(f clientFilter, matched map[int]Client, modified time.Time) {
+	cfg := liveConfig().Cache
+	if !cfg.Enabled {
+		return
+	}
+	listCacheMu.Lock()
+	defer listCacheMu.Unlock()
+	now := time.Now()
+	if len(listCache) >= cfg.MaxEntries {
+		for k, e := range listCache {
+			if now.After(e.Expires) {
+				delete(listCache, k)
+				cacheStats.Evictions.Add(1)
+			}
+		}
+	}
+	if len(listCache) >= cfg.MaxEntries {
+		cacheStats.Evictions.Add(int64(len(listCache)))
+		clear(listCache)
+	}
+	listCache[filterCacheKey(f)] = cachedList{
+		Clients:  matched,
+		Modified: modified,
+		Expires:  now.Add(time.Duration(cfg.TTL)),
+	}
+}
+
+// invalidateListCache сбрасывает все выборки.
+func invalidateListCache() {
+	listCacheMu.Lock()
+	defer listCacheMu.Unlock()
+	if len(listCache) > 0 {
+		clear(listCache)
+		cacheStats.Invalidations.Add(1)
+	}
+}
+
+// cacheOnClientEvent сбрасывает выборки при любом изменении клиента.
+func cacheOnClientEvent(clientEvent) {
+	invalidateListCache()
+}
+
+// cacheHandler показывает состояние кэша (GET /admin/cache) и сбрасывает
+// его (DELETE /admin/cache).
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listCacheMu.Lock()
+		entries := len(listCache)
+		listCacheMu.Unlock()
+
+		cfg := liveConfig().Cache
+		hits, misses := cacheStats.Hits.Load(), cacheStats.Misses.Load()
+		hitRate := 0.0
+		if hits+misses > 0 {
+			hitRate = float64(hits) / float64(hits+misses)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"enabled": cfg.Enabled,
+			"ttl":     cfg.TTL,
+			"entries": entries,
+			"hitRate": hitRate,
+			"stats": map[string]int64{
+				"hits":          hits,
+				"misses":        misses,
+				"invalidations": cacheStats.Invalidations.Load(),
+				"evictions":     cacheStats.Evictions.Load(),
+			},
+		})
+	case http.MethodDelete:
+		invalidateListCache()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
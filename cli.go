@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Команды для обслуживания; все, кроме bench, работают без запущенного
+// сервера:
+//
+//	adv-prog [serve]                 — сервер, как раньше
+//	adv-prog import [флаги] file.csv — импорт CSV в новый снимок хранилища
+//	adv-prog export [флаги]          — выгрузка клиентов из снимка
+//	adv-prog migrate                 — миграции сохраненных данных (migrations.go)
+//	adv-prog seed                    — тестовые клиенты в новый снимок (seed.go)
+//	adv-prog bench [флаги]           — нагрузочный прогон работающего сервера (bench.go)
+//	adv-prog crm-sync [флаги]        — синхронизация из CRM в новый снимок (crm.go)
+//
+// Клиенты вне процесса сервера живут только в снимках хранилища файлов
+// (backup.go), поэтому import и export работают со снимками: import берет
+// последний снимок, добавляет клиентов и сохраняет новый, а сервер
+// подхватывает его через POST /admin/backups/{name}/restore. Подписчики
+// событий в командах не запускаются: вебхуков и писем импорт не вызывает.
+
+const cliUsage = `Использование:
+  adv-prog [serve] [флаги]           запустить сервер
+  adv-prog import [флаги] file.csv   импортировать клиентов в новый снимок
+  adv-prog export [флаги]            выгрузить клиентов из снимка
+  adv-prog migrate                   применить миграции данных
+  adv-prog seed [-count=1000]        добавить тестовых клиентов в новый снимок
+  adv-prog bench [флаги]             нагрузочный прогон работающего сервера
+  adv-prog crm-sync [флаги]          синхронизировать клиентов из CRM в новый снимок
+
+Конфигурация — из файла CONFIG_PATH, по умолчанию config.json.
+Флаги команды: adv-prog <команда> -h
+`
+
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	var err error
+	switch cmd {
+	case "serve":
+		serve(args)
+		return
+	case "import":
+		err = importCommand(args)
+	case "export":
+		err = exportCommand(args)
+	case "migrate":
+		err = migrateCommand(args)
+	case "seed":
+		err = seedCommand(args)
+	case "bench":
+		err = benchCommand(args)
+	case "crm-sync":
+		err = crmSyncCommand(args)
+	case "help":
+		fmt.Print(cliUsage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n%s", cmd, cliUsage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+// initConfig читает конфигурацию из CONFIG_PATH или config.json.
+func initConfig() error {
+	configPath = os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config.json"
+	}
+	var err error
+	config, err = loadConfig(configPath)
+	return err
+}
+
+// openOffline готовит то, что нужно командам без сервера: ключи
+// шифрования, хранилище файлов, справочник кофе, меню и кофейни.
+func openOffline() error {
+	if err := initConfig(); err != nil {
+		return fmt.Errorf("конфигурация: %w", err)
+	}
+	var err error
+	if pii, err = newFieldCipher(config.Encryption); err != nil {
+		return fmt.Errorf("ключи шифрования: %w", err)
+	}
+	if blobs, err = newBlobStore(config.Blobs); err != nil {
+		return fmt.Errorf("хранилище файлов: %w", err)
+	}
+	if err := loadCoffeeTaxonomy(); err != nil {
+		return fmt.Errorf("справочник кофе: %w", err)
+	}
+	if err := loadMenu(); err != nil {
+		return fmt.Errorf("меню: %w", err)
+	}
+	if err := loadTenants(); err != nil {
+		return fmt.Errorf("кофейни: %w", err)
+	}
+	return nil
+}
+
+// loadSnapshot загружает в хранилище снимок name или, если name пуст,
+// последний сохраненный; сервер так же загружает последний снимок при
+// запуске. Возвращает имя загруженного снимка; если снимков нет, хранилище
+// остается пустым, а имя — пустым.
+func loadSnapshot(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		list, err := blobs.List(ctx, backupPrefix)
+		if err != nil {
+			return "", err
+		}
+		if len(list) == 0 {
+			return "", nil
+		}
+		name = strings.TrimPrefix(list[len(list)-1].Key, backupPrefix)
+	}
+	blob, err := blobs.Get(ctx, backupPrefix+name)
+	if errors.Is(err, errBlobNotFound) {
+		return "", fmt.Errorf("снимок %s не найден", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	b, err := parseBackup(bytes.NewReader(blob.Data))
+	if err != nil {
+		return "", fmt.Errorf("снимок %s: %w", name, err)
+	}
+	if _, err := restoreBackup(b, "replace"); err != nil {
+		return "", fmt.Errorf("снимок %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// importCommand импортирует CSV, как POST /clients/import, в новый снимок.
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	delimiter := fs.String("delimiter", "", "разделитель: semicolon, tab или символ; по умолчанию запятая")
+	tenant := fs.String("tenant", "", "кофейня клиентов; по умолчанию основная")
+	snapshot := fs.String("snapshot", "", "снимок, к которому добавить клиентов; по умолчанию последний")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: adv-prog import [флаги] file.csv")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("укажите один файл CSV")
+	}
+	if err := openOffline(); err != nil {
+		return err
+	}
+	if !tenantExists(*tenant) {
+		return fmt.Errorf("неизвестная кофейня %s", *tenant)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	from, err := loadSnapshot(ctx, *snapshot)
+	if err != nil {
+		return err
+	}
+	summary, err := importClients(newImportReader(f, *delimiter), *tenant)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(summary)
+	if summary.Imported == 0 {
+		fmt.Fprintln(os.Stderr, "Ни одного клиента не импортировано, снимок не сохранен")
+		return nil
+	}
+	info, err := storeBackup(ctx)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		from = "пустого хранилища"
+	}
+	fmt.Fprintf(os.Stderr, "Снимок %s сохранен на основе %s; восстановить: POST /admin/backups/%s/restore?mode=replace\n", info.Key, from, info.Key)
+	return nil
+}
+
+// exportCommand выгружает клиентов снимка в CSV или XLSX.
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "csv или xlsx")
+	output := fs.String("o", "", "файл; по умолчанию стандартный вывод")
+	tenant := fs.String("tenant", "", "только клиенты кофейни; по умолчанию все кофейни")
+	snapshot := fs.String("snapshot", "", "снимок; по умолчанию последний")
+	includeDeleted := fs.Bool("include-deleted", false, "выгрузить и мягко удаленных")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, ok := exportContentTypes[*format]; !ok {
+		return errors.New("Неизвестный формат: поддерживаются csv и xlsx")
+	}
+	if err := openOffline(); err != nil {
+		return err
+	}
+	if !tenantExists(*tenant) {
+		return fmt.Errorf("неизвестная кофейня %s", *tenant)
+	}
+	name, err := loadSnapshot(context.Background(), *snapshot)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("сохраненных снимков нет")
+	}
+
+	f := clientFilter{Tenant: *tenant, AllTenants: *tenant == "", IncludeDeleted: *includeDeleted}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	list := filterClients(f)
+	if err := writeClients(w, *format, list); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Выгружено клиентов: %d из снимка %s\n", len(list), name)
+	return nil
+}
+
+// migrateCommand применяет миграции данных; с -status только показывает
+// версию данных и недостающие миграции.
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := fs.Bool("status", false, "только показать версию данных")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openOffline(); err != nil {
+		return err
+	}
+	st, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if *status {
+		fmt.Printf("Версия данных: %d, выпуск ждет: %d\n", st.Version, latestMigration())
+		for _, m := range pendingMigrations(st) {
+			fmt.Printf("  не применена: %d %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+	done, err := applyMigrations(context.Background(), st)
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Printf("Данные уже в версии %d\n", latestMigration())
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxClientBatch ограничивает число элементов в одном пакетном запросе.
+const maxClientBatch = 1000
+
+// Режимы пакетных запросов (?mode=):
+//
+//	atomic  — все или ничего: при первой же ошибке хранилище не меняется
+//	partial — каждый элемент обрабатывается отдельно
+const (
+	batchModeAtomic  = "atomic"
+	batchModePartial = "partial"
+)
+
+// Статусы элементов пакета.
+const (
+	itemCreated  = "created"
+	itemDeleted  = "deleted"
+	itemUpdated  = "updated"
+	itemFailed   = "failed"
+	itemNotFound = "not_found"
+	itemSkipped  = "skipped" // элемент корректен, но пакет atomic отклонен
+)
+
+// batchItemResult — итог обработки одного элемента пакета.
+type batchItemResult struct {
+	Index  int    `json:"index"`
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResult — ответ пакетного запроса.
+type batchResult struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Items     []batchItemResult `json:"items"`
+}
+
+// parseBatchMode читает ?mode=; по умолчанию atomic.
+func parseBatchMode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", batchModeAtomic:
+		return batchModeAtomic, true
+	case batchModePartial:
+		return batchModePartial, true
+	default:
+		http.Error(w, "Укажите ?mode=atomic или ?mode=partial", http.StatusBadRequest)
+		return "", false
+	}
+}
+
+// decodeBatch читает массив элементов пакета из тела запроса.
+func decodeBatch[T any](w http.ResponseWriter, r *http.Request) ([]T, bool) {
+	var items []T
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&items); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса: ожидается массив", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(items) == 0 {
+		http.Error(w, "Пустой пакет", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(items) > maxClientBatch {
+		http.Error(w, "Слишком много элементов в пакете", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return items, true
+}
+
+// writeBatchResult отвечает 200, если все элементы обработаны, 207 при
+// частичном успехе в режиме partial и 422, если не прошел ни один.
+func writeBatchResult(w http.ResponseWriter, res batchResult, okStatus int) {
+	status := okStatus
+	switch {
+	case res.Failed > 0 && res.Succeeded > 0:
+		status = http.StatusMultiStatus
+	case res.Failed > 0:
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// batchCreateHandler добавляет массив клиентов: POST /clients/batch.
+func batchCreateHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := parseBatchMode(w, r)
+	if !ok {
+		return
+	}
+	list, ok := decodeBatch[Client](w, r)
+	if !ok {
+		return
+	}
+	tenant := requestTenant(r)
+
+	res := batchResult{Mode: mode, Items: make([]batchItemResult, len(list))}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	// Сначала проверяются все элементы, затем в хранилище записываются
+	// корректные; в режиме atomic — только если корректны все.
+	seen := make(map[int]bool, len(list))
+	nextID := nextClientIDLocked()
+	for i, c := range list {
+		c.Tenant = tenant
+		if tenant != "" { // как в createClientLocked
+			c.ID = nextID
+			nextID++
+		}
+		item := batchItemResult{Index: i, ID: c.ID, Status: itemCreated}
+		var err error
+		if list[i], err = prepareNewClientLocked(c); err != nil {
+			item.Status, item.Error = itemFailed, err.Error()
+		} else if seen[c.ID] {
+			item.Status, item.Error = itemFailed, "ID повторяется в пакете"
+		}
+		seen[c.ID] = true
+		if item.Status == itemFailed {
+			res.Failed++
+		}
+		res.Items[i] = item
+	}
+
+	if mode == batchModeAtomic && res.Failed > 0 {
+		for i := range res.Items {
+			if res.Items[i].Status == itemCreated {
+				res.Items[i].Status = itemSkipped
+			}
+		}
+		writeBatchResult(w, res, http.StatusCreated)
+		return
+	}
+
+	for i, c := range list {
+		if res.Items[i].Status != itemCreated {
+			continue
+		}
+		insertClientLocked(c, sourceBatch)
+		res.Succeeded++
+	}
+	writeBatchResult(w, res, http.StatusCreated)
+}
+
+// batchDeleteHandler мягко удаляет клиентов по массиву ID: DELETE /clients/batch.
+func batchDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := parseBatchMode(w, r)
+	if !ok {
+		return
+	}
+	ids, ok := decodeBatch[int](w, r)
+	if !ok {
+		return
+	}
+	tenant := requestTenant(r)
+
+	now := time.Now()
+	res := batchResult{Mode: mode, Items: make([]batchItemResult, len(ids))}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	seen := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		item := batchItemResult{Index: i, ID: id, Status: itemDeleted}
+		if c, exists := tenantClientLocked(tenant, id); !exists || c.deleted() {
+			item.Status, item.Error = itemNotFound, "клиент не найден"
+		} else if seen[id] {
+			item.Status, item.Error = itemFailed, "ID повторяется в пакете"
+		}
+		seen[id] = true
+		if item.Status != itemDeleted {
+			res.Failed++
+		}
+		res.Items[i] = item
+	}
+
+	if mode == batchModeAtomic && res.Failed > 0 {
+		for i := range res.Items {
+			if res.Items[i].Status == itemDeleted {
+				res.Items[i].Status = itemSkipped
+			}
+		}
+		writeBatchResult(w, res, http.StatusOK)
+		return
+	}
+
+	for i, id := range ids {
+		if res.Items[i].Status != itemDeleted {
+			continue
+		}
+		softDeleteLocked(tenant, id, now, sourceBatch)
+		res.Succeeded++
+	}
+	writeBatchResult(w, res, http.StatusOK)
+}
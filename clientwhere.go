@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Условные пакетные операции: клиенты выбираются тем же фильтром, что и в
+// списке (/getClients), а не массивом ID. POST /clients/updateWhere меняет
+// выбранных, DELETE /clients/where мягко удаляет. Операция выполняется
+// целиком под clientsMu: отбор и изменения не перемежаются с другими
+// записями, и при ошибке хотя бы у одного клиента хранилище не меняется.
+// ?dryRun=true только считает, кого операция затронет. Мягко удаленные
+// клиенты не выбираются.
+
+// whereUpdate — изменения POST /clients/updateWhere; применяются заданные
+// поля.
+type whereUpdate struct {
+	FavCoffee  *string  `json:"favCoffee,omitempty"` // "" — убрать любимый кофе
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+}
+
+// whereResult — ответ условной операции.
+type whereResult struct {
+	DryRun   bool  `json:"dryRun"`
+	Matched  int   `json:"matched"`  // подошли под фильтр
+	Affected int   `json:"affected"` // изменены; с dryRun — были бы изменены
+	IDs      []int `json:"ids"`      // затронутые по возрастанию
+}
+
+// parseWhereRequest читает фильтр и ?dryRun= условной операции.
+func parseWhereRequest(w http.ResponseWriter, r *http.Request) (clientFilter, bool, bool) {
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return f, false, false
+	}
+	if f.IncludeDeleted {
+		http.Error(w, "includeDeleted не поддерживается в условных операциях", http.StatusBadRequest)
+		return f, false, false
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "dryRun: ожидается true или false", http.StatusBadRequest)
+			return f, false, false
+		}
+	}
+	return f, dryRun, true
+}
+
+// matchClientsLocked возвращает ID подходящих под фильтр клиентов по
+// возрастанию. Вызывается под clientsMu.
+func matchClientsLocked(f clientFilter) []int {
+	ids := []int{}
+	for id, c := range clients {
+		if f.match(c) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// updateWhereHandler меняет клиентов, подходящих под фильтр:
+// POST /clients/updateWhere?city=Алматы с телом {"addTags": ["almaty"]}.
+// Версия растет только у клиентов, которые изменились.
+func updateWhereHandler(w http.ResponseWriter, r *http.Request) {
+	f, dryRun, ok := parseWhereRequest(w, r)
+	if !ok {
+		return
+	}
+	var upd whereUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&upd); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	if upd.FavCoffee == nil && len(upd.AddTags) == 0 && len(upd.RemoveTags) == 0 {
+		http.Error(w, "Не указано ни одного изменения", http.StatusBadRequest)
+		return
+	}
+	add, err := normalizeTags(upd.AddTags)
+	if err == nil {
+		upd.RemoveTags, err = normalizeTags(upd.RemoveTags)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	// Сначала изменения считаются для всех клиентов, затем записываются.
+	matched := matchClientsLocked(f)
+	res := whereResult{DryRun: dryRun, Matched: len(matched), IDs: []int{}}
+	changed := make([]Client, 0, len(matched))
+	for _, id := range matched {
+		c := clients[id]
+		next := c
+		if upd.FavCoffee != nil {
+			coffee, err := menuCoffeeLocked(id, *upd.FavCoffee)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			next.FavCoffee = coffee
+		}
+		tags := slices.DeleteFunc(append(slices.Clone(c.Tags), add...), func(t string) bool {
+			return slices.Contains(upd.RemoveTags, t)
+		})
+		if next.Tags, err = normalizeTags(tags); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if next.FavCoffee == c.FavCoffee && slices.Equal(next.Tags, c.Tags) {
+			continue
+		}
+		changed = append(changed, next)
+		res.IDs = append(res.IDs, id)
+	}
+	res.Affected = len(changed)
+
+	if !dryRun {
+		for _, c := range changed {
+			c.Version++
+			clients[c.ID] = c
+			publishClientEvent(eventClientUpdated, c, sourceBatch)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// deleteWhereHandler мягко удаляет клиентов, подходящих под фильтр:
+// DELETE /clients/where. Без условий отбора запрос отклоняется, чтобы не
+// удалить всех клиентов кофейни по ошибке.
+func deleteWhereHandler(w http.ResponseWriter, r *http.Request) {
+	f, dryRun, ok := parseWhereRequest(w, r)
+	if !ok {
+		return
+	}
+	if f.empty() {
+		http.Error(w, "Укажите хотя бы одно условие отбора", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	ids := matchClientsLocked(f)
+	res := whereResult{DryRun: dryRun, Matched: len(ids), Affected: len(ids), IDs: ids}
+	if !dryRun {
+		for _, id := range ids {
+			softDeleteLocked(f.Tenant, id, now, sourceBatch)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
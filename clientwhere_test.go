@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// whereClients — клиенты для проверки условных операций: двое из Алматы
+// и по одному из Астаны и другой кофейни.
+func whereClients() map[int]Client {
+	return map[int]Client{
+		1: {ID: 1, Name: "Айгерим", Version: 1, Address: Address{City: "Алматы"}},
+		2: {ID: 2, Name: "Дана", Version: 1, Address: Address{City: "Алматы"}, Tags: []string{"almaty"}},
+		3: {ID: 3, Name: "Ерлан", Version: 1, Address: Address{City: "Астана"}},
+		4: {ID: 4, Name: "Нурлан", Version: 1, Address: Address{City: "Алматы"}, Tenant: "north"},
+	}
+}
+
+func TestUpdateWhere(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		want     int
+		affected []int
+		versions map[int]int // версии после запроса
+	}{
+		{"добавить метку", "/clients/updateWhere?city=Алматы", `{"addTags":["almaty"]}`, http.StatusOK,
+			[]int{1}, map[int]int{1: 2, 2: 1, 3: 1, 4: 1}},
+		{"только подсчет", "/clients/updateWhere?city=Алматы&dryRun=true", `{"addTags":["almaty"]}`, http.StatusOK,
+			[]int{1}, map[int]int{1: 1, 2: 1, 3: 1, 4: 1}},
+		{"убрать метку", "/clients/updateWhere", `{"removeTags":["almaty"]}`, http.StatusOK,
+			[]int{2}, map[int]int{1: 1, 2: 2, 3: 1, 4: 1}},
+		{"без изменений", "/clients/updateWhere?city=Алматы", `{}`, http.StatusBadRequest,
+			nil, map[int]int{1: 1, 2: 1, 3: 1, 4: 1}},
+		{"неверный dryRun", "/clients/updateWhere?dryRun=да", `{"addTags":["x"]}`, http.StatusBadRequest,
+			nil, map[int]int{1: 1, 2: 1, 3: 1, 4: 1}},
+		{"удаленные не выбираются", "/clients/updateWhere?includeDeleted=true", `{"addTags":["x"]}`, http.StatusBadRequest,
+			nil, map[int]int{1: 1, 2: 1, 3: 1, 4: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			clients = whereClients()
+			w := testRequest(http.HandlerFunc(updateWhereHandler), http.MethodPost, tt.target, "", tt.body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK {
+				var res whereResult
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(res.IDs, tt.affected) || res.Affected != len(tt.affected) {
+					t.Errorf("затронуты %v (%d), ожидались %v", res.IDs, res.Affected, tt.affected)
+				}
+			}
+			for id, v := range tt.versions {
+				if got := clients[id].Version; got != v {
+					t.Errorf("клиент %d: версия %d, ожидалась %d", id, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    int
+		deleted []int
+	}{
+		{"по городу", "/clients/where?city=Алматы", http.StatusOK, []int{1, 2}},
+		{"только подсчет", "/clients/where?city=Алматы&dryRun=true", http.StatusOK, nil},
+		{"без условий", "/clients/where", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			clients = whereClients()
+			w := testRequest(http.HandlerFunc(deleteWhereHandler), http.MethodDelete, tt.target, "", "", nil)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			var deleted []int
+			for id := 1; id <= len(clients); id++ {
+				if clients[id].deleted() {
+					deleted = append(deleted, id)
+				}
+			}
+			if !slices.Equal(deleted, tt.deleted) {
+				t.Errorf("удалены %v, ожидались %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
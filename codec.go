@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Форматы тел эндпоинтов клиентов. Ответ выбирается по Accept, тело
+// запроса читается по Content-Type; без заголовков — JSON, на старых
+// эндпоинтах — и с неизвестным Content-Type.
+
+// codec — формат тела.
+type codec struct {
+	ContentType string
+	Marshal     func(v any) ([]byte, error)
+	Unmarshal   func(data []byte, v any) error
+}
+
+var (
+	jsonCodec    = codec{"application/json", json.Marshal, json.Unmarshal}
+	xmlCodec     = codec{"application/xml", marshalClientXML, xml.Unmarshal}
+	msgpackCodec = codec{"application/msgpack", marshalMsgpack, unmarshalMsgpack}
+	protoCodec   = codec{"application/x-protobuf", marshalClientsProto, unmarshalClientsProto}
+)
+
+// codecs — поддерживаемые форматы; первый используется по умолчанию.
+var codecs = []codec{jsonCodec, xmlCodec, msgpackCodec, protoCodec}
+
+// codecAliases — другие названия тех же форматов.
+var codecAliases = map[string]string{
+	"text/xml":                "application/xml",
+	"application/x-msgpack":   "application/msgpack",
+	"application/vnd.msgpack": "application/msgpack",
+	"application/protobuf":    "application/x-protobuf",
+}
+
+func codecFor(mediaType string) (codec, bool) {
+	if alias, ok := codecAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	for _, c := range codecs {
+		if c.ContentType == mediaType {
+			return c, true
+		}
+	}
+	return codec{}, false
+}
+
+// negotiateCodec выбирает формат ответа по Accept с учетом q; при равных q
+// побеждает порядок в codecs.
+func negotiateCodec(accept string) (codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], true
+	}
+	best, bestQ := codec{}, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var c codec
+		switch {
+		case mediaType == "*/*" || mediaType == "application/*":
+			c = codecs[0]
+		default:
+			var ok bool
+			if c, ok = codecFor(mediaType); !ok {
+				continue
+			}
+		}
+		if q > bestQ || q == bestQ && q > 0 && codecIndex(c) < codecIndex(best) {
+			best, bestQ = c, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func codecIndex(c codec) int {
+	for i, known := range codecs {
+		if known.ContentType == c.ContentType {
+			return i
+		}
+	}
+	return len(codecs)
+}
+
+// unsupportedBodyTypes — структурированные форматы, которых нет в codecs;
+// на них 415 и на старых эндпоинтах.
+var unsupportedBodyTypes = map[string]bool{
+	"multipart/form-data": true,
+	"application/yaml":    true,
+	"application/x-yaml":  true,
+	"text/yaml":           true,
+	"application/cbor":    true,
+	"text/csv":            true,
+}
+
+// requestCodec выбирает формат тела запроса по Content-Type; false —
+// формат не поддерживается. Старые эндпоинты (legacy) принимали JSON с
+// любым заголовком, например form-urlencoded от curl -d, поэтому на них
+// неизвестный или неразбираемый тип читается как JSON, а отказ получают
+// только структурированные форматы: из unsupportedBodyTypes и с суффиксом
+// вроде +yaml.
+func requestCodec(contentType string, legacy bool) (codec, bool) {
+	if strings.TrimSpace(contentType) == "" {
+		return codecs[0], true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if c, ok := codecFor(mediaType); ok {
+			return c, true
+		}
+		switch {
+		case strings.HasSuffix(mediaType, "+json"):
+			return jsonCodec, true
+		case strings.HasSuffix(mediaType, "+xml"):
+			return xmlCodec, true
+		}
+	}
+	if !legacy || err == nil && (unsupportedBodyTypes[mediaType] || strings.Contains(mediaType, "+")) {
+		return codec{}, false
+	}
+	return codecs[0], true
+}
+
+// decodeRequest читает тело запроса в формате из Content-Type (см.
+// requestCodec). Тело ограничено maxImportSize, как JSON в
+// withSchemaValidation. При ошибке отвечает 415, 413 или 400 и возвращает
+// false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any, legacy bool) bool {
+	ct := r.Header.Get("Content-Type")
+	c, ok := requestCodec(ct, legacy)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Неподдерживаемый Content-Type %q", ct), http.StatusUnsupportedMediaType)
+		return false
+	}
+	var body bytes.Buffer
+	_, err := body.ReadFrom(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Тело запроса больше %d МБ", maxImportSize>>20), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+		return false
+	}
+	if err := c.Unmarshal(body.Bytes(), v); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// responseCodec выбирает формат ответа. Если ни один не подходит,
+// отвечает 406 и возвращает false.
+func responseCodec(w http.ResponseWriter, r *http.Request) (codec, bool) {
+	w.Header().Add("Vary", "Accept")
+	c, ok := negotiateCodec(r.Header.Get("Accept"))
+	if !ok {
+		types := make([]string, len(codecs))
+		for i, c := range codecs {
+			types[i] = c.ContentType
+		}
+		http.Error(w, "Поддерживаются форматы: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+	}
+	return c, ok
+}
+
+// writeResponse отдает v в формате из Accept.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	c, ok := responseCodec(w, r)
+	if !ok {
+		return
+	}
+	body, err := c.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// XML
+
+// xmlClientList — список клиентов в XML: <clients><client>…</client></clients>.
+type xmlClientList struct {
+	XMLName xml.Name `xml:"clients"`
+	Clients []Client `xml:"client"`
+}
+
+// marshalClientXML кодирует клиента как <client> и список клиентов по ID
+// (ответ /getClients) как <clients> по возрастанию ID.
+func marshalClientXML(v any) ([]byte, error) {
+	var doc any
+	switch v := v.(type) {
+	case Client:
+		doc = struct {
+			XMLName xml.Name `xml:"client"`
+			Client
+		}{Client: v.withCurrentAge()}
+	case shapedClient:
+		doc = v
+	case map[int]shapedClient:
+		list := struct {
+			XMLName xml.Name       `xml:"clients"`
+			Clients []shapedClient `xml:"client"`
+		}{Clients: make([]shapedClient, 0, len(v))}
+		for _, c := range v {
+			list.Clients = append(list.Clients, c)
+		}
+		sort.Slice(list.Clients, func(i, j int) bool { return list.Clients[i].ID < list.Clients[j].ID })
+		doc = list
+	case map[int]Client:
+		list := xmlClientList{Clients: make([]Client, 0, len(v))}
+		for _, c := range v {
+			list.Clients = append(list.Clients, c.withCurrentAge())
+		}
+		sort.Slice(list.Clients, func(i, j int) bool { return list.Clients[i].ID < list.Clients[j].ID })
+		doc = list
+	default:
+		return nil, fmt.Errorf("XML: тип %T не поддерживается", v)
+	}
+	body, err := xml.Marshal(doc)
+	return append([]byte(xml.Header), body...), err
+}
+
+// Protobuf: сообщения Client и ClientList из proto/client.proto.
+
+func marshalClientsProto(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case Client:
+		return marshalClientProto(v), nil
+	case map[int]Client:
+		list := make([]Client, 0, len(v))
+		for _, c := range v {
+			list = append(list, c)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		var b []byte
+		for _, c := range list {
+			b = protoAppendBytes(b, 1, marshalClientProto(c))
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("protobuf: тип %T не поддерживается", v)
+}
+
+func unmarshalClientsProto(data []byte, v any) error {
+	c, ok := v.(*Client)
+	if !ok {
+		return fmt.Errorf("protobuf: тип %T не поддерживается", v)
+	}
+	var err error
+	*c, err = unmarshalClientProto(data)
+	return err
+}
+
+// MessagePack. Значение проходит через то же представление, что и JSON
+// (имена полей, время в RFC 3339), поэтому форматы взаимозаменяемы.
+
+func marshalMsgpack(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, generic), nil
+}
+
+func unmarshalMsgpack(data []byte, v any) error {
+	generic, rest, err := readMsgpack(data, 0)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errMsgpackMalformed
+	}
+	js, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+func appendMsgpack(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, n)
+		}
+		f, _ := v.Float64()
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= 0xff:
+			b = append(b, 0xd9, byte(n))
+		case n <= 0xffff:
+			b = append(b, 0xda, byte(n>>8), byte(n))
+		default:
+			b = append(b, 0xdb)
+			b = binary.BigEndian.AppendUint32(b, uint32(n))
+		}
+		return append(b, v...)
+	case []any:
+		b = appendMsgpackLen(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, e := range v {
+			b = appendMsgpack(b, e)
+		}
+		return b
+	case map[string]any:
+		b = appendMsgpackLen(b, len(v), 0x80, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = appendMsgpack(b, k)
+			b = appendMsgpack(b, v[k])
+		}
+		return b
+	}
+	panic(fmt.Sprintf("msgpack: тип %T", v)) // json.Decoder других типов не выдает
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n < 128:
+		return append(b, byte(n))
+	case n < 0 && n >= -32:
+		return append(b, byte(n))
+	case n >= -1<<31 && n < 1<<31:
+		b = append(b, 0xd2)
+		return binary.BigEndian.AppendUint32(b, uint32(n))
+	default:
+		b = append(b, 0xd3)
+		return binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+}
+
+// appendMsgpackLen пишет заголовок массива или словаря: fix-форма, 16 или 32 бита.
+func appendMsgpackLen(b []byte, n int, fix, b16, b32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= 0xffff:
+		return append(b, b16, byte(n>>8), byte(n))
+	default:
+		b = append(b, b32)
+		return binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+}
+
+var errMsgpackMalformed = errors.New("неверный формат MessagePack")
+
+// msgpackMaxDepth ограничивает вложенность, чтобы тело не исчерпало стек.
+const msgpackMaxDepth = 32
+
+// msgpackSizes — размер длины или значения, идущего сразу за байтом типа.
+var msgpackSizes = map[byte]int{
+	0xc4: 1, 0xc5: 2, 0xc6: 4, // bin
+	0xca: 4, 0xcb: 8, // float
+	0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8, // uint
+	0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8, // int
+	0xd9: 1, 0xda: 2, 0xdb: 4, // str
+	0xdc: 2, 0xdd: 4, // array
+	0xde: 2, 0xdf: 4, // map
+}
+
+// readMsgpack читает одно значение и возвращает остаток данных.
+func readMsgpack(b []byte, depth int) (any, []byte, error) {
+	if len(b) == 0 || depth > msgpackMaxDepth {
+		return nil, nil, errMsgpackMalformed
+	}
+	t, b := b[0], b[1:]
+	switch {
+	case t <= 0x7f:
+		return int64(t), b, nil
+	case t >= 0xe0:
+		return int64(int8(t)), b, nil
+	case t&0xf0 == 0x80:
+		return readMsgpackMap(b, int(t&0x0f), depth)
+	case t&0xf0 == 0x90:
+		return readMsgpackArray(b, int(t&0x0f), depth)
+	case t&0xe0 == 0xa0:
+		return readMsgpackString(b, int(t&0x1f))
+	}
+
+	switch t {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	}
+	size, ok := msgpackSizes[t]
+	if !ok || len(b) < size {
+		return nil, nil, errMsgpackMalformed
+	}
+	var u uint64
+	for _, c := range b[:size] {
+		u = u<<8 | uint64(c)
+	}
+	b = b[size:]
+	switch t {
+	case 0xca:
+		return float64(math.Float32frombits(uint32(u))), b, nil
+	case 0xcb:
+		return math.Float64frombits(u), b, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return u, b, nil
+	case 0xd0:
+		return int64(int8(u)), b, nil
+	case 0xd1:
+		return int64(int16(u)), b, nil
+	case 0xd2:
+		return int64(int32(u)), b, nil
+	case 0xd3:
+		return int64(u), b, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		return readMsgpackString(b, int(u))
+	case 0xdc, 0xdd:
+		return readMsgpackArray(b, int(u), depth)
+	default:
+		return readMsgpackMap(b, int(u), depth)
+	}
+}
+
+func readMsgpackString(b []byte, n int) (any, []byte, error) {
+	if n < 0 || len(b) < n {
+		return nil, nil, errMsgpackMalformed
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func readMsgpackArray(b []byte, n, depth int) (any, []byte, error) {
+	if n < 0 || n > len(b) { // каждый элемент занимает хотя бы байт
+		return nil, nil, errMsgpackMalformed
+	}
+	list := make([]any, n)
+	for i := range list {
+		var err error
+		if list[i], b, err = readMsgpack(b, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return list, b, nil
+}
+
+func readMsgpackMap(b []byte, n, depth int) (any, []byte, error) {
+	if n < 0 || 2*n > len(b) {
+		return nil, nil, errMsgpackMalformed
+	}
+	m := make(map[string]any, n)
+	for range n {
+		k, rest, err := readMsgpack(b, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		var v any
+		if v, b, err = readMsgpack(rest, depth+1); err != nil {
+			return nil, nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, b, nil
+}
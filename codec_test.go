@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestCodec(t *testing.T) {
+	tests := []struct {
+		contentType string
+		legacy      bool
+		want        string // ContentType выбранного формата; "" — 415
+	}{
+		{"", false, "application/json"},
+		{"", true, "application/json"},
+		{"application/json", false, "application/json"},
+		{"application/json; charset=utf-8", false, "application/json"},
+		{"text/xml", false, "application/xml"},
+		{"application/x-msgpack", false, "application/msgpack"},
+		{"application/protobuf", false, "application/x-protobuf"},
+		{"application/merge-patch+json", false, "application/json"},
+		{"application/atom+xml", false, "application/xml"},
+		{"application/x-www-form-urlencoded", false, ""},
+		{"application/x-www-form-urlencoded", true, "application/json"},
+		{"text/plain", true, "application/json"},
+		{"not a type;;", false, ""},
+		{"not a type;;", true, "application/json"},
+		{"text/csv", true, ""},
+		{"multipart/form-data; boundary=x", true, ""},
+		{"application/vnd.api+yaml", true, ""},
+		{"application/yaml", false, ""},
+	}
+	for _, tt := range tests {
+		c, ok := requestCodec(tt.contentType, tt.legacy)
+		got := ""
+		if ok {
+			got = c.ContentType
+		}
+		if got != tt.want {
+			t.Errorf("requestCodec(%q, %v) = %q, ожидался %q", tt.contentType, tt.legacy, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		legacy      bool
+		want        int // 0 — тело прочитано
+	}{
+		{"JSON", "application/json", `{"id":1,"name":"Айгерим"}`, false, 0},
+		{"curl -d", "application/x-www-form-urlencoded", `{"id":1,"name":"Айгерим"}`, true, 0},
+		{"форма на новом эндпоинте", "application/x-www-form-urlencoded", `{"id":1,"name":"Айгерим"}`, false, http.StatusUnsupportedMediaType},
+		{"XML", "application/xml", `<client><id>1</id><name>Айгерим</name></client>`, false, 0},
+		{"битый JSON", "application/json", `{"id":`, true, http.StatusBadRequest},
+		{"CSV", "text/csv", "1,Айгерим", true, http.StatusUnsupportedMediaType},
+		{"XML больше предела", "application/xml", "<client><name>" + strings.Repeat("a", maxImportSize) + "</name></client>", false, http.StatusRequestEntityTooLarge},
+		{"msgpack больше предела", "application/msgpack", strings.Repeat("a", maxImportSize+1), false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/addClient", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			var c Client
+			ok := decodeRequest(w, r, &c, tt.legacy)
+			if tt.want == 0 {
+				if !ok {
+					t.Fatalf("тело не прочитано: %d %s", w.Code, w.Body)
+				}
+				if c.ID != 1 || c.Name != "Айгерим" {
+					t.Errorf("прочитано %+v", c)
+				}
+				return
+			}
+			if ok || w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// TestCodecRoundTrip проверяет, что клиент переживает запись и чтение в
+// каждом формате.
+func TestCodecRoundTrip(t *testing.T) {
+	want := Client{ID: 7, Name: "Дана", Age: 28, FavCoffee: "Латте", Tags: []string{"vip"}, Version: 2}
+	for _, c := range codecs {
+		t.Run(c.ContentType, func(t *testing.T) {
+			data, err := c.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Client
+			if err := c.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != want.ID || got.Name != want.Name || got.Age != want.Age ||
+				got.FavCoffee != want.FavCoffee || got.Version != want.Version ||
+				len(got.Tags) != 1 || got.Tags[0] != "vip" {
+				t.Errorf("прочитано %+v, ожидалось %+v", got, want)
+			}
+		})
+	}
+}
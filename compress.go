@@ -0,0 +1,187 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionConfig задает сжатие ответов. Ответы короче MinSize
+// отправляются как есть: на них сжатие только добавляет накладные расходы.
+type CompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	MinSize int  `json:"minSize"`
+}
+
+var (
+	gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibPool = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// resetWriter — общий интерфейс gzip.Writer и zlib.Writer.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// negotiateEncoding выбирает кодировку по Accept-Encoding: gzip, затем deflate.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[enc]; ok || (!listed && accepted["*"]) {
+			return enc
+		}
+	}
+	return ""
+}
+
+// incompressible сообщает, что содержимое уже сжато или передается потоком.
+func incompressible(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return (strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg")) ||
+		strings.HasPrefix(ct, "video/") ||
+		strings.HasPrefix(ct, "text/event-stream") ||
+		strings.Contains(ct, "zip") ||
+		strings.Contains(ct, "openxmlformats") ||
+		strings.Contains(ct, "gzip")
+}
+
+// compressWriter копит начало ответа до MinSize и только потом решает,
+// сжимать ли его.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	cw      resetWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		h := w.Header()
+		if h.Get("Content-Encoding") != "" || incompressible(h.Get("Content-Type")) ||
+			w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+			w.status == http.StatusPartialContent {
+			w.startRaw()
+		} else {
+			w.buf = append(w.buf, p...)
+			if len(w.buf) >= w.minSize {
+				if err := w.startCompressed(); err != nil {
+					return 0, err
+				}
+			}
+			return len(p), nil
+		}
+	}
+	if w.cw != nil {
+		return w.cw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) startRaw() error {
+	w.decided = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) startCompressed() error {
+	w.decided = true
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if w.encoding == "gzip" {
+		w.cw = gzipPool.Get().(*gzip.Writer)
+	} else {
+		w.cw = zlibPool.Get().(*zlib.Writer)
+	}
+	w.cw.Reset(w.ResponseWriter)
+	_, err := w.cw.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Flush отправляет накопленное; до порога ответ уходит несжатым.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.startRaw()
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap дает http.ResponseController доступ к исходному writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.startRaw()
+	}
+	if w.cw == nil {
+		return
+	}
+	w.cw.Close()
+	switch cw := w.cw.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(cw)
+	case *zlib.Writer:
+		zlibPool.Put(cw)
+	}
+	w.cw = nil
+}
+
+// compress прозрачно сжимает ответы, если клиент это поддерживает.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := config.Compression
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !c.Enabled || enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: c.MinSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
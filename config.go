@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Config содержит настройки сервера.
+type Config struct {
+	Addr        string            `json:"addr"` // TCP; пусто — только listen.socket или systemd
+	Listen      ListenConfig      `json:"listen"`
+	DataDir     string            `json:"dataDir"` // каталог для файлов состояния
+	Batch       BatchConfig       `json:"batch"`
+	Auth        AuthConfig        `json:"auth"`
+	Onboarding  OnboardingConfig  `json:"onboarding"`
+	RateLimit   RateLimitConfig   `json:"rateLimit"`
+	Quotas      QuotaConfig       `json:"quotas"`
+	Proxy       ProxyConfig       `json:"proxy"`
+	CORS        CORSConfig        `json:"cors"`
+	Compression CompressionConfig `json:"compression"`
+	Status      StatusConfig      `json:"status"`
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	Locks       LockConfig        `json:"locks"`
+	Journal     JournalConfig     `json:"journal"`
+	Webhooks    WebhookConfig     `json:"webhooks"`
+	GRPC        GRPCConfig        `json:"grpc"`
+	Templates   TemplatesConfig   `json:"templates"`
+	Sessions    SessionConfig     `json:"sessions"`
+	I18n        I18nConfig        `json:"i18n"`
+	Blobs       BlobConfig        `json:"blobs"`
+	Loyalty     LoyaltyConfig     `json:"loyalty"`
+	Scheduler   SchedulerConfig   `json:"scheduler"`
+	Email       EmailConfig       `json:"email"`
+	Telegram    TelegramConfig    `json:"telegram"`
+	Queue       QueueConfig       `json:"queue"`
+	Encryption  EncryptionConfig  `json:"encryption"`
+	Retention   RetentionConfig   `json:"retention"`
+	Geocoding   GeocodingConfig   `json:"geocoding"`
+	Cache       CacheConfig       `json:"cache"`
+	Debug       DebugConfig       `json:"debug"`
+	Shutdown    ShutdownConfig    `json:"shutdown"`
+	Log         LogConfig         `json:"log"`
+	Replica     ReplicaConfig     `json:"replica"`
+	// ErrorReporting отправляет паники и ошибки в Sentry.
+	ErrorReporting ErrorReportingConfig `json:"errorReporting"`
+	// OffsiteBackup — ночные зашифрованные снимки во внешнем бакете S3.
+	OffsiteBackup OffsiteConfig `json:"offsiteBackup"`
+	// Validation — проверка тел запросов по схемам /schemas/.
+	Validation ValidationConfig `json:"validation"`
+	// Outbound — повторы и размыкание цепи запросов к внешним сервисам.
+	Outbound OutboundConfig `json:"outbound"`
+	// Archive — архив окончательно удаленных клиентов.
+	Archive ArchiveConfig `json:"archive"`
+	// CRM — синхронизация клиентов из прежней CRM.
+	CRM CRMConfig `json:"crm"`
+}
+
+// AuthConfig содержит настройки аутентификации.
+type AuthConfig struct {
+	JWTSecret string   `json:"jwtSecret"`
+	TokenTTL  Duration `json:"tokenTTL"`
+	Users     []User   `json:"users"`
+	// Require2FA — роли, которым вход без двухфакторной аутентификации запрещен.
+	Require2FA []Role `json:"require2FA"`
+}
+
+// User описывает учетную запись, которой разрешено получать токены.
+// Без явной роли пользователь получает права только на чтение.
+type User struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+	Tenant   string `json:"tenant,omitempty"` // кофейня; пусто — основная, администратору все через X-Tenant-ID
+}
+
+// Duration — time.Duration, которая читается из строки вида "15m".
+type Duration time.Duration
+
+// UnmarshalJSON разбирает длительность из строки.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON записывает длительность строкой.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfig возвращает настройки по умолчанию.
+func defaultConfig() Config {
+	return Config{
+		Addr:    ":8090",
+		Listen:  ListenConfig{SocketMode: "0660", Systemd: true},
+		DataDir: "data",
+		Batch: BatchConfig{
+			ChunkSize:     500,
+			ChunkInterval: Duration(100 * time.Millisecond),
+		},
+		Auth: AuthConfig{
+			TokenTTL: Duration(time.Hour),
+		},
+		Onboarding: defaultOnboarding(),
+		RateLimit: RateLimitConfig{
+			RPS:         10,
+			Burst:       20,
+			APIKeyRPS:   50,
+			APIKeyBurst: 100,
+		},
+		CORS:        defaultCORS(),
+		Compression: CompressionConfig{Enabled: true, MinSize: 1024},
+		Status: StatusConfig{
+			ProbeInterval: Duration(30 * time.Second),
+			HistoryDays:   30,
+		},
+		Idempotency: IdempotencyConfig{TTL: Duration(24 * time.Hour)},
+		Locks:       LockConfig{LeaseTTL: Duration(30 * time.Second)},
+		Journal:     JournalConfig{Enabled: true, MaxEntries: 10000, FlushInterval: Duration(10 * time.Second)},
+		Webhooks: WebhookConfig{
+			MaxAttempts:    8,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(5 * time.Minute),
+			Timeout:        Duration(10 * time.Second),
+		},
+		Templates: TemplatesConfig{Dir: "templates"},
+		Sessions:  SessionConfig{Store: sessionStoreFile, TTL: Duration(30 * 24 * time.Hour)},
+		I18n:      I18nConfig{Dir: "locales", DefaultLocale: sourceLocale},
+		Blobs:     BlobConfig{Backend: blobBackendLocal, S3: S3Config{Timeout: Duration(30 * time.Second)}},
+		Loyalty:   LoyaltyConfig{Percent: 5},
+		Scheduler: SchedulerConfig{Enabled: true},
+		Email:     defaultEmail(),
+		Telegram:  TelegramConfig{APIURL: "https://api.telegram.org", PollTimeout: Duration(30 * time.Second)},
+		Queue: QueueConfig{
+			Workers:        4,
+			Size:           10000,
+			MaxAttempts:    5,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(5 * time.Minute),
+			DrainTimeout:   Duration(10 * time.Second),
+			DeadLetters:    1000,
+		},
+		Encryption: EncryptionConfig{Fields: []string{"name", "email", "address.street"}},
+		Geocoding: GeocodingConfig{
+			URL:      "https://nominatim.openstreetmap.org",
+			Interval: Duration(time.Second),
+			Timeout:  Duration(10 * time.Second),
+		},
+		Cache:          CacheConfig{Enabled: true, TTL: Duration(time.Minute), MaxEntries: 1000},
+		Shutdown:       ShutdownConfig{Timeout: Duration(30 * time.Second), Snapshot: true},
+		Log:            LogConfig{Level: "info", MaxSizeMB: 100, RotateEvery: Duration(24 * time.Hour), MaxBackups: 14, MaxAge: Duration(30 * 24 * time.Hour)},
+		Replica:        ReplicaConfig{Retry: Duration(3 * time.Second)},
+		ErrorReporting: ErrorReportingConfig{Timeout: Duration(5 * time.Second)},
+		OffsiteBackup: OffsiteConfig{
+			S3:         S3Config{Timeout: Duration(5 * time.Minute)},
+			Keep:       30,
+			MaxAge:     Duration(90 * 24 * time.Hour),
+			StaleAfter: Duration(26 * time.Hour),
+		},
+		Outbound: OutboundConfig{
+			Retries:          2,
+			InitialBackoff:   Duration(200 * time.Millisecond),
+			MaxBackoff:       Duration(5 * time.Second),
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration(30 * time.Second),
+		},
+		Archive: ArchiveConfig{Enabled: true, KeepDays: 90},
+		CRM:     CRMConfig{Connector: "csv", ExternalIDColumn: "id", Timeout: Duration(time.Minute)},
+	}
+}
+
+// loadConfig читает конфигурацию из JSON-файла. Если файла нет,
+// используются значения по умолчанию.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("разбор %s: %w", path, err)
+		}
+	}
+	settings, err := readSettings(cfg.DataDir)
+	if err != nil {
+		return cfg, err
+	}
+	settings.apply(&cfg)
+	for i, u := range cfg.Auth.Users {
+		if u.Role == "" {
+			cfg.Auth.Users[i].Role = RoleViewer
+		} else if !u.Role.Valid() {
+			return cfg, fmt.Errorf("пользователь %s: неизвестная роль %q", u.Username, u.Role)
+		}
+	}
+	for _, role := range cfg.Auth.Require2FA {
+		if !role.Valid() {
+			return cfg, fmt.Errorf("auth.require2FA: неизвестная роль %q", role)
+		}
+	}
+	if err := cfg.CORS.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Onboarding.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Email.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Encryption.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Telegram.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Retention.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Archive.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.CRM.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Geocoding.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Replica.validate(cfg.Auth); err != nil {
+		return cfg, err
+	}
+	if err := cfg.OffsiteBackup.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Outbound.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Cache.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Listen.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Proxy.parse(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Quotas.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Log.validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.ErrorReporting.validate(); err != nil {
+		return cfg, err
+	}
+	if cfg.Shutdown.Timeout <= 0 {
+		return cfg, fmt.Errorf("shutdown: timeout должен быть положительным")
+	}
+	if rl := cfg.RateLimit; rl.Enabled && (rl.RPS <= 0 || rl.Burst < 1 || rl.APIKeyRPS <= 0 || rl.APIKeyBurst < 1) {
+		return cfg, fmt.Errorf("rateLimit: скорость и burst должны быть положительными")
+	}
+	if cfg.Batch.ChunkSize < 1 || cfg.Batch.ChunkInterval <= 0 {
+		return cfg, fmt.Errorf("batch: chunkSize и chunkInterval должны быть положительными")
+	}
+	if cfg.Status.ProbeInterval <= 0 || cfg.Status.HistoryDays < 1 {
+		return cfg, fmt.Errorf("status: probeInterval и historyDays должны быть положительными")
+	}
+	if j := cfg.Journal; j.Enabled && (j.MaxEntries < 1 || j.FlushInterval <= 0) {
+		return cfg, fmt.Errorf("journal: maxEntries и flushInterval должны быть положительными")
+	}
+	if wh := cfg.Webhooks; wh.MaxAttempts < 1 || wh.InitialBackoff <= 0 || wh.MaxBackoff < wh.InitialBackoff || wh.Timeout <= 0 {
+		return cfg, fmt.Errorf("webhooks: maxAttempts, паузы и timeout должны быть положительными, maxBackoff не меньше initialBackoff")
+	}
+	if q := cfg.Queue; q.Workers < 1 || q.Size < 1 || q.MaxAttempts < 1 || q.InitialBackoff <= 0 || q.MaxBackoff < q.InitialBackoff || q.DrainTimeout < 0 || q.DeadLetters < 0 {
+		return cfg, fmt.Errorf("queue: workers, size, maxAttempts и паузы должны быть положительными, maxBackoff не меньше initialBackoff")
+	}
+	if time.Duration(cfg.Locks.LeaseTTL) < time.Second {
+		return cfg, fmt.Errorf("locks: leaseTTL должен быть не меньше 1s")
+	}
+	if s := cfg.Sessions; (s.Store != sessionStoreMemory && s.Store != sessionStoreFile) || s.TTL <= 0 {
+		return cfg, fmt.Errorf("sessions: store — memory или file, ttl должен быть положительным")
+	}
+	if p := cfg.Loyalty.Percent; p < 0 || p > 100 {
+		return cfg, fmt.Errorf("loyalty: percent должен быть от 0 до 100")
+	}
+	for name, spec := range cfg.Scheduler.Jobs {
+		if spec == scheduleOff {
+			continue
+		}
+		if _, err := parseSchedule(spec); err != nil {
+			return cfg, fmt.Errorf("scheduler.jobs.%s: %w", name, err)
+		}
+	}
+	switch cfg.Blobs.Backend {
+	case blobBackendLocal:
+	case blobBackendS3:
+		if err := cfg.Blobs.S3.validate(); err != nil {
+			return cfg, err
+		}
+	default:
+		return cfg, fmt.Errorf("blobs: неизвестное хранилище %q", cfg.Blobs.Backend)
+	}
+	return cfg, nil
+}
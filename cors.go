@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig задает, с каких источников браузеру разрешено обращаться к API.
+// Пустой список AllowedOrigins отключает CORS; "*" разрешает любой источник.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           Duration `json:"maxAge"`
+}
+
+func defaultCORS() CORSConfig {
+	return CORSConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", idempotencyHeader, apiKeyHeader, requestIDHeader},
+		MaxAge:         Duration(10 * time.Minute),
+	}
+}
+
+// validate запрещает "*" вместе с allowCredentials: тогда любой сайт мог бы
+// читать ответы от имени вошедшего пользователя.
+func (c CORSConfig) validate() error {
+	if c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		return fmt.Errorf("cors: allowCredentials нельзя включать вместе с allowedOrigins \"*\"")
+	}
+	return nil
+}
+
+func (c CORSConfig) originAllowed(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+// isAPIPath отделяет API от HTML-страницы и статики, которым CORS не нужен.
+func isAPIPath(path string) bool {
+	return path != "/" && !strings.HasPrefix(path, "/static/")
+}
+
+// cors добавляет CORS-заголовки к ответам API и отвечает на preflight-запросы.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := liveConfig().CORS
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(c.AllowedOrigins) == 0 || !isAPIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !c.originAllowed(origin) {
+			if preflight {
+				http.Error(w, "Источник не разрешен", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// С "*" источник не повторяется, а cookie и заголовки входа браузер
+		// не отправляет, даже если в конфигурацию попал allowCredentials.
+		if slices.Contains(c.AllowedOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			if c.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", "ETag, Retry-After, "+requestIDHeader)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !slices.Contains(c.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+			http.Error(w, "Метод не разрешен", http.StatusForbidden)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(c.MaxAge).Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCORSWildcardCredentials проверяет, что "*" вместе с allowCredentials
+// не проходит проверку конфигурации и не дает чужим сайтам читать ответы
+// с cookie.
+func TestCORSWildcardCredentials(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	data := `{"dataDir": ` + strconv.Quote(dir) + `, "cors": {"allowedOrigins": ["*"], "allowCredentials": true}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path); err == nil || !strings.HasPrefix(err.Error(), "cors:") {
+		t.Errorf("ошибка %v, ожидалась ошибка cors", err)
+	}
+
+	tests := []struct {
+		name            string
+		cors            CORSConfig
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"любой источник", CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "*", ""},
+		{"список источников", CORSConfig{AllowedOrigins: []string{"https://evil.example"}, AllowCredentials: true}, "https://evil.example", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			config.CORS = tt.cors
+			h := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			w := testRequest(h, http.MethodGet, "/clients/1", "", "", map[string]string{"Origin": "https://evil.example"})
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, ожидался %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, ожидался %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Односторонняя синхронизация клиентов из прежней CRM. Коннектор
+// (crmConnector) читает записи CRM и называет их поля так же, как колонки
+// импорта CSV (import.go); синхронизация сопоставляет записи с клиентами и
+// добавляет или изменяет их. Запускается задачей crm-sync по расписанию,
+// вручную через /admin/jobs или командой adv-prog crm-sync.
+//
+// Запись CRM связывается с клиентом по ее внешнему ID; связи хранятся в
+// crm.json в dataDir. Запись без связи сопоставляется с клиентом по id,
+// если он передан, иначе по email; не нашлось — клиент добавляется со
+// следующим свободным ID. Конфликтом, который синхронизация не трогает,
+// считаются:
+//
+//   - клиент изменен у нас после последней синхронизации;
+//   - связанный клиент удален — заново он не создается;
+//   - id записи занят клиентом, не связанным с CRM, или email есть у
+//     нескольких клиентов.
+//
+// Записи, которые не прошли проверку, пропускаются, как строки импорта.
+// Удаление записи в CRM клиента не удаляет. Изменения применяются разом
+// под clientsMu, события идут с источником import: уведомления и онбординг
+// их пропускают, как и импорт.
+
+// CRMConfig задает синхронизацию из CRM.
+type CRMConfig struct {
+	Enabled   bool   `json:"enabled"`   // задача crm-sync по расписанию
+	Connector string `json:"connector"` // "csv" — выгрузка CSV по HTTP
+	URL       string `json:"url"`
+	Token     string `json:"token"` // Authorization: Bearer; по умолчанию из CRM_TOKEN
+	Delimiter string `json:"delimiter,omitempty"`
+	// ExternalIDColumn — колонка с ID записи в CRM. В id клиента она не
+	// попадает, если mapping явно этого не задает.
+	ExternalIDColumn string `json:"externalIdColumn"`
+	// Mapping — колонка CRM → колонка импорта; колонки не из mapping
+	// переносятся, если называются как колонки импорта, остальные
+	// пропускаются.
+	Mapping map[string]string `json:"mapping,omitempty"`
+	Tenant  string            `json:"tenant,omitempty"` // кофейня клиентов; по умолчанию основная
+	Timeout Duration          `json:"timeout"`
+}
+
+func (c CRMConfig) validate() error {
+	if _, ok := crmConnectors[c.Connector]; !ok {
+		return fmt.Errorf("crm: неизвестный коннектор %q", c.Connector)
+	}
+	if strings.TrimSpace(c.ExternalIDColumn) == "" {
+		return errors.New("crm: не указан externalIdColumn")
+	}
+	for from, to := range c.Mapping {
+		if _, ok := importColumns[strings.ToLower(to)]; !ok {
+			return fmt.Errorf("crm.mapping: %s — неизвестная колонка импорта %q", from, to)
+		}
+	}
+	if c.Enabled {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("crm: url должен быть адресом http(s)://")
+		}
+	}
+	if c.Timeout <= 0 {
+		return errors.New("crm: timeout должен быть положительным")
+	}
+	return nil
+}
+
+// crmRecord — запись CRM. Fields — значения по колонкам импорта.
+type crmRecord struct {
+	ExternalID string
+	Fields     map[string]string
+	Err        error // запись не разобрана
+}
+
+// crmConnector читает все записи CRM.
+type crmConnector interface {
+	Records(ctx context.Context) ([]crmRecord, error)
+}
+
+// crmConnectors — коннекторы по имени в crm.connector.
+var crmConnectors = map[string]func(cfg CRMConfig) (crmConnector, error){
+	"csv": newCSVConnector,
+}
+
+// crmSource — коннектор задачи crm-sync; nil, если crm.enabled выключен.
+var crmSource crmConnector
+
+func newCRMConnector(cfg CRMConfig) (crmConnector, error) {
+	return crmConnectors[cfg.Connector](cfg)
+}
+
+// csvConnector забирает выгрузку CSV по адресу crm.url.
+type csvConnector struct {
+	cfg    CRMConfig
+	client *outboundClient
+	file   string // локальный файл вместо url (adv-prog crm-sync -file)
+}
+
+func newCSVConnector(cfg CRMConfig) (crmConnector, error) {
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("CRM_TOKEN")
+	}
+	return &csvConnector{cfg: cfg, client: newOutboundClient("crm", outboundOptions{Timeout: time.Duration(cfg.Timeout)})}, nil
+}
+
+func (c *csvConnector) Records(ctx context.Context) ([]crmRecord, error) {
+	data, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cr := newImportReader(bytes.NewReader(data), c.cfg.Delimiter)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать заголовок CSV: %w", err)
+	}
+	mapping := make(map[string]string, len(c.cfg.Mapping))
+	for from, to := range c.cfg.Mapping {
+		mapping[strings.ToLower(from)] = strings.ToLower(to)
+	}
+	extColumn := strings.ToLower(c.cfg.ExternalIDColumn)
+	ext, columns := -1, make([]string, len(header)) // колонка импорта по номеру; "" — пропустить
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if key == extColumn {
+			ext = i
+		}
+		if to, ok := mapping[key]; ok {
+			columns[i] = to
+		} else if _, ok := importColumns[key]; ok && key != extColumn {
+			columns[i] = key
+		}
+	}
+	if ext < 0 {
+		return nil, fmt.Errorf("в выгрузке нет колонки %s с ID записи", c.cfg.ExternalIDColumn)
+	}
+
+	var records []crmRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		line, _ := cr.FieldPos(0)
+		var perr *csv.ParseError
+		switch {
+		case errors.As(err, &perr):
+			records = append(records, crmRecord{Err: fmt.Errorf("строка %d: %v", perr.Line, perr.Err)})
+			continue
+		case err != nil:
+			return nil, err
+		case len(row) != len(header):
+			rec := crmRecord{Err: fmt.Errorf("строка %d: число колонок не совпадает с заголовком", line)}
+			if ext < len(row) {
+				rec.ExternalID = strings.TrimSpace(row[ext])
+			}
+			records = append(records, rec)
+			continue
+		}
+		rec := crmRecord{ExternalID: strings.TrimSpace(row[ext]), Fields: make(map[string]string)}
+		for i, v := range row {
+			if columns[i] != "" {
+				rec.Fields[columns[i]] = strings.TrimSpace(v)
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// fetch читает выгрузку целиком: синхронизация применяется разом.
+func (c *csvConnector) fetch(ctx context.Context) ([]byte, error) {
+	var body io.Reader
+	if c.file != "" {
+		f, err := os.Open(c.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	} else {
+		if c.cfg.URL == "" {
+			return nil, errors.New("crm: не указан url")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/csv")
+		if c.cfg.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			return nil, fmt.Errorf("CRM ответила %s", resp.Status)
+		}
+		body = resp.Body
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportSize {
+		return nil, fmt.Errorf("выгрузка CRM больше %d МБ", maxImportSize>>20)
+	}
+	return data, nil
+}
+
+// crmLink связывает запись CRM с клиентом.
+type crmLink struct {
+	ClientID int `json:"clientId"`
+	Version  int `json:"version"` // версия клиента после последней синхронизации
+}
+
+// Итоги записи CRM.
+const (
+	crmCreated  = "created"
+	crmUpdated  = "updated"
+	crmSkipped  = "skipped"
+	crmConflict = "conflict"
+)
+
+// maxCRMIssues ограничивает список пропущенных записей и конфликтов в
+// итоге; счетчики считают все.
+const maxCRMIssues = 100
+
+// crmIssue — пропущенная запись или конфликт.
+type crmIssue struct {
+	ExternalID string `json:"externalId"`
+	ClientID   int    `json:"clientId,omitempty"`
+	Status     string `json:"status"` // skipped или conflict
+	Reason     string `json:"reason"`
+}
+
+// crmSummary — итог синхронизации.
+type crmSummary struct {
+	StartedAt time.Time  `json:"startedAt"`
+	DryRun    bool       `json:"dryRun"`
+	Records   int        `json:"records"` // записей в CRM
+	Created   int        `json:"created"`
+	Updated   int        `json:"updated"`
+	Skipped   int        `json:"skipped"` // без изменений или не прошли проверку
+	Conflicts int        `json:"conflicts"`
+	Issues    []crmIssue `json:"issues"`
+}
+
+// crmState — сохраняемое состояние синхронизации.
+type crmState struct {
+	Links   map[string]crmLink `json:"links"` // по внешнему ID
+	LastRun *crmSummary        `json:"lastRun,omitempty"`
+}
+
+var (
+	crm   = crmState{Links: make(map[string]crmLink)} // Связи с CRM и итог последнего запуска
+	crmMu sync.Mutex                                  // Мьютекс для защиты состояния CRM; берется до clientsMu
+)
+
+func crmPath() string {
+	return filepath.Join(config.DataDir, "crm.json")
+}
+
+// loadCRM читает связи с CRM.
+func loadCRM() error {
+	data, err := os.ReadFile(crmPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	crmMu.Lock()
+	defer crmMu.Unlock()
+	if err := json.Unmarshal(data, &crm); err != nil {
+		return fmt.Errorf("разбор %s: %w", crmPath(), err)
+	}
+	if crm.Links == nil {
+		crm.Links = make(map[string]crmLink)
+	}
+	return nil
+}
+
+// crmRun — один проход синхронизации. Изменения копятся в pending и
+// попадают в хранилище, только если проход не пробный.
+type crmRun struct {
+	tenant  string
+	links   map[string]crmLink
+	linked  map[int]bool     // ID клиентов, связанных с CRM
+	byEmail map[string][]int // активные клиенты кофейни по email в нижнем регистре
+	nextID  int
+	seen    map[string]bool
+	pending map[int]Client
+	order   []clientEvent // события в порядке записей
+}
+
+// current возвращает клиента кофейни с учетом изменений прохода.
+// Вызывается под clientsMu.
+func (run *crmRun) current(id int) (Client, bool) {
+	if c, ok := run.pending[id]; ok {
+		return c, true
+	}
+	return tenantClientLocked(run.tenant, id)
+}
+
+// apply сопоставляет запись с клиентом и готовит изменение. Возвращает
+// итог, ID клиента и причину пропуска или конфликта. Вызывается под
+// clientsMu.
+func (run *crmRun) apply(rec crmRecord, now time.Time) (string, int, string) {
+	switch {
+	case rec.Err != nil:
+		return crmSkipped, 0, rec.Err.Error()
+	case rec.ExternalID == "":
+		return crmSkipped, 0, "нет ID записи"
+	case run.seen[rec.ExternalID]:
+		return crmSkipped, 0, "ID записи повторяется в выгрузке"
+	}
+	run.seen[rec.ExternalID] = true
+
+	var cur Client
+	exists := false
+	if link, ok := run.links[rec.ExternalID]; ok {
+		c, found := run.current(link.ClientID)
+		switch {
+		case !found || c.deleted():
+			return crmConflict, link.ClientID, "связанный клиент удален"
+		case c.Version != link.Version:
+			return crmConflict, link.ClientID, "клиент изменен после последней синхронизации"
+		}
+		cur, exists = c, true
+	} else if v, ok := rec.Fields["id"]; ok {
+		var probe Client
+		if err := importColumns["id"](&probe, v); err != nil {
+			return crmSkipped, 0, err.Error()
+		}
+		_, taken := clients[probe.ID]
+		if _, ok := run.pending[probe.ID]; ok || taken {
+			return crmConflict, probe.ID, "ID занят клиентом, не связанным с CRM"
+		}
+	} else if email := strings.ToLower(rec.Fields["email"]); email != "" {
+		var free []int
+		for _, id := range run.byEmail[email] {
+			if !run.linked[id] {
+				free = append(free, id)
+			}
+		}
+		switch {
+		case len(free) > 1:
+			return crmConflict, 0, "email есть у нескольких клиентов"
+		case len(free) == 1:
+			cur, exists = clients[free[0]], true
+		case len(run.byEmail[email]) > 0:
+			return crmConflict, run.byEmail[email][0], "клиент с этим email связан с другой записью CRM"
+		}
+	}
+
+	next := Client{}
+	if exists {
+		next = cur
+		next.Addresses = nil // основной адрес — из записи, остальные сохраняются
+	}
+	for key, v := range rec.Fields {
+		if err := importColumns[key](&next, v); err != nil {
+			return crmSkipped, next.ID, err.Error()
+		}
+	}
+	if exists && next.ID != cur.ID {
+		return crmConflict, cur.ID, fmt.Sprintf("id в CRM (%d) не совпадает со связанным клиентом", next.ID)
+	}
+	id := next.ID // 0 — новый клиент без id: номер ему выдается, только если запись подошла
+	if next.ID == 0 {
+		next.ID = run.nextID
+	}
+
+	if exists {
+		if err := checkClientLocked(&next); err != nil {
+			return crmSkipped, id, err.Error()
+		}
+		syncAddresses(&next, &cur)
+		if sameClient(next, cur) {
+			run.link(rec.ExternalID, cur)
+			return crmSkipped, cur.ID, ""
+		}
+		next.Version = cur.Version + 1
+		run.record(eventClientUpdated, rec.ExternalID, next, now)
+		return crmUpdated, next.ID, ""
+	}
+	next.Tenant = run.tenant
+	next, err := prepareNewClientLocked(next)
+	if err != nil {
+		return crmSkipped, id, err.Error()
+	}
+	run.nextID = max(run.nextID, next.ID+1)
+	run.record(eventClientCreated, rec.ExternalID, next, now)
+	return crmCreated, next.ID, ""
+}
+
+func (run *crmRun) link(ext string, c Client) {
+	run.links[ext] = crmLink{ClientID: c.ID, Version: c.Version}
+	run.linked[c.ID] = true
+}
+
+func (run *crmRun) record(typ, ext string, c Client, now time.Time) {
+	run.pending[c.ID] = c
+	run.order = append(run.order, clientEvent{Type: typ, Client: c, At: now})
+	run.link(ext, c)
+}
+
+// sameClient сообщает, что запись CRM ничего не меняет у клиента.
+func sameClient(a, b Client) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// syncCRM забирает записи из conn и применяет их к клиентам кофейни
+// tenant; с dryRun только считает итог.
+func syncCRM(ctx context.Context, conn crmConnector, tenant string, dryRun bool) (crmSummary, error) {
+	now := time.Now()
+	summary := crmSummary{StartedAt: now, DryRun: dryRun, Issues: []crmIssue{}}
+	records, err := conn.Records(ctx)
+	if err != nil {
+		return summary, fmt.Errorf("чтение CRM: %w", err)
+	}
+	summary.Records = len(records)
+
+	crmMu.Lock()
+	defer crmMu.Unlock()
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	run := &crmRun{
+		tenant:  tenant,
+		links:   maps.Clone(crm.Links),
+		linked:  make(map[int]bool, len(crm.Links)),
+		byEmail: make(map[string][]int),
+		nextID:  1,
+		seen:    make(map[string]bool, len(records)),
+		pending: make(map[int]Client),
+	}
+	for _, l := range crm.Links {
+		run.linked[l.ClientID] = true
+	}
+	for id, c := range clients {
+		run.nextID = max(run.nextID, id+1)
+		if c.Tenant == tenant && !c.deleted() && c.Email != "" {
+			key := strings.ToLower(c.Email)
+			run.byEmail[key] = append(run.byEmail[key], id)
+		}
+	}
+
+	for _, rec := range records {
+		status, id, reason := run.apply(rec, now)
+		switch status {
+		case crmCreated:
+			summary.Created++
+		case crmUpdated:
+			summary.Updated++
+		case crmSkipped:
+			summary.Skipped++
+		case crmConflict:
+			summary.Conflicts++
+		}
+		if reason != "" && len(summary.Issues) < maxCRMIssues {
+			summary.Issues = append(summary.Issues, crmIssue{ExternalID: rec.ExternalID, ClientID: id, Status: status, Reason: reason})
+		}
+	}
+	if dryRun {
+		return summary, nil
+	}
+
+	// Связи сохраняются первыми: без них следующий проход принял бы
+	// добавленных клиентов за чужих.
+	prev := crm
+	crm = crmState{Links: run.links, LastRun: &summary}
+	if err := writeJSONFile(crmPath(), crm); err != nil {
+		crm = prev
+		return summary, err
+	}
+	for _, e := range run.order {
+		clients[e.Client.ID] = e.Client
+		publishClientEvent(e.Type, e.Client, sourceImport)
+	}
+	return summary, nil
+}
+
+// crmSyncJob синхронизирует клиентов из CRM; регистрируется, только если
+// crm.enabled.
+var crmSyncJob = &scheduledJob{
+	Name:     "crm-sync",
+	Schedule: "30 2 * * *",
+	Run: func(ctx context.Context) error {
+		s, err := syncCRM(ctx, crmSource, config.CRM.Tenant, false)
+		if err != nil {
+			return err
+		}
+		logf("Синхронизация с CRM: записей %d, добавлено %d, изменено %d, пропущено %d, конфликтов %d",
+			s.Records, s.Created, s.Updated, s.Skipped, s.Conflicts)
+		return nil
+	},
+}
+
+// crmStatus — ответ GET /admin/crm.
+type crmStatus struct {
+	Enabled bool        `json:"enabled"`
+	Links   int         `json:"links"` // связанных записей
+	LastRun *crmSummary `json:"lastRun,omitempty"`
+}
+
+// crmHandler показывает итог последней синхронизации: GET /admin/crm.
+// Запустить синхронизацию — POST /admin/jobs?job=crm-sync.
+func crmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	crmMu.Lock()
+	st := crmStatus{Enabled: config.CRM.Enabled, Links: len(crm.Links), LastRun: crm.LastRun}
+	crmMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// crmSyncCommand синхронизирует клиентов из CRM в новый снимок хранилища,
+// как import. Связи с CRM пишутся в crm.json сразу, поэтому сохраненный
+// снимок нужно восстановить на сервере; при работающем сервере лучше
+// запускать задачу crm-sync, а команду — с -dry-run.
+func crmSyncCommand(args []string) error {
+	fs := flag.NewFlagSet("crm-sync", flag.ContinueOnError)
+	file := fs.String("file", "", "локальная выгрузка CSV вместо crm.url")
+	dryRun := fs.Bool("dry-run", false, "только посчитать итог, ничего не сохранять")
+	snapshot := fs.String("snapshot", "", "снимок, к которому применить записи; по умолчанию последний")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openOffline(); err != nil {
+		return err
+	}
+	if err := loadCRM(); err != nil {
+		return fmt.Errorf("связи с CRM: %w", err)
+	}
+	if !tenantExists(config.CRM.Tenant) {
+		return fmt.Errorf("неизвестная кофейня %s", config.CRM.Tenant)
+	}
+	conn, err := newCRMConnector(config.CRM)
+	if err != nil {
+		return err
+	}
+	if *file != "" {
+		c, ok := conn.(*csvConnector)
+		if !ok {
+			return errors.New("-file поддерживается только для коннектора csv")
+		}
+		c.file = *file
+	}
+
+	ctx := context.Background()
+	from, err := loadSnapshot(ctx, *snapshot)
+	if err != nil {
+		return err
+	}
+	summary, err := syncCRM(ctx, conn, config.CRM.Tenant, *dryRun)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(summary)
+	if *dryRun {
+		return nil
+	}
+	if summary.Created+summary.Updated == 0 {
+		fmt.Fprintln(os.Stderr, "Клиенты не изменились, снимок не сохранен")
+		return nil
+	}
+	info, err := storeBackup(ctx)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		from = "пустого хранилища"
+	}
+	fmt.Fprintf(os.Stderr, "Снимок %s сохранен на основе %s; восстановить: POST /admin/backups/%s/restore?mode=replace\n", info.Key, from, info.Key)
+	return nil
+}
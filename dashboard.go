@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Панель регистраций /admin/dashboard (с /dashboard — перенаправление:
+// cookie входа действует только в разделе /admin/). Графики строятся на
+// сервере из той же сводки, что GET /stats, без сторонних библиотек.
+// Страница подписана на поток изменений клиентов /admin/dashboard/events и
+// после изменений перечитывает себя; без JavaScript обновляется раз в
+// минуту.
+
+// dashboardMonths — сколько последних месяцев показывать на графике
+// регистраций.
+const dashboardMonths = 24
+
+// dashboardCities — сколько городов показывать.
+const dashboardCities = 10
+
+// dashboardBar — столбец графика. Percent — высота или длина в процентах от
+// самого большого столбца.
+type dashboardBar struct {
+	Label   string
+	Count   int
+	Percent float64
+}
+
+// dashboardPage — данные страницы панели.
+type dashboardPage struct {
+	User          principal
+	Total         int
+	AverageAge    string // пусто — возраст не указан ни у кого
+	Registrations []dashboardBar
+	Coffees       []dashboardBar
+	Cities        []dashboardBar
+	UpdatedAt     time.Time
+}
+
+// dashboardBars переводит значения в столбцы графика.
+func dashboardBars(labels []string, counts []int) []dashboardBar {
+	top := 0
+	for _, n := range counts {
+		top = max(top, n)
+	}
+	bars := make([]dashboardBar, len(counts))
+	for i, n := range counts {
+		bars[i] = dashboardBar{Label: labels[i], Count: n}
+		if top > 0 {
+			bars[i].Percent = float64(n) * 100 / float64(top)
+		}
+	}
+	return bars
+}
+
+func statsCountBars(list []statsCount) []dashboardBar {
+	labels := make([]string, len(list))
+	counts := make([]int, len(list))
+	for i, c := range list {
+		labels[i], counts[i] = c.Name, c.Count
+	}
+	return dashboardBars(labels, counts)
+}
+
+// registrationBars — регистрации за последние dashboardMonths месяцев,
+// включая месяцы без регистраций.
+func registrationBars(months []statsMonth, now time.Time) []dashboardBar {
+	byMonth := make(map[string]int, len(months))
+	for _, m := range months {
+		byMonth[m.Month] = m.Count
+	}
+	first := time.Date(now.UTC().Year(), now.UTC().Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-dashboardMonths, 0)
+	labels := make([]string, dashboardMonths)
+	counts := make([]int, dashboardMonths)
+	for i := range dashboardMonths {
+		m := first.AddDate(0, i, 0).Format("2006-01")
+		labels[i], counts[i] = m, byMonth[m]
+	}
+	return dashboardBars(labels, counts)
+}
+
+// dashboardHandler отрисовывает панель регистраций по клиентам кофейни
+// пользователя.
+func dashboardHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		now := time.Now()
+		st, _ := computeClientStats(clientFilter{Tenant: requestTenant(r)})
+		cities := st.Cities
+		if len(cities) > dashboardCities {
+			cities = cities[:dashboardCities]
+		}
+		page := dashboardPage{
+			User:          p,
+			Total:         st.TotalClients,
+			Registrations: registrationBars(st.RegistrationsPerMonth, now),
+			Coffees:       statsCountBars(st.TopCoffees),
+			Cities:        statsCountBars(cities),
+			UpdatedAt:     now,
+		}
+		if st.AverageAge != nil {
+			page.AverageAge = strconv.FormatFloat(*st.AverageAge, 'f', 1, 64)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := templates.render(w, r, "dashboard.html", page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+)
+
+// Отладочные эндпоинты: профили net/http/pprof под /debug/pprof/ и
+// переменные expvar под /debug/vars — память (memstats), число горутин и
+// размер хранилищ. Нужны, чтобы разбираться с ростом памяти в работе.
+//
+// Пакеты pprof и expvar сами регистрируют обработчики в
+// http.DefaultServeMux, поэтому запросы к /debug/ перехватывает
+// withDebug и до этих обработчиков они не доходят.
+
+// DebugConfig задает отладочные эндпоинты.
+type DebugConfig struct {
+	Enabled bool `json:"enabled"`
+	// Addr — отдельный порт без аутентификации, например 127.0.0.1:6060;
+	// пусто — на основном порту, только администраторам развертывания.
+	Addr string `json:"addr"`
+}
+
+// debugHandler — собственный маршрутизатор отладочных эндпоинтов.
+var debugHandler = newDebugMux()
+
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// publishDebugVars добавляет к переменным expvar горутины, размер
+// хранилищ, состояние внешних снимков и исходящих запросов.
+func publishDebugVars() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("store", expvar.Func(storeSizes))
+	expvar.Publish("offsiteBackup", expvar.Func(offsiteStatus))
+	expvar.Publish("outbound", expvar.Func(outboundStatus))
+}
+
+// storeSizes — число записей в хранилищах в памяти.
+func storeSizes() any {
+	sizes := make(map[string]int)
+	clientsMu.Lock()
+	for _, c := range clients {
+		if c.deleted() {
+			sizes["clientsDeleted"]++
+		}
+	}
+	sizes["clients"] = len(clients)
+	sizes["geoIndexCells"] = len(geoIndex)
+	clientsMu.Unlock()
+
+	ordersMu.Lock()
+	sizes["orders"] = len(orders)
+	ordersMu.Unlock()
+
+	notesMu.Lock()
+	sizes["notes"] = len(notes.Notes)
+	notesMu.Unlock()
+
+	listCacheMu.Lock()
+	sizes["cachedLists"] = len(listCache)
+	listCacheMu.Unlock()
+
+	queueMu.Lock()
+	sizes["queueReady"] = len(queueReady)
+	sizes["queueDelayed"] = len(queueDelayed)
+	sizes["deadLetters"] = len(deadLetters)
+	queueMu.Unlock()
+	return sizes
+}
+
+// withDebug отдает /debug/ на основном порту администраторам, если
+// отладка включена без отдельного порта; иначе отвечает 404.
+func withDebug(next http.Handler) http.Handler {
+	guarded := requireDeploymentAdmin(debugHandler.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !config.Debug.Enabled || config.Debug.Addr != "" {
+			http.NotFound(w, r)
+			return
+		}
+		guarded(w, r)
+	})
+}
+
+// newDebugServer создает сервер отладки на отдельном порту.
+func newDebugServer(addr string) *http.Server {
+	return &http.Server{Addr: addr, Handler: debugHandler}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EmailConfig задает отправку писем клиентам по SMTP. Письма — это шаги
+// онбординга (см. OnboardingConfig); пока отправка выключена, они только
+// пишутся в лог. Пустой пароль берется из SMTP_PASSWORD.
+type EmailConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Username    string   `json:"username"` // пусто — без аутентификации
+	Password    string   `json:"password"`
+	From        string   `json:"from"` // например "Кофейня <hello@example.com>"
+	TLS         string   `json:"tls"`  // starttls, tls (сразу по TLS, обычно порт 465) или none
+	Timeout     Duration `json:"timeout"`
+	MaxAttempts int      `json:"maxAttempts"` // паузы между попытками — из queue
+	// Templates — шаблоны по имени шага онбординга; шаг без своего шаблона
+	// отправляется по шаблону default.
+	Templates map[string]EmailTemplate `json:"templates"`
+}
+
+// EmailTemplate — тема и текст письма в синтаксисе text/template. В
+// шаблоне доступны .Client и .Message — текст шага онбординга.
+type EmailTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Режимы шифрования соединения с SMTP-сервером.
+const (
+	emailTLSStart    = "starttls"
+	emailTLSImplicit = "tls"
+	emailTLSNone     = "none"
+)
+
+// emailDefaultTemplate — шаблон для шагов без своего шаблона.
+const emailDefaultTemplate = "default"
+
+func defaultEmail() EmailConfig {
+	return EmailConfig{
+		Port:        587,
+		TLS:         emailTLSStart,
+		Timeout:     Duration(30 * time.Second),
+		MaxAttempts: 5,
+		Templates: map[string]EmailTemplate{
+			emailDefaultTemplate: {Subject: "Новости кофейни", Body: "{{.Message}}"},
+			"welcome": {
+				Subject: "Добро пожаловать, {{.Client.Name}}!",
+				Body:    "{{.Message}}\n\nМы рады, что вы с нами.{{with .Client.FavCoffee}} Ваш {{.}} всегда ждет вас.{{end}}",
+			},
+		},
+	}
+}
+
+// validate проверяет настройки до запуска сервера.
+func (c EmailConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Host == "" || c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("email: host и port обязательны")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("email.from: %v", err)
+	}
+	if c.TLS != emailTLSStart && c.TLS != emailTLSImplicit && c.TLS != emailTLSNone {
+		return fmt.Errorf("email.tls: ожидается starttls, tls или none")
+	}
+	if c.Timeout <= 0 || c.MaxAttempts < 1 {
+		return fmt.Errorf("email: timeout и maxAttempts должны быть положительными")
+	}
+	if _, ok := c.Templates[emailDefaultTemplate]; !ok {
+		return fmt.Errorf("email.templates: нет шаблона default")
+	}
+	if _, err := parseEmailTemplates(c.Templates); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseEmailTemplates(list map[string]EmailTemplate) (*template.Template, error) {
+	tmpl := template.New("").Option("missingkey=error")
+	for name, t := range list {
+		if _, err := tmpl.New(name + ".subject").Parse(t.Subject); err != nil {
+			return nil, fmt.Errorf("email.templates.%s.subject: %v", name, err)
+		}
+		if _, err := tmpl.New(name + ".body").Parse(t.Body); err != nil {
+			return nil, fmt.Errorf("email.templates.%s.body: %v", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// checkEmail проверяет адрес клиента: пустой допустим, иначе — один адрес
+// вида user@example.com без имени и пробелов.
+func checkEmail(s string) error {
+	if s == "" {
+		return nil
+	}
+	if a, err := mail.ParseAddress(s); err != nil || a.Address != s || len(s) > 254 {
+		return fmt.Errorf("Неверный email %q", s)
+	}
+	return nil
+}
+
+// emailMessage — письмо в очереди на отправку.
+type emailMessage struct {
+	ClientID int          `json:"clientId"`
+	To       mail.Address `json:"to"`
+	Subject  string       `json:"subject"`
+	Body     string       `json:"body"`
+}
+
+// emailSender отправляет сообщения клиентам письмами. Send только ставит
+// письмо в очередь задач; отправляет его emailTask.
+type emailSender struct {
+	cfg  EmailConfig
+	from *mail.Address
+	tmpl *template.Template
+}
+
+// mailer — настроенная отправка писем; nil, если она выключена.
+var mailer *emailSender
+
+// emailTask отправляет одно письмо.
+var emailTask = &taskKind{
+	Name: "email",
+	Handle: func(ctx context.Context, t *queueTask) error {
+		if mailer == nil {
+			return permanentError{errors.New("отправка писем выключена")}
+		}
+		var m emailMessage
+		if err := json.Unmarshal(t.Payload, &m); err != nil {
+			return permanentError{err}
+		}
+		return mailer.sendSMTP(m)
+	},
+	Retry: func() retryPolicy {
+		p := defaultRetryPolicy()
+		p.MaxAttempts = config.Email.MaxAttempts
+		return p
+	},
+	Client: func(payload json.RawMessage) int {
+		var m emailMessage
+		json.Unmarshal(payload, &m)
+		return m.ClientID
+	},
+}
+
+func newEmailSender(cfg EmailConfig) (*emailSender, error) {
+	if cfg.Password == "" {
+		cfg.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parseEmailTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	return &emailSender{cfg: cfg, from: from, tmpl: tmpl}, nil
+}
+
+// Send ставит в очередь письмо по шаблону шага subject. Клиенту без email
+// письмо не отправляется.
+func (s *emailSender) Send(c Client, subject, body string) error {
+	if c.Email == "" {
+		return nil
+	}
+	name := subject
+	if s.tmpl.Lookup(name+".subject") == nil {
+		name = emailDefaultTemplate
+	}
+	data := struct {
+		Client  Client
+		Message string
+	}{c, body}
+
+	var subj, text bytes.Buffer
+	if err := s.tmpl.ExecuteTemplate(&subj, name+".subject", data); err != nil {
+		return err
+	}
+	if err := s.tmpl.ExecuteTemplate(&text, name+".body", data); err != nil {
+		return err
+	}
+	m := emailMessage{
+		ClientID: c.ID,
+		To:       mail.Address{Name: c.Name, Address: c.Email},
+		// Перевод строки в теме сломал бы заголовки письма.
+		Subject: strings.Join(strings.Fields(subj.String()), " "),
+		Body:    text.String(),
+	}
+	return enqueueTask(emailTask.Name, m)
+}
+
+// sendSMTP отправляет одно письмо отдельным соединением.
+func (s *emailSender) sendSMTP(m emailMessage) error {
+	timeout := time.Duration(s.cfg.Timeout)
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.TLS == emailTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	defer c.Close()
+
+	if s.cfg.TLS == emailTLSStart {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP: сервер не поддерживает STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("SMTP: %w", err)
+		}
+	}
+	// PlainAuth сам откажется передавать пароль без TLS, кроме как на localhost.
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP: %w", err)
+		}
+	}
+	if err := c.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	if err := c.Rcpt(m.To.Address); err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	if _, err := w.Write(s.compose(m, time.Now())); err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP: %w", err)
+	}
+	return c.Quit()
+}
+
+// compose собирает письмо: текст в UTF-8, quoted-printable.
+func (s *emailSender) compose(m emailMessage, now time.Time) []byte {
+	var b bytes.Buffer
+	domain := s.from.Address[strings.LastIndex(s.from.Address, "@")+1:]
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", &m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", randomHex(16), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(m.Body))
+	qp.Close()
+	return b.Bytes()
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncryptionConfig задает шифрование персональных данных клиентов при
+// хранении: поля Fields записываются в снимки хранилища зашифрованными
+// AES-256-GCM, а при чтении расшифровываются. Ключей может быть несколько:
+// новые записи шифруются ключом KeyID, остальные нужны для чтения старых.
+// После смены KeyID POST /admin/encryption перешифровывает сохраненные
+// снимки, и старый ключ можно убрать.
+//
+// Ключи — 32 байта в base64 — берутся из keys, из переменной PII_KEYS вида
+// "id=ключ,id2=ключ" или из вывода keyCommand: команда (например, клиент
+// KMS) печатает JSON-объект {"id": "ключ"}. Источники объединяются.
+type EncryptionConfig struct {
+	Enabled    bool              `json:"enabled"`
+	KeyID      string            `json:"keyId"`
+	Keys       map[string]string `json:"keys"`
+	KeyCommand []string          `json:"keyCommand"` // команда и аргументы, без оболочки
+	Fields     []string          `json:"fields"`
+}
+
+// encryptableFields — строковые поля клиента, которые можно шифровать.
+var encryptableFields = []string{"name", "email", "address.city", "address.street"}
+
+// encryptedPrefix отмечает зашифрованное значение:
+// enc:<ID ключа>:<base64 от nonce и шифротекста>.
+const encryptedPrefix = "enc:"
+
+// validate проверяет настройки до запуска сервера; ключи проверяются при
+// загрузке, см. newFieldCipher.
+func (c EncryptionConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.KeyID == "" || strings.Contains(c.KeyID, ":") {
+		return fmt.Errorf("encryption.keyId: укажите ID ключа без двоеточий")
+	}
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("encryption.fields: укажите поля для шифрования")
+	}
+	for _, f := range c.Fields {
+		if !slices.Contains(encryptableFields, f) {
+			return fmt.Errorf("encryption.fields: %q, допустимы %s", f, strings.Join(encryptableFields, ", "))
+		}
+	}
+	return nil
+}
+
+// fieldCipher шифрует и расшифровывает поля клиента.
+type fieldCipher struct {
+	active string // ID ключа для шифрования; пусто — только чтение
+	aeads  map[string]cipher.AEAD
+	fields []string
+}
+
+// pii — ключи шифрования; nil, если ни одного ключа не задано. Ключи
+// загружаются и при выключенном шифровании, чтобы читать старые снимки.
+var pii *fieldCipher
+
+// newFieldCipher собирает ключи из всех источников. Без ключей возвращает nil.
+func newFieldCipher(cfg EncryptionConfig) (*fieldCipher, error) {
+	keys := make(map[string]string)
+	for id, k := range cfg.Keys {
+		keys[id] = k
+	}
+	if env := os.Getenv("PII_KEYS"); env != "" {
+		for _, pair := range strings.Split(env, ",") {
+			id, k, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return nil, errors.New("PII_KEYS: ожидается id=ключ через запятую")
+			}
+			keys[id] = k
+		}
+	}
+	if len(cfg.KeyCommand) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, cfg.KeyCommand[0], cfg.KeyCommand[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryption.keyCommand: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		var fetched map[string]string
+		if err := json.Unmarshal(out, &fetched); err != nil {
+			return nil, fmt.Errorf("encryption.keyCommand: ожидается JSON-объект с ключами: %v", err)
+		}
+		for id, k := range fetched {
+			keys[id] = k
+		}
+	}
+	if len(keys) == 0 {
+		if cfg.Enabled {
+			return nil, errors.New("encryption: не задано ни одного ключа")
+		}
+		return nil, nil
+	}
+
+	f := &fieldCipher{aeads: make(map[string]cipher.AEAD, len(keys)), fields: cfg.Fields}
+	for id, k := range keys {
+		raw, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("encryption: ключ %s должен быть 32 байтами в base64", id)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		if f.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Enabled {
+		if _, ok := f.aeads[cfg.KeyID]; !ok {
+			return nil, fmt.Errorf("encryption: нет ключа %s", cfg.KeyID)
+		}
+		f.active = cfg.KeyID
+	}
+	return f, nil
+}
+
+// sealedField — шифруемое значение клиента. Name — поле из
+// encryption.fields, AAD — имя значения для fieldAAD.
+type sealedField struct {
+	Name string
+	AAD  string
+	P    *string
+}
+
+// clientFields возвращает указатели на шифруемые поля клиента; address.city
+// и address.street шифруются во всех адресах. Координаты не шифруются: по
+// ним ищут клиентов рядом. Адреса копируются, чтобы не менять клиента в
+// хранилище через общий срез.
+func clientFields(c *Client) []sealedField {
+	fields := []sealedField{
+		{"name", "name", &c.Name},
+		{"email", "email", &c.Email},
+		{"address.city", "address.city", &c.Address.City},
+		{"address.street", "address.street", &c.Address.Street},
+	}
+	c.Addresses = slices.Clone(c.Addresses)
+	for i := range c.Addresses {
+		a := &c.Addresses[i]
+		fields = append(fields,
+			sealedField{"address.city", "addresses." + a.Type + ".city", &a.City},
+			sealedField{"address.street", "addresses." + a.Type + ".street", &a.Street})
+	}
+	return fields
+}
+
+// fieldAAD привязывает шифротекст к клиенту и полю, чтобы значение нельзя
+// было незаметно переставить в другую запись.
+func fieldAAD(id int, field string) []byte {
+	return []byte(strconv.Itoa(id) + "/" + field)
+}
+
+// sealClient шифрует поля клиента для записи активным ключом, в том числе
+// зашифрованные раньше другим ключом. Без активного ключа клиент
+// возвращается как есть.
+func sealClient(c Client) (Client, error) {
+	f := pii
+	if f == nil || f.active == "" {
+		return c, nil
+	}
+	c, err := openClient(c)
+	if err != nil {
+		return c, err
+	}
+	for _, field := range clientFields(&c) {
+		p := field.P
+		if *p == "" || !slices.Contains(f.fields, field.Name) {
+			continue
+		}
+		nonce := make([]byte, f.aeads[f.active].NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return c, err
+		}
+		sealed := f.aeads[f.active].Seal(nonce, nonce, []byte(*p), fieldAAD(c.ID, field.AAD))
+		*p = encryptedPrefix + f.active + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+	}
+	return c, nil
+}
+
+// openClient расшифровывает поля клиента, прочитанного из хранилища.
+// Незашифрованные значения остаются как есть: так читаются старые записи.
+func openClient(c Client) (Client, error) {
+	for _, field := range clientFields(&c) {
+		name, p := field.AAD, field.P
+		if !strings.HasPrefix(*p, encryptedPrefix) {
+			continue
+		}
+		id, data, ok := strings.Cut(strings.TrimPrefix(*p, encryptedPrefix), ":")
+		if !ok {
+			return c, fmt.Errorf("клиент %d, поле %s: неверный формат шифротекста", c.ID, name)
+		}
+		var aead cipher.AEAD
+		if pii != nil {
+			aead = pii.aeads[id]
+		}
+		if aead == nil {
+			return c, fmt.Errorf("клиент %d, поле %s: нет ключа %s", c.ID, name, id)
+		}
+		raw, err := base64.RawStdEncoding.DecodeString(data)
+		if err != nil || len(raw) < aead.NonceSize() {
+			return c, fmt.Errorf("клиент %d, поле %s: неверный формат шифротекста", c.ID, name)
+		}
+		plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], fieldAAD(c.ID, name))
+		if err != nil {
+			return c, fmt.Errorf("клиент %d, поле %s: не удалось расшифровать ключом %s", c.ID, name, id)
+		}
+		*p = string(plain)
+	}
+	return c, nil
+}
+
+// sealedWithActive сообщает, что поля клиента уже записаны так, как
+// записал бы sealClient: перешифровывать его незачем.
+func sealedWithActive(c Client) bool {
+	f := pii
+	prefix := ""
+	if f != nil && f.active != "" {
+		prefix = encryptedPrefix + f.active + ":"
+	}
+	for _, field := range clientFields(&c) {
+		p := field.P
+		if *p == "" {
+			continue
+		}
+		if prefix != "" && slices.Contains(f.fields, field.Name) {
+			if !strings.HasPrefix(*p, prefix) {
+				return false
+			}
+		} else if strings.HasPrefix(*p, encryptedPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// reencryptResult — итог перешифрования.
+type reencryptResult struct {
+	Backups   int `json:"backups"`   // снимков проверено
+	Rewritten int `json:"rewritten"` // из них перезаписано
+}
+
+// reencryptBackups переписывает сохраненные снимки текущими настройками
+// шифрования: после смены ключа, добавления поля или выключения шифрования.
+func reencryptBackups(ctx context.Context) (reencryptResult, error) {
+	var res reencryptResult
+	// При выключенном шифровании снимки переписываются открытым текстом.
+	seal := sealClient
+	if pii == nil || pii.active == "" {
+		seal = openClient
+	}
+	list, err := blobs.List(ctx, backupPrefix)
+	if err != nil {
+		return res, err
+	}
+	for _, info := range list {
+		blob, err := blobs.Get(ctx, info.Key)
+		if err != nil {
+			return res, err
+		}
+		var b Backup
+		if err := json.Unmarshal(blob.Data, &b); err != nil {
+			return res, fmt.Errorf("%s: %v", info.Key, err)
+		}
+		res.Backups++
+		if !slices.ContainsFunc(b.Clients, func(c Client) bool { return !sealedWithActive(c) }) {
+			continue
+		}
+		for i, c := range b.Clients {
+			if b.Clients[i], err = seal(c); err != nil {
+				return res, fmt.Errorf("%s: %v", info.Key, err)
+			}
+		}
+		data, err := json.Marshal(b)
+		if err != nil {
+			return res, err
+		}
+		if err := blobs.Put(ctx, info.Key, Blob{Data: data, ContentType: blob.ContentType}); err != nil {
+			return res, err
+		}
+		res.Rewritten++
+	}
+	return res, nil
+}
+
+// encryptionHandler показывает настройки шифрования (GET) и перешифровывает
+// сохраненные снимки (POST /admin/encryption).
+func encryptionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := map[string]any{"enabled": config.Encryption.Enabled, "keys": []string{}}
+		if pii != nil {
+			ids := make([]string, 0, len(pii.aeads))
+			for id := range pii.aeads {
+				ids = append(ids, id)
+			}
+			slices.Sort(ids)
+			status["keys"] = ids
+			if pii.active != "" {
+				status["keyId"], status["fields"] = pii.active, pii.fields
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case http.MethodPost:
+		var res reencryptResult
+		err := runExclusive(r.Context(), "reencrypt", func(ctx context.Context) error {
+			var err error
+			res, err = reencryptBackups(ctx)
+			return err
+		})
+		if errors.Is(err, errLeaseHeld) {
+			http.Error(w, "Перешифрование уже выполняется", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
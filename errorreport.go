@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Отчеты об ошибках во внешнюю систему (Sentry, sentry.go): паники
+// обработчиков, ответы 5xx и сообщения журнала об ошибках. Отчеты
+// отправляются в фоне и не задерживают запрос; если отправка не успевает,
+// лишние отбрасываются.
+
+// ErrorReportingConfig задает отправку отчетов об ошибках.
+type ErrorReportingConfig struct {
+	Enabled     bool     `json:"enabled"`
+	DSN         string   `json:"dsn"`         // DSN проекта Sentry; пусто — из SENTRY_DSN
+	Environment string   `json:"environment"` // например production
+	Release     string   `json:"release"`
+	Timeout     Duration `json:"timeout"` // на отправку одного отчета
+}
+
+func (c ErrorReportingConfig) validate() error {
+	if c.Enabled && c.Timeout <= 0 {
+		return fmt.Errorf("errorReporting: timeout должен быть положительным")
+	}
+	return nil
+}
+
+// Виды отчетов.
+const (
+	errorKindPanic = "panic"
+	errorKindHTTP  = "http"
+	errorKindLog   = "log"
+)
+
+// errorEvent — ошибка для отчета.
+type errorEvent struct {
+	Time    time.Time
+	Kind    string
+	Message string
+	Stack   []uintptr // для паник: место паники, см. runtime.Callers
+
+	// Запрос, при обработке которого произошла ошибка.
+	RequestID string
+	Method    string
+	Path      string // без параметров: в них бывают данные клиентов
+	Status    int
+}
+
+// errorReporter отправляет отчет во внешнюю систему.
+type errorReporter interface {
+	Report(ctx context.Context, e errorEvent) error
+}
+
+var (
+	reporter       errorReporter                // Получатель отчетов; nil — отчеты выключены. Задается в serve до запуска фоновых задач
+	errorReports   = make(chan errorEvent, 100) // Отчеты, ожидающие отправки
+	errorReportsWG sync.WaitGroup               // Поставленные, но не отправленные отчеты
+)
+
+// startErrorReports включает отправку отчетов через rep.
+func startErrorReports(rep errorReporter) {
+	reporter = rep
+	go func() {
+		for e := range errorReports {
+			if err := rep.Report(context.Background(), e); err != nil {
+				// Не через logf: ошибка в журнале сама стала бы отчетом.
+				fmt.Fprintf(os.Stderr, "Отчет об ошибке не отправлен: %v\n", err)
+			}
+			errorReportsWG.Done()
+		}
+	}()
+}
+
+// reportError ставит отчет в очередь на отправку.
+func reportError(e errorEvent) {
+	if reporter == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	errorReportsWG.Add(1)
+	select {
+	case errorReports <- e:
+	default:
+		errorReportsWG.Done() // очередь заполнена: ошибок и так достаточно
+	}
+}
+
+// withRecovery перехватывает паники обработчиков и отвечает 500; о
+// паниках и ответах 5xx отправляются отчеты. 503 — штатный отказ
+// (перегрузка, остановка), о нем отчета нет.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorWriter{ResponseWriter: w}
+		e := errorEvent{RequestID: r.Header.Get(requestIDHeader), Method: r.Method, Path: r.URL.Path}
+		defer func() {
+			v := recover()
+			if v == http.ErrAbortHandler {
+				panic(v) // net/http молча обрывает ответ
+			}
+			if v != nil {
+				stack := make([]uintptr, 64)
+				stack = stack[:runtime.Callers(3, stack)]
+				logf("Паника в %s %s от %s: %v", r.Method, r.URL.Path, clientIP(r), v)
+				e.Kind, e.Message, e.Stack, e.Status = errorKindPanic, fmt.Sprint(v), stack, http.StatusInternalServerError
+				reportError(e)
+				if ew.status == 0 {
+					http.Error(ew, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+				}
+				return
+			}
+			if ew.status >= 500 && ew.status != http.StatusServiceUnavailable {
+				e.Kind, e.Status = errorKindHTTP, ew.status
+				e.Message = fmt.Sprintf("%d %s %s: %s", ew.status, r.Method, r.URL.Path, strings.TrimSpace(string(ew.body)))
+				reportError(e)
+			}
+		}()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// maxErrorBody — сколько байт тела ответа 5xx попадает в отчет.
+const maxErrorBody = 512
+
+// errorWriter запоминает статус ответа и начало тела ответа 5xx: там текст
+// ошибки из http.Error.
+type errorWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 500 && len(w.body) < maxErrorBody {
+		w.body = append(w.body, p[:min(len(p), maxErrorBody-len(w.body))]...)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap дает http.ResponseController доступ к исходному writer.
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *errorWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clientsModified — время последнего изменения хранилища клиентов.
+// Защищено clientsMu.
+var clientsModified = time.Now()
+
+// touchClients отмечает изменение хранилища. Вызывается под clientsMu.
+func touchClients() {
+	clientsModified = time.Now()
+}
+
+// etagOnClientEvent сбрасывает Last-Modified списков при любом изменении клиента.
+func etagOnClientEvent(clientEvent) {
+	touchClients()
+}
+
+// bodyETag вычисляет сильный ETag по телу ответа.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// jsonETag вычисляет ETag, который writeConditional выдал бы для v.
+func jsonETag(v any) (string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return bodyETag(append(body, '\n')), nil
+}
+
+// etagMatch ищет etag в списке из If-None-Match или If-Match. Префикс W/
+// игнорируется: все ETag сервера сильные.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified сообщает, что у клиента уже есть актуальная версия ответа.
+// If-None-Match имеет приоритет над If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// writeConditional отдает v в формате из Accept с ETag и Last-Modified или
+// отвечает 304, если версия у клиента совпадает. ETag считается по JSON и
+// одинаков для всех форматов, чтобы If-Match при изменении не зависел от
+// того, в каком формате клиент прочитал данные.
+func writeConditional(w http.ResponseWriter, r *http.Request, v any, modified time.Time) {
+	c, ok := responseCodec(w, r)
+	if !ok {
+		return
+	}
+	etag, err := jsonETag(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := c.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c.ContentType == jsonCodec.ContentType {
+		body = append(body, '\n')
+	}
+	h.Set("Content-Type", c.ContentType)
+	w.Write(body)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Типы событий жизненного цикла клиента.
+const (
+	eventClientCreated = "client.created"
+	eventClientUpdated = "client.updated"
+	eventClientDeleted = "client.deleted" // мягкое удаление
+	eventClientPurged  = "client.purged"  // окончательное удаление
+)
+
+// Источники изменений, чтобы подписчики могли отличить, например, импорт
+// от регистрации через API.
+const (
+	sourceAPI     = "api"
+	sourceBatch   = "batch"
+	sourceImport  = "import"
+	sourceJob     = "job"
+	sourceAdmin   = "admin"   // веб-интерфейс администратора
+	sourceSeed    = "seed"    // тестовые данные, см. seed.go
+	sourceReplica = "replica" // изменение, полученное репликой от ведущего, см. replica.go
+	sourceRestore = "restore" // клиент возвращен из архива, см. archive.go; это не регистрация
+)
+
+// clientEvent — изменение клиента в хранилище.
+type clientEvent struct {
+	Type   string
+	Client Client // состояние после изменения
+	Source string
+	At     time.Time
+}
+
+// clientSubscriber обрабатывает события. Вызывается синхронно под
+// clientsMu, поэтому не должен блокироваться и брать clientsMu; долгую
+// работу подписчик выносит в свою горутину.
+type clientSubscriber func(e clientEvent)
+
+var (
+	clientSubscribers   []clientSubscriber // Подписчики в порядке регистрации
+	clientSubscribersMu sync.RWMutex       // Мьютекс для защиты списка подписчиков
+)
+
+// subscribeClientEvents добавляет подписчика на все события клиентов.
+func subscribeClientEvents(fn clientSubscriber) {
+	clientSubscribersMu.Lock()
+	defer clientSubscribersMu.Unlock()
+	clientSubscribers = append(clientSubscribers, fn)
+}
+
+// publishClientEvent рассылает событие подписчикам. Вызывается под
+// clientsMu сразу после изменения хранилища.
+func publishClientEvent(typ string, c Client, source string) {
+	e := clientEvent{Type: typ, Client: c, Source: source, At: time.Now()}
+
+	clientSubscribersMu.RLock()
+	defer clientSubscribersMu.RUnlock()
+	for _, fn := range clientSubscribers {
+		fn(e)
+	}
+}
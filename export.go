@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumns — колонки выгрузки; совпадают с колонками импорта, так что
+// выгруженный CSV можно загрузить обратно.
+var exportColumns = []string{"id", "name", "age", "registerDate", "favCoffee", "city", "street", "email", "tags", "birthDate"}
+
+// exportRow возвращает значения колонок клиента в порядке exportColumns.
+func exportRow(c Client) []string {
+	return []string{
+		strconv.Itoa(c.ID),
+		c.Name,
+		strconv.Itoa(c.currentAge()),
+		c.RegisterDate.Format(time.RFC3339),
+		c.FavCoffee,
+		c.Address.City,
+		c.Address.Street,
+		c.Email,
+		strings.Join(c.Tags, " "),
+		c.BirthDate,
+	}
+}
+
+// numericColumns — индексы колонок, которые в xlsx пишутся числами.
+var numericColumns = map[int]bool{0: true, 2: true}
+
+// exportClientsHandler отдает клиентов, подходящих под фильтр списка,
+// файлом CSV (по умолчанию) или XLSX.
+func exportClientsHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+
+	format, contentType, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	list := filterClients(f)
+	filename := fmt.Sprintf("clients-%s.%s", time.Now().Format(time.DateOnly), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if err := writeClients(w, format, list); err != nil {
+		// Заголовки уже отправлены: остается только оборвать ответ.
+		logError("Ошибка выгрузки клиентов: %v", err)
+	}
+}
+
+// exportFormat читает ?format= (по умолчанию csv); при неизвестном формате
+// отвечает 400.
+func exportFormat(w http.ResponseWriter, r *http.Request) (format, contentType string, ok bool) {
+	format = r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok = exportContentTypes[format]
+	if !ok {
+		http.Error(w, "Неизвестный формат: поддерживаются csv и xlsx", http.StatusBadRequest)
+	}
+	return format, contentType, ok
+}
+
+var exportContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+func writeClients(w io.Writer, format string, list []Client) error {
+	if format == "xlsx" {
+		return writeClientsXLSX(w, list)
+	}
+	return writeClientsCSV(w, list)
+}
+
+func writeClientsCSV(w io.Writer, list []Client) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, c := range list {
+		if err := cw.Write(exportRow(c)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Минимальный набор частей OOXML-книги с одним листом.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Clients" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+)
+
+// writeClientsXLSX пишет книгу Excel прямо в w: zip-архив формируется
+// потоково, строки листа — по одной.
+func writeClientsXLSX(w io.Writer, list []Client) error {
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	writeXLSXRow(bw, exportColumns, nil)
+	for _, c := range list {
+		writeXLSXRow(bw, exportRow(c), numericColumns)
+	}
+	bw.WriteString(`</sheetData></worksheet>`)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeXLSXRow(bw *bufio.Writer, values []string, numeric map[int]bool) {
+	bw.WriteString("<row>")
+	for i, v := range values {
+		if numeric[i] {
+			bw.WriteString("<c><v>")
+			xml.EscapeText(bw, []byte(v))
+			bw.WriteString("</v></c>")
+			continue
+		}
+		bw.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(bw, []byte(v))
+		bw.WriteString("</t></is></c>")
+	}
+	bw.WriteString("</row>")
+}
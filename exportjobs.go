@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Выгрузка большой базы занимает время, поэтому ее можно заказать в фоне:
+// POST /clients/export ставит задачу в очередь, готовый файл сохраняется в
+// хранилище файлов под exports/ и отдается по GET /exports/{id}/file.
+
+// Состояния фоновой выгрузки.
+const (
+	exportPending = "pending"
+	exportDone    = "done"
+	exportFailed  = "failed"
+)
+
+// exportTTL — сколько хранится готовая выгрузка.
+const exportTTL = 24 * time.Hour
+
+// exportJob — фоновая выгрузка.
+type exportJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Format     string     `json:"format"`
+	Query      string     `json:"query,omitempty"` // фильтр в виде строки запроса
+	Tenant     string     `json:"tenant,omitempty"`
+	Rows       int        `json:"rows"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+var (
+	exportJobs   = make(map[string]*exportJob) // Фоновые выгрузки по ID
+	exportJobsMu sync.Mutex                    // Мьютекс для защиты выгрузок
+)
+
+func exportJobsPath() string {
+	return filepath.Join(config.DataDir, "exports.json")
+}
+
+func exportKey(j *exportJob) string {
+	return "exports/" + j.ID + "." + j.Format
+}
+
+// loadExportJobs читает список фоновых выгрузок.
+func loadExportJobs() error {
+	data, err := os.ReadFile(exportJobsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	if err := json.Unmarshal(data, &exportJobs); err != nil {
+		return fmt.Errorf("разбор %s: %w", exportJobsPath(), err)
+	}
+	return nil
+}
+
+func saveExportJobsLocked() error {
+	return writeJSONFile(exportJobsPath(), exportJobs)
+}
+
+// exportTask формирует файл выгрузки.
+var exportTask = &taskKind{Name: "export", Handle: runExportJob}
+
+func runExportJob(ctx context.Context, t *queueTask) error {
+	var id string
+	if err := json.Unmarshal(t.Payload, &id); err != nil {
+		return permanentError{err}
+	}
+	exportJobsMu.Lock()
+	j, ok := exportJobs[id]
+	var job exportJob
+	if ok {
+		job = *j
+	}
+	exportJobsMu.Unlock()
+	if !ok {
+		return nil // выгрузку уже удалили
+	}
+
+	q, _ := url.ParseQuery(job.Query)
+	f, err := parseClientFilter(q)
+	if err != nil {
+		return permanentError{err}
+	}
+	f.Tenant = job.Tenant
+	list := filterClients(f)
+	var buf bytes.Buffer
+	if err = writeClients(&buf, job.Format, list); err == nil {
+		err = blobs.Put(ctx, exportKey(&job), Blob{Data: buf.Bytes(), ContentType: exportContentTypes[job.Format]})
+	}
+
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	j, ok = exportJobs[id]
+	if !ok {
+		return nil
+	}
+	var perm permanentError
+	switch {
+	case err == nil:
+		j.Status, j.Rows, j.Error = exportDone, len(list), ""
+	case errors.As(err, &perm) || t.Attempts >= defaultRetryPolicy().MaxAttempts:
+		j.Status, j.Error = exportFailed, err.Error()
+	default:
+		j.Error = err.Error() // будет повтор
+	}
+	if j.Status != exportPending {
+		now := time.Now()
+		j.FinishedAt = &now
+	}
+	if saveErr := saveExportJobsLocked(); saveErr != nil {
+		logError("Ошибка сохранения выгрузок: %v", saveErr)
+	}
+	return err
+}
+
+// pruneExportsJob удаляет выгрузки старше exportTTL вместе с файлами.
+var pruneExportsJob = &scheduledJob{
+	Name:     "prune-exports",
+	Schedule: "@every 1h",
+	Run: func(ctx context.Context) error {
+		exportJobsMu.Lock()
+		defer exportJobsMu.Unlock()
+		cutoff := time.Now().Add(-exportTTL)
+		changed := false
+		for id, j := range exportJobs {
+			if j.Status == exportPending || j.CreatedAt.After(cutoff) {
+				continue
+			}
+			if err := blobs.Delete(ctx, exportKey(j)); err != nil {
+				return err
+			}
+			delete(exportJobs, id)
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return saveExportJobsLocked()
+	},
+}
+
+// startExportHandler заказывает фоновую выгрузку: POST /clients/export с
+// теми же параметрами, что GET /clients/export.
+func startExportHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+	format, _, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	q.Del("format")
+	j := &exportJob{ID: randomHex(8), Status: exportPending, Format: format, Query: q.Encode(), Tenant: f.Tenant, CreatedAt: time.Now()}
+
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	exportJobs[j.ID] = j
+	err = saveExportJobsLocked()
+	if err == nil {
+		err = enqueueTask(exportTask.Name, j.ID)
+	}
+	if err != nil {
+		delete(exportJobs, j.ID)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Location", "/exports/"+j.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
+
+// exportJobFromPath возвращает копию выгрузки по ID из пути; если ее нет
+// или она другой кофейни, отвечает 404.
+func exportJobFromPath(w http.ResponseWriter, r *http.Request) (exportJob, bool) {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	j, ok := exportJobs[r.PathValue("id")]
+	if !ok || j.Tenant != requestTenant(r) {
+		http.Error(w, "Выгрузка не найдена", http.StatusNotFound)
+		return exportJob{}, false
+	}
+	return *j, true
+}
+
+// exportJobHandler показывает состояние выгрузки: GET /exports/{id}.
+func exportJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := exportJobFromPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
+
+// exportFileHandler отдает готовый файл: GET /exports/{id}/file.
+func exportFileHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := exportJobFromPath(w, r)
+	if !ok {
+		return
+	}
+	if j.Status != exportDone {
+		http.Error(w, "Выгрузка еще не готова", http.StatusConflict)
+		return
+	}
+	b, err := blobs.Get(r.Context(), exportKey(&j))
+	if errors.Is(err, errBlobNotFound) {
+		http.Error(w, "Выгрузка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filename := fmt.Sprintf("clients-%s.%s", j.CreatedAt.Format(time.DateOnly), j.Format)
+	w.Header().Set("Content-Type", exportContentTypes[j.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Write(b.Data)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Форма ответа GET /clients/{id} и /getClients:
+//
+//	?fields=id,name,favCoffee — только перечисленные поля клиента (имена как в JSON)
+//	?expand=orders,notes      — добавить к клиенту заказы и заметки
+//
+// Связанные ресурсы из expand попадают в ответ и без упоминания в fields.
+// fields поддерживается в JSON и MessagePack, expand — во всех форматах,
+// кроме protobuf.
+
+// clientExpand — что добавить к клиенту (?expand=).
+type clientExpand struct {
+	Notes  bool
+	Orders bool
+}
+
+func (e clientExpand) any() bool { return e.Notes || e.Orders }
+
+// parseExpand читает ?expand= — через запятую, что добавить к клиенту.
+func parseExpand(v string) (clientExpand, error) {
+	var e clientExpand
+	for _, name := range strings.Split(v, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "notes":
+			e.Notes = true
+		case "orders":
+			e.Orders = true
+		default:
+			return clientExpand{}, fmt.Errorf("expand: неизвестное значение %q", name)
+		}
+	}
+	return e, nil
+}
+
+// clientFieldNames — имена полей клиента в JSON, допустимые в ?fields=.
+var clientFieldNames = func() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(clientJSON{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// parseFields читает ?fields= — через запятую, какие поля клиента вернуть.
+// Пустой список — все поля.
+func parseFields(v string) ([]string, error) {
+	var fields []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "notes" || name == "orders" {
+			continue
+		}
+		if !clientFieldNames[name] {
+			return nil, fmt.Errorf("fields: неизвестное поле %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// clientShape — запрошенная форма ответа.
+type clientShape struct {
+	Fields []string
+	Expand clientExpand
+}
+
+// parseClientShape читает ?fields= и ?expand=.
+func parseClientShape(r *http.Request) (clientShape, error) {
+	var s clientShape
+	var err error
+	if s.Fields, err = parseFields(r.URL.Query().Get("fields")); err != nil {
+		return s, err
+	}
+	s.Expand, err = parseExpand(r.URL.Query().Get("expand"))
+	return s, err
+}
+
+// plain — ответ без изменений формы.
+func (s clientShape) plain() bool { return len(s.Fields) == 0 && !s.Expand.any() }
+
+// allowCodec отвечает 406, если форма не поддерживается форматом ответа.
+func (s clientShape) allowCodec(w http.ResponseWriter, c codec) bool {
+	switch {
+	case c.ContentType == protoCodec.ContentType && !s.plain():
+		http.Error(w, "fields и expand не поддерживаются для application/x-protobuf", http.StatusNotAcceptable)
+		return false
+	case c.ContentType == xmlCodec.ContentType && len(s.Fields) > 0:
+		http.Error(w, "fields не поддерживается для application/xml", http.StatusNotAcceptable)
+		return false
+	}
+	return true
+}
+
+// shapedClient — клиент в запрошенной форме. Notes и Orders равны nil,
+// если их не просили.
+type shapedClient struct {
+	Client
+	Fields []string
+	Notes  []clientNote
+	Orders []Order
+}
+
+// MarshalJSON нужен, потому что встроенный Client.MarshalJSON иначе
+// закодировал бы одного клиента целиком и без связанных ресурсов.
+func (c shapedClient) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(clientJSON(c.Client.withCurrentAge()))
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(c.Fields) > 0 {
+		all := doc
+		doc = make(map[string]json.RawMessage, len(c.Fields)+2)
+		for _, name := range c.Fields {
+			if v, ok := all[name]; ok { // omitempty-поля могут отсутствовать
+				doc[name] = v
+			}
+		}
+	}
+	if c.Notes != nil {
+		if doc["notes"], err = json.Marshal(c.Notes); err != nil {
+			return nil, err
+		}
+	}
+	if c.Orders != nil {
+		if doc["orders"], err = json.Marshal(c.Orders); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// MarshalXML кодирует клиента как <client>; fields в XML не применяется.
+// Указатели нужны, чтобы не просившие заметок или заказов не получали
+// пустых <notes/> и <orders/>.
+func (c shapedClient) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type xmlNotes struct {
+		Notes []clientNote `xml:"note"`
+	}
+	type xmlOrders struct {
+		Orders []Order `xml:"order"`
+	}
+	doc := struct {
+		Client
+		Notes  *xmlNotes  `xml:"notes,omitempty"`
+		Orders *xmlOrders `xml:"orders,omitempty"`
+	}{Client: c.Client.withCurrentAge()}
+	if c.Notes != nil {
+		doc.Notes = &xmlNotes{c.Notes}
+	}
+	if c.Orders != nil {
+		doc.Orders = &xmlOrders{c.Orders}
+	}
+	return e.EncodeElement(doc, xml.StartElement{Name: xml.Name{Local: "client"}})
+}
+
+// shapeClients приводит клиентов к форме s, добираясь до заметок и
+// заказов за один проход по каждому хранилищу.
+func shapeClients(list []Client, s clientShape) []shapedClient {
+	shaped := make([]shapedClient, len(list))
+	index := make(map[int]int, len(list))
+	for i, c := range list {
+		shaped[i] = shapedClient{Client: c, Fields: s.Fields}
+		index[c.ID] = i
+		if s.Expand.Notes {
+			shaped[i].Notes = []clientNote{}
+		}
+		if s.Expand.Orders {
+			shaped[i].Orders = []Order{}
+		}
+	}
+	if s.Expand.Notes {
+		notesMu.Lock()
+		for _, n := range notes.Notes {
+			if i, ok := index[n.ClientID]; ok {
+				shaped[i].Notes = append(shaped[i].Notes, n)
+			}
+		}
+		notesMu.Unlock()
+	}
+	if s.Expand.Orders {
+		for _, o := range findOrders(func(o Order) bool { _, ok := index[o.ClientID]; return ok }) {
+			i := index[o.ClientID]
+			shaped[i].Orders = append(shaped[i].Orders, o)
+		}
+	}
+	return shaped
+}
+
+// writeShapedClient отвечает клиентом в форме s. ETag — как у клиента
+// целиком, чтобы его можно было передать в If-Match; ответ 304 не
+// отдается, так как заметки и заказы меняются отдельно от клиента.
+func writeShapedClient(w http.ResponseWriter, r *http.Request, c Client, s clientShape) {
+	codec, ok := responseCodec(w, r)
+	if !ok || !s.allowCodec(w, codec) {
+		return
+	}
+	etag, err := jsonETag(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := codec.Marshal(shapeClients([]Client{c}, s)[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if codec.ContentType == jsonCodec.ContentType {
+		body = append(body, '\n')
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", codec.ContentType)
+	w.Write(body)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientFilter — условия отбора клиентов из параметров запроса:
+//
+//	name           — подстрока имени без учета регистра
+//	city           — город без учета регистра
+//	favCoffee      — любимый кофе без учета регистра, синонимы из справочника допустимы
+//	minAge, maxAge — границы возраста включительно
+//	registeredFrom, registeredTo — границы даты регистрации (ГГГГ-ММ-ДД), to включительно
+//	tag            — метка; повторяется, если нужны все из нескольких
+//	includeDeleted — показывать мягко удаленных (только администраторам)
+type clientFilter struct {
+	Name      string
+	City      string
+	FavCoffee string
+	MinAge    *int
+	MaxAge    *int
+	From      time.Time
+	To        time.Time
+	Tags      []string // нормализованные, см. normalizeTags
+
+	IncludeDeleted bool
+
+	// Tenant — кофейня клиентов; AllTenants снимает это ограничение.
+	Tenant     string
+	AllTenants bool
+}
+
+// parseClientFilter читает фильтр из параметров запроса.
+func parseClientFilter(q url.Values) (clientFilter, error) {
+	f := clientFilter{
+		Name:      strings.ToLower(strings.TrimSpace(q.Get("name"))),
+		City:      strings.TrimSpace(q.Get("city")),
+		FavCoffee: canonicalCoffee(q.Get("favCoffee")),
+	}
+
+	for _, p := range []struct {
+		param string
+		dst   **int
+	}{{"minAge", &f.MinAge}, {"maxAge", &f.MaxAge}} {
+		if v := q.Get(p.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return f, errors.New(p.param + ": ожидается целое число")
+			}
+			*p.dst = &n
+		}
+	}
+
+	for _, p := range []struct {
+		param string
+		dst   *time.Time
+	}{{"registeredFrom", &f.From}, {"registeredTo", &f.To}} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				return f, errors.New(p.param + ": ожидается дата ГГГГ-ММ-ДД")
+			}
+			*p.dst = t
+		}
+	}
+	if !f.To.IsZero() {
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	if q.Has("tag") {
+		tags, err := normalizeTags(q["tag"])
+		if err != nil {
+			return f, err
+		}
+		f.Tags = tags
+	}
+	include, err := parseIncludeDeleted(q)
+	if err != nil {
+		return f, err
+	}
+	f.IncludeDeleted = include
+	return f, nil
+}
+
+// match проверяет клиента на соответствие фильтру.
+func (f clientFilter) match(c Client) bool {
+	switch {
+	case c.deleted() && !f.IncludeDeleted:
+		return false
+	case c.Tenant != f.Tenant && !f.AllTenants:
+		return false
+	case f.Name != "" && !strings.Contains(strings.ToLower(c.Name), f.Name):
+		return false
+	case f.City != "" && !strings.EqualFold(c.Address.City, f.City):
+		return false
+	case f.FavCoffee != "" && !strings.EqualFold(c.FavCoffee, f.FavCoffee):
+		return false
+	case f.MinAge != nil && c.currentAge() < *f.MinAge:
+		return false
+	case f.MaxAge != nil && c.currentAge() > *f.MaxAge:
+		return false
+	case !f.From.IsZero() && c.RegisterDate.Before(f.From):
+		return false
+	case !f.To.IsZero() && !c.RegisterDate.Before(f.To):
+		return false
+	case !c.hasTags(f.Tags):
+		return false
+	}
+	return true
+}
+
+// empty сообщает, что фильтр не задает ни одного условия отбора.
+func (f clientFilter) empty() bool {
+	return f.Name == "" && f.City == "" && f.FavCoffee == "" && f.MinAge == nil && f.MaxAge == nil &&
+		f.From.IsZero() && f.To.IsZero() && len(f.Tags) == 0
+}
+
+// filterClients возвращает подходящих под фильтр клиентов по возрастанию ID.
+func filterClients(f clientFilter) []Client {
+	clientsMu.Lock()
+	list := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		if f.match(c) {
+			list = append(list, c)
+		}
+	}
+	clientsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Запросы субъекта персональных данных: выгрузка всего, что о нем хранится,
+// и право на забвение. Забвение обезличивает клиента, а не удаляет его:
+// заказы и баллы остаются для отчетов, но больше ни к кому не привязаны.
+// Каждое обезличивание записывается в журнал обезличиваний; записи связаны
+// цепочкой хешей, поэтому удаление или правка записи заметны.
+
+// anonymousName — имя обезличенного клиента.
+const anonymousName = "Обезличенный клиент"
+
+// anonymizedFields — поля клиента, которые стираются. Любимый кофе, город и
+// дата регистрации остаются для статистики.
+var anonymizedFields = []string{"name", "age", "birthDate", "email", "address.street", "address.location"}
+
+// erasureCertificate — запись об обезличивании клиента.
+type erasureCertificate struct {
+	ID        string         `json:"id"`
+	ClientID  int            `json:"clientId"`
+	ErasedAt  time.Time      `json:"erasedAt"`
+	Actor     string         `json:"actor,omitempty"`
+	RequestID string         `json:"requestId,omitempty"` // для поиска запроса в /admin/journal
+	Fields    []string       `json:"fields"`              // обезличенные поля клиента
+	Removed   map[string]int `json:"removed"`             // что еще удалено, по видам
+	Prev      string         `json:"prev,omitempty"`      // Hash предыдущей записи
+	Hash      string         `json:"hash"`                // SHA-256 записи без Hash
+}
+
+var (
+	erasures   []erasureCertificate // Журнал обезличиваний, новые последними
+	erasuresMu sync.Mutex           // Мьютекс для защиты журнала; берется после clientsMu
+)
+
+func erasuresPath() string {
+	return filepath.Join(config.DataDir, "erasures.json")
+}
+
+// loadErasures читает журнал обезличиваний.
+func loadErasures() error {
+	data, err := os.ReadFile(erasuresPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	erasuresMu.Lock()
+	defer erasuresMu.Unlock()
+	if err := json.Unmarshal(data, &erasures); err != nil {
+		return fmt.Errorf("разбор %s: %w", erasuresPath(), err)
+	}
+	return nil
+}
+
+// certificateHash считает хеш записи без поля Hash.
+func certificateHash(c erasureCertificate) string {
+	c.Hash = ""
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// clientErasuresLocked возвращает записи об обезличивании клиента.
+// Вызывается под erasuresMu.
+func clientErasuresLocked(id int) []erasureCertificate {
+	var list []erasureCertificate
+	for _, c := range erasures {
+		if c.ClientID == id {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// journalForClient возвращает записи журнала запросов к данным клиента,
+// от новых к старым.
+func journalForClient(id int) []journalEntry {
+	idStr := strconv.Itoa(id)
+	prefix := "/clients/" + idStr
+	list := []journalEntry{}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	for k := range len(journal) {
+		e := journalNewest(k)
+		match := e.Path == prefix || strings.HasPrefix(e.Path, prefix+"/")
+		if !match && e.Query != "" {
+			q, _ := url.ParseQuery(e.Query)
+			match = (e.Path == "/deleteClient" && q.Get("id") == idStr) ||
+				(e.Path == "/orders" && q.Get("clientId") == idStr)
+		}
+		if match {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// clientDataExport — все, что хранится о клиенте.
+type clientDataExport struct {
+	GeneratedAt time.Time            `json:"generatedAt"`
+	Client      Client               `json:"client"`
+	Orders      []Order              `json:"orders"`
+	Loyalty     loyaltyAccount       `json:"loyalty"`
+	Notes       []clientNote         `json:"notes"`
+	LastSeen    *time.Time           `json:"lastSeen,omitempty"` // последний визит
+	Onboarding  *dripEnrollment      `json:"onboarding,omitempty"`
+	Avatar      bool                 `json:"avatar"` // сам файл — GET /clients/{id}/avatar
+	Audit       []journalEntry       `json:"audit"`  // запросы к данным клиента из журнала
+	Erasures    []erasureCertificate `json:"erasures,omitempty"`
+}
+
+// clientDataExportHandler выгружает все данные клиента, в том числе
+// удаленного мягко: GET /clients/{id}/export.
+func clientDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	out := clientDataExport{GeneratedAt: time.Now()}
+
+	clientsMu.Lock()
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists {
+		clientsMu.Unlock()
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	out.Client = c
+	out.Orders = findOrders(func(o Order) bool { return o.ClientID == id })
+	loyaltyMu.Lock()
+	out.Loyalty = loyaltyAccount{ClientID: id, Balance: balances[id], Transactions: []loyaltyTransaction{}}
+	for _, t := range loyalty.Transactions {
+		if t.ClientID == id {
+			out.Loyalty.Transactions = append(out.Loyalty.Transactions, t)
+		}
+	}
+	loyaltyMu.Unlock()
+	notesMu.Lock()
+	out.Notes = clientNotesLocked(id)
+	notesMu.Unlock()
+	out.LastSeen = clientLastSeen(id)
+	err = updateDrips(func() bool {
+		if e, ok := drips[id]; ok {
+			copied := *e
+			out.Onboarding = &copied
+		}
+		return false
+	})
+	if err != nil {
+		clientsMu.Unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	erasuresMu.Lock()
+	out.Erasures = clientErasuresLocked(id)
+	erasuresMu.Unlock()
+	clientsMu.Unlock()
+
+	out.Audit = journalForClient(id)
+	_, err = blobs.Get(r.Context(), avatarKey(id))
+	if err != nil && !errors.Is(err, errBlobNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out.Avatar = err == nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="client-%d.json"`, id))
+	json.NewEncoder(w).Encode(out)
+}
+
+// eraseClientHandler необратимо обезличивает клиента: DELETE /clients/{id}/gdpr.
+// Клиент заодно удаляется мягко. Стираются также комментарии к операциям с
+// баллами, цепочка онбординга, аватар, записи архива удаленных и ожидающие
+// задачи с его данными.
+// Резервные копии, снятые раньше, не меняются и устаревают по своему сроку.
+func eraseClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	cert := erasureCertificate{
+		ID:        randomHex(8),
+		ClientID:  id,
+		ErasedAt:  now,
+		RequestID: r.Header.Get(requestIDHeader),
+		Fields:    anonymizedFields,
+		Removed:   make(map[string]int),
+	}
+	if p, ok := principalFrom(r); ok {
+		cert.Actor = p.Kind + ":" + p.Name
+	}
+
+	clientsMu.Lock()
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists {
+		clientsMu.Unlock()
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	erasuresMu.Lock()
+	done := len(clientErasuresLocked(id)) > 0
+	erasuresMu.Unlock()
+	if done {
+		clientsMu.Unlock()
+		http.Error(w, "Данные клиента уже обезличены", http.StatusConflict)
+		return
+	}
+
+	// Задачи удаляются до события: подписчики поставят новые, уже без
+	// персональных данных.
+	cert.Removed["tasks"] = dropClientTasks(id)
+	wasDeleted := c.deleted()
+	c.Name, c.Age, c.BirthDate, c.Email = anonymousName, 0, "", ""
+	// Улица и координаты стираются во всех адресах; адреса, от которых
+	// ничего не осталось, удаляются.
+	var kept []Address
+	for _, a := range c.Addresses {
+		if a.Street, a.Location = "", nil; a.City != "" {
+			kept = append(kept, a)
+		}
+	}
+	c.Addresses = kept
+	c.Address.Street, c.Address.Location = "", nil
+	syncAddresses(&c, nil)
+	if !wasDeleted {
+		c.DeletedAt = &now
+	}
+	c.Version++
+	clients[id] = c
+	if wasDeleted {
+		publishClientEvent(eventClientUpdated, c, sourceAPI)
+	} else {
+		publishClientEvent(eventClientDeleted, c, sourceAPI)
+	}
+
+	loyaltyMu.Lock()
+	for i, t := range loyalty.Transactions {
+		if t.ClientID == id && t.Note != "" {
+			loyalty.Transactions[i].Note = ""
+			cert.Removed["loyaltyNotes"]++
+		}
+	}
+	if cert.Removed["loyaltyNotes"] > 0 {
+		if err := writeJSONFile(loyaltyPath(), loyalty); err != nil {
+			logError("Ошибка сохранения баллов лояльности: %v", err)
+		}
+	}
+	loyaltyMu.Unlock()
+	notesMu.Lock()
+	// Заметки пишут сотрудники свободным текстом, поэтому удаляются целиком.
+	if n := dropClientNotesLocked(id); n > 0 {
+		cert.Removed["notes"] = n
+		if err := writeJSONFile(notesPath(), notes); err != nil {
+			logError("Ошибка сохранения заметок: %v", err)
+		}
+	}
+	notesMu.Unlock()
+	err = updateDrips(func() bool {
+		if _, ok := drips[id]; !ok {
+			return false
+		}
+		delete(drips, id)
+		cert.Removed["onboarding"] = 1
+		return true
+	})
+	if err != nil {
+		logError("Ошибка удаления цепочки онбординга клиента %d: %v", id, err)
+	}
+	if n := dropArchivedClient(id); n > 0 {
+		cert.Removed["archive"] = n
+	}
+	clientsMu.Unlock()
+
+	// Хранилище может быть сетевым, поэтому аватар удаляется вне clientsMu.
+	if _, err := blobs.Get(r.Context(), avatarKey(id)); err == nil {
+		if err := blobs.Delete(r.Context(), avatarKey(id)); err != nil {
+			logError("Ошибка удаления аватара клиента %d: %v", id, err)
+		} else {
+			cert.Removed["avatar"] = 1
+		}
+	}
+
+	erasuresMu.Lock()
+	defer erasuresMu.Unlock()
+	if n := len(erasures); n > 0 {
+		cert.Prev = erasures[n-1].Hash
+	}
+	cert.Hash = certificateHash(cert)
+	erasures = append(erasures, cert)
+	if err := writeJSONFile(erasuresPath(), erasures); err != nil {
+		// Клиент уже обезличен; запись остается в памяти и сохранится со следующей.
+		logError("Ошибка сохранения журнала обезличиваний: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cert)
+}
+
+// erasuresHandler возвращает журнал обезличиваний (GET /admin/erasures,
+// ?clientId= — записи одного клиента) и проверяет цепочку хешей: valid
+// ложно, если запись изменили или удалили из середины.
+func erasuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := 0
+	if v := r.URL.Query().Get("clientId"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "clientId: ожидается число", http.StatusBadRequest)
+			return
+		}
+		clientID = n
+	}
+
+	erasuresMu.Lock()
+	defer erasuresMu.Unlock()
+	valid := true
+	prev := ""
+	list := []erasureCertificate{}
+	for _, c := range erasures {
+		if c.Prev != prev || certificateHash(c) != c.Hash {
+			valid = false
+		}
+		prev = c.Hash
+		if clientID == 0 || c.ClientID == clientID {
+			list = append(list, c)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"valid": valid, "erasures": list})
+}
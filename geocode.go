@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeocodingConfig задает геокодирование адресов клиентов. Координаты
+// определяются в очереди задач после создания или изменения адреса и
+// сохраняются в address.location.
+type GeocodingConfig struct {
+	Provider  string   `json:"provider"`  // "" — выключено, "nominatim"
+	URL       string   `json:"url"`       // адрес API провайдера
+	UserAgent string   `json:"userAgent"` // Nominatim требует название приложения и контакт
+	Interval  Duration `json:"interval"`  // пауза между запросами к провайдеру
+	Timeout   Duration `json:"timeout"`   // на один запрос
+}
+
+// Geocoder определяет координаты адреса. errGeocodeNotFound — адрес не
+// найден; повторять такой запрос бесполезно.
+type Geocoder interface {
+	Geocode(ctx context.Context, a Address) (GeoPoint, error)
+}
+
+var errGeocodeNotFound = errors.New("Адрес не найден геокодером")
+
+// geocoderProviders — провайдеры геокодирования по имени в
+// geocoding.provider.
+var geocoderProviders = map[string]func(cfg GeocodingConfig) (Geocoder, error){
+	"nominatim": newNominatimGeocoder,
+}
+
+// geocoder — настроенный провайдер; nil, если геокодирование выключено.
+var geocoder Geocoder
+
+// validate проверяет настройки до запуска сервера.
+func (c GeocodingConfig) validate() error {
+	if c.Provider == "" {
+		return nil
+	}
+	if _, ok := geocoderProviders[c.Provider]; !ok {
+		return fmt.Errorf("geocoding: неизвестный провайдер %q", c.Provider)
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("geocoding: url должен быть адресом http(s)://")
+	}
+	if c.Interval < 0 || c.Timeout <= 0 {
+		return fmt.Errorf("geocoding: interval не может быть отрицательным, timeout должен быть положительным")
+	}
+	return nil
+}
+
+// newGeocoder создает провайдера по настройкам; без провайдера — nil.
+func newGeocoder(cfg GeocodingConfig) (Geocoder, error) {
+	if cfg.Provider == "" {
+		return nil, nil
+	}
+	return geocoderProviders[cfg.Provider](cfg)
+}
+
+// nominatimGeocoder обращается к Nominatim (OpenStreetMap). Публичный
+// сервер разрешает не больше одного запроса в секунду, поэтому запросы
+// идут по одному с паузой cfg.Interval.
+type nominatimGeocoder struct {
+	cfg    GeocodingConfig
+	client *outboundClient
+
+	mu   sync.Mutex
+	next time.Time // раньше этого момента следующий запрос не отправляется
+}
+
+func newNominatimGeocoder(cfg GeocodingConfig) (Geocoder, error) {
+	if strings.TrimSpace(cfg.UserAgent) == "" {
+		return nil, errors.New("geocoding: для nominatim укажите userAgent")
+	}
+	return &nominatimGeocoder{cfg: cfg, client: newOutboundClient("geocoding", outboundOptions{Timeout: time.Duration(cfg.Timeout)})}, nil
+}
+
+func (g *nominatimGeocoder) Geocode(ctx context.Context, a Address) (GeoPoint, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if wait := time.Until(g.next); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return GeoPoint{}, ctx.Err()
+		}
+	}
+	defer func() { g.next = time.Now().Add(time.Duration(g.cfg.Interval)) }()
+
+	q := url.Values{"format": {"jsonv2"}, "limit": {"1"}}
+	if a.City != "" {
+		q.Set("city", a.City)
+	}
+	if a.Street != "" {
+		q.Set("street", a.Street)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(g.cfg.URL, "/")+"/search?"+q.Encode(), nil)
+	if err != nil {
+		return GeoPoint{}, err
+	}
+	req.Header.Set("User-Agent", g.cfg.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return GeoPoint{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return GeoPoint{}, fmt.Errorf("nominatim: ответ %s", resp.Status)
+	}
+	var found []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&found); err != nil {
+		return GeoPoint{}, fmt.Errorf("nominatim: %w", err)
+	}
+	if len(found) == 0 {
+		return GeoPoint{}, errGeocodeNotFound
+	}
+	lat, err1 := strconv.ParseFloat(found[0].Lat, 64)
+	lon, err2 := strconv.ParseFloat(found[0].Lon, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return GeoPoint{}, fmt.Errorf("nominatim: %w", err)
+	}
+	return GeoPoint{Lat: lat, Lon: lon}, nil
+}
+
+// maxGeocodeMisses ограничивает память о ненайденных адресах.
+const maxGeocodeMisses = 10000
+
+var (
+	geocodeMisses   = make(map[Address]bool) // Адреса (город и улица), которые геокодер не нашел
+	geocodeMissesMu sync.Mutex               // Мьютекс для защиты geocodeMisses; берется после clientsMu
+)
+
+// geocodeTaskPayload — задача геокодирования одного адреса. Адрес лежит в
+// задаче, чтобы результат не записался к адресу, который успели изменить.
+type geocodeTaskPayload struct {
+	ClientID int    `json:"clientId"`
+	Type     string `json:"type"`
+	City     string `json:"city"`
+	Street   string `json:"street"`
+}
+
+// geocodeTask определяет координаты адреса и записывает их клиенту.
+var geocodeTask = &taskKind{
+	Name: "geocode",
+	Handle: func(ctx context.Context, t *queueTask) error {
+		if geocoder == nil {
+			return permanentError{errors.New("геокодирование выключено")}
+		}
+		var p geocodeTaskPayload
+		if err := json.Unmarshal(t.Payload, &p); err != nil {
+			return permanentError{err}
+		}
+		addr := Address{Type: p.Type, City: p.City, Street: p.Street}
+		point, err := geocoder.Geocode(ctx, addr)
+		if errors.Is(err, errGeocodeNotFound) {
+			// Иначе адрес уходил бы геокодеру при каждом изменении клиента.
+			geocodeMissesMu.Lock()
+			if len(geocodeMisses) >= maxGeocodeMisses {
+				clear(geocodeMisses)
+			}
+			geocodeMisses[Address{City: p.City, Street: p.Street}] = true
+			geocodeMissesMu.Unlock()
+			return permanentError{err}
+		}
+		if err != nil {
+			return err
+		}
+
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		c, exists := clients[p.ClientID]
+		if !exists || c.deleted() {
+			return nil
+		}
+		i := slices.IndexFunc(c.Addresses, func(a Address) bool {
+			return a.Type == addr.Type && sameAddress(a, addr) && a.Location == nil
+		})
+		if i < 0 {
+			return nil
+		}
+		c.Addresses = slices.Clone(c.Addresses)
+		c.Addresses[i].Location = &point
+		c.Address = c.Addresses[0]
+		c.Version++
+		clients[c.ID] = c
+		publishClientEvent(eventClientUpdated, c, sourceJob)
+		return nil
+	},
+	Client: func(payload json.RawMessage) int {
+		var p geocodeTaskPayload
+		json.Unmarshal(payload, &p)
+		return p.ClientID
+	},
+}
+
+// geocodeOnClientEvent ставит в очередь адреса клиента без координат, кроме
+// тех, что геокодер уже не нашел. Подписывается, только если
+// геокодирование включено.
+func geocodeOnClientEvent(e clientEvent) {
+	if e.Type != eventClientCreated && e.Type != eventClientUpdated {
+		return
+	}
+	geocodeMissesMu.Lock()
+	defer geocodeMissesMu.Unlock()
+	for _, a := range e.Client.Addresses {
+		if a.Location != nil || a.City == "" || geocodeMisses[Address{City: a.City, Street: a.Street}] {
+			continue
+		}
+		p := geocodeTaskPayload{ClientID: e.Client.ID, Type: a.Type, City: a.City, Street: a.Street}
+		if err := enqueueTask(geocodeTask.Name, p); err != nil {
+			logf("Адрес клиента %d не поставлен на геокодирование: %v", e.Client.ID, err)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module adv-prog
+
+go 1.24
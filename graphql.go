@@ -0,0 +1,876 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GraphQL для клиентов — подмножество спецификации без фрагментов,
+// директив и интроспекции:
+//
+//	type Query {
+//	  client(id: Int!, includeDeleted: Boolean): Client
+//	  clients(name: String, city: String, favCoffee: String, minAge: Int, maxAge: Int,
+//	          registeredFrom: String, registeredTo: String, tag: String, includeDeleted: Boolean): [Client!]!
+//	}
+//	type Mutation {
+//	  createClient(input: ClientInput!): Client!
+//	  updateClient(id: Int!, version: Int!, input: ClientInput!): Client!
+//	  deleteClient(id: Int!): Boolean!
+//	}
+//	type Client { id name age birthDate registerDate favCoffee address addresses email tags version deletedAt }
+//	type Address { type city street lat lon }
+//	input ClientInput { id name age birthDate registerDate favCoffee address: { city street }
+//	                    addresses: [{ type city street }] email tags }
+//
+// Аргументы clients совпадают с параметрами GET /getClients. Права те же,
+// что у REST: чтение — viewer, изменение — editor, удаление — admin.
+
+// gqlField — поле в запросе.
+type gqlField struct {
+	Alias     string
+	Name      string
+	Args      map[string]any
+	Selection []gqlField
+}
+
+func (f gqlField) key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// gqlOperation — разобранная операция.
+type gqlOperation struct {
+	Type      string // query или mutation
+	Name      string
+	Selection []gqlField
+}
+
+// gqlObject — объект ответа с полями в порядке запроса.
+type gqlObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *gqlObject) set(k string, v any) {
+	o.keys = append(o.keys, k)
+	o.values = append(o.values, v)
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// gqlError — ошибка в ответе; Path указывает поле, в котором она возникла.
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   any        `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+// Разбор запроса
+
+type gqlParser struct {
+	src  string
+	pos  int
+	vars map[string]any
+}
+
+var errGQLEOF = errors.New("GraphQL: неожиданный конец запроса")
+
+func (p *gqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("GraphQL, позиция %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skip пропускает пробелы, запятые и комментарии.
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		if p.pos >= len(p.src) {
+			return errGQLEOF
+		}
+		return p.errorf("ожидается %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) name() (string, error) {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("ожидается имя")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// parseGraphQL разбирает документ и возвращает операцию operationName
+// (или единственную операцию документа).
+func parseGraphQL(src, operationName string, variables map[string]any) (gqlOperation, error) {
+	var found []gqlOperation
+	p := &gqlParser{src: src}
+	for p.peek() != 0 {
+		op, err := p.operation(variables)
+		if err != nil {
+			return op, err
+		}
+		found = append(found, op)
+	}
+	switch {
+	case len(found) == 0:
+		return gqlOperation{}, errors.New("GraphQL: пустой запрос")
+	case operationName == "" && len(found) > 1:
+		return gqlOperation{}, errors.New("GraphQL: в документе несколько операций, укажите operationName")
+	case operationName == "":
+		return found[0], nil
+	}
+	for _, op := range found {
+		if op.Name == operationName {
+			return op, nil
+		}
+	}
+	return gqlOperation{}, fmt.Errorf("GraphQL: операция %q не найдена", operationName)
+}
+
+func (p *gqlParser) operation(variables map[string]any) (gqlOperation, error) {
+	op := gqlOperation{Type: "query"}
+	p.vars = map[string]any{}
+	if p.peek() != '{' {
+		typ, err := p.name()
+		if err != nil {
+			return op, err
+		}
+		if typ != "query" && typ != "mutation" {
+			if typ == "fragment" || typ == "subscription" {
+				return op, p.errorf("%s не поддерживается", typ)
+			}
+			return op, p.errorf("неизвестная операция %q", typ)
+		}
+		op.Type = typ
+		if c := p.peek(); isNameByte(c, true) {
+			if op.Name, err = p.name(); err != nil {
+				return op, err
+			}
+		}
+		if p.peek() == '(' {
+			if err := p.variableDefinitions(variables); err != nil {
+				return op, err
+			}
+		}
+	}
+	sel, err := p.selectionSet()
+	op.Selection = sel
+	return op, err
+}
+
+// variableDefinitions читает ($id: Int!, $city: String = "Алматы") и
+// подставляет значения из variables; тип проверяется только на обязательность.
+func (p *gqlParser) variableDefinitions(variables map[string]any) error {
+	p.pos++
+	for p.peek() != ')' {
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		required, err := p.typeRef()
+		if err != nil {
+			return err
+		}
+		v, given := variables[name]
+		if p.peek() == '=' {
+			p.pos++
+			def, err := p.value(true)
+			if err != nil {
+				return err
+			}
+			if !given {
+				v, given = def, true
+			}
+		}
+		if required && (!given || v == nil) {
+			return fmt.Errorf("GraphQL: не задана обязательная переменная $%s", name)
+		}
+		p.vars[name] = v
+	}
+	p.pos++
+	return nil
+}
+
+// typeRef пропускает тип переменной и сообщает, обязателен ли он.
+func (p *gqlParser) typeRef() (required bool, err error) {
+	if p.peek() == '[' {
+		p.pos++
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(']'); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.peek() == '!' {
+		p.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for p.peek() != '}' {
+		switch p.peek() {
+		case 0:
+			return nil, errGQLEOF
+		case '.':
+			return nil, p.errorf("фрагменты не поддерживаются")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.pos++
+	if len(fields) == 0 {
+		return nil, p.errorf("пустой набор полей")
+	}
+	return fields, nil
+}
+
+func (p *gqlParser) field() (gqlField, error) {
+	var f gqlField
+	name, err := p.name()
+	if err != nil {
+		return f, err
+	}
+	f.Name = name
+	if p.peek() == ':' {
+		p.pos++
+		f.Alias = name
+		if f.Name, err = p.name(); err != nil {
+			return f, err
+		}
+	}
+	if p.peek() == '(' {
+		p.pos++
+		f.Args = map[string]any{}
+		for p.peek() != ')' {
+			arg, err := p.name()
+			if err != nil {
+				return f, err
+			}
+			if err := p.expect(':'); err != nil {
+				return f, err
+			}
+			if f.Args[arg], err = p.value(false); err != nil {
+				return f, err
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == '@' {
+		return f, p.errorf("директивы не поддерживаются")
+	}
+	if p.peek() == '{' {
+		f.Selection, err = p.selectionSet()
+	}
+	return f, err
+}
+
+// value читает значение аргумента. Числа приводятся к int64 или float64,
+// объекты — к map[string]any, как у значений переменных из JSON.
+func (p *gqlParser) value(constant bool) (any, error) {
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, errGQLEOF
+	case c == '$':
+		if constant {
+			return nil, p.errorf("переменная в значении по умолчанию")
+		}
+		p.pos++
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		v, ok := p.vars[name]
+		if !ok {
+			return nil, p.errorf("переменная $%s не объявлена", name)
+		}
+		return v, nil
+	case c == '"':
+		return p.stringValue()
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		lit := p.src[start:p.pos]
+		if n, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			return n, nil
+		}
+		n, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, p.errorf("неверное число %q", lit)
+		}
+		return n, nil
+	case c == '[':
+		p.pos++
+		list := []any{}
+		for p.peek() != ']' {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++
+		return list, nil
+	case c == '{':
+		p.pos++
+		obj := map[string]any{}
+		for p.peek() != '}' {
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if obj[key], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+		return obj, nil
+	default:
+		name, err := p.name()
+		switch {
+		case err != nil:
+			return nil, err
+		case name == "true":
+			return true, nil
+		case name == "false":
+			return false, nil
+		case name == "null":
+			return nil, nil
+		}
+		return name, nil // значение перечисления
+	}
+}
+
+// stringValue читает строку в кавычках; экранирование то же, что в JSON.
+func (p *gqlParser) stringValue() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			return "", p.errorf("перевод строки в строке")
+		case '"':
+			p.pos++
+			var s string
+			if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+				return "", p.errorf("неверная строка")
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	return "", errGQLEOF
+}
+
+// Выполнение
+
+// gqlArgs — проверенный доступ к аргументам поля.
+type gqlArgs map[string]any
+
+func (a gqlArgs) int(name string, required bool) (*int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		if required {
+			return nil, fmt.Errorf("аргумент %s обязателен", name)
+		}
+		return nil, nil
+	case int64:
+		n := int(v)
+		return &n, nil
+	case float64: // из переменных JSON
+		if v == float64(int(v)) {
+			n := int(v)
+			return &n, nil
+		}
+	}
+	return nil, fmt.Errorf("аргумент %s: ожидается Int", name)
+}
+
+func (a gqlArgs) bool(name string) (bool, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("аргумент %s: ожидается Boolean", name)
+}
+
+func (a gqlArgs) string(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("аргумент %s: ожидается String", name)
+}
+
+// input читает ClientInput: поля совпадают с JSON клиента, поэтому значение
+// проходит через тот же json.Unmarshal, что и тело REST-запроса.
+func (a gqlArgs) input(name string) (Client, error) {
+	var c Client
+	in, ok := a[name].(map[string]any)
+	if !ok {
+		return c, fmt.Errorf("аргумент %s: ожидается ClientInput", name)
+	}
+	for _, k := range []string{"version", "deletedAt"} {
+		if _, ok := in[k]; ok {
+			return c, fmt.Errorf("аргумент %s: поле %s задается сервером", name, k)
+		}
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return c, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("аргумент %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// gqlExecutor выполняет операцию от имени p.
+type gqlExecutor struct {
+	p      principal
+	tenant string
+	errors []gqlError
+}
+
+func (e *gqlExecutor) fail(path []any, err error) {
+	e.errors = append(e.errors, gqlError{Message: err.Error(), Path: path})
+}
+
+func (e *gqlExecutor) need(role Role) error {
+	if !e.p.Role.Allows(role) {
+		return fmt.Errorf("Недостаточно прав для выполнения операции: нужна роль %s", role)
+	}
+	return nil
+}
+
+func (e *gqlExecutor) allowDeleted(include bool) error {
+	if include && !e.p.Role.Allows(RoleAdmin) {
+		return errors.New("Удаленных клиентов видят только администраторы")
+	}
+	return nil
+}
+
+// execute выполняет поля верхнего уровня по порядку. Ошибка поля делает
+// его null и попадает в errors, остальные поля выполняются.
+func (e *gqlExecutor) execute(op gqlOperation) *gqlObject {
+	data := &gqlObject{}
+	for _, f := range op.Selection {
+		path := []any{f.key()}
+		var v any
+		var err error
+		if f.Name == "__typename" {
+			v = map[string]string{"query": "Query", "mutation": "Mutation"}[op.Type]
+		} else if op.Type == "mutation" {
+			v, err = e.mutation(f, path)
+		} else {
+			v, err = e.query(f, path)
+		}
+		if err != nil {
+			e.fail(path, err)
+			v = nil
+		}
+		data.set(f.key(), v)
+	}
+	return data
+}
+
+func (e *gqlExecutor) query(f gqlField, path []any) (any, error) {
+	if err := e.need(RoleViewer); err != nil {
+		return nil, err
+	}
+	args := gqlArgs(f.Args)
+	switch f.Name {
+	case "client":
+		id, err := args.int("id", true)
+		if err != nil {
+			return nil, err
+		}
+		include, err := args.bool("includeDeleted")
+		if err != nil {
+			return nil, err
+		}
+		if err := e.allowDeleted(include); err != nil {
+			return nil, err
+		}
+		clientsMu.Lock()
+		c, exists := tenantClientLocked(e.tenant, *id)
+		clientsMu.Unlock()
+		if !exists || (c.deleted() && !include) {
+			return nil, nil
+		}
+		return e.client(c, f.Selection, path)
+
+	case "clients":
+		q := url.Values{}
+		for _, name := range []string{"name", "city", "favCoffee", "registeredFrom", "registeredTo", "tag"} {
+			s, err := args.string(name)
+			if err != nil {
+				return nil, err
+			}
+			if s != "" {
+				q.Set(name, s)
+			}
+		}
+		for _, name := range []string{"minAge", "maxAge"} {
+			n, err := args.int(name, false)
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				q.Set(name, strconv.Itoa(*n))
+			}
+		}
+		include, err := args.bool("includeDeleted")
+		if err != nil {
+			return nil, err
+		}
+		q.Set("includeDeleted", strconv.FormatBool(include))
+		filter, err := parseClientFilter(q)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.allowDeleted(filter.IncludeDeleted); err != nil {
+			return nil, err
+		}
+		filter.Tenant = e.tenant
+		list := []any{}
+		for i, c := range filterClients(filter) {
+			v, err := e.client(c, f.Selection, append(path, i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("Неизвестное поле Query.%s", f.Name)
+}
+
+func (e *gqlExecutor) mutation(f gqlField, path []any) (any, error) {
+	args := gqlArgs(f.Args)
+	switch f.Name {
+	case "createClient":
+		if err := e.need(RoleEditor); err != nil {
+			return nil, err
+		}
+		c, err := args.input("input")
+		if err != nil {
+			return nil, err
+		}
+		c.Tenant = e.tenant
+		clientsMu.Lock()
+		c, err = createClientLocked(c, sourceAPI)
+		clientsMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return e.client(c, f.Selection, path)
+
+	case "updateClient":
+		if err := e.need(RoleEditor); err != nil {
+			return nil, err
+		}
+		id, err := args.int("id", true)
+		if err != nil {
+			return nil, err
+		}
+		version, err := args.int("version", true)
+		if err != nil {
+			return nil, err
+		}
+		upd, err := args.input("input")
+		if err != nil {
+			return nil, err
+		}
+		if upd.ID != 0 && upd.ID != *id {
+			return nil, errors.New("ID в input не совпадает с аргументом id")
+		}
+		upd.Version = *version
+
+		clientsMu.Lock()
+		if cur, exists := tenantClientLocked(e.tenant, *id); !exists || cur.deleted() {
+			err = errors.New("Клиент не найден")
+		} else {
+			keepBirthDate(&upd, cur)
+			upd, err = updateClientLocked(*id, upd, sourceAPI)
+		}
+		clientsMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return e.client(upd, f.Selection, path)
+
+	case "deleteClient":
+		if err := e.need(RoleAdmin); err != nil {
+			return nil, err
+		}
+		if f.Selection != nil {
+			return nil, errors.New("deleteClient возвращает Boolean, поля не выбираются")
+		}
+		id, err := args.int("id", true)
+		if err != nil {
+			return nil, err
+		}
+		clientsMu.Lock()
+		ok := softDeleteLocked(e.tenant, *id, time.Now(), sourceAPI)
+		clientsMu.Unlock()
+		return ok, nil
+	}
+	return nil, fmt.Errorf("Неизвестное поле Mutation.%s", f.Name)
+}
+
+// client собирает выбранные поля клиента.
+func (e *gqlExecutor) client(c Client, sel []gqlField, path []any) (any, error) {
+	if sel == nil {
+		return nil, errors.New("для Client нужно выбрать поля")
+	}
+	obj := &gqlObject{}
+	for _, f := range sel {
+		var v any
+		switch f.Name {
+		case "__typename":
+			v = "Client"
+		case "id":
+			v = c.ID
+		case "name":
+			v = c.Name
+		case "age":
+			v = c.currentAge()
+		case "birthDate":
+			if c.BirthDate != "" {
+				v = c.BirthDate
+			}
+		case "registerDate":
+			v = c.RegisterDate
+		case "favCoffee":
+			v = c.FavCoffee
+		case "email":
+			v = c.Email
+		case "tags":
+			v = c.Tags
+			if c.Tags == nil {
+				v = []string{}
+			}
+		case "version":
+			v = c.Version
+		case "deletedAt":
+			v = c.DeletedAt
+		case "address":
+			addr, err := gqlAddress(c.Address, f.Selection)
+			if err != nil {
+				return nil, err
+			}
+			v = addr
+		case "addresses":
+			list := []any{}
+			for _, a := range c.Addresses {
+				addr, err := gqlAddress(a, f.Selection)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, addr)
+			}
+			v = list
+		default:
+			return nil, fmt.Errorf("Неизвестное поле Client.%s", f.Name)
+		}
+		if f.Name != "address" && f.Name != "addresses" && f.Selection != nil {
+			return nil, fmt.Errorf("Client.%s: у скалярного поля нет вложенных полей", f.Name)
+		}
+		obj.set(f.key(), v)
+	}
+	return obj, nil
+}
+
+// gqlAddress собирает выбранные поля адреса; lat и lon — null, пока
+// геокодер не определил координаты.
+func gqlAddress(a Address, sel []gqlField) (any, error) {
+	if sel == nil {
+		return nil, errors.New("для Address нужно выбрать поля")
+	}
+	obj := &gqlObject{}
+	for _, f := range sel {
+		var v any
+		switch f.Name {
+		case "__typename":
+			v = "Address"
+		case "type":
+			v = a.Type
+		case "city":
+			v = a.City
+		case "street":
+			v = a.Street
+		case "lat", "lon":
+			if a.Location != nil {
+				v = a.Location.Lat
+				if f.Name == "lon" {
+					v = a.Location.Lon
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Неизвестное поле Address.%s", f.Name)
+		}
+		obj.set(f.key(), v)
+	}
+	return obj, nil
+}
+
+// graphqlHandler выполняет запросы GraphQL: POST /graphql с телом
+// {"query", "variables", "operationName"} или GET /graphql?query= (только
+// чтение). Ошибки разбора — 400, ошибки полей приходят в errors рядом с
+// данными с кодом 200.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "variables: ожидается объект JSON", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op, err := parseGraphQL(req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		writeGraphQL(w, r, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: err.Error()}}})
+		return
+	}
+	if op.Type == "mutation" && r.Method != http.MethodPost {
+		writeGraphQL(w, r, http.StatusMethodNotAllowed, gqlResponse{Errors: []gqlError{{Message: "Изменения выполняются только через POST"}}})
+		return
+	}
+
+	p, _ := principalFrom(r)
+	if op.Type == "mutation" && config.Replica.enabled() {
+		writeGraphQL(w, r, http.StatusMisdirectedRequest, gqlResponse{Errors: []gqlError{{Message: replicaMessage}}})
+		return
+	}
+	if m := currentMaintenance(); op.Type == "mutation" && m.Enabled && !maintenanceOperator(p) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Duration(m.RetryAfter).Seconds())))
+		writeGraphQL(w, r, http.StatusServiceUnavailable, gqlResponse{Errors: []gqlError{{Message: maintenanceMessage}}})
+		return
+	}
+	e := &gqlExecutor{p: p, tenant: requestTenant(r)}
+	data := e.execute(op)
+	writeGraphQL(w, r, http.StatusOK, gqlResponse{Data: data, Errors: e.errors})
+}
+
+func writeGraphQL(w http.ResponseWriter, r *http.Request, status int, resp gqlResponse) {
+	locale := localeFrom(r)
+	for i := range resp.Errors {
+		resp.Errors[i].Message = translate(locale, resp.Errors[i].Message)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
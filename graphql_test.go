@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           string
+		operationName string
+		variables     map[string]any
+		want          gqlOperation
+	}{
+		{"сокращенная запись", `{ clients { id } }`, "", nil, gqlOperation{Type: "query", Selection: []gqlField{
+			{Name: "clients", Selection: []gqlField{{Name: "id"}}},
+		}}},
+		{"псевдоним и аргументы", `query Q { c: client(id: 1, includeDeleted: true) { name } }`, "", nil, gqlOperation{Type: "query", Name: "Q", Selection: []gqlField{
+			{Alias: "c", Name: "client", Args: map[string]any{"id": int64(1), "includeDeleted": true}, Selection: []gqlField{{Name: "name"}}},
+		}}},
+		{"вложенные поля", `{ client(id: 1) { address { city } addresses { type street } } }`, "", nil, gqlOperation{Type: "query", Selection: []gqlField{
+			{Name: "client", Args: map[string]any{"id": int64(1)}, Selection: []gqlField{
+				{Name: "address", Selection: []gqlField{{Name: "city"}}},
+				{Name: "addresses", Selection: []gqlField{{Name: "type"}, {Name: "street"}}},
+			}},
+		}}},
+		{"переменные", `query ($id: Int!, $tags: [String!]) { client(id: $id) { tags } }`, "", map[string]any{"id": 7.0}, gqlOperation{Type: "query", Selection: []gqlField{
+			{Name: "client", Args: map[string]any{"id": 7.0}, Selection: []gqlField{{Name: "tags"}}},
+		}}},
+		{"значение по умолчанию", `query ($city: String = "Алматы") { clients(city: $city) { id } }`, "", nil, gqlOperation{Type: "query", Selection: []gqlField{
+			{Name: "clients", Args: map[string]any{"city": "Алматы"}, Selection: []gqlField{{Name: "id"}}},
+		}}},
+		{"объект и список", `mutation { createClient(input: {id: 1, name: "Айгерим", tags: ["vip"]}) { id } }`, "", nil, gqlOperation{Type: "mutation", Selection: []gqlField{
+			{Name: "createClient", Args: map[string]any{"input": map[string]any{"id": int64(1), "name": "Айгерим", "tags": []any{"vip"}}}, Selection: []gqlField{{Name: "id"}}},
+		}}},
+		{"выбор операции", `query A { clients { id } } query B { clients { name } }`, "B", nil, gqlOperation{Type: "query", Name: "B", Selection: []gqlField{
+			{Name: "clients", Selection: []gqlField{{Name: "name"}}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGraphQL(tt.src, tt.operationName, tt.variables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("разобрано %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           string
+		operationName string
+		variables     map[string]any
+	}{
+		{"пустой запрос", "", "", nil},
+		{"незакрытая скобка", `{ clients { id }`, "", nil},
+		{"пустой набор полей", `{ }`, "", nil},
+		{"неизвестная операция", `select { clients { id } }`, "", nil},
+		{"подписка", `subscription { clients { id } }`, "", nil},
+		{"фрагмент", `{ clients { ...F } }`, "", nil},
+		{"директива", `{ clients @include(if: true) { id } }`, "", nil},
+		{"незакрытая строка", `{ clients(name: "Айг) { id } }`, "", nil},
+		{"неверное число", `{ client(id: 1-2) { id } }`, "", nil},
+		{"нет обязательной переменной", `query ($id: Int!) { client(id: $id) { id } }`, "", nil},
+		{"null в обязательной переменной", `query ($id: Int!) { client(id: $id) { id } }`, "", map[string]any{"id": nil}},
+		{"необъявленная переменная", `{ client(id: $id) { id } }`, "", map[string]any{"id": 1.0}},
+		{"переменная в значении по умолчанию", `query ($a: Int = $b) { client(id: $a) { id } }`, "", nil},
+		{"несколько операций без имени", `query A { clients { id } } query B { clients { id } }`, "", nil},
+		{"нет операции с таким именем", `query A { clients { id } }`, "B", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if op, err := parseGraphQL(tt.src, tt.operationName, tt.variables); err == nil {
+				t.Errorf("запрос разобран: %+v", op)
+			}
+		})
+	}
+}
+
+func TestGraphQLExecute(t *testing.T) {
+	setupTest(t)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 2, FavCoffee: "Латте",
+		Address: Address{Type: "home", City: "Алматы"}, Addresses: []Address{{Type: "home", City: "Алматы"}}}}
+	h := withTenant(requireRole(RoleViewer, graphqlHandler))
+	viewer := testToken(t, "viewer", RoleViewer, "")
+
+	tests := []struct {
+		name      string
+		method    string
+		query     string
+		variables string
+		want      int
+		body      string
+	}{
+		{"вложенные поля и псевдоним", http.MethodPost, `{ c: client(id: 1) { id name address { city } addresses { type } } }`, "", http.StatusOK,
+			`{"data":{"c":{"id":1,"name":"Айгерим","address":{"city":"Алматы"},"addresses":[{"type":"home"}]}}}`},
+		{"переменные", http.MethodPost, `query ($id: Int!) { client(id: $id) { name version } }`, `{"id": 1}`, http.StatusOK,
+			`{"data":{"client":{"name":"Айгерим","version":2}}}`},
+		{"переменные в GET", http.MethodGet, `query ($id: Int!) { client(id: $id) { name } }`, `{"id": 1}`, http.StatusOK,
+			`{"data":{"client":{"name":"Айгерим"}}}`},
+		{"нет клиента", http.MethodPost, `{ client(id: 2) { id } }`, "", http.StatusOK,
+			`{"data":{"client":null}}`},
+		{"неизвестное поле клиента", http.MethodPost, `{ client(id: 1) { id foo } clients { id } }`, "", http.StatusOK,
+			`{"data":{"client":null,"clients":[{"id":1}]},"errors":[{"message":"Неизвестное поле Client.foo","path":["client"]}]}`},
+		{"неизвестное поле запроса", http.MethodPost, `{ foo }`, "", http.StatusOK,
+			`{"data":{"foo":null},"errors":[{"message":"Неизвестное поле Query.foo","path":["foo"]}]}`},
+		{"поля скаляра", http.MethodPost, `{ client(id: 1) { name { x } } }`, "", http.StatusOK,
+			`{"data":{"client":null},"errors":[{"message":"Client.name: у скалярного поля нет вложенных полей","path":["client"]}]}`},
+		{"без прав на изменение", http.MethodPost, `mutation { deleteClient(id: 1) }`, "", http.StatusOK,
+			`{"data":{"deleteClient":null},"errors":[{"message":"Недостаточно прав для выполнения операции: нужна роль admin","path":["deleteClient"]}]}`},
+		{"изменение через GET", http.MethodGet, `mutation { deleteClient(id: 1) }`, "", http.StatusMethodNotAllowed,
+			`{"errors":[{"message":"Изменения выполняются только через POST"}]}`},
+		{"синтаксическая ошибка", http.MethodPost, `{ client(id: 1) { id }`, "", http.StatusBadRequest,
+			`{"errors":[{"message":"GraphQL: неожиданный конец запроса"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, body := "/graphql?"+url.Values{"query": {tt.query}, "variables": {tt.variables}}.Encode(), ""
+			if tt.method == http.MethodPost {
+				req := map[string]any{"query": tt.query}
+				if tt.variables != "" {
+					req["variables"] = json.RawMessage(tt.variables)
+				}
+				data, err := json.Marshal(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				target, body = "/graphql", string(data)
+			}
+			w := testRequest(h, tt.method, target, viewer, body, nil)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d", w.Code, tt.want)
+			}
+			if got := w.Body.String(); got != tt.body+"\n" {
+				t.Errorf("ответ %s, ожидался %s", got, tt.body)
+			}
+		})
+	}
+	if clients[1].deleted() {
+		t.Error("клиент удален без прав администратора")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GRPCConfig задает сервер gRPC (clients.v1.ClientService из
+// proto/client.proto). Пустой Addr отключает его.
+type GRPCConfig struct {
+	Addr string `json:"addr"`
+}
+
+// grpcMaxMessage — предел размера входящего сообщения, как в grpc-go.
+const grpcMaxMessage = 4 << 20
+
+// Коды статуса gRPC, которые возвращает сервис.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcFailedPrecondition = 9
+	grpcAborted            = 10
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError — ошибка вызова с кодом статуса gRPC.
+type grpcError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcError) Error() string { return e.Message }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// grpcMethod — метод сервиса. Запрос приходит одним сообщением, ответ —
+// одним или, у потоковых методов, несколькими вызовами send.
+type grpcMethod struct {
+	Need Role
+	Call func(p principal, req []byte, send func([]byte) error) error
+}
+
+var grpcMethods = map[string]grpcMethod{
+	"/clients.v1.ClientService/Create": {RoleEditor, grpcCreateClient},
+	"/clients.v1.ClientService/Get":    {RoleViewer, grpcGetClient},
+	"/clients.v1.ClientService/List":   {RoleViewer, grpcListClients},
+	"/clients.v1.ClientService/Update": {RoleEditor, grpcUpdateClient},
+	"/clients.v1.ClientService/Delete": {RoleAdmin, grpcDeleteClient},
+}
+
+// grpcHandler принимает вызовы gRPC поверх HTTP/2 без TLS (h2c).
+// Метаданные authorization и x-api-key проверяются так же, как заголовки
+// REST API, и права те же: чтение — viewer, изменение — editor,
+// удаление — admin.
+func grpcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Ожидается запрос gRPC", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	err := serveGRPC(w, r)
+
+	var ge *grpcError
+	switch {
+	case err == nil:
+		ge = &grpcError{Code: grpcOK}
+	case !errors.As(err, &ge):
+		ge = &grpcError{Code: grpcInternal, Message: err.Error()}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(ge.Code))
+	if ge.Message != "" {
+		// grpc-message передается в percent-encoding; язык — из метаданных
+		// accept-language.
+		msg := translate(acceptLanguage(r.Header.Get("Accept-Language")), ge.Message)
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func serveGRPC(w http.ResponseWriter, r *http.Request) error {
+	m, ok := grpcMethods[r.URL.Path]
+	if !ok {
+		return grpcErrorf(grpcUnimplemented, "Неизвестный метод %s", r.URL.Path)
+	}
+	p, err := authenticate(r)
+	if err != nil {
+		return grpcErrorf(grpcUnauthenticated, "%v", err)
+	}
+	if p.Scope == scope2FAEnroll {
+		return grpcErrorf(grpcPermissionDenied, "Требуется настроить двухфакторную аутентификацию")
+	}
+	if !p.Role.Allows(m.Need) {
+		return grpcErrorf(grpcPermissionDenied, "Недостаточно прав для выполнения операции: нужна роль %s", m.Need)
+	}
+	if m.Need != RoleViewer && config.Replica.enabled() {
+		return grpcErrorf(grpcFailedPrecondition, replicaMessage)
+	}
+	if m.Need != RoleViewer && currentMaintenance().Enabled && !maintenanceOperator(p) {
+		return grpcErrorf(grpcUnavailable, maintenanceMessage)
+	}
+	// Кофейня выбирается метаданными x-tenant-id по тем же правилам, что в
+	// REST; дальше методы работают с клиентами кофейни p.Tenant.
+	if p.Tenant, err = resolveTenant(r); err != nil {
+		if errors.Is(err, errTenantForbidden) {
+			return grpcErrorf(grpcPermissionDenied, "%v", err)
+		}
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	flusher, _ := w.(http.Flusher)
+	return m.Call(p, req, func(msg []byte) error {
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		if _, err := w.Write(append(frame, msg...)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// readGRPCMessage читает одно сообщение: флаг сжатия, длина, тело.
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "Нет сообщения запроса")
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "Сжатие сообщений не поддерживается")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcInvalidArgument, "Сообщение больше %d байт", grpcMaxMessage)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "Сообщение запроса оборвано")
+	}
+	return msg, nil
+}
+
+// grpcRequest разбирает сообщение запроса; ошибка формата — INVALID_ARGUMENT.
+func grpcRequest(req []byte, fn func(f protoField) error) error {
+	if err := protoEachField(req, fn); err != nil {
+		var ge *grpcError
+		if errors.As(err, &ge) {
+			return err
+		}
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return nil
+}
+
+func grpcClientField(req []byte) (Client, error) {
+	var c Client
+	err := grpcRequest(req, func(f protoField) error {
+		if f.Num != 1 {
+			return nil
+		}
+		var err error
+		c, err = unmarshalClientProto(f.Bytes)
+		return err
+	})
+	return c, err
+}
+
+func grpcCreateClient(p principal, req []byte, send func([]byte) error) error {
+	c, err := grpcClientField(req)
+	if err != nil {
+		return err
+	}
+	c.Tenant = p.Tenant
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	c, err = createClientLocked(c, sourceAPI)
+	if errors.Is(err, errClientExists) {
+		return grpcErrorf(grpcAlreadyExists, "%v", err)
+	}
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return send(marshalClientProto(c))
+}
+
+func grpcGetClient(p principal, req []byte, send func([]byte) error) error {
+	var id int
+	var include bool
+	err := grpcRequest(req, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			id = int(int64(f.Varint))
+		case 2:
+			include = f.Varint != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := grpcAllowDeleted(p, include); err != nil {
+		return err
+	}
+
+	clientsMu.Lock()
+	c, exists := tenantClientLocked(p.Tenant, id)
+	clientsMu.Unlock()
+	if !exists || (c.deleted() && !include) {
+		return grpcErrorf(grpcNotFound, "Клиент не найден")
+	}
+	return send(marshalClientProto(c))
+}
+
+// grpcListClients переводит запрос в параметры GET /getClients, чтобы
+// условия отбора разбирались в одном месте.
+func grpcListClients(p principal, req []byte, send func([]byte) error) error {
+	q := url.Values{}
+	params := map[int]string{1: "name", 2: "city", 3: "favCoffee", 6: "registeredFrom", 7: "registeredTo"}
+	err := grpcRequest(req, func(f protoField) error {
+		switch f.Num {
+		case 4:
+			q.Set("minAge", strconv.Itoa(int(int32(f.Varint))))
+		case 5:
+			q.Set("maxAge", strconv.Itoa(int(int32(f.Varint))))
+		case 8:
+			q.Set("includeDeleted", strconv.FormatBool(f.Varint != 0))
+		case 9:
+			q.Add("tag", string(f.Bytes))
+		default:
+			if name, ok := params[f.Num]; ok {
+				q.Set(name, string(f.Bytes))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	filter, err := parseClientFilter(q)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err := grpcAllowDeleted(p, filter.IncludeDeleted); err != nil {
+		return err
+	}
+	filter.Tenant = p.Tenant
+
+	for _, c := range filterClients(filter) {
+		if err := send(marshalClientProto(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func grpcUpdateClient(p principal, req []byte, send func([]byte) error) error {
+	upd, err := grpcClientField(req)
+	if err != nil {
+		return err
+	}
+	if upd.Version == 0 {
+		return grpcErrorf(grpcFailedPrecondition, "Требуется поле version")
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	cur, exists := tenantClientLocked(p.Tenant, upd.ID)
+	if !exists || cur.deleted() {
+		return grpcErrorf(grpcNotFound, "Клиент не найден")
+	}
+	keepBirthDate(&upd, cur)
+	upd, err = updateClientLocked(upd.ID, upd, sourceAPI)
+	var conflict versionConflictError
+	if errors.As(err, &conflict) {
+		return grpcErrorf(grpcAborted, "%v", err)
+	}
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return send(marshalClientProto(upd))
+}
+
+func grpcDeleteClient(p principal, req []byte, send func([]byte) error) error {
+	var id int
+	err := grpcRequest(req, func(f protoField) error {
+		if f.Num == 1 {
+			id = int(int64(f.Varint))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if !softDeleteLocked(p.Tenant, id, time.Now(), sourceAPI) {
+		return grpcErrorf(grpcNotFound, "Клиент не найден")
+	}
+	return send(nil) // DeleteClientResponse без полей
+}
+
+// grpcAllowDeleted — то же правило, что allowIncludeDeleted в REST.
+func grpcAllowDeleted(p principal, include bool) error {
+	if include && !p.Role.Allows(RoleAdmin) {
+		return grpcErrorf(grpcPermissionDenied, "Удаленных клиентов видят только администраторы")
+	}
+	return nil
+}
+
+// newGRPCServer создает сервер gRPC на отдельном порту. gRPC требует
+// HTTP/2, поэтому включен только h2c.
+func newGRPCServer(addr string) *http.Server {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:      addr,
+		Handler:   http.HandlerFunc(grpcHandler),
+		Protocols: &protocols,
+	}
+}
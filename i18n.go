@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Локализация. Исходный язык — русский: сообщения в коде и шаблонах пишутся
+// по-русски и служат ключами каталогов <dir>/<язык>.json. Ключ с %s, %d, %q
+// или %v сопоставляется с готовым сообщением как шаблон, а подставленные
+// значения переносятся в перевод по порядку или по номеру (%[2]s). Так
+// переводятся и ошибки, собранные через fmt.Errorf глубоко в коде.
+
+// I18nConfig задает каталоги переводов.
+type I18nConfig struct {
+	Dir           string `json:"dir"`
+	DefaultLocale string `json:"defaultLocale"` // если Accept-Language не подошел
+}
+
+// sourceLocale — язык сообщений в коде; каталог для него не нужен.
+const sourceLocale = "ru"
+
+// localeCookie запоминает язык, выбранный параметром ?lang=.
+const localeCookie = "lang"
+
+const ctxLocale ctxKey = ctxPrincipal + 1
+
+// catalog — переводы на один язык.
+type catalog struct {
+	exact    map[string]string
+	patterns []catalogPattern
+}
+
+type catalogPattern struct {
+	re      *regexp.Regexp
+	literal int // длина постоянной части: более точные шаблоны проверяются первыми
+	to      string
+}
+
+// catalogs — переводы по языкам; у исходного языка каталог nil.
+var catalogs = map[string]*catalog{sourceLocale: nil}
+
+var catalogVerb = regexp.MustCompile(`%(\[\d+\])?[sdqvw]`)
+
+// loadCatalogs читает все *.json из каталога переводов.
+func loadCatalogs(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var entries map[string]string
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("разбор %s: %w", path, err)
+		}
+		locale := strings.TrimSuffix(filepath.Base(path), ".json")
+		catalogs[locale] = newCatalog(entries)
+	}
+	if _, ok := catalogs[config.I18n.DefaultLocale]; !ok {
+		return fmt.Errorf("i18n.defaultLocale: нет каталога %q", config.I18n.DefaultLocale)
+	}
+	return nil
+}
+
+func newCatalog(entries map[string]string) *catalog {
+	c := &catalog{exact: make(map[string]string)}
+	for from, to := range entries {
+		if !catalogVerb.MatchString(from) {
+			c.exact[from] = to
+			continue
+		}
+		var expr strings.Builder
+		p := catalogPattern{to: to}
+		last := 0
+		for _, m := range catalogVerb.FindAllStringIndex(from, -1) {
+			expr.WriteString(regexp.QuoteMeta(from[last:m[0]]))
+			expr.WriteString("(.+?)")
+			p.literal += m[0] - last
+			last = m[1]
+		}
+		expr.WriteString(regexp.QuoteMeta(from[last:]))
+		p.literal += len(from) - last
+		p.re = regexp.MustCompile("^" + expr.String() + "$")
+		c.patterns = append(c.patterns, p)
+	}
+	slices.SortFunc(c.patterns, func(a, b catalogPattern) int { return b.literal - a.literal })
+	return c
+}
+
+// translate переводит сообщение; без перевода возвращает его как есть.
+func translate(locale, msg string) string {
+	return catalogs[locale].translate(msg, 3)
+}
+
+// translate ищет точный перевод, затем шаблон. Подставленные значения тоже
+// переводятся — это вложенные ошибки, — но не глубже depth уровней.
+func (c *catalog) translate(msg string, depth int) string {
+	if c == nil || msg == "" {
+		return msg
+	}
+	if to, ok := c.exact[msg]; ok {
+		return to
+	}
+	if depth == 0 {
+		return msg
+	}
+	for _, p := range c.patterns {
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		args := m[1:]
+		for i := range args {
+			args[i] = c.translate(args[i], depth-1)
+		}
+		return substituteArgs(p.to, args)
+	}
+	return msg
+}
+
+// substituteArgs вставляет значения вместо %s, %d и т. п. в переводе:
+// по порядку или по номеру %[n]. %% остается знаком процента.
+func substituteArgs(format string, args []string) string {
+	var b strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		if format[i+1] == '%' {
+			b.WriteByte('%')
+			i++
+			continue
+		}
+		m := catalogVerb.FindStringSubmatchIndex(format[i:])
+		if m == nil || m[0] != 0 {
+			b.WriteByte('%')
+			continue
+		}
+		n := next
+		if m[2] >= 0 {
+			n, _ = strconv.Atoi(format[i+m[2]+1 : i+m[3]-1])
+			n--
+		}
+		if n >= 0 && n < len(args) {
+			b.WriteString(args[n])
+		}
+		next = n + 1
+		i += m[1] - 1
+	}
+	return b.String()
+}
+
+// acceptLanguage выбирает язык из заголовка Accept-Language с учетом q.
+func acceptLanguage(header string) string {
+	best, bestQ := config.I18n.DefaultLocale, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && k == "q" {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[primary]; ok && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// requestLocale определяет язык: ?lang= (запоминается в cookie), cookie,
+// затем Accept-Language.
+func requestLocale(w http.ResponseWriter, r *http.Request) string {
+	if l := r.URL.Query().Get("lang"); l != "" {
+		if _, ok := catalogs[l]; ok {
+			http.SetCookie(w, &http.Cookie{
+				Name:     localeCookie,
+				Value:    l,
+				Path:     "/",
+				MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+				SameSite: http.SameSiteLaxMode,
+			})
+			return l
+		}
+	}
+	if c, err := r.Cookie(localeCookie); err == nil {
+		if _, ok := catalogs[c.Value]; ok {
+			return c.Value
+		}
+	}
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// localeFrom возвращает язык, выбранный для запроса в localize.
+func localeFrom(r *http.Request) string {
+	if l, ok := r.Context().Value(ctxLocale).(string); ok {
+		return l
+	}
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// localize выбирает язык запроса и переводит на него тексты http.Error,
+// поэтому обработчики по-прежнему пишут ошибки по-русски. Ответы JSON
+// переводят writeAPIError и writeGraphQL, страницы — шаблоны.
+func localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := requestLocale(w, r)
+		r = r.WithContext(context.WithValue(r.Context(), ctxLocale, locale))
+		if catalogs[locale] == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&localizeWriter{ResponseWriter: w, locale: locale}, r)
+	})
+}
+
+// localizeWriter узнает ответ http.Error по его заголовкам и переводит
+// текст; http.Error пишет тело одним вызовом Write.
+type localizeWriter struct {
+	http.ResponseWriter
+	locale    string
+	wrote     bool
+	translate bool
+}
+
+func (w *localizeWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		h := w.Header()
+		w.translate = code >= 400 && h.Get("X-Content-Type-Options") == "nosniff" &&
+			strings.HasPrefix(h.Get("Content-Type"), "text/plain") && h.Get("Content-Encoding") == ""
+		if w.translate {
+			h.Add("Vary", "Accept-Language")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *localizeWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.translate {
+		return w.ResponseWriter.Write(p)
+	}
+	msg := translate(w.locale, strings.TrimSuffix(string(p), "\n"))
+	if _, err := w.ResponseWriter.Write([]byte(msg + "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Unwrap дает http.ResponseController доступ к исходному writer.
+func (w *localizeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *localizeWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader — заголовок, которым клиент помечает повторяемый запрос.
+const idempotencyHeader = "Idempotency-Key"
+
+// IdempotencyConfig задает, сколько хранится первый ответ на ключ.
+type IdempotencyConfig struct {
+	TTL Duration `json:"ttl"`
+}
+
+// idempotentResponse — сохраненный ответ на первый запрос с ключом.
+type idempotentResponse struct {
+	bodyHash [sha256.Size]byte
+	done     bool // false, пока первый запрос еще выполняется
+	status   int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+var (
+	idempotent   = make(map[string]*idempotentResponse) // Ответы по ключу
+	idempotentMu sync.Mutex                             // Мьютекс для защиты ответов
+)
+
+// pruneIdempotencyJob удаляет просроченные ответы, даже если повторяемых
+// запросов давно не было.
+var pruneIdempotencyJob = &scheduledJob{
+	Name:     "prune-idempotency",
+	Schedule: "@every 1h",
+	Run: func(ctx context.Context) error {
+		idempotentMu.Lock()
+		defer idempotentMu.Unlock()
+		pruneIdempotentLocked(time.Now())
+		return nil
+	},
+}
+
+// pruneIdempotentLocked удаляет завершенные ответы с истекшим сроком.
+// Вызывается под idempotentMu.
+func pruneIdempotentLocked(now time.Time) {
+	for k, resp := range idempotent {
+		if resp.done && now.After(resp.expires) {
+			delete(idempotent, k)
+		}
+	}
+}
+
+// replayedHeaders — заголовки, которые сохраняются вместе с ответом.
+// Content-Encoding и Content-Length не сохраняются: их выставляет
+// middleware сжатия для каждого ответа заново.
+var replayedHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location"}
+
+// responseRecorder пишет ответ клиенту и одновременно запоминает его.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// withIdempotency повторяет сохраненный ответ для запросов с тем же
+// Idempotency-Key. Ключ действует в пределах отправителя, кофейни и эндпоинта;
+// повтор ключа с другим телом отклоняется. Тело больше limit отклоняется
+// с 413.
+func withIdempotency(next http.HandlerFunc, limit int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Тело запроса больше %d МБ", limit>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		p, _ := principalFrom(r)
+		scope := p.Kind + ":" + p.Name + " " + requestTenant(r) + " " + r.Method + " " + r.URL.Path + " " + key
+		now := time.Now()
+
+		idempotentMu.Lock()
+		pruneIdempotentLocked(now)
+		saved, exists := idempotent[scope]
+		var prev idempotentResponse
+		if exists {
+			prev = *saved
+		} else {
+			saved = &idempotentResponse{bodyHash: hash}
+			idempotent[scope] = saved
+		}
+		idempotentMu.Unlock()
+
+		if exists {
+			switch {
+			case prev.bodyHash != hash:
+				http.Error(w, "Idempotency-Key уже использован с другим телом запроса", http.StatusUnprocessableEntity)
+			case !prev.done:
+				http.Error(w, "Запрос с этим Idempotency-Key еще выполняется", http.StatusConflict)
+			default:
+				for k, v := range prev.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(prev.status)
+				w.Write(prev.body)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		finished := false
+		defer func() {
+			if finished {
+				return
+			}
+			// Обработчик завершился паникой: без этого повторы получали
+			// бы 409 до перезапуска сервера.
+			idempotentMu.Lock()
+			defer idempotentMu.Unlock()
+			if idempotent[scope] == saved {
+				delete(idempotent, scope)
+			}
+		}()
+		next(rec, r)
+		finished = true
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		idempotentMu.Lock()
+		defer idempotentMu.Unlock()
+		if rec.status >= http.StatusInternalServerError {
+			// Сбой сервера не кэшируется: повтор должен выполниться заново.
+			delete(idempotent, scope)
+			return
+		}
+		saved.done = true
+		saved.status = rec.status
+		saved.header = make(http.Header)
+		for _, k := range replayedHeaders {
+			if v := w.Header().Values(k); len(v) > 0 {
+				saved.header[k] = v
+			}
+		}
+		saved.body = rec.body.Bytes()
+		saved.expires = time.Now().Add(time.Duration(config.Idempotency.TTL))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIdempotency(t *testing.T) {
+	type step struct {
+		key      string
+		body     string
+		want     int
+		replayed bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		calls int // сколько раз вызван обработчик
+	}{
+		{"без ключа", []step{
+			{"", `{"n":1}`, http.StatusCreated, false},
+			{"", `{"n":1}`, http.StatusCreated, false},
+		}, 2},
+		{"повтор", []step{
+			{"k1", `{"n":1}`, http.StatusCreated, false},
+			{"k1", `{"n":1}`, http.StatusCreated, true},
+		}, 1},
+		{"другое тело", []step{
+			{"k1", `{"n":1}`, http.StatusCreated, false},
+			{"k1", `{"n":2}`, http.StatusUnprocessableEntity, false},
+		}, 1},
+		{"разные ключи", []step{
+			{"k1", `{"n":1}`, http.StatusCreated, false},
+			{"k2", `{"n":1}`, http.StatusCreated, false},
+		}, 2},
+		{"тело больше предела", []step{
+			{"k1", strings.Repeat("x", 65), http.StatusRequestEntityTooLarge, false},
+			{"k1", `{"n":1}`, http.StatusCreated, false},
+		}, 1},
+		{"сбой не запоминается", []step{
+			{"k1", `fail`, http.StatusInternalServerError, false},
+			{"k1", `fail`, http.StatusInternalServerError, false},
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			calls := 0
+			h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				if string(body) == "fail" {
+					http.Error(w, "сбой", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Location", fmt.Sprintf("/clients/%d", calls))
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, "вызов %d", calls)
+			}, 64)
+
+			var first string
+			for i, s := range tt.steps {
+				headers := map[string]string{}
+				if s.key != "" {
+					headers[idempotencyHeader] = s.key
+				}
+				w := testRequest(h, http.MethodPost, "/clients/batch", "", s.body, headers)
+				if w.Code != s.want {
+					t.Fatalf("шаг %d: статус %d, ожидался %d: %s", i, w.Code, s.want, w.Body)
+				}
+				if got := w.Header().Get("Idempotent-Replayed") == "true"; got != s.replayed {
+					t.Errorf("шаг %d: Idempotent-Replayed = %v", i, got)
+				}
+				if i == 0 {
+					first = w.Body.String()
+				} else if s.replayed && (w.Body.String() != first || w.Header().Get("Location") != "/clients/1") {
+					t.Errorf("шаг %d: повтор %q, Location %q, ожидался %q", i, w.Body, w.Header().Get("Location"), first)
+				}
+			}
+			if calls != tt.calls {
+				t.Errorf("обработчик вызван %d раз, ожидалось %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+// TestIdempotencyScope проверяет, что ключ одного отправителя не дает
+// ответ другому.
+func TestIdempotencyScope(t *testing.T) {
+	setupTest(t)
+	calls := 0
+	h := requireAuth(withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}, 1<<20))
+
+	for _, user := range []string{"aigerim", "dana", "aigerim"} {
+		headers := map[string]string{idempotencyHeader: "k1"}
+		w := testRequest(h, http.MethodPost, "/addClient", testToken(t, user, RoleEditor, ""), "{}", headers)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: статус %d", user, w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 2", calls)
+	}
+}
+
+// TestIdempotencyTenantScope проверяет, что ключ, использованный в одной
+// кофейне, не дает ответ в другой.
+func TestIdempotencyTenantScope(t *testing.T) {
+	setupTest(t)
+	tenants["north"] = Tenant{ID: "north"}
+	calls := 0
+	h := withTenant(requireAuth(withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}, 1<<20)))
+	admin := testToken(t, "root", RoleAdmin, "")
+
+	for _, tenant := range []string{"", "north", ""} {
+		headers := map[string]string{idempotencyHeader: "k1"}
+		if tenant != "" {
+			headers[tenantHeader] = tenant
+		}
+		w := testRequest(h, http.MethodPost, "/addClient", admin, "{}", headers)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("кофейня %q: статус %d", tenant, w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 2", calls)
+	}
+}
+
+// TestIdempotencyPanic проверяет, что после паники обработчика ключ можно
+// использовать снова.
+func TestIdempotencyPanic(t *testing.T) {
+	setupTest(t)
+	calls := 0
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("сбой")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}, 1<<20)
+	headers := map[string]string{idempotencyHeader: "k1"}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("паники не было")
+			}
+		}()
+		testRequest(h, http.MethodPost, "/addClient", "", "{}", headers)
+	}()
+	if w := testRequest(h, http.MethodPost, "/addClient", "", "{}", headers); w.Code != http.StatusCreated {
+		t.Errorf("повтор после паники: статус %d: %s", w.Code, w.Body)
+	}
+	if calls != 2 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 2", calls)
+	}
+}
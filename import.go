@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Колонки CSV для импорта клиентов. Первая строка файла — заголовок с
+// названиями колонок (регистр не важен, порядок любой):
+//
+//	id           — обязательна, целое > 0
+//	name         — обязательна, непустая строка
+//	age          — целое от 0 до 150
+//	registerDate — RFC 3339 или ГГГГ-ММ-ДД; по умолчанию — момент импорта
+//	favCoffee    — строка
+//	city         — Address.City
+//	street       — Address.Street
+//	email        — адрес для писем, проверяется как в API
+//
+// Неизвестные колонки отклоняют весь файл, чтобы опечатка в заголовке не
+// привела к молчаливой потере данных.
+var importColumns = map[string]func(c *Client, v string) error{
+	"id": func(c *Client, v string) error {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("id: не число")
+		}
+		c.ID = id
+		return nil
+	},
+	"name": func(c *Client, v string) error { c.Name = v; return nil },
+	"age": func(c *Client, v string) error {
+		if v == "" {
+			return nil
+		}
+		age, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("age: не число")
+		}
+		c.Age = age
+		return nil
+	},
+	"birthdate": func(c *Client, v string) error { c.BirthDate = v; return nil },
+	"registerdate": func(c *Client, v string) error {
+		if v == "" {
+			return nil
+		}
+		for _, layout := range []string{time.RFC3339, time.DateOnly} {
+			if t, err := time.Parse(layout, v); err == nil {
+				c.RegisterDate = t
+				return nil
+			}
+		}
+		return fmt.Errorf("registerDate: ожидается RFC 3339 или ГГГГ-ММ-ДД")
+	},
+	"favcoffee": func(c *Client, v string) error { c.FavCoffee = v; return nil },
+	"city":      func(c *Client, v string) error { c.Address.City = v; return nil },
+	"street":    func(c *Client, v string) error { c.Address.Street = v; return nil },
+	"email":     func(c *Client, v string) error { c.Email = v; return nil },
+	// Метки в ячейке разделяются пробелами: в самих метках пробелов нет.
+	"tags": func(c *Client, v string) error {
+		tags, err := normalizeTags(strings.Fields(v))
+		c.Tags = tags
+		return err
+	},
+}
+
+// validateClient проверяет поля клиента перед сохранением.
+func validateClient(c Client) error {
+	switch {
+	case c.ID <= 0:
+		return errors.New("id должен быть положительным")
+	case strings.TrimSpace(c.Name) == "":
+		return errors.New("не указано имя")
+	case c.Age < 0 || c.Age > maxClientAge:
+		return errors.New("age вне диапазона 0–150")
+	}
+	if err := checkBirthDate(c.BirthDate); err != nil {
+		return err
+	}
+	return checkEmail(c.Email)
+}
+
+// importRejection — строка файла, которая не была импортирована.
+type importRejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// importSummary — итог импорта.
+type importSummary struct {
+	Imported int               `json:"imported"`
+	Rejected []importRejection `json:"rejected"`
+}
+
+// maxImportSize ограничивает размер загружаемого файла.
+const maxImportSize = 50 << 20
+
+// importClientsHandler импортирует клиентов из CSV, переданного в поле file
+// формы multipart/form-data. Файл читается потоково; разделитель можно
+// сменить параметром ?delimiter=semicolon (так сохраняет CSV русский Excel),
+// tab или любым символом в URL-кодировке.
+func importClientsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Ожидается multipart/form-data с полем file", http.StatusBadRequest)
+		return
+	}
+
+	var file io.Reader
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Ошибка чтения формы", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			file = part
+			break
+		}
+	}
+	if file == nil {
+		http.Error(w, "Не передано поле file", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := importClients(newImportReader(file, r.URL.Query().Get("delimiter")), requestTenant(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// newImportReader создает читатель CSV с разделителем delimiter: пусто —
+// запятая, semicolon, tab или сам символ.
+func newImportReader(r io.Reader, delimiter string) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	switch delimiter {
+	case "":
+	case "semicolon":
+		cr.Comma = ';'
+	case "tab":
+		cr.Comma = '\t'
+	default:
+		cr.Comma, _ = utf8.DecodeRuneInString(delimiter)
+	}
+	return cr
+}
+
+// importClients читает заголовок и затем строки по одной, сохраняя каждую
+// прошедшую проверку строку сразу. Клиенты добавляются в кофейню tenant.
+func importClients(cr *csv.Reader, tenant string) (importSummary, error) {
+	summary := importSummary{Rejected: []importRejection{}}
+
+	header, err := cr.Read()
+	if err != nil {
+		return summary, fmt.Errorf("не удалось прочитать заголовок CSV: %w", err)
+	}
+	setters := make([]func(*Client, string) error, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		set, ok := importColumns[key]
+		if !ok {
+			return summary, fmt.Errorf("неизвестная колонка %q", name)
+		}
+		setters[i] = set
+		seen[key] = true
+	}
+	if !seen["id"] || !seen["name"] {
+		return summary, errors.New("в заголовке должны быть колонки id и name")
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return summary, nil
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				summary.Rejected = append(summary.Rejected, importRejection{Line: perr.Line, Reason: perr.Err.Error()})
+				continue
+			}
+			return summary, err
+		}
+		if len(record) != len(setters) {
+			summary.Rejected = append(summary.Rejected, importRejection{Line: line, Reason: "число колонок не совпадает с заголовком"})
+			continue
+		}
+
+		if err := importRow(setters, record, tenant); err != nil {
+			summary.Rejected = append(summary.Rejected, importRejection{Line: line, Reason: err.Error()})
+			continue
+		}
+		summary.Imported++
+	}
+}
+
+func importRow(setters []func(*Client, string) error, record []string, tenant string) error {
+	var c Client
+	for i, v := range record {
+		if err := setters[i](&c, strings.TrimSpace(v)); err != nil {
+			return err
+		}
+	}
+	c.Tenant = tenant
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	_, err := createClientLocked(c, sourceImport)
+	return err
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDHeader — идентификатор корреляции запроса. Переданный клиентом
+// сохраняется, иначе генерируется; в обоих случаях возвращается в ответе.
+const requestIDHeader = "X-Request-ID"
+
+// JournalConfig задает журнал последних запросов к API.
+type JournalConfig struct {
+	Enabled       bool     `json:"enabled"`
+	MaxEntries    int      `json:"maxEntries"`    // старые записи вытесняются новыми
+	FlushInterval Duration `json:"flushInterval"` // как часто журнал сохраняется на диск
+}
+
+// journalEntry — запись журнала об одном запросе.
+type journalEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	Principal string    `json:"principal,omitempty"` // "user:имя" или "apikey:имя"
+	IP        string    `json:"ip"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latencyMs"`
+}
+
+// journal — кольцевой буфер записей: после заполнения journalNext
+// указывает на самую старую запись, которая будет вытеснена следующей.
+var (
+	journal      []journalEntry // не больше MaxEntries записей
+	journalNext  int            // позиция следующей записи в заполненном буфере
+	journalDirty bool           // есть записи, не сохраненные на диск
+	journalMu    sync.Mutex     // Мьютекс для защиты журнала
+)
+
+func journalPath() string {
+	return filepath.Join(config.DataDir, "journal.json")
+}
+
+// loadJournal читает сохраненный журнал.
+func loadJournal() error {
+	data, err := os.ReadFile(journalPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return fmt.Errorf("разбор %s: %w", journalPath(), err)
+	}
+	if n := config.Journal.MaxEntries; len(journal) > n {
+		journal = journal[len(journal)-n:]
+	}
+	journalNext = 0
+	return nil
+}
+
+// flushJournal сохраняет журнал, если в нем есть новые записи.
+func flushJournal() error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if !journalDirty {
+		return nil
+	}
+	ordered := make([]journalEntry, 0, len(journal))
+	for i := len(journal) - 1; i >= 0; i-- {
+		ordered = append(ordered, journalNewest(i))
+	}
+	if err := writeJSONFile(journalPath(), ordered); err != nil {
+		return err
+	}
+	journalDirty = false
+	return nil
+}
+
+// runJournal периодически сохраняет журнал до отмены ctx.
+func runJournal(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(config.Journal.FlushInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := flushJournal(); err != nil {
+				logError("Ошибка сохранения журнала запросов: %v", err)
+			}
+		}
+	}
+}
+
+func appendJournal(e journalEntry) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if len(journal) < config.Journal.MaxEntries {
+		journal = append(journal, e)
+	} else {
+		journal[journalNext] = e
+		journalNext = (journalNext + 1) % len(journal)
+	}
+	journalDirty = true
+}
+
+// journalNewest возвращает k-ю с конца запись журнала (0 — самая свежая).
+// Вызывается под journalMu.
+func journalNewest(k int) journalEntry {
+	return journal[(journalNext-1-k+2*len(journal))%len(journal)]
+}
+
+// statusWriter запоминает код ответа.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withJournal назначает запросу идентификатор корреляции и записывает
+// запросы к API в журнал.
+func withJournal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = randomHex(8)
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		if !config.Journal.Enabled || !isAPIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		e := journalEntry{
+			Time:      start,
+			RequestID: id,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     r.URL.RawQuery,
+			IP:        clientIP(r),
+			Status:    sw.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		// Отправитель определяется заново: контекст обработчика сюда не возвращается.
+		if p, err := authenticate(r); err == nil {
+			e.Principal = p.Kind + ":" + p.Name
+		}
+		appendJournal(e)
+	})
+}
+
+// journalHandler ищет в журнале: GET /admin/journal. Параметры:
+//
+//	from, to   — границы времени (RFC 3339)
+//	method     — метод запроса
+//	path       — префикс пути
+//	principal  — отправитель, например apikey:crm
+//	status     — код ответа (404) или класс (4xx)
+//	requestId  — идентификатор корреляции
+//	limit      — число записей, по умолчанию 100
+//
+// Записи возвращаются от новых к старым.
+func journalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+
+	var from, to time.Time
+	for _, p := range []struct {
+		param string
+		dst   *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.param+": ожидается время RFC 3339", http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit: ожидается положительное число", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	method := strings.ToUpper(q.Get("method"))
+	path := q.Get("path")
+	who := q.Get("principal")
+	requestID := q.Get("requestId")
+	status := strings.ToLower(q.Get("status"))
+
+	matchStatus := func(code int) bool {
+		if status == "" {
+			return true
+		}
+		if len(status) == 3 && strings.HasSuffix(status, "xx") {
+			return strconv.Itoa(code/100) == status[:1]
+		}
+		return strconv.Itoa(code) == status
+	}
+
+	result := []journalEntry{}
+	journalMu.Lock()
+	for k := 0; k < len(journal) && len(result) < limit; k++ {
+		e := journalNewest(k)
+		switch {
+		case !from.IsZero() && e.Time.Before(from):
+			continue
+		case !to.IsZero() && e.Time.After(to):
+			continue
+		case method != "" && e.Method != method:
+			continue
+		case path != "" && !strings.HasPrefix(e.Path, path):
+			continue
+		case who != "" && e.Principal != who:
+			continue
+		case requestID != "" && e.RequestID != requestID:
+			continue
+		case !matchStatus(e.Status):
+			continue
+		}
+		result = append(result, e)
+	}
+	journalMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
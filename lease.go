@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LockConfig задает аренды фоновых задач. Каталог Dir должен быть общим
+// для всех экземпляров сервера (например, сетевой том): задача выполняется
+// только тем экземпляром, который держит ее аренду.
+type LockConfig struct {
+	Dir      string   `json:"dir"`      // по умолчанию <dataDir>/locks
+	LeaseTTL Duration `json:"leaseTTL"` // аренда продлевается каждые LeaseTTL/3
+}
+
+// leaseGuardStale — возраст, после которого файл-замок считается
+// оставленным упавшим процессом.
+const leaseGuardStale = 10 * time.Second
+
+var (
+	errLeaseHeld = errors.New("задача выполняется другим экземпляром")
+	errLeaseLost = errors.New("аренда задачи перехвачена другим экземпляром")
+)
+
+// instanceID отличает этот процесс от других экземпляров.
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), randomHex(3))
+}()
+
+// leaseRecord — содержимое файла аренды.
+type leaseRecord struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Token      string    `json:"token"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// leaseStats — счетчики аренд этого экземпляра.
+var leaseStats struct {
+	Acquired  atomic.Int64 // получено аренд
+	Contended atomic.Int64 // попыток, когда аренду держал другой экземпляр
+	Takeovers atomic.Int64 // аренд, перехваченных после истечения срока
+	Lost      atomic.Int64 // аренд, потерянных во время работы задачи
+}
+
+func leaseDir() string {
+	if config.Locks.Dir != "" {
+		return config.Locks.Dir
+	}
+	return filepath.Join(config.DataDir, "locks")
+}
+
+func leasePath(name string) string {
+	return filepath.Join(leaseDir(), name+".json")
+}
+
+// lockLeaseFile захватывает файл-замок, под которым аренда читается и
+// перезаписывается. Создание с O_EXCL атомарно и на общем томе.
+func lockLeaseFile(name string) (unlock func(), err error) {
+	if err := os.MkdirAll(leaseDir(), 0o755); err != nil {
+		return nil, err
+	}
+	guard := filepath.Join(leaseDir(), name+".guard")
+	deadline := time.Now().Add(time.Second)
+	for {
+		f, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(guard) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(guard); err == nil && time.Since(fi.ModTime()) > leaseGuardStale {
+			os.Remove(guard)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errLeaseHeld
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readLease(name string) (leaseRecord, bool, error) {
+	var rec leaseRecord
+	data, err := os.ReadFile(leasePath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, false, nil
+	}
+	if err != nil {
+		return rec, false, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		// Испорченный файл аренды никому не принадлежит.
+		return rec, false, nil
+	}
+	return rec, true, nil
+}
+
+// acquireLease берет аренду name, если она свободна или ее срок истек.
+func acquireLease(name string) (leaseRecord, error) {
+	unlock, err := lockLeaseFile(name)
+	if err != nil {
+		if errors.Is(err, errLeaseHeld) {
+			leaseStats.Contended.Add(1)
+		}
+		return leaseRecord{}, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	cur, exists, err := readLease(name)
+	if err != nil {
+		return leaseRecord{}, err
+	}
+	if exists && now.Before(cur.ExpiresAt) {
+		leaseStats.Contended.Add(1)
+		return leaseRecord{}, errLeaseHeld
+	}
+	if exists && cur.Owner != instanceID {
+		leaseStats.Takeovers.Add(1)
+		logf("Аренда %s перехвачена у %s: срок истек %s", name, cur.Owner, cur.ExpiresAt.Format(time.RFC3339))
+	}
+
+	rec := leaseRecord{
+		Name:       name,
+		Owner:      instanceID,
+		Token:      randomHex(8),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(time.Duration(config.Locks.LeaseTTL)),
+	}
+	if err := writeJSONFile(leasePath(name), rec); err != nil {
+		return leaseRecord{}, err
+	}
+	leaseStats.Acquired.Add(1)
+	return rec, nil
+}
+
+// renewLease продлевает аренду, если она все еще принадлежит rec.
+func renewLease(rec *leaseRecord) error {
+	unlock, err := lockLeaseFile(rec.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cur, exists, err := readLease(rec.Name)
+	if err != nil {
+		return err
+	}
+	if !exists || cur.Token != rec.Token {
+		return errLeaseLost
+	}
+	rec.ExpiresAt = time.Now().Add(time.Duration(config.Locks.LeaseTTL))
+	return writeJSONFile(leasePath(rec.Name), rec)
+}
+
+// releaseLease освобождает аренду, если она все еще принадлежит rec.
+func releaseLease(rec leaseRecord) {
+	unlock, err := lockLeaseFile(rec.Name)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if cur, exists, _ := readLease(rec.Name); exists && cur.Token == rec.Token {
+		os.Remove(leasePath(rec.Name))
+	}
+}
+
+// runExclusive выполняет fn, только если удалось взять аренду name, и
+// продлевает ее, пока fn работает. Если аренду перехватили, контекст fn
+// отменяется и возвращается errLeaseLost. Занятая аренда — errLeaseHeld.
+func runExclusive(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	rec, err := acquireLease(name)
+	if err != nil {
+		return err
+	}
+	defer releaseLease(rec)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Locks.LeaseTTL) / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := renewLease(&rec)
+				if ctx.Err() != nil {
+					return // fn уже завершилась, аренда освобождается
+				}
+				if errors.Is(err, errLeaseLost) {
+					leaseStats.Lost.Add(1)
+					cancel(errLeaseLost)
+					return
+				}
+				if err != nil {
+					logError("Ошибка продления аренды %s: %v", name, err)
+				}
+			}
+		}
+	}()
+
+	err = fn(ctx)
+	if err != nil && errors.Is(context.Cause(ctx), errLeaseLost) {
+		return errLeaseLost
+	}
+	return err
+}
+
+// locksHandler показывает аренды в общем каталоге и счетчики конкуренции
+// этого экземпляра.
+func locksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	leases := []leaseRecord{}
+	entries, err := os.ReadDir(leaseDir())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if rec, exists, _ := readLease(name); exists {
+			leases = append(leases, rec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"instance": instanceID,
+		"leases":   leases,
+		"stats": map[string]int64{
+			"acquired":  leaseStats.Acquired.Load(),
+			"contended": leaseStats.Contended.Load(),
+			"takeovers": leaseStats.Takeovers.Load(),
+			"lost":      leaseStats.Lost.Load(),
+		},
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Где сервер принимает запросы: на TCP-адресе addr, на unix-сокете
+// listen.socket для обратного прокси на той же машине и на сокетах,
+// переданных systemd (socket activation, LISTEN_FDS). Если systemd передал
+// сокеты, addr и listen.socket не используются: адреса задает .socket-юнит.
+
+// ListenConfig задает дополнительные способы принимать запросы.
+type ListenConfig struct {
+	Socket     string `json:"socket"`     // путь unix-сокета; пусто — без него
+	SocketMode string `json:"socketMode"` // права на файл сокета, восьмерично
+	Systemd    bool   `json:"systemd"`    // принимать сокеты от systemd, если они переданы
+}
+
+func (c ListenConfig) validate() error {
+	if c.Socket == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil {
+		return fmt.Errorf("listen: socketMode должен быть восьмеричным, например 0660")
+	}
+	return nil
+}
+
+// systemdListeners возвращает сокеты, переданные systemd, или nil, если
+// процесс запущен не через socket activation. Переменные окружения
+// удаляются, чтобы их не унаследовали дочерние процессы.
+func systemdListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFD = 3 // SD_LISTEN_FDS_START
+	var list []net.Listener
+	for i := range n {
+		fd := firstFD + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener держит свою копию дескриптора
+		if err != nil {
+			return nil, fmt.Errorf("сокет systemd %s: %w", name, err)
+		}
+		list = append(list, ln)
+	}
+	return list, nil
+}
+
+// listenUnix открывает unix-сокет. Оставшийся от прошлого запуска файл
+// сокета удаляется; файл другого типа по этому пути — ошибка. При закрытии
+// слушателя (srv.Shutdown) файл удаляется.
+func listenUnix(path, mode string) (net.Listener, error) {
+	if st, err := os.Lstat(path); err == nil {
+		if st.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s существует и не является сокетом", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	perm, _ := strconv.ParseUint(mode, 8, 32)
+	if err := os.Chmod(path, fs.FileMode(perm)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serverListeners открывает все слушатели API: сокеты systemd или addr и
+// listen.socket.
+func serverListeners() ([]net.Listener, error) {
+	if config.Listen.Systemd {
+		list, err := systemdListeners()
+		if err != nil || len(list) > 0 {
+			return list, err
+		}
+	}
+	var list []net.Listener
+	closeAll := func() {
+		for _, ln := range list {
+			ln.Close()
+		}
+	}
+	if config.Addr != "" {
+		ln, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ln)
+	}
+	if config.Listen.Socket != "" {
+		ln, err := listenUnix(config.Listen.Socket, config.Listen.SocketMode)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		list = append(list, ln)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("не задан ни addr, ни listen.socket")
+	}
+	return list, nil
+}
+
+// apiAddr — адрес, на котором самопроверка обращается к API (status.go):
+// первый TCP-слушатель, а если их нет — первый unix-сокет. Задается в serve
+// до запуска проверок.
+var apiAddr net.Addr
+
+func pickAPIAddr(list []net.Listener) net.Addr {
+	for _, ln := range list {
+		if ln.Addr().Network() == "tcp" {
+			return ln.Addr()
+		}
+	}
+	return list[0].Addr()
+}
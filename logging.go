@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Журнал сервера. Сообщения, как и раньше, печатаются в стандартный вывод,
+// а если задан log.file — еще и пишутся в файл строками JSON (time, level,
+// msg), чтобы на машинах без сборщика логов их можно было разбирать. Файл
+// сменяется по размеру и по сроку, старые файлы удаляются по числу и
+// возрасту. Сообщения ниже log.level не печатаются нигде; уровень меняется
+// на ходу через /admin/settings.
+
+// LogConfig задает файл журнала.
+type LogConfig struct {
+	// Level — debug, info, warn или error; сообщения сервера имеют уровень
+	// info или error.
+	Level     string `json:"level"`
+	File      string `json:"file"`      // пусто — только стандартный вывод
+	MaxSizeMB int    `json:"maxSizeMB"` // сменить файл, когда он больше; 0 — без ограничения
+	// RotateEvery сменяет файл через этот срок после открытия; 0 — только
+	// по размеру.
+	RotateEvery Duration `json:"rotateEvery"`
+	MaxBackups  int      `json:"maxBackups"` // сколько старых файлов хранить; 0 — все
+	MaxAge      Duration `json:"maxAge"`     // удалять старые файлы старше; 0 — не удалять по возрасту
+}
+
+func (c LogConfig) validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return fmt.Errorf("log.level: ожидается debug, info, warn или error")
+	}
+	if c.MaxSizeMB < 0 || c.RotateEvery < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return fmt.Errorf("log: maxSizeMB, rotateEvery, maxBackups и maxAge не могут быть отрицательными")
+	}
+	return nil
+}
+
+// level возвращает уровень журнала; настройки уже проверены.
+func (c LogConfig) level() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+	return level
+}
+
+// logLevel — действующий уровень журнала.
+var logLevel slog.LevelVar
+
+// fileLog пишет в файл журнала; nil, пока файл не открыт. Задается в serve
+// до запуска фоновых задач.
+var fileLog *slog.Logger
+
+// logf печатает сообщение журнала с уровнем INFO.
+func logf(format string, args ...any) {
+	logAt(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// logError печатает сообщение об ошибке: в файле у него уровень ERROR, и
+// оно уходит в отчеты об ошибках (errorreport.go).
+func logError(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	reportError(errorEvent{Kind: errorKindLog, Message: msg})
+	logAt(slog.LevelError, msg)
+}
+
+// logAt печатает сообщение, если оно не ниже log.level.
+func logAt(level slog.Level, msg string) {
+	if level < logLevel.Level() {
+		return
+	}
+	fmt.Println(msg)
+	if fileLog != nil {
+		fileLog.Log(context.Background(), level, msg)
+	}
+}
+
+// openLogFile открывает файл журнала из конфигурации.
+func openLogFile(cfg LogConfig) error {
+	if cfg.File == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.File), 0o755); err != nil {
+		return err
+	}
+	f := &rotatingFile{cfg: cfg}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	fileLog = slog.New(slog.NewJSONHandler(f, nil))
+	return nil
+}
+
+// rotatingFile — файл журнала со сменой по размеру и сроку. Старый файл
+// переименовывается в имя с временем смены: server.log →
+// server-20261014T093000.000.log.
+type rotatingFile struct {
+	cfg    LogConfig
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			// Пишем в прежний файл: терять сообщения хуже, чем превысить размер.
+			fmt.Fprintf(os.Stderr, "Ошибка смены файла журнала: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// due сообщает, пора ли сменить файл перед записью n байт.
+func (r *rotatingFile) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSizeMB > 0 && r.size+int64(n) > int64(r.cfg.MaxSizeMB)<<20 {
+		return true
+	}
+	return r.cfg.RotateEvery > 0 && time.Since(r.opened) >= time.Duration(r.cfg.RotateEvery)
+}
+
+// backupPattern делит имя файла на основу и расширение; старые файлы
+// называются base-<время>ext.
+func (r *rotatingFile) backupPattern() (base, ext string) {
+	ext = filepath.Ext(r.cfg.File)
+	return strings.TrimSuffix(r.cfg.File, ext), ext
+}
+
+// rotate переименовывает текущий файл и открывает новый. Если новый не
+// открылся, запись продолжается в переименованный.
+func (r *rotatingFile) rotate() error {
+	base, ext := r.backupPattern()
+	name := base + "-" + time.Now().Format("20060102T150405.000") + ext
+	if err := os.Rename(r.cfg.File, name); err != nil {
+		return err
+	}
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	old.Close()
+	r.prune()
+	return nil
+}
+
+// prune удаляет старые файлы сверх maxBackups и старше maxAge.
+func (r *rotatingFile) prune() {
+	base, ext := r.backupPattern()
+	names, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return
+	}
+	sort.Strings(names) // время в имени: сначала старые
+	for i, name := range names {
+		drop := r.cfg.MaxBackups > 0 && i < len(names)-r.cfg.MaxBackups
+		if !drop && r.cfg.MaxAge > 0 {
+			st, err := os.Stat(name)
+			drop = err == nil && time.Since(st.ModTime()) > time.Duration(r.cfg.MaxAge)
+		}
+		if drop {
+			os.Remove(name)
+		}
+	}
+}
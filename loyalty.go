@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoyaltyConfig задает программу лояльности.
+type LoyaltyConfig struct {
+	// Percent — доля суммы выполненного заказа, которая начисляется баллами;
+	// балл равен минимальной единице валюты. 0 отключает автоначисление.
+	Percent int `json:"percent"`
+}
+
+// Виды операций с баллами.
+const (
+	loyaltyAccrual = "accrual" // начисление вручную
+	loyaltyRedeem  = "redeem"  // списание
+	loyaltyOrder   = "order"   // начисление за выполненный заказ
+)
+
+// loyaltyTransaction — операция с баллами клиента. Операции не меняются и
+// не удаляются, пока существует клиент: баланс — их сумма.
+type loyaltyTransaction struct {
+	ID       int       `json:"id"`
+	ClientID int       `json:"clientId"`
+	Kind     string    `json:"kind"`
+	Points   int       `json:"points"` // у списания отрицательное
+	OrderID  int       `json:"orderId,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Actor    string    `json:"actor,omitempty"` // пользователь или API-ключ; пусто — сервер
+	At       time.Time `json:"at"`
+}
+
+// loyaltyAccount — баланс клиента и его операции, новые последними.
+type loyaltyAccount struct {
+	ClientID     int                  `json:"clientId"`
+	Balance      int                  `json:"balance"`
+	Transactions []loyaltyTransaction `json:"transactions"`
+}
+
+// loyaltyState — сохраняемое состояние программы.
+type loyaltyState struct {
+	NextID       int                  `json:"nextId"`
+	Transactions []loyaltyTransaction `json:"transactions"`
+}
+
+var (
+	loyalty   = loyaltyState{NextID: 1} // Операции всех клиентов
+	balances  = make(map[int]int)       // Баланс по ID клиента, сумма операций
+	loyaltyMu sync.Mutex                // Мьютекс для защиты баллов; берется после clientsMu и ordersMu
+)
+
+var errLoyaltyInsufficient = errors.New("Недостаточно баллов")
+
+func loyaltyPath() string {
+	return filepath.Join(config.DataDir, "loyalty.json")
+}
+
+// loadLoyalty читает операции с баллами и пересчитывает балансы.
+func loadLoyalty() error {
+	data, err := os.ReadFile(loyaltyPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loyaltyMu.Lock()
+	defer loyaltyMu.Unlock()
+	if err := json.Unmarshal(data, &loyalty); err != nil {
+		return fmt.Errorf("разбор %s: %w", loyaltyPath(), err)
+	}
+	for _, t := range loyalty.Transactions {
+		balances[t.ClientID] += t.Points
+	}
+	return nil
+}
+
+// addLoyaltyLocked записывает операцию. Списание больше баланса
+// отклоняется. Вызывается под loyaltyMu.
+func addLoyaltyLocked(t loyaltyTransaction) (loyaltyTransaction, error) {
+	if balances[t.ClientID]+t.Points < 0 {
+		return t, errLoyaltyInsufficient
+	}
+	t.ID = loyalty.NextID
+	t.At = time.Now()
+	loyalty.NextID++
+	loyalty.Transactions = append(loyalty.Transactions, t)
+	balances[t.ClientID] += t.Points
+	if err := writeJSONFile(loyaltyPath(), loyalty); err != nil {
+		// Без записи на диск операция потерялась бы при перезапуске.
+		loyalty.NextID--
+		loyalty.Transactions = loyalty.Transactions[:len(loyalty.Transactions)-1]
+		balances[t.ClientID] -= t.Points
+		return t, err
+	}
+	return t, nil
+}
+
+// loyaltyOnOrderCompleted начисляет баллы за выполненный заказ. Вызывается
+// под clientsMu и ordersMu; ошибку записи только сообщает в лог, чтобы не
+// отменять смену статуса заказа.
+func loyaltyOnOrderCompleted(o Order) {
+	points := o.Total * config.Loyalty.Percent / 100
+	if points <= 0 {
+		return
+	}
+	loyaltyMu.Lock()
+	defer loyaltyMu.Unlock()
+	t := loyaltyTransaction{ClientID: o.ClientID, Kind: loyaltyOrder, Points: points, OrderID: o.ID}
+	if _, err := addLoyaltyLocked(t); err != nil {
+		logError("Ошибка начисления баллов за заказ %d: %v", o.ID, err)
+	}
+}
+
+// loyaltyOnClientEvent удаляет баллы окончательно удаленного клиента.
+func loyaltyOnClientEvent(e clientEvent) {
+	if e.Type != eventClientPurged {
+		return
+	}
+	loyaltyMu.Lock()
+	defer loyaltyMu.Unlock()
+	if _, ok := balances[e.Client.ID]; !ok {
+		return
+	}
+	kept := loyalty.Transactions[:0]
+	for _, t := range loyalty.Transactions {
+		if t.ClientID != e.Client.ID {
+			kept = append(kept, t)
+		}
+	}
+	loyalty.Transactions = kept
+	delete(balances, e.Client.ID)
+	if err := writeJSONFile(loyaltyPath(), loyalty); err != nil {
+		logError("Ошибка удаления баллов клиента %d: %v", e.Client.ID, err)
+	}
+}
+
+// loyaltyHandler возвращает баланс и историю операций:
+// GET /clients/{id}/loyalty.
+func loyaltyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	if !activeClient(requestTenant(r), id) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+
+	loyaltyMu.Lock()
+	acc := loyaltyAccount{ClientID: id, Balance: balances[id], Transactions: []loyaltyTransaction{}}
+	for _, t := range loyalty.Transactions {
+		if t.ClientID == id {
+			acc.Transactions = append(acc.Transactions, t)
+		}
+	}
+	loyaltyMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acc)
+}
+
+// loyaltyRequest — тело начисления или списания.
+type loyaltyRequest struct {
+	Points  int    `json:"points"`
+	OrderID int    `json:"orderId,omitempty"` // заказ, в счет которого списываются баллы
+	Note    string `json:"note,omitempty"`
+}
+
+// accrueLoyaltyHandler начисляет баллы вручную:
+// POST /clients/{id}/loyalty/accrue.
+func accrueLoyaltyHandler(w http.ResponseWriter, r *http.Request) {
+	loyaltyChange(w, r, loyaltyAccrual)
+}
+
+// redeemLoyaltyHandler списывает баллы: POST /clients/{id}/loyalty/redeem.
+func redeemLoyaltyHandler(w http.ResponseWriter, r *http.Request) {
+	loyaltyChange(w, r, loyaltyRedeem)
+}
+
+func loyaltyChange(w http.ResponseWriter, r *http.Request, kind string) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	var req loyaltyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	if req.Points <= 0 || req.Points > 1_000_000_000 {
+		http.Error(w, "points должно быть положительным", http.StatusBadRequest)
+		return
+	}
+	if len(req.Note) > 500 {
+		http.Error(w, "Комментарий длиннее 500 символов", http.StatusBadRequest)
+		return
+	}
+	t := loyaltyTransaction{ClientID: id, Kind: kind, Points: req.Points, Note: req.Note}
+	if kind == loyaltyRedeem {
+		t.Points, t.OrderID = -req.Points, req.OrderID
+	} else if req.OrderID != 0 {
+		http.Error(w, "orderId указывается только при списании", http.StatusBadRequest)
+		return
+	}
+	if p, ok := principalFrom(r); ok {
+		t.Actor = p.Name
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, exists := tenantClientLocked(requestTenant(r), id); !exists || c.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	if t.OrderID != 0 {
+		ordersMu.Lock()
+		o, exists := orders[t.OrderID]
+		ordersMu.Unlock()
+		if !exists || o.ClientID != id {
+			http.Error(w, fmt.Sprintf("Заказ %d не найден у клиента", t.OrderID), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	loyaltyMu.Lock()
+	defer loyaltyMu.Unlock()
+	t, err = addLoyaltyLocked(t)
+	switch {
+	case errors.Is(err, errLoyaltyInsufficient):
+		http.Error(w, fmt.Sprintf("Недостаточно баллов: на счете %d", balances[id]), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loyaltyAccount{ClientID: id, Balance: balances[id], Transactions: []loyaltyTransaction{t}})
+}
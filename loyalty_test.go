@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestRedeemAgainstOrder проверяет списание баллов в счет заказа: заказ
+// должен существовать и принадлежать тому же клиенту.
+func TestRedeemAgainstOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"свой заказ", `{"points":100,"orderId":1}`, http.StatusCreated},
+		{"без заказа", `{"points":100}`, http.StatusCreated},
+		{"чужой заказ", `{"points":100,"orderId":2}`, http.StatusUnprocessableEntity},
+		{"нет заказа", `{"points":100,"orderId":3}`, http.StatusUnprocessableEntity},
+		{"больше баланса", `{"points":501,"orderId":1}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			clients = map[int]Client{
+				1: {ID: 1, Name: "Айгерим", Version: 1},
+				2: {ID: 2, Name: "Дана", Version: 1},
+			}
+			item := []OrderItem{{Name: "Латте", Quantity: 1, Price: 1500}}
+			orders[1] = Order{ID: 1, ClientID: 1, Items: item, Total: 1500, Status: OrderCompleted, Version: 1}
+			orders[2] = Order{ID: 2, ClientID: 2, Items: item, Total: 1500, Status: OrderCompleted, Version: 1}
+			nextOrderID = 3
+			loyaltyMu.Lock()
+			if _, err := addLoyaltyLocked(loyaltyTransaction{ClientID: 1, Kind: loyaltyAccrual, Points: 500}); err != nil {
+				t.Fatal(err)
+			}
+			loyaltyMu.Unlock()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /clients/{id}/loyalty/redeem", redeemLoyaltyHandler)
+			w := testRequest(mux, http.MethodPost, "/clients/1/loyalty/redeem", "", tt.body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			want := 500
+			if tt.want == http.StatusCreated {
+				want = 400
+			}
+			if got := balances[1]; got != want {
+				t.Errorf("баланс %d, ожидался %d", got, want)
+			}
+		})
+	}
+}
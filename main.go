@@ -3,90 +3,718 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
-// Address представляет адрес клиента.
+// Address представляет адрес клиента, см. addresses.go.
 type Address struct {
-	City   string `json:"city"`
-	Street string `json:"street"`
+	Type     string    `json:"type,omitempty" xml:"type,omitempty"` // home, work и т. п.
+	City     string    `json:"city" xml:"city"`
+	Street   string    `json:"street" xml:"street"`
+	Location *GeoPoint `json:"location,omitempty" xml:"location,omitempty"` // задает геокодер
 }
 
 // Client представляет клиента.
 type Client struct {
-	ID           int       `json:"id"`
-	Name         string    `json:"name"`
-	Age          int       `json:"age"`
-	RegisterDate time.Time `json:"registerDate"`
-	FavCoffee    string    `json:"favCoffee"`
-	Address      Address   `json:"address"`
+	ID           int       `json:"id" xml:"id"`
+	Name         string    `json:"name" xml:"name"`
+	Age          int       `json:"age" xml:"age"`
+	BirthDate    string    `json:"birthDate,omitempty" xml:"birthDate,omitempty"` // ГГГГ-ММ-ДД; если задана, age считается по ней
+	RegisterDate time.Time `json:"registerDate" xml:"registerDate"`
+	FavCoffee    string    `json:"favCoffee" xml:"favCoffee"`
+	Address      Address   `json:"address" xml:"address"` // основной, первый из Addresses
+	Addresses    []Address `json:"addresses,omitempty" xml:"addresses>address,omitempty"`
+	Email        string    `json:"email,omitempty" xml:"email,omitempty"`   // для писем онбординга
+	Tags         []string  `json:"tags,omitempty" xml:"tags>tag,omitempty"` // метки, см. tags.go
+
+	// Tenant — кофейня клиента; задается сервером по запросу, см. tenants.go.
+	Tenant string `json:"tenant,omitempty" xml:"tenant,omitempty"`
+
+	// Version увеличивается при каждом изменении и защищает от потерянных
+	// обновлений: PUT принимается, только если клиент знает текущую версию.
+	Version int `json:"version" xml:"version"`
+
+	// DeletedAt задан у мягко удаленного клиента: он скрыт из списков, но
+	// его можно восстановить до окончательного удаления.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" xml:"deletedAt,omitempty"`
 }
 
 // Welcome используется для отображения приветственной страницы.
 type Welcome struct {
-	Name string
+	Name string // пустое — гость
 	Time string
+
+	// Accessible включает высококонтрастный режим без JavaScript.
+	Accessible bool
 }
 
 var (
 	clients   = make(map[int]Client) // Хранилище клиентов
 	clientsMu sync.Mutex             // Мьютекс для защиты данных клиентов
+	config    Config                 // Настройки сервера
 )
 
-func main() {
-	// Динамическое приветствие
-	welcome := Welcome{"Гость", time.Now().Format(time.Stamp)}
-	templates := template.Must(template.ParseFiles("templates/main.html"))
+// serve запускает сервер и работает до SIGINT или SIGTERM.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	autoMigrate := flags.Bool("auto-migrate", false, "применить недостающие миграции данных при запуске")
+	seedCount := flags.Int("seed", 0, "заполнить хранилище тестовыми клиентами, см. seed.go")
+	flags.Parse(args)
+
+	// Конфигурация
+	err := initConfig()
+	if err != nil {
+		logError("Ошибка чтения конфигурации: %v", err)
+		os.Exit(1)
+	}
+	logLevel.Set(config.Log.level())
+	if err := openLogFile(config.Log); err != nil {
+		logError("Ошибка открытия файла журнала: %v", err)
+		os.Exit(1)
+	}
+	if config.ErrorReporting.Enabled {
+		rep, err := newSentryReporter(config.ErrorReporting)
+		if err != nil {
+			logError("Ошибка настройки отчетов об ошибках: %v", err)
+			os.Exit(1)
+		}
+		startErrorReports(rep)
+	}
+	if config.Auth.JWTSecret != "" {
+		jwtSecret = []byte(config.Auth.JWTSecret)
+	} else {
+		jwtSecret = randomSecret()
+		logf("auth.jwtSecret не задан: токены не переживут перезапуск сервера")
+	}
+
+	if err := loadCatalogs(config.I18n.Dir); err != nil {
+		logError("Ошибка чтения переводов: %v", err)
+		os.Exit(1)
+	}
+	templates, err := newTemplateManager(config.Templates)
+	if err != nil {
+		logError("Ошибка загрузки шаблонов: %v", err)
+		os.Exit(1)
+	}
 
 	// Эндпоинт для статики
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	http.Handle(assetPrefix, staticHandler())
+
+	// Сессии посетителей
+	if sessions, err = newSessionStore(config.Sessions); err != nil {
+		logError("Ошибка чтения сессий: %v", err)
+		os.Exit(1)
+	}
+
+	// Ключи шифрования персональных данных в снимках
+	if pii, err = newFieldCipher(config.Encryption); err != nil {
+		logError("Ошибка загрузки ключей шифрования: %v", err)
+		os.Exit(1)
+	}
+
+	// Хранилище файлов: аватары, снимки
+	if blobs, err = newBlobStore(config.Blobs); err != nil {
+		logError("Ошибка настройки хранилища файлов: %v", err)
+		os.Exit(1)
+	}
+
+	// Версия сохраненных данных
+	if err := checkMigrations(context.Background(), *autoMigrate); err != nil {
+		logError("Ошибка миграции данных: %v", err)
+		os.Exit(1)
+	}
 
 	// Главная страница
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if name := r.FormValue("name"); name != "" {
-			welcome.Name = name
+	http.HandleFunc("/", welcomeHandler(templates))
+
+	// Эндпоинты для работы с клиентами, заказами, меню и сегментами
+	// (описание и права — в clientAPI, orderAPI, menuAPI и segmentAPI, см. openapi.go)
+	for _, e := range clientAPI {
+		handleAPI(e.op, e.h)
+	}
+	for _, e := range orderAPI {
+		handleAPI(e.op, e.h)
+	}
+	for _, e := range menuAPI {
+		handleAPI(e.op, e.h)
+	}
+	for _, e := range segmentAPI {
+		handleAPI(e.op, e.h)
+	}
+	http.HandleFunc("GET /openapi.json", openAPIHandler)
+	http.HandleFunc("GET /docs", docsHandler)
+	http.HandleFunc("GET /schemas/{$}", schemasIndexHandler)
+	http.HandleFunc("GET /schemas/{name}", schemaHandler)
+
+	// Аутентификация
+	// Без состояния 2FA вход прошел бы по одному паролю, поэтому ошибка чтения фатальна.
+	if err := loadTwoFactor(); err != nil {
+		logError("Ошибка чтения состояния 2FA: %v", err)
+		os.Exit(1)
+	}
+	// Без файла ключей все интеграции остались бы без доступа, а следующая
+	// запись затерла бы их ключи, поэтому ошибка чтения фатальна.
+	if err := loadAPIKeys(); err != nil {
+		logError("Ошибка чтения API-ключей: %v", err)
+		os.Exit(1)
+	}
+	// Без сохраненного учета квоты партнеров начались бы заново.
+	if err := loadKeyUsages(); err != nil {
+		logError("Ошибка чтения учета запросов API-ключей: %v", err)
+		os.Exit(1)
+	}
+	handleAPI(loginOperation, loginHandler)
+	http.HandleFunc("/auth/2fa/enroll", requireEnrollment(twoFactorEnrollHandler))
+	http.HandleFunc("/auth/2fa/confirm", requireEnrollment(twoFactorConfirmHandler))
+	http.HandleFunc("/auth/2fa/disable", requireEnrollment(twoFactorDisableHandler))
+	http.HandleFunc("/admin/keys", requireDeploymentAdmin(apiKeysHandler))
+	http.HandleFunc("GET /admin/keys/{id}/usage", requireDeploymentAdmin(keyUsageHandler))
+	http.HandleFunc("/admin/onboarding", requireDeploymentAdmin(onboardingHandler))
+	http.HandleFunc("/admin/batch", requireDeploymentAdmin(batchHandler))
+	http.HandleFunc("/admin/incidents", requireDeploymentAdmin(incidentsHandler))
+	http.HandleFunc("/admin/backup", requireDeploymentAdmin(backupHandler))
+	http.HandleFunc("/admin/restore", requireDeploymentAdmin(restoreHandler))
+	http.HandleFunc("GET /admin/backups", requireDeploymentAdmin(listBackupsHandler))
+	http.HandleFunc("POST /admin/backups", requireDeploymentAdmin(storeBackupHandler))
+	http.HandleFunc("GET /admin/backups/{name}", requireDeploymentAdmin(getBackupHandler))
+	http.HandleFunc("POST /admin/backups/{name}/restore", requireDeploymentAdmin(restoreStoredBackupHandler))
+	if config.OffsiteBackup.Enabled {
+		http.HandleFunc("/admin/offsite", requireDeploymentAdmin(offsiteBackupsHandler))
+		http.HandleFunc("POST /admin/offsite/{name}/restore", requireDeploymentAdmin(restoreOffsiteBackupHandler))
+	}
+	http.HandleFunc("/admin/locks", requireDeploymentAdmin(locksHandler))
+	http.HandleFunc("/admin/jobs", requireDeploymentAdmin(jobsHandler))
+	http.HandleFunc("/admin/queue", requireDeploymentAdmin(queueHandler))
+	http.HandleFunc("/admin/journal", requireDeploymentAdmin(journalHandler))
+	http.HandleFunc("/admin/erasures", requireDeploymentAdmin(erasuresHandler))
+	http.HandleFunc("/admin/encryption", requireDeploymentAdmin(encryptionHandler))
+	http.HandleFunc("/admin/coffee", requireDeploymentAdmin(coffeeTaxonomyHandler))
+	http.HandleFunc("/admin/webhooks", requireDeploymentAdmin(webhooksHandler))
+	http.HandleFunc("/admin/tenants", requireDeploymentAdmin(tenantsHandler))
+	http.HandleFunc("/admin/retention", requireDeploymentAdmin(retentionPreviewHandler))
+	http.HandleFunc("/admin/retention/log", requireDeploymentAdmin(retentionLogHandler))
+	http.HandleFunc("/admin/merges", requireDeploymentAdmin(mergesHandler))
+	http.HandleFunc("/admin/archive", requireDeploymentAdmin(archiveHandler))
+	http.HandleFunc("POST /admin/archive/{id}/restore", requireDeploymentAdmin(restoreArchivedHandler))
+	http.HandleFunc("DELETE /admin/archive/{id}", requireDeploymentAdmin(deleteArchivedHandler))
+	http.HandleFunc("/admin/crm", requireDeploymentAdmin(crmHandler))
+	http.HandleFunc("/admin/cache", requireDeploymentAdmin(cacheHandler))
+	http.HandleFunc("/admin/reload", requireDeploymentAdmin(reloadHandler))
+	http.HandleFunc("/admin/settings", requireDeploymentAdmin(settingsHandler))
+	http.HandleFunc("/admin/maintenance", requireDeploymentAdmin(maintenanceHandler))
+	http.HandleFunc("/admin/replication", requireDeploymentAdmin(replicationHandler))
+	http.HandleFunc("GET /admin/quality", requireRole(RoleEditor, qualityReportHandler))
+	http.HandleFunc("GET /admin/quality/clients", requireRole(RoleEditor, qualityClientsHandler))
+
+	// Страница состояния
+	http.HandleFunc("GET /status", statusHandler(templates))
+	http.HandleFunc("GET /healthz", healthzHandler)
+
+	// Веб-интерфейс администратора (вход по cookie, см. admin.go)
+	http.HandleFunc("/admin/login", adminLoginHandler(templates))
+	http.HandleFunc("POST /admin/logout", adminLogoutHandler)
+	http.HandleFunc("GET /admin/{$}", requireAdminUI(RoleViewer, adminListHandler(templates)))
+	http.HandleFunc("GET /admin/clients/new", requireAdminUI(RoleEditor, adminNewClientHandler(templates)))
+	http.HandleFunc("POST /admin/clients", requireAdminUI(RoleEditor, adminCreateClientHandler(templates)))
+	http.HandleFunc("GET /admin/clients/{id}", requireAdminUI(RoleEditor, adminEditClientHandler(templates)))
+	http.HandleFunc("POST /admin/clients/{id}", requireAdminUI(RoleEditor, adminUpdateClientHandler(templates)))
+	http.HandleFunc("POST /admin/clients/{id}/delete", requireAdminUI(RoleAdmin, adminDeleteClientHandler))
+	http.HandleFunc("GET /admin/dashboard", requireAdminUI(RoleViewer, dashboardHandler(templates)))
+	http.Handle("GET /dashboard", http.RedirectHandler("/admin/dashboard", http.StatusMovedPermanently))
+
+	// Фоновые задачи
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	if config.Email.Enabled {
+		sender, err := newEmailSender(config.Email)
+		if err != nil {
+			logError("Ошибка настройки почты: %v", err)
+			os.Exit(1)
 		}
-		if err := templates.ExecuteTemplate(w, "main.html", welcome); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		mailer = sender
+		dripSender = sender
+	}
+	if err := loadOnboarding(); err != nil {
+		logError("Ошибка чтения цепочек онбординга: %v", err)
+		os.Exit(1)
+	}
+	if config.Onboarding.Enabled {
+		go runOnboarding(bgCtx)
+	}
+	if err := loadCoffeeTaxonomy(); err != nil {
+		logError("Ошибка чтения справочника кофе: %v", err)
+		os.Exit(1)
+	}
+	if err := loadMenu(); err != nil {
+		logError("Ошибка чтения меню: %v", err)
+		os.Exit(1)
+	}
+	// Операции с баллами ссылаются на заказы по ID, поэтому заказы читаются
+	// раньше и ошибка фатальна.
+	if err := loadOrders(); err != nil {
+		logError("Ошибка чтения заказов: %v", err)
+		os.Exit(1)
+	}
+	// Баланс без истории операций не восстановить, поэтому ошибка фатальна.
+	if err := loadLoyalty(); err != nil {
+		logError("Ошибка чтения баллов лояльности: %v", err)
+		os.Exit(1)
+	}
+	if err := loadErasures(); err != nil {
+		logError("Ошибка чтения журнала обезличиваний: %v", err)
+		os.Exit(1)
+	}
+	if err := loadNotes(); err != nil {
+		logError("Ошибка чтения заметок: %v", err)
+		os.Exit(1)
+	}
+	if err := loadActivity(); err != nil {
+		logError("Ошибка чтения визитов клиентов: %v", err)
+		os.Exit(1)
+	}
+	if err := loadSegments(); err != nil {
+		logError("Ошибка чтения сегментов: %v", err)
+		os.Exit(1)
+	}
+	if err := loadCRM(); err != nil {
+		logError("Ошибка чтения связей с CRM: %v", err)
+		os.Exit(1)
+	}
+	if err := loadMerges(); err != nil {
+		logError("Ошибка чтения журнала слияний: %v", err)
+		os.Exit(1)
+	}
+	if err := loadRetentionLog(); err != nil {
+		logError("Ошибка чтения журнала удалений по сроку хранения: %v", err)
+		os.Exit(1)
+	}
+	if err := loadTenants(); err != nil {
+		logError("Ошибка чтения кофеен: %v", err)
+		os.Exit(1)
+	}
+	for _, u := range config.Auth.Users {
+		if !tenantExists(u.Tenant) {
+			logf("Пользователь %s: неизвестная кофейня %s", u.Username, u.Tenant)
+			os.Exit(1)
 		}
-	})
+	}
 
-	// Эндпоинты для работы с клиентами
-	http.HandleFunc("/addClient", addClientHandler)
-	http.HandleFunc("/deleteClient", deleteClientHandler)
-	http.HandleFunc("/getClients", getClientsHandler)
+	// Клиенты хранятся в памяти, а заметки, баллы, заказы и визиты — в
+	// файлах по ID клиента. Ведущий сервер при запуске загружает последний
+	// снимок, иначе эти данные достались бы новым клиентам с теми же ID.
+	// Реплика получает клиентов от ведущего.
+	if !config.Replica.enabled() {
+		name, err := loadSnapshot(context.Background(), "")
+		if err != nil {
+			logError("Ошибка загрузки снимка хранилища: %v", err)
+			os.Exit(1)
+		}
+		if name != "" {
+			clientsMu.Lock()
+			n := len(clients)
+			clientsMu.Unlock()
+			logf("Загружен снимок хранилища %s, клиентов: %d", name, n)
+		}
+	}
+	registerBatchJob(recanonicalizeCoffeeJob)
+	registerScheduledJob(pruneIdempotencyJob)
+	registerScheduledJob(pruneExportsJob)
+	// Снимки и очистку хранилища выполняет ведущий сервер.
+	if !config.Replica.enabled() {
+		registerScheduledJob(storeBackupJob)
+		if config.OffsiteBackup.Enabled {
+			registerScheduledJob(offsiteBackupJob)
+		}
+		if config.Retention.Enabled {
+			registerScheduledJob(retentionJob)
+		}
+		if config.Archive.Enabled {
+			registerScheduledJob(pruneArchiveJob)
+		}
+		registerScheduledJob(segmentsJob)
+		if config.CRM.Enabled {
+			src, err := newCRMConnector(config.CRM)
+			if err != nil {
+				logError("Ошибка настройки синхронизации с CRM: %v", err)
+				os.Exit(1)
+			}
+			crmSource = src
+			registerScheduledJob(crmSyncJob)
+		}
+	}
+	if err := loadJobStates(); err != nil {
+		logError("Ошибка чтения истории задач: %v", err)
+	}
+	registerTaskKind(webhookTask)
+	registerTaskKind(emailTask)
+	registerTaskKind(exportTask)
+	registerTaskKind(geocodeTask)
+	if err := loadExportJobs(); err != nil {
+		logError("Ошибка чтения выгрузок: %v", err)
+	}
+	if err := loadQueue(); err != nil {
+		logError("Ошибка чтения очереди задач: %v", err)
+	}
+	startQueue()
+
+	// Тестовые клиенты добавляются до подписчиков: им не нужны ни письма,
+	// ни вебхуки.
+	if *seedCount > 0 {
+		clientsMu.Lock()
+		n, err := seedStoreLocked(*seedCount, "", 1)
+		clientsMu.Unlock()
+		if err != nil {
+			logError("Ошибка заполнения тестовыми клиентами: %v", err)
+			os.Exit(1)
+		}
+		logf("Добавлено тестовых клиентов: %d", n)
+	}
+
+	// Побочные эффекты изменений клиентов. Письма, вебхуки, геокодирование
+	// и очистку связанных данных выполняет ведущий сервер, реплика только
+	// обновляет свои индексы и потоки.
+	subscribeClientEvents(etagOnClientEvent)
+	if !config.Replica.enabled() {
+		subscribeClientEvents(onboardingOnClientEvent)
+		subscribeClientEvents(webhooksOnClientEvent)
+	}
+	subscribeClientEvents(streamOnClientEvent)
+	if !config.Replica.enabled() {
+		subscribeClientEvents(avatarsOnClientEvent)
+		subscribeClientEvents(ordersOnClientEvent)
+		subscribeClientEvents(loyaltyOnClientEvent)
+		subscribeClientEvents(notesOnClientEvent)
+		subscribeClientEvents(activityOnClientEvent)
+	}
+	subscribeClientEvents(geoIndexOnClientEvent)
+	subscribeClientEvents(cacheOnClientEvent)
+	if geocoder, err = newGeocoder(config.Geocoding); err != nil {
+		logError("Ошибка настройки геокодирования: %v", err)
+		os.Exit(1)
+	}
+	if geocoder != nil && !config.Replica.enabled() {
+		subscribeClientEvents(geocodeOnClientEvent)
+	}
+	if config.Telegram.Enabled && !config.Replica.enabled() {
+		bot, err := newTelegramBot(config.Telegram)
+		if err != nil {
+			logError("Ошибка настройки Telegram: %v", err)
+			os.Exit(1)
+		}
+		telegram = bot
+		subscribeClientEvents(telegramOnClientEvent)
+		go runTelegram(bgCtx)
+	}
+	handleAPI(clientEventsOperation, clientEventsHandler(bgCtx))
+	// Тот же поток для панели регистраций: EventSource не передает токен,
+	// поэтому вход по cookie раздела администратора.
+	http.HandleFunc("GET /admin/dashboard/events", requireAdminUI(RoleViewer, clientEventsHandler(bgCtx)))
+	handleAPI(liveClientsOperation, liveClientsHandler(bgCtx))
+	http.HandleFunc("GET /admin/replication/stream", requireDeploymentAdmin(replicationStreamHandler(bgCtx)))
+	if config.OffsiteBackup.Enabled {
+		if err := openOffsite(bgCtx, config.OffsiteBackup); err != nil {
+			logError("Ошибка настройки внешних снимков: %v", err)
+			os.Exit(1)
+		}
+		if !config.Replica.enabled() {
+			statusProbes["offsite"] = probeOffsite
+		}
+	}
+	if config.Replica.enabled() {
+		statusProbes["replica"] = probeReplica
+		go runReplica(bgCtx)
+	}
+	if err := loadWebhooks(); err != nil {
+		logError("Ошибка чтения вебхуков: %v", err)
+	}
+	if err := loadBatchCheckpoints(bgCtx); err != nil {
+		logError("Ошибка чтения контрольных точек пересчетов: %v", err)
+	}
+	if err := loadMaintenance(); err != nil {
+		logError("Ошибка чтения режима обслуживания: %v", err)
+	}
+	if err := loadStatus(); err != nil {
+		logError("Ошибка чтения истории проверок: %v", err)
+	}
+	if config.Journal.Enabled {
+		if err := loadJournal(); err != nil {
+			logError("Ошибка чтения журнала запросов: %v", err)
+		}
+		go runJournal(bgCtx)
+	}
+	go runKeyUsages(bgCtx)
 
 	// Настройка сервера
+	publishDebugVars()
 	srv := &http.Server{
-		Addr: ":8090",
+		Addr:    config.Addr,
+		Handler: withJournal(localize(withRecovery(cors(rateLimit(withQuota(withReplica(withMaintenance(compress(withTenant(withDebug(http.DefaultServeMux))))))))))),
 	}
+	// Потоки SSE и WebSocket живут до остановки фоновых задач, поэтому без
+	// этого Shutdown ждал бы их до истечения timeout.
+	srv.RegisterOnShutdown(stopBackground)
+
+	// Порт открывается до запуска самопроверок, чтобы первая проверка API
+	// не застала сервер еще не слушающим.
+	listeners, err := serverListeners()
+	if err != nil {
+		logError("Ошибка сервера: %v", err)
+		os.Exit(1)
+	}
+	apiAddr = pickAPIAddr(listeners)
+	for _, ln := range listeners {
+		go func() {
+			logf("Сервер запущен на %s %s", ln.Addr().Network(), ln.Addr())
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logError("Ошибка сервера: %v", err)
+			}
+		}()
+	}
+	var grpcSrv *http.Server
+	if config.GRPC.Addr != "" {
+		grpcSrv = newGRPCServer(config.GRPC.Addr)
+		go func() {
+			logf("gRPC запущен на %s", config.GRPC.Addr)
+			if err := grpcSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logError("Ошибка сервера gRPC: %v", err)
+			}
+		}()
+	}
+	var debugSrv *http.Server
+	if config.Debug.Enabled && config.Debug.Addr != "" {
+		debugSrv = newDebugServer(config.Debug.Addr)
+		go func() {
+			logf("Отладка запущена на %s", config.Debug.Addr)
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logError("Ошибка сервера отладки: %v", err)
+			}
+		}()
+	}
+	go runProbes(bgCtx)
+	go runScheduler(bgCtx)
+
+	// Снимок хранилища при остановке нужен, только если клиенты изменились
+	// после запуска.
+	clientsMu.Lock()
+	backupStoredModified = clientsModified
+	clientsMu.Unlock()
 
+	// Graceful Shutdown
+	onShutdown("прием запросов", func(ctx context.Context) error {
+		err := srv.Shutdown(ctx)
+		if grpcSrv != nil {
+			err = errors.Join(err, grpcSrv.Shutdown(ctx))
+		}
+		if debugSrv != nil {
+			err = errors.Join(err, debugSrv.Close())
+		}
+		return errors.Join(err, waitGroup(ctx, &wsConnsWG))
+	})
+	onShutdown("фоновые задачи", func(ctx context.Context) error {
+		stopBackground()
+		return errors.Join(waitGroup(ctx, &batchRunsWG), waitGroup(ctx, &schedulerWG))
+	})
+	onShutdown("очередь задач и вебхуки", func(context.Context) error {
+		drainQueue()
+		return nil
+	})
+	onShutdown("журнал запросов", func(context.Context) error {
+		return flushJournal()
+	})
+	onShutdown("учет запросов API-ключей", func(context.Context) error {
+		return flushKeyUsages()
+	})
+	if config.Shutdown.Snapshot && !config.Replica.enabled() {
+		onShutdown("снимок хранилища", storeShutdownSnapshot)
+	}
+	onShutdown("отчеты об ошибках", func(ctx context.Context) error {
+		return waitGroup(ctx, &errorReportsWG)
+	})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		fmt.Println("Сервер запущен на http://localhost:8090")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("Ошибка сервера: %v\n", err)
+		for range hup {
+			logReload(reloadConfig())
 		}
 	}()
 
-	// Graceful Shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
+	signal.Stop(hup)
+	shutdown(time.Duration(config.Shutdown.Timeout))
+}
+
+// welcomeHandler показывает главную страницу; имя для приветствия
+// хранится в сессии посетителя. Клиентов здесь нет: cookie входа
+// отправляется только в /admin/, где и находится их список.
+func welcomeHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, sess := loadSession(r)
+		if name := r.FormValue("name"); name != "" && name != sess.Values["name"] {
+			sess.Values["name"] = name
+			if _, err := saveSession(w, r, id, sess); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else if err := touchSession(w, r, id, sess); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		page := Welcome{Name: sess.Values["name"], Time: time.Now().Format(time.Stamp)}
+		page.Accessible = renderMode(w, r) == modeAccessible
+		if err := templates.render(w, r, "main.html", page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// errClientExists — клиент с таким ID уже есть.
+var errClientExists = errors.New("Клиент с таким ID уже существует")
+
+// versionConflictError — клиента изменили после того, как его прочитали.
+type versionConflictError struct{ Current, Given int }
+
+func (e versionConflictError) Error() string {
+	return fmt.Sprintf("Версия клиента устарела: текущая %d, передана %d", e.Current, e.Given)
+}
+
+// checkClientLocked проверяет данные клиента и приводит их к единому виду:
+// обязательные поля, email, дата рождения, метки, адреса и любимый кофе по
+// меню. Общая проверка всех способов добавить или изменить клиента, чтобы
+// клиента, которого отклоняет один из них, не принимал другой. Вызывается
+// под clientsMu.
+func checkClientLocked(c *Client) error {
+	if err := normalizeClient(c); err != nil {
+		return err
+	}
+	var err error
+	c.FavCoffee, err = menuCoffeeLocked(c.ID, c.FavCoffee)
+	return err
+}
+
+// normalizeClient — проверка checkClientLocked без сверки любимого кофе с
+// меню. Нужна для уже сохраненных клиентов (снимки): позицию могли убрать из
+// меню после того, как клиента записали.
+func normalizeClient(c *Client) error {
+	if err := validateClient(*c); err != nil {
+		return err
+	}
+	var err error
+	if c.Tags, err = normalizeTags(c.Tags); err != nil {
+		return err
+	}
+	c.Addresses, err = normalizeAddresses(c.Addresses)
+	return err
+}
+
+// prepareNewClientLocked проверяет нового клиента и заполняет служебные
+// поля, не сохраняя его: так пакет можно сначала проверить целиком.
+// Вызывается под clientsMu.
+func prepareNewClientLocked(c Client) (Client, error) {
+	if err := checkClientLocked(&c); err != nil {
+		return c, err
+	}
+	if _, exists := clients[c.ID]; exists {
+		return c, errClientExists
+	}
+	if c.RegisterDate.IsZero() {
+		c.RegisterDate = time.Now()
+	}
+	c.Version = 1
+	c.DeletedAt = nil
+	syncAddresses(&c, nil)
+	return c, nil
+}
+
+// insertClientLocked сохраняет клиента, подготовленного
+// prepareNewClientLocked. Вызывается под clientsMu.
+func insertClientLocked(c Client, source string) {
+	clients[c.ID] = c
+	publishClientEvent(eventClientCreated, c, source)
+}
+
+// nextClientIDLocked возвращает ID после наибольшего занятого.
+// Вызывается под clientsMu.
+func nextClientIDLocked() int {
+	id := 1
+	for cur := range clients {
+		id = max(id, cur+1)
+	}
+	return id
+}
+
+// sortedClients возвращает копию списка клиентов, упорядоченную по ID.
+func sortedClients() []Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	list := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		fmt.Printf("Ошибка остановки сервера: %+v\n", err)
+// createClientLocked проверяет и сохраняет нового клиента с версией 1.
+// Вызывается под clientsMu; общая часть всех способов добавить клиента.
+// Занятый ID — errClientExists, остальные ошибки — неверные данные.
+//
+// ID общие для всех кофеен, поэтому клиентам кофеен ID назначает сервер:
+// иначе errClientExists на выбранный отправителем ID выдавал бы, что он
+// занят клиентом чужой кофейни.
+func createClientLocked(c Client, source string) (Client, error) {
+	if c.Tenant != "" {
+		c.ID = nextClientIDLocked()
 	}
-	fmt.Println("Сервер остановлен")
+	c, err := prepareNewClientLocked(c)
+	if err != nil {
+		return c, err
+	}
+	insertClientLocked(c, source)
+	return c, nil
+}
+
+// updateClientLocked заменяет данные существующего клиента, если upd.Version
+// совпадает с текущей версией. Без поля tags метки остаются прежними, без
+// addresses — все адреса, кроме основного (см. syncAddresses).
+// Данные проверяет checkClientLocked; устаревшая версия —
+// versionConflictError. Вызывается под clientsMu; удаленный клиент должен
+// быть отсеян вызывающим.
+func updateClientLocked(id int, upd Client, source string) (Client, error) {
+	cur := clients[id]
+	if upd.Version != cur.Version {
+		return upd, versionConflictError{Current: cur.Version, Given: upd.Version}
+	}
+	upd.ID = id
+	upd.Tenant = cur.Tenant
+	if err := checkClientLocked(&upd); err != nil {
+		return upd, err
+	}
+	if upd.Tags == nil {
+		upd.Tags = cur.Tags
+	}
+	upd.Version = cur.Version + 1
+	upd.DeletedAt = nil
+	if upd.RegisterDate.IsZero() {
+		upd.RegisterDate = cur.RegisterDate
+	}
+	syncAddresses(&upd, &cur)
+	clients[id] = upd
+	publishClientEvent(eventClientUpdated, upd, source)
+	return upd, nil
 }
 
 // addClientHandler добавляет клиента.
@@ -97,25 +725,146 @@ func addClientHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var newClient Client
-	if err := json.NewDecoder(r.Body).Decode(&newClient); err != nil {
-		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+	if !decodeRequest(w, r, &newClient, true) {
+		return
+	}
+	newClient.Tenant = requestTenant(r)
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	newClient, err := createClientLocked(newClient, sourceAPI)
+	if errors.Is(err, errClientExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, r, http.StatusCreated, newClient)
+}
+
+// updateClientHandler заменяет данные клиента. Текущая версия передается
+// в If-Match (ETag из GET /clients/{id}) или в поле version тела запроса.
+//
+// С ?mode=upsert версия не обязательна: отсутствующий клиент создается
+// (201), существующий заменяется целиком (200) — так можно повторно
+// выгружать весь список клиентов из внешней системы. Переданные If-Match
+// или version по-прежнему проверяются.
+func updateClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	upsert := false
+	switch r.URL.Query().Get("mode") {
+	case "":
+	case "upsert":
+		upsert = true
+	default:
+		http.Error(w, "Укажите ?mode=upsert или не указывайте mode", http.StatusBadRequest)
+		return
+	}
+
+	var upd Client
+	if !decodeRequest(w, r, &upd, false) {
+		return
+	}
+	if upd.ID != 0 && upd.ID != id {
+		http.Error(w, "ID в теле не совпадает с ID в адресе", http.StatusBadRequest)
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && upd.Version == 0 && !upsert {
+		http.Error(w, "Требуется заголовок If-Match или поле version", http.StatusPreconditionRequired)
 		return
 	}
 
 	clientsMu.Lock()
 	defer clientsMu.Unlock()
 
-	if _, exists := clients[newClient.ID]; exists {
-		http.Error(w, "Клиент с таким ID уже существует", http.StatusConflict)
+	cur, exists := tenantClientLocked(requestTenant(r), id)
+	if upsert && !exists && ifMatch == "" && upd.Version == 0 {
+		upsertCreateLocked(w, r, id, upd)
+		return
+	}
+	if !exists {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
 		return
 	}
+	if cur.deleted() {
+		if upsert {
+			// Удаленного клиента не воскрешаем повторной выгрузкой.
+			http.Error(w, "Клиент удален; восстановите его через POST /clients/{id}/restore", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	if upsert && ifMatch == "" && upd.Version == 0 {
+		upd.Version = cur.Version
+	}
+	if ifMatch != "" {
+		etag, err := jsonETag(cur)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !etagMatch(ifMatch, etag) {
+			http.Error(w, "Клиент был изменен другим запросом", http.StatusPreconditionFailed)
+			return
+		}
+		upd.Version = cur.Version
+	}
+	keepBirthDate(&upd, cur)
 
-	clients[newClient.ID] = newClient
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newClient)
+	upd, err = updateClientLocked(id, upd, sourceAPI)
+	var conflict versionConflictError
+	if errors.As(err, &conflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if etag, err := jsonETag(upd); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	writeResponse(w, r, http.StatusOK, upd)
+}
+
+// upsertCreateLocked создает клиента для PUT /clients/{id}?mode=upsert.
+// Вызывается под clientsMu.
+// Клиентам кофеен ID назначает сервер (см. createClientLocked), поэтому
+// создать их по ID из адреса нельзя.
+func upsertCreateLocked(w http.ResponseWriter, r *http.Request, id int, c Client) {
+	c.ID = id
+	c.Tenant = requestTenant(r)
+	if c.Tenant != "" {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	c, err := createClientLocked(c, sourceAPI)
+	if errors.Is(err, errClientExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if etag, err := jsonETag(c); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/clients/%d", id))
+	writeResponse(w, r, http.StatusCreated, c)
 }
 
-// deleteClientHandler удаляет клиента.
+// deleteClientHandler мягко удаляет клиента (см. softDeleteLocked).
 func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
@@ -132,26 +881,132 @@ func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
 	clientsMu.Lock()
 	defer clientsMu.Unlock()
 
-	if _, exists := clients[id]; !exists {
+	if !softDeleteLocked(requestTenant(r), id, time.Now(), sourceAPI) {
 		http.Error(w, "Клиент не найден", http.StatusNotFound)
 		return
 	}
-
-	delete(clients, id)
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Клиент с ID %d успешно удален", id)
 }
 
-// getClientsHandler возвращает всех клиентов.
+// getClientsHandler возвращает всех клиентов или только подходящих под
+// фильтр из параметров запроса (см. clientFilter).
 func getClientsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
 		return
 	}
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shape, err := parseClientShape(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+	matched, modified := selectClients(f)
+	if shape.plain() {
+		writeConditional(w, r, matched, modified)
+		return
+	}
+	if c, ok := negotiateCodec(r.Header.Get("Accept")); ok && !shape.allowCodec(w, c) {
+		return
+	}
+	list := make([]Client, 0, len(matched))
+	for _, c := range matched {
+		list = append(list, c)
+	}
+	shaped := make(map[int]shapedClient, len(list))
+	for _, c := range shapeClients(list, shape) {
+		shaped[c.ID] = c
+	}
+	// Заметки и заказы меняются отдельно от клиентов, поэтому с expand
+	// ответ 304 отдается только по ETag.
+	if shape.Expand.any() {
+		modified = time.Time{}
+	}
+	writeConditional(w, r, shaped, modified)
+}
+
+// selectClients возвращает клиентов по фильтру и время изменения
+// хранилища, по возможности из кэша (cache.go).
+func selectClients(f clientFilter) (map[int]Client, time.Time) {
+	if e, ok := cachedClients(f); ok {
+		return e.Clients, e.Modified
+	}
 
 	clientsMu.Lock()
 	defer clientsMu.Unlock()
+	matched := make(map[int]Client)
+	for id, c := range clients {
+		if f.match(c) {
+			matched[id] = c
+		}
+	}
+	cacheClientsLocked(f, matched, clientsModified)
+	return matched, clientsModified
+}
+
+// clientCount — ответ GET /clients/count.
+type clientCount struct {
+	Count int `json:"count"`
+}
 
+// countClientsHandler возвращает число клиентов по тому же фильтру, что и
+// /getClients: GET /clients/count.
+func countClientsHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+	matched, modified := selectClients(f)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clients)
+	json.NewEncoder(w).Encode(clientCount{Count: len(matched)})
+}
+
+// getClientHandler возвращает одного клиента.
+func getClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	include, err := parseIncludeDeleted(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shape, err := parseClientShape(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, include) {
+		return
+	}
+
+	clientsMu.Lock()
+	client, exists := tenantClientLocked(requestTenant(r), id)
+	modified := clientsModified
+	clientsMu.Unlock()
+
+	if !exists || (client.deleted() && !include) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	if !shape.plain() {
+		writeShapedClient(w, r, client, shape)
+		return
+	}
+	writeConditional(w, r, client, modified)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTest сбрасывает состояние сервера: пустые хранилища, данные во
+// временном каталоге и известный ключ подписи токенов.
+func setupTest(t *testing.T) {
+	t.Helper()
+	config = Config{DataDir: t.TempDir()}
+	config.Idempotency.TTL = Duration(time.Hour)
+	jwtSecret = []byte("test-secret")
+	clients = make(map[int]Client)
+	tenants = make(map[string]Tenant)
+	twoFactors = make(map[string]*twoFactor)
+	idempotent = make(map[string]*idempotentResponse)
+	apiKeys = make(map[string]APIKey)
+	keyUsages = make(map[string]*keyUsage)
+	drips = make(map[int]*dripEnrollment)
+	orders = make(map[int]Order)
+	nextOrderID = 1
+	loyalty = loyaltyState{NextID: 1}
+	balances = make(map[int]int)
+	menu = make(map[string]MenuItem)
+}
+
+// testToken выпускает действующий JWT для пользователя с ролью role.
+func testToken(t *testing.T, name string, role Role, tenant string) string {
+	t.Helper()
+	now := time.Now()
+	token, err := signJWT(jwtClaims{
+		Subject:   name,
+		Role:      role,
+		Tenant:    tenant,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}, jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// testRequest выполняет запрос к h; token и заголовки из headers
+// необязательны.
+func testRequest(h http.Handler, method, target, token, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpdateClientConditional(t *testing.T) {
+	setupTest(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /clients/{id}", updateClientHandler)
+
+	cur := Client{ID: 1, Name: "Айгерим", Age: 30, Version: 3}
+	etag, err := jsonETag(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		ifMatch string
+		want    int
+		version int // версия клиента после запроса
+	}{
+		{"без версии", "/clients/1", `{"name":"Айгерим","age":31}`, "", http.StatusPreconditionRequired, 3},
+		{"устаревший ETag", "/clients/1", `{"name":"Айгерим","age":31}`, `"stale"`, http.StatusPreconditionFailed, 3},
+		{"устаревшая версия", "/clients/1", `{"name":"Айгерим","age":31,"version":2}`, "", http.StatusConflict, 3},
+		{"верный ETag", "/clients/1", `{"name":"Айгерим","age":31}`, etag, http.StatusOK, 4},
+		{"верная версия", "/clients/1", `{"name":"Айгерим","age":31,"version":3}`, "", http.StatusOK, 4},
+		{"любая версия", "/clients/1", `{"name":"Айгерим","age":31}`, "*", http.StatusOK, 4},
+		{"неверные данные", "/clients/1", `{"name":"","version":3}`, "", http.StatusBadRequest, 3},
+		{"чужой ID в теле", "/clients/1", `{"id":2,"name":"Айгерим","version":3}`, "", http.StatusBadRequest, 3},
+		{"нет клиента", "/clients/2", `{"name":"Айгерим","version":1}`, "", http.StatusNotFound, 3},
+		{"upsert без версии", "/clients/1?mode=upsert", `{"name":"Айгерим","age":31}`, "", http.StatusOK, 4},
+		{"upsert с устаревшим ETag", "/clients/1?mode=upsert", `{"name":"Айгерим"}`, `"stale"`, http.StatusPreconditionFailed, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients = map[int]Client{1: cur}
+			headers := map[string]string{"Content-Type": "application/json"}
+			if tt.ifMatch != "" {
+				headers["If-Match"] = tt.ifMatch
+			}
+			w := testRequest(mux, http.MethodPut, tt.target, "", tt.body, headers)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := clients[1].Version; got != tt.version {
+				t.Errorf("версия %d, ожидалась %d", got, tt.version)
+			}
+			if w.Code == http.StatusOK && w.Header().Get("ETag") == "" {
+				t.Error("ответ без ETag")
+			}
+		})
+	}
+}
+
+func TestUpsertCreatesClient(t *testing.T) {
+	setupTest(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /clients/{id}", updateClientHandler)
+
+	w := testRequest(mux, http.MethodPut, "/clients/7?mode=upsert", "", `{"name":"Дана"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("статус %d, ожидался 201: %s", w.Code, w.Body)
+	}
+	if c := clients[7]; c.Name != "Дана" || c.Version != 1 {
+		t.Errorf("сохранен %+v", c)
+	}
+	if got := w.Header().Get("Location"); got != "/clients/7" {
+		t.Errorf("Location %q", got)
+	}
+}
+
+// TestWelcomeWithAdminCookie проверяет через настоящий cookie jar, что
+// cookie входа в /admin/ не уходит на главную страницу, а главная не
+// выдает себя за пустой список клиентов.
+func TestWelcomeWithAdminCookie(t *testing.T) {
+	setupTest(t)
+	config.Auth.Users = []User{{Username: "aigerim", Password: "secret", Role: RoleViewer}}
+	config.I18n.DefaultLocale = sourceLocale
+	config.Auth.TokenTTL = Duration(time.Hour)
+	clients = map[int]Client{1: {ID: 1, Name: "Данияр", Version: 1}}
+	sessions = newMemorySessionStore()
+	t.Cleanup(func() { sessions = nil })
+	templates, err := newTemplateManager(TemplatesConfig{Dir: "templates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", welcomeHandler(templates))
+	mux.HandleFunc("/admin/login", adminLoginHandler(templates))
+	mux.HandleFunc("GET /admin/{$}", requireAdminUI(RoleViewer, adminListHandler(templates)))
+	srv := httptest.NewServer(withTenant(mux))
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	get := func(path string) string {
+		t.Helper()
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: статус %d: %s", path, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	resp, err := client.PostForm(srv.URL+"/admin/login", url.Values{"username": {"aigerim"}, "password": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(get("/admin/"), "Данияр") {
+		t.Fatal("после входа в /admin/ нет списка клиентов")
+	}
+
+	home := get("/")
+	if strings.Contains(home, "Данияр") || strings.Contains(home, "Клиентов пока нет") {
+		t.Error("главная страница показывает список клиентов")
+	}
+	if !strings.Contains(home, `href="/admin/"`) {
+		t.Error("на главной нет ссылки на раздел для сотрудников")
+	}
+}
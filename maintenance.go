@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Режим обслуживания: на время пересчетов и восстановления из снимка
+// изменения через API отклоняются с 503 и Retry-After, чтение работает.
+// Администраторы всего развертывания проходят: именно они выполняют
+// восстановление и пересчеты. Фоновые задачи планировщика режим не
+// останавливает. Режим сохраняется в файл и переживает перезапуск.
+
+// maintenanceState — состояние режима обслуживания.
+type maintenanceState struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"` // показывается в ответе 503
+	RetryAfter Duration   `json:"retryAfter"`        // через сколько повторить запрос
+	Since      *time.Time `json:"since,omitempty"`
+	By         string     `json:"by,omitempty"` // кто включил
+}
+
+// defaultMaintenanceRetry — Retry-After, если он не задан при включении.
+const defaultMaintenanceRetry = Duration(5 * time.Minute)
+
+var (
+	maintenance   maintenanceState // Текущее состояние режима обслуживания
+	maintenanceMu sync.Mutex       // Мьютекс для защиты режима обслуживания
+)
+
+func maintenancePath() string {
+	return filepath.Join(config.DataDir, "maintenance.json")
+}
+
+// loadMaintenance восстанавливает режим обслуживания после перезапуска.
+func loadMaintenance() error {
+	data, err := os.ReadFile(maintenancePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	if err := json.Unmarshal(data, &maintenance); err != nil {
+		return fmt.Errorf("разбор %s: %w", maintenancePath(), err)
+	}
+	if maintenance.Enabled {
+		logf("Включен режим обслуживания: изменения через API отклоняются")
+	}
+	return nil
+}
+
+// currentMaintenance возвращает состояние режима обслуживания.
+func currentMaintenance() maintenanceState {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return maintenance
+}
+
+// maintenanceOperator сообщает, может ли p изменять данные в режиме
+// обслуживания.
+func maintenanceOperator(p principal) bool {
+	return p.Role.Allows(RoleAdmin) && p.Tenant == ""
+}
+
+// mutatingRequest сообщает, может ли запрос изменить данные. Вход в
+// систему и запросы, которые только читают, хотя и приходят методом POST,
+// изменениями не считаются.
+func mutatingRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	switch r.URL.Path {
+	case "/auth/login", "/admin/login", "/admin/logout":
+		return false
+	case "/clients/export":
+		return false // только читает клиентов
+	case "/graphql":
+		return false // мутации проверяет graphqlHandler
+	}
+	return !strings.HasPrefix(r.URL.Path, "/auth/2fa/")
+}
+
+// maintenanceAllowed сообщает, пропускается ли запрос в режиме
+// обслуживания: чтение, вход в систему и запросы администраторов
+// развертывания.
+func maintenanceAllowed(r *http.Request) bool {
+	if !mutatingRequest(r) || r.URL.Path == "/admin/maintenance" {
+		return true
+	}
+	if p, err := authenticate(r); err == nil && maintenanceOperator(p) {
+		return true
+	}
+	if p, ok := adminPrincipal(r); ok && maintenanceOperator(p) {
+		return true
+	}
+	return false
+}
+
+// withMaintenance отклоняет изменения, пока включен режим обслуживания.
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := currentMaintenance(); m.Enabled && !maintenanceAllowed(r) {
+			writeMaintenance(w, r, m)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeMaintenance отвечает 503 на изменение в режиме обслуживания.
+func writeMaintenance(w http.ResponseWriter, r *http.Request, m maintenanceState) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Duration(m.RetryAfter).Seconds())))
+	msg := m.Message
+	if msg == "" {
+		msg = maintenanceMessage
+	}
+	writeAPIError(w, r, http.StatusServiceUnavailable, apiError{Error: "maintenance", Message: msg})
+}
+
+const maintenanceMessage = "Сервер на обслуживании, изменения временно недоступны"
+
+// maintenanceRequest — тело POST /admin/maintenance.
+type maintenanceRequest struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message"`
+	RetryAfter *Duration `json:"retryAfter"`
+}
+
+// maintenanceHandler показывает (GET) и переключает (POST с телом
+// {"enabled": true, "message": "...", "retryAfter": "10m"}) режим
+// обслуживания.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentMaintenance())
+
+	case http.MethodPost:
+		var req maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		retry := defaultMaintenanceRetry
+		if req.RetryAfter != nil {
+			retry = *req.RetryAfter
+		}
+		if retry < Duration(time.Second) {
+			http.Error(w, "retryAfter должен быть не меньше 1s", http.StatusBadRequest)
+			return
+		}
+
+		p, _ := principalFrom(r)
+		maintenanceMu.Lock()
+		defer maintenanceMu.Unlock()
+		prev := maintenance
+		if req.Enabled {
+			maintenance = maintenanceState{Enabled: true, Message: req.Message, RetryAfter: retry, Since: prev.Since, By: prev.By}
+			if !prev.Enabled {
+				now := time.Now()
+				maintenance.Since, maintenance.By = &now, p.Name
+			}
+		} else {
+			maintenance = maintenanceState{}
+		}
+		if err := writeJSONFile(maintenancePath(), maintenance); err != nil {
+			maintenance = prev
+			http.Error(w, "Ошибка сохранения режима обслуживания", http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case req.Enabled && !prev.Enabled:
+			logf("Режим обслуживания включен (%s)", p.Name)
+		case !req.Enabled && prev.Enabled:
+			logf("Режим обслуживания выключен (%s)", p.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(maintenance)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MenuItem — позиция меню кофейни.
+type MenuItem struct {
+	Name      string `json:"name"`  // каноническое название из справочника кофе
+	Price     int    `json:"price"` // в минимальных единицах валюты
+	Available bool   `json:"available"`
+}
+
+var (
+	menu   = make(map[string]MenuItem) // Меню по каноническому названию
+	menuMu sync.Mutex                  // Мьютекс для защиты меню; берется после clientsMu
+)
+
+func menuPath() string {
+	return filepath.Join(config.DataDir, "menu.json")
+}
+
+// loadMenu читает меню; без файла меню пустое.
+func loadMenu() error {
+	data, err := os.ReadFile(menuPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var items []MenuItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("разбор %s: %w", menuPath(), err)
+	}
+
+	menuMu.Lock()
+	defer menuMu.Unlock()
+	for _, it := range items {
+		menu[it.Name] = it
+	}
+	return nil
+}
+
+func saveMenuLocked() error {
+	return writeJSONFile(menuPath(), sortedMenuLocked())
+}
+
+func sortedMenuLocked() []MenuItem {
+	list := make([]MenuItem, 0, len(menu))
+	for _, it := range menu {
+		list = append(list, it)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// menuName приводит название к ключу меню: синонимы из справочника — к
+// каноническому названию, остальное — к нижнему регистру без лишних пробелов.
+func menuName(name string) string {
+	return coffeeKey(canonicalCoffee(name))
+}
+
+// menuCoffeeLocked приводит FavCoffee клиента к названию из меню. Пока меню
+// пусто, название только приводится к каноническому. Уже сохраненное у
+// клиента id название принимается, даже если его убрали из меню, чтобы
+// клиента можно было изменить, не трогая любимый кофе. Вызывается под clientsMu.
+func menuCoffeeLocked(id int, name string) (string, error) {
+	canonical := canonicalCoffee(name)
+	if canonical == "" {
+		return "", nil
+	}
+	if c, exists := clients[id]; exists && c.FavCoffee == canonical {
+		return canonical, nil
+	}
+
+	menuMu.Lock()
+	defer menuMu.Unlock()
+	if len(menu) == 0 {
+		return canonical, nil
+	}
+	if it, ok := menu[coffeeKey(canonical)]; ok {
+		return it.Name, nil
+	}
+	return "", fmt.Errorf("Кофе %q нет в меню", name)
+}
+
+// decodeMenuItem читает позицию меню из тела запроса.
+func decodeMenuItem(w http.ResponseWriter, r *http.Request) (MenuItem, bool) {
+	var it MenuItem
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&it); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return it, false
+	}
+	if it.Price < 0 || it.Price > 100_000_000 {
+		http.Error(w, "Неверная цена", http.StatusBadRequest)
+		return it, false
+	}
+	return it, true
+}
+
+// listMenuHandler возвращает меню по алфавиту; ?available=true — только
+// то, что сейчас можно заказать.
+func listMenuHandler(w http.ResponseWriter, r *http.Request) {
+	onlyAvailable := r.URL.Query().Get("available") == "true"
+
+	menuMu.Lock()
+	list := sortedMenuLocked()
+	menuMu.Unlock()
+
+	if onlyAvailable {
+		n := 0
+		for _, it := range list {
+			if it.Available {
+				list[n] = it
+				n++
+			}
+		}
+		list = list[:n]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// createMenuItemHandler добавляет позицию: POST /menu.
+func createMenuItemHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := decodeMenuItem(w, r)
+	if !ok {
+		return
+	}
+	it.Name = menuName(it.Name)
+	if it.Name == "" {
+		http.Error(w, "Не указано название", http.StatusBadRequest)
+		return
+	}
+
+	menuMu.Lock()
+	defer menuMu.Unlock()
+	if _, exists := menu[it.Name]; exists {
+		http.Error(w, "Позиция уже есть в меню", http.StatusConflict)
+		return
+	}
+	menu[it.Name] = it
+	if err := saveMenuLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(it)
+}
+
+// getMenuItemHandler возвращает позицию: GET /menu/{name}. Название можно
+// указать синонимом.
+func getMenuItemHandler(w http.ResponseWriter, r *http.Request) {
+	name := menuName(r.PathValue("name"))
+
+	menuMu.Lock()
+	it, exists := menu[name]
+	menuMu.Unlock()
+	if !exists {
+		http.Error(w, "Позиции нет в меню", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(it)
+}
+
+// updateMenuItemHandler меняет цену и доступность: PUT /menu/{name}.
+func updateMenuItemHandler(w http.ResponseWriter, r *http.Request) {
+	upd, ok := decodeMenuItem(w, r)
+	if !ok {
+		return
+	}
+	name := menuName(r.PathValue("name"))
+	if upd.Name != "" && menuName(upd.Name) != name {
+		http.Error(w, "Название в теле не совпадает с названием в адресе", http.StatusBadRequest)
+		return
+	}
+
+	menuMu.Lock()
+	defer menuMu.Unlock()
+	if _, exists := menu[name]; !exists {
+		http.Error(w, "Позиции нет в меню", http.StatusNotFound)
+		return
+	}
+	upd.Name = name
+	menu[name] = upd
+	if err := saveMenuLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upd)
+}
+
+// deleteMenuItemHandler убирает позицию: DELETE /menu/{name}. У клиентов,
+// выбравших ее любимым кофе, название остается.
+func deleteMenuItemHandler(w http.ResponseWriter, r *http.Request) {
+	name := menuName(r.PathValue("name"))
+
+	menuMu.Lock()
+	defer menuMu.Unlock()
+	if _, exists := menu[name]; !exists {
+		http.Error(w, "Позиции нет в меню", http.StatusNotFound)
+		return
+	}
+	delete(menu, name)
+	if err := saveMenuLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
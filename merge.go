@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Поиск дублей и слияние клиентов. Дубли ищутся по похожему имени в одном
+// городе; при слиянии второй клиент (source) переносится в первый
+// (target) и удаляется окончательно, а запись об этом остается в журнале
+// слияний.
+
+// defaultDuplicateScore — сходство имен, начиная с которого клиенты
+// считаются возможными дублями.
+const defaultDuplicateScore = 0.8
+
+// duplicatePair — пара возможных дублей.
+type duplicatePair struct {
+	A     Client  `json:"a"`
+	B     Client  `json:"b"`
+	Score float64 `json:"score"` // сходство имен от 0 до 1
+}
+
+// normalizeName приводит имя к виду для сравнения: нижний регистр, ё как е,
+// одиночные пробелы.
+func normalizeName(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// levenshtein — число вставок, удалений и замен символов между a и b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// nameSimilarity сравнивает нормализованные имена с точностью до опечаток;
+// порядок слов не важен: «Иван Петров» и «Петров Иван» совпадают.
+func nameSimilarity(a, b string) float64 {
+	score := func(x, y string) float64 {
+		n := max(utf8.RuneCountInString(x), utf8.RuneCountInString(y))
+		if n == 0 {
+			return 0
+		}
+		return 1 - float64(levenshtein(x, y))/float64(n)
+	}
+	sorted := func(s string) string {
+		words := strings.Fields(s)
+		slices.Sort(words)
+		return strings.Join(words, " ")
+	}
+	return max(score(a, b), score(sorted(a), sorted(b)))
+}
+
+// findDuplicates ищет пары неудаленных клиентов кофейни tenant из одного
+// города с именами не менее похожими, чем minScore. Пары упорядочены от
+// самых похожих.
+func findDuplicates(tenant string, minScore float64) []duplicatePair {
+	clientsMu.Lock()
+	byCity := make(map[string][]Client)
+	for _, c := range clients {
+		if c.Tenant == tenant && !c.deleted() {
+			city := normalizeName(c.Address.City)
+			byCity[city] = append(byCity[city], c)
+		}
+	}
+	clientsMu.Unlock()
+
+	pairs := []duplicatePair{}
+	for _, list := range byCity {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		names := make([]string, len(list))
+		for i, c := range list {
+			names[i] = normalizeName(c.Name)
+		}
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if s := nameSimilarity(names[i], names[j]); s >= minScore {
+					pairs = append(pairs, duplicatePair{A: list[i], B: list[j], Score: s})
+				}
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return pairs[i].A.ID < pairs[j].A.ID || (pairs[i].A.ID == pairs[j].A.ID && pairs[i].B.ID < pairs[j].B.ID)
+	})
+	return pairs
+}
+
+// duplicatesHandler возвращает возможные дубли:
+// GET /clients/duplicates?minScore=0.8.
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	minScore := defaultDuplicateScore
+	if v := r.URL.Query().Get("minScore"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s < 0 || s > 1 {
+			http.Error(w, "minScore: ожидается число от 0 до 1", http.StatusBadRequest)
+			return
+		}
+		minScore = s
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findDuplicates(requestTenant(r), minScore))
+}
+
+// mergeRecord — запись журнала слияний. Данных удаленного клиента в ней
+// нет, только что куда перенесено.
+type mergeRecord struct {
+	ID        string    `json:"id"`
+	TargetID  int       `json:"targetId"`
+	SourceID  int       `json:"sourceId"` // удален окончательно
+	Tenant    string    `json:"tenant,omitempty"`
+	MergedAt  time.Time `json:"mergedAt"`
+	Actor     string    `json:"actor,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Fields    []string  `json:"fields"`  // поля target, взятые из source
+	Orders    []int     `json:"orders"`  // перенесенные заказы
+	Loyalty   int       `json:"loyalty"` // перенесено операций с баллами
+	Points    int       `json:"points"`  // перенесено баллов
+	Notes     int       `json:"notes"`   // перенесено заметок
+}
+
+var (
+	merges   []mergeRecord // Журнал слияний, новые последними
+	mergesMu sync.Mutex    // Мьютекс для защиты журнала; берется после clientsMu
+)
+
+func mergesPath() string {
+	return filepath.Join(config.DataDir, "merges.json")
+}
+
+// loadMerges читает журнал слияний.
+func loadMerges() error {
+	data, err := os.ReadFile(mergesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mergesMu.Lock()
+	defer mergesMu.Unlock()
+	if err := json.Unmarshal(data, &merges); err != nil {
+		return fmt.Errorf("разбор %s: %w", mergesPath(), err)
+	}
+	return nil
+}
+
+// mergeClientFields дополняет target пустыми у него полями, метками и
+// адресами source и оставляет более раннюю дату регистрации. Возвращает взятые поля.
+func mergeClientFields(target *Client, source Client) []string {
+	fields := []string{}
+	fill := func(name string, dst *string, src string) {
+		if *dst == "" && src != "" {
+			*dst = src
+			fields = append(fields, name)
+		}
+	}
+	if target.Age == 0 && source.Age != 0 {
+		target.Age = source.Age
+		fields = append(fields, "age")
+	}
+	fill("birthDate", &target.BirthDate, source.BirthDate)
+	fill("email", &target.Email, source.Email)
+	fill("favCoffee", &target.FavCoffee, source.FavCoffee)
+	// Адреса source добавляются к адресам target, если таких типов у него нет.
+	for _, a := range source.Addresses {
+		if len(target.Addresses) < maxAddresses && !slices.ContainsFunc(target.Addresses, func(b Address) bool { return b.Type == a.Type }) {
+			target.Addresses = append(slices.Clone(target.Addresses), a)
+			fields = append(fields, "addresses."+a.Type)
+		}
+	}
+	syncAddresses(target, nil)
+	// Если вместе меток больше maxClientTags, остаются метки target.
+	if tags, err := normalizeTags(append(slices.Clone(target.Tags), source.Tags...)); err == nil && !slices.Equal(tags, target.Tags) {
+		target.Tags = tags
+		fields = append(fields, "tags")
+	}
+	if !source.RegisterDate.IsZero() && (target.RegisterDate.IsZero() || source.RegisterDate.Before(target.RegisterDate)) {
+		target.RegisterDate = source.RegisterDate
+		fields = append(fields, "registerDate")
+	}
+	return fields
+}
+
+// mergeRequest — тело POST /clients/merge.
+type mergeRequest struct {
+	TargetID int `json:"targetId"` // остается
+	SourceID int `json:"sourceId"` // переносится в target и удаляется
+}
+
+// mergeResult — ответ POST /clients/merge.
+type mergeResult struct {
+	Client Client      `json:"client"`
+	Merge  mergeRecord `json:"merge"`
+}
+
+// mergeClientsHandler сливает двух клиентов: POST /clients/merge. Заказы,
+// операции с баллами и заметки source переходят к target, пустые поля target
+// заполняются из source, дата регистрации берется более ранняя. Клиент
+// source удаляется окончательно вместе с аватаром.
+func mergeClientsHandler(w http.ResponseWriter, r *http.Request) {
+	var req mergeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == req.SourceID {
+		http.Error(w, "targetId и sourceId должны различаться", http.StatusBadRequest)
+		return
+	}
+	tenant := requestTenant(r)
+	rec := mergeRecord{
+		ID:        randomHex(8),
+		TargetID:  req.TargetID,
+		SourceID:  req.SourceID,
+		Tenant:    tenant,
+		MergedAt:  time.Now(),
+		RequestID: r.Header.Get(requestIDHeader),
+		Orders:    []int{},
+	}
+	if p, ok := principalFrom(r); ok {
+		rec.Actor = p.Kind + ":" + p.Name
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	target, ok := tenantClientLocked(tenant, req.TargetID)
+	source, ok2 := tenantClientLocked(tenant, req.SourceID)
+	if !ok || !ok2 || target.deleted() || source.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	// source удаляется окончательно, поэтому попадает в архив до того, как
+	// его заказы и баллы перейдут к target.
+	if err := archiveClientLocked(source, sourceAPI); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ordersMu.Lock()
+	for id, o := range orders {
+		if o.ClientID == source.ID {
+			o.ClientID = target.ID
+			orders[id] = o
+			rec.Orders = append(rec.Orders, id)
+		}
+	}
+	if len(rec.Orders) > 0 {
+		if err := saveOrdersLocked(); err != nil {
+			logError("Ошибка сохранения заказов: %v", err)
+		}
+	}
+	ordersMu.Unlock()
+	slices.Sort(rec.Orders)
+
+	loyaltyMu.Lock()
+	for i, t := range loyalty.Transactions {
+		if t.ClientID == source.ID {
+			loyalty.Transactions[i].ClientID = target.ID
+			rec.Loyalty++
+		}
+	}
+	if rec.Loyalty > 0 {
+		rec.Points = balances[source.ID]
+		balances[target.ID] += balances[source.ID]
+		delete(balances, source.ID)
+		if err := writeJSONFile(loyaltyPath(), loyalty); err != nil {
+			logError("Ошибка сохранения баллов лояльности: %v", err)
+		}
+	}
+	loyaltyMu.Unlock()
+
+	notesMu.Lock()
+	for i, n := range notes.Notes {
+		if n.ClientID == source.ID {
+			notes.Notes[i].ClientID = target.ID
+			rec.Notes++
+		}
+	}
+	if rec.Notes > 0 {
+		if err := writeJSONFile(notesPath(), notes); err != nil {
+			logError("Ошибка сохранения заметок: %v", err)
+		}
+	}
+	notesMu.Unlock()
+
+	lastSeenMu.Lock()
+	mergeActivityLocked(target.ID, source.ID)
+	lastSeenMu.Unlock()
+
+	rec.Fields = mergeClientFields(&target, source)
+	target.Version++
+	clients[target.ID] = target
+	publishClientEvent(eventClientUpdated, target, sourceAPI)
+	// Заказы, баллы и заметки уже у target, поэтому подписчики удалят у source только
+	// аватар и цепочку онбординга.
+	delete(clients, source.ID)
+	publishClientEvent(eventClientPurged, source, sourceAPI)
+
+	mergesMu.Lock()
+	merges = append(merges, rec)
+	if err := writeJSONFile(mergesPath(), merges); err != nil {
+		// Слияние уже выполнено; запись остается в памяти и сохранится со следующей.
+		logError("Ошибка сохранения журнала слияний: %v", err)
+	}
+	mergesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mergeResult{Client: target, Merge: rec})
+}
+
+// mergesHandler возвращает журнал слияний: GET /admin/merges, ?clientId= —
+// слияния, в которых клиент был target или source.
+func mergesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := 0
+	if v := r.URL.Query().Get("clientId"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "clientId: ожидается число", http.StatusBadRequest)
+			return
+		}
+		clientID = n
+	}
+
+	mergesMu.Lock()
+	defer mergesMu.Unlock()
+	list := []mergeRecord{}
+	for _, m := range merges {
+		if clientID == 0 || m.TargetID == clientID || m.SourceID == clientID {
+			list = append(list, m)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
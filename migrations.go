@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Миграции сохраненных данных: файлов состояния в dataDir и снимков в
+// хранилище файлов. Базы данных у сервера нет, поэтому миграции — функции
+// на Go, а не SQL. Миграция добавляется в конец dataMigrations со
+// следующим номером и не меняется после выпуска. Примененные версии
+// записываются в dataDir/migrations.json.
+//
+// Сервер с непримененными миграциями не запускается, пока их не применит
+// adv-prog migrate или флаг serve --auto-migrate. Данные версии новее, чем
+// знает сервер, не открываются вовсе: их записал более новый выпуск.
+
+// dataMigration — шаг миграции данных.
+type dataMigration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+}
+
+// dataMigrations — миграции по возрастанию версий.
+var dataMigrations []dataMigration
+
+// appliedMigration — запись о примененной миграции.
+type appliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// migrationsState — сохраняемые версии данных.
+type migrationsState struct {
+	Version int                `json:"version"`
+	Applied []appliedMigration `json:"applied"`
+}
+
+func migrationsPath() string {
+	return filepath.Join(config.DataDir, "migrations.json")
+}
+
+// latestMigration — версия данных, которую ждет этот выпуск.
+func latestMigration() int {
+	if len(dataMigrations) == 0 {
+		return 0
+	}
+	return dataMigrations[len(dataMigrations)-1].Version
+}
+
+// loadMigrations читает версию данных. Если файла нет, а dataDir пуст или
+// отсутствует, данные считаются новыми: миграции им не нужны.
+func loadMigrations() (migrationsState, error) {
+	st := migrationsState{Applied: []appliedMigration{}}
+	data, err := os.ReadFile(migrationsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		entries, err := os.ReadDir(config.DataDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return st, err
+		}
+		if len(entries) == 0 {
+			st.Version = latestMigration()
+		}
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("разбор %s: %w", migrationsPath(), err)
+	}
+	return st, nil
+}
+
+// pendingMigrations возвращает миграции новее версии данных.
+func pendingMigrations(st migrationsState) []dataMigration {
+	var pending []dataMigration
+	for _, m := range dataMigrations {
+		if m.Version > st.Version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// checkMigrations проверяет при запуске, что версия данных совпадает с
+// ожидаемой; с auto применяет недостающие миграции.
+func checkMigrations(ctx context.Context, auto bool) error {
+	st, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if st.Version > latestMigration() {
+		return fmt.Errorf("данные версии %d новее, чем знает этот выпуск (%d)", st.Version, latestMigration())
+	}
+	if pending := pendingMigrations(st); len(pending) > 0 && !auto {
+		return fmt.Errorf("данные версии %d, нужна %d: выполните adv-prog migrate или запустите с --auto-migrate", st.Version, latestMigration())
+	}
+	_, err = applyMigrations(ctx, st)
+	return err
+}
+
+// applyMigrations применяет недостающие миграции по порядку и после
+// каждой записывает версию, так что прерванный запуск продолжится со
+// следующей. Возвращает примененные.
+func applyMigrations(ctx context.Context, st migrationsState) ([]appliedMigration, error) {
+	if st.Version > latestMigration() {
+		return nil, fmt.Errorf("данные версии %d новее, чем знает этот выпуск (%d)", st.Version, latestMigration())
+	}
+	var done []appliedMigration
+	_, statErr := os.Stat(migrationsPath())
+	for _, m := range pendingMigrations(st) {
+		start := time.Now()
+		if err := m.Up(ctx); err != nil {
+			return done, fmt.Errorf("миграция %d (%s): %w", m.Version, m.Name, err)
+		}
+		a := appliedMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+		st.Version = m.Version
+		st.Applied = append(st.Applied, a)
+		if err := writeJSONFile(migrationsPath(), st); err != nil {
+			return done, err
+		}
+		done = append(done, a)
+		logf("Миграция %d (%s) применена за %v", m.Version, m.Name, time.Since(start).Round(time.Millisecond))
+	}
+	// Новые данные получают файл версии сразу, иначе следующий запуск не
+	// отличил бы их от данных до появления миграций.
+	if errors.Is(statErr, fs.ErrNotExist) && len(done) == 0 {
+		if err := writeJSONFile(migrationsPath(), st); err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
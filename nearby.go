@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Поиск клиентов рядом с точкой, например с кофейней: GET /clients/nearby.
+// Координаты адресов задает геокодер (geocode.go). Чтобы не перебирать всех
+// клиентов, адреса с координатами разложены по ячейкам сетки в
+// geoCellDegrees градусов; запрос просматривает только ячейки, которые
+// задевает круг поиска.
+
+const (
+	geoCellDegrees    = 0.1 // сторона ячейки, около 11 км по широте
+	earthRadiusKm     = 6371.0088
+	kmPerDegree       = earthRadiusKm * math.Pi / 180
+	maxNearbyRadiusKm = 100
+)
+
+// geoCell — ячейка сетки: номера полос широты и долготы.
+type geoCell struct {
+	Lat, Lon int
+}
+
+// geoLonCells — ячеек в полосе широты, долгота идет по кругу.
+var geoLonCells = int(math.Round(360 / geoCellDegrees))
+
+var (
+	geoIndex       = make(map[geoCell][]int) // ID клиентов с адресом в ячейке; защищено clientsMu
+	geoClientCells = make(map[int][]geoCell) // Ячейки адресов клиента; защищено clientsMu
+)
+
+// cellOf возвращает ячейку точки.
+func cellOf(p GeoPoint) geoCell {
+	return geoCell{
+		Lat: int(math.Floor(p.Lat / geoCellDegrees)),
+		Lon: wrapLonCell(int(math.Floor(p.Lon / geoCellDegrees))),
+	}
+}
+
+// wrapLonCell приводит номер полосы долготы к диапазону [-180°, 180°).
+func wrapLonCell(i int) int {
+	half := geoLonCells / 2
+	return ((i+half)%geoLonCells+geoLonCells)%geoLonCells - half
+}
+
+// indexClientLocked заменяет адреса клиента в индексе; удаленных клиентов
+// убирает. Вызывается под clientsMu.
+func indexClientLocked(c Client) {
+	for _, cell := range geoClientCells[c.ID] {
+		ids := slices.DeleteFunc(geoIndex[cell], func(id int) bool { return id == c.ID })
+		if len(ids) == 0 {
+			delete(geoIndex, cell)
+		} else {
+			geoIndex[cell] = ids
+		}
+	}
+	delete(geoClientCells, c.ID)
+	if c.deleted() {
+		return
+	}
+	var cells []geoCell
+	for _, a := range c.Addresses {
+		if a.Location == nil {
+			continue
+		}
+		if cell := cellOf(*a.Location); !slices.Contains(cells, cell) {
+			cells = append(cells, cell)
+			geoIndex[cell] = append(geoIndex[cell], c.ID)
+		}
+	}
+	if cells != nil {
+		geoClientCells[c.ID] = cells
+	}
+}
+
+// rebuildGeoIndexLocked строит индекс заново после замены хранилища
+// целиком. Вызывается под clientsMu.
+func rebuildGeoIndexLocked() {
+	clear(geoIndex)
+	clear(geoClientCells)
+	for _, c := range clients {
+		indexClientLocked(c)
+	}
+}
+
+// geoIndexOnClientEvent обновляет индекс при изменении клиента.
+func geoIndexOnClientEvent(e clientEvent) {
+	c := e.Client
+	if e.Type == eventClientPurged {
+		c.DeletedAt = nil
+		c.Addresses = nil
+	}
+	indexClientLocked(c)
+}
+
+// distanceKm — расстояние между точками по поверхности Земли (гаверсинус).
+func distanceKm(a, b GeoPoint) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lon - a.Lon) * rad
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// nearbyCellsLocked возвращает ID клиентов из ячеек, которые задевает круг
+// радиусом radiusKm вокруг p. Вызывается под clientsMu.
+func nearbyCellsLocked(p GeoPoint, radiusKm float64) map[int]bool {
+	dLat := radiusKm / kmPerDegree
+	latFrom := int(math.Floor(max(p.Lat-dLat, -90) / geoCellDegrees))
+	latTo := int(math.Floor(min(p.Lat+dLat, 90) / geoCellDegrees))
+
+	// Полосы долготы сужаются к полюсам; у полюса круг задевает их все.
+	lonFrom, lonTo := -geoLonCells/2, geoLonCells/2-1
+	if edge := math.Abs(p.Lat) + dLat; edge < 89 {
+		dLon := radiusKm / (kmPerDegree * math.Cos(edge*math.Pi/180))
+		if dLon < 180 {
+			lonFrom = int(math.Floor((p.Lon - dLon) / geoCellDegrees))
+			lonTo = int(math.Floor((p.Lon + dLon) / geoCellDegrees))
+		}
+	}
+
+	found := make(map[int]bool)
+	for lat := latFrom; lat <= latTo; lat++ {
+		for lon := lonFrom; lon <= lonTo; lon++ {
+			for _, id := range geoIndex[geoCell{lat, wrapLonCell(lon)}] {
+				found[id] = true
+			}
+		}
+	}
+	return found
+}
+
+// nearbyClient — клиент в ответе GET /clients/nearby.
+type nearbyClient struct {
+	Client      Client  `json:"client"`
+	AddressType string  `json:"addressType"` // ближайший из адресов клиента
+	DistanceKm  float64 `json:"distanceKm"`
+}
+
+// findNearby возвращает неудаленных клиентов кофейни tenant, у которых
+// есть адрес не дальше radiusKm от p, ближайших первыми.
+func findNearby(tenant string, p GeoPoint, radiusKm float64) []nearbyClient {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	list := []nearbyClient{}
+	for id := range nearbyCellsLocked(p, radiusKm) {
+		c, exists := clients[id]
+		if !exists || c.deleted() || c.Tenant != tenant {
+			continue
+		}
+		best := nearbyClient{Client: c, DistanceKm: math.Inf(1)}
+		for _, a := range c.Addresses {
+			if a.Location == nil {
+				continue
+			}
+			if d := distanceKm(p, *a.Location); d < best.DistanceKm {
+				best.AddressType, best.DistanceKm = a.Type, d
+			}
+		}
+		if best.DistanceKm <= radiusKm {
+			best.DistanceKm = math.Round(best.DistanceKm*1000) / 1000
+			list = append(list, best)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DistanceKm != list[j].DistanceKm {
+			return list[i].DistanceKm < list[j].DistanceKm
+		}
+		return list[i].Client.ID < list[j].Client.ID
+	})
+	return list
+}
+
+// nearbyHandler ищет клиентов рядом с точкой:
+// GET /clients/nearby?lat=55.75&lon=37.62&radiusKm=2.
+func nearbyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, "Координаты вне диапазона: lat от -90 до 90, lon от -180 до 180", http.StatusBadRequest)
+		return
+	}
+	radius, err := strconv.ParseFloat(q.Get("radiusKm"), 64)
+	if err != nil || radius <= 0 || radius > maxNearbyRadiusKm {
+		http.Error(w, fmt.Sprintf("radiusKm: ожидается число больше 0 и не больше %d", maxNearbyRadiusKm), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findNearby(requestTenant(r), GeoPoint{Lat: lat, Lon: lon}, radius))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Заметки сотрудников о клиенте: «аллергия на лактозу», «любит овсяное
+// молоко». Заметки хранятся отдельно от клиента и не меняют его версию.
+
+// maxNoteLength — символов в заметке.
+const maxNoteLength = 2000
+
+// clientNote — заметка о клиенте.
+type clientNote struct {
+	ID        int       `json:"id" xml:"id"`
+	ClientID  int       `json:"clientId" xml:"clientId"`
+	Text      string    `json:"text" xml:"text"`
+	Author    string    `json:"author,omitempty" xml:"author,omitempty"` // пользователь или API-ключ
+	CreatedAt time.Time `json:"createdAt" xml:"createdAt"`
+}
+
+// notesState — сохраняемые заметки.
+type notesState struct {
+	NextID int          `json:"nextId"`
+	Notes  []clientNote `json:"notes"`
+}
+
+var (
+	notes   = notesState{NextID: 1} // Заметки всех клиентов, новые последними
+	notesMu sync.Mutex              // Мьютекс для защиты заметок; берется после clientsMu
+)
+
+func notesPath() string {
+	return filepath.Join(config.DataDir, "notes.json")
+}
+
+// loadNotes читает заметки.
+func loadNotes() error {
+	data, err := os.ReadFile(notesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	notesMu.Lock()
+	defer notesMu.Unlock()
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return fmt.Errorf("разбор %s: %w", notesPath(), err)
+	}
+	return nil
+}
+
+// clientNotesLocked возвращает заметки клиента, новые последними.
+// Вызывается под notesMu.
+func clientNotesLocked(id int) []clientNote {
+	list := []clientNote{}
+	for _, n := range notes.Notes {
+		if n.ClientID == id {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// notesOnClientEvent удаляет заметки окончательно удаленного клиента.
+func notesOnClientEvent(e clientEvent) {
+	if e.Type != eventClientPurged {
+		return
+	}
+	notesMu.Lock()
+	defer notesMu.Unlock()
+	if dropClientNotesLocked(e.Client.ID) > 0 {
+		if err := writeJSONFile(notesPath(), notes); err != nil {
+			logError("Ошибка сохранения заметок: %v", err)
+		}
+	}
+}
+
+// dropClientNotesLocked удаляет заметки клиента и возвращает их число.
+// Вызывается под notesMu.
+func dropClientNotesLocked(id int) int {
+	kept := notes.Notes[:0]
+	for _, n := range notes.Notes {
+		if n.ClientID != id {
+			kept = append(kept, n)
+		}
+	}
+	removed := len(notes.Notes) - len(kept)
+	notes.Notes = kept
+	return removed
+}
+
+// clientNotesHandler возвращает заметки клиента: GET /clients/{id}/notes.
+func clientNotesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	if !activeClient(requestTenant(r), id) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	notesMu.Lock()
+	list := clientNotesLocked(id)
+	notesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// noteRequest — тело POST /clients/{id}/notes.
+type noteRequest struct {
+	Text string `json:"text"`
+}
+
+// addNoteHandler добавляет заметку: POST /clients/{id}/notes. Автор и
+// время задает сервер.
+func addNoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	var req noteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		http.Error(w, "Пустая заметка", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(text) > maxNoteLength {
+		http.Error(w, fmt.Sprintf("Заметка длиннее %d символов", maxNoteLength), http.StatusBadRequest)
+		return
+	}
+	n := clientNote{ClientID: id, Text: text, CreatedAt: time.Now()}
+	if p, ok := principalFrom(r); ok {
+		n.Author = p.Name
+	}
+
+	// clientsMu держится до записи, чтобы клиента не удалили между проверкой
+	// и сохранением.
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, exists := tenantClientLocked(requestTenant(r), id); !exists || c.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	notesMu.Lock()
+	defer notesMu.Unlock()
+	n.ID = notes.NextID
+	notes.NextID++
+	notes.Notes = append(notes.Notes, n)
+	if err := writeJSONFile(notesPath(), notes); err != nil {
+		notes.NextID--
+		notes.Notes = notes.Notes[:len(notes.Notes)-1]
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/clients/"+strconv.Itoa(id)+"/notes")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(n)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Внешние снимки: каждую ночь полный снимок хранилища сжимается gzip,
+// шифруется целиком AES-256-GCM ключом из encryption.keys и загружается
+// в отдельный бакет S3 — на случай, если пропадет сервер вместе с
+// основным хранилищем файлов. Старые снимки удаляются по offsiteBackup.keep
+// и offsiteBackup.maxAge. Время последнего снимка видно в /healthz, в
+// /debug/vars и в проверке offsite на /status.
+
+// OffsiteConfig задает внешние снимки.
+type OffsiteConfig struct {
+	Enabled bool     `json:"enabled"`
+	S3      S3Config `json:"s3"`
+	// KeyID — ключ из encryption.keys; пусто — encryption.keyId.
+	KeyID      string   `json:"keyId"`
+	Keep       int      `json:"keep"`       // сколько последних снимков хранить; 0 — все
+	MaxAge     Duration `json:"maxAge"`     // снимки старше удаляются; 0 — не удалять
+	StaleAfter Duration `json:"staleAfter"` // проверка offsite падает, если снимка нет дольше
+}
+
+func (c OffsiteConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if err := c.S3.validate(); err != nil {
+		return fmt.Errorf("offsiteBackup: %w", err)
+	}
+	if c.Keep < 0 || c.MaxAge < 0 {
+		return errors.New("offsiteBackup: keep и maxAge не могут быть отрицательными")
+	}
+	if c.StaleAfter <= 0 {
+		return errors.New("offsiteBackup.staleAfter должен быть положительным")
+	}
+	return nil
+}
+
+// offsiteMagic начинает зашифрованный снимок; за ним ID ключа, перевод
+// строки, nonce и шифротекст. Заголовок целиком — дополнительные данные
+// AEAD, поэтому подменить ID ключа нельзя.
+const offsiteMagic = "ADVPROG-OFFSITE-1\n"
+
+const offsitePrefix = "backups/"
+
+// offsite — бакет внешних снимков; nil, если они выключены.
+var offsite BlobStore
+
+// offsiteState — итог последней загрузки.
+type offsiteState struct {
+	LastBackup  *time.Time `json:"lastBackup,omitempty"` // время последнего успешного снимка
+	LastKey     string     `json:"lastKey,omitempty"`
+	LastSize    int64      `json:"lastSize,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+var (
+	offsiteLast   offsiteState // Итог последней загрузки
+	offsiteLastMu sync.Mutex   // Мьютекс для защиты итога загрузки
+)
+
+// openOffsite подключает бакет внешних снимков и читает время последнего
+// снимка, чтобы /healthz показывал его сразу после запуска.
+func openOffsite(ctx context.Context, cfg OffsiteConfig) error {
+	if _, err := offsiteKey(cfg); err != nil {
+		return err
+	}
+	store, err := newS3BlobStore("offsiteBackup", cfg.S3)
+	if err != nil {
+		return fmt.Errorf("offsiteBackup: %w", err)
+	}
+	offsite = store
+	go func() {
+		list, err := offsite.List(ctx, offsitePrefix)
+		if err != nil {
+			logError("Ошибка чтения списка внешних снимков: %v", err)
+			return
+		}
+		if len(list) == 0 {
+			return
+		}
+		last := slices.MaxFunc(list, func(a, b BlobInfo) int { return strings.Compare(a.Key, b.Key) })
+		offsiteLastMu.Lock()
+		if offsiteLast.LastBackup == nil {
+			offsiteLast.LastBackup, offsiteLast.LastKey, offsiteLast.LastSize = &last.ModTime, strings.TrimPrefix(last.Key, offsitePrefix), last.Size
+		}
+		offsiteLastMu.Unlock()
+	}()
+	return nil
+}
+
+// offsiteKey возвращает ID ключа шифрования внешних снимков.
+func offsiteKey(cfg OffsiteConfig) (string, error) {
+	id := cfg.KeyID
+	if id == "" {
+		id = config.Encryption.KeyID
+	}
+	if pii == nil || pii.aeads[id] == nil {
+		return "", fmt.Errorf("offsiteBackup: нет ключа шифрования %q в encryption.keys", id)
+	}
+	return id, nil
+}
+
+// offsiteBackupJob каждую ночь загружает снимок во внешний бакет.
+var offsiteBackupJob = &scheduledJob{
+	Name:     "offsite-backup",
+	Schedule: "30 3 * * *",
+	Run: func(ctx context.Context) error {
+		_, err := uploadOffsiteBackup(ctx)
+		return err
+	},
+}
+
+// uploadOffsiteBackup загружает снимок и удаляет устаревшие.
+func uploadOffsiteBackup(ctx context.Context) (BlobInfo, error) {
+	info, err := putOffsiteBackup(ctx)
+	now := time.Now()
+	offsiteLastMu.Lock()
+	if err != nil {
+		offsiteLast.LastError, offsiteLast.LastErrorAt = err.Error(), &now
+	} else {
+		offsiteLast = offsiteState{LastBackup: &info.ModTime, LastKey: info.Key, LastSize: info.Size}
+	}
+	offsiteLastMu.Unlock()
+	if err != nil {
+		return info, err
+	}
+	if err := pruneOffsite(ctx, config.OffsiteBackup, now); err != nil {
+		logError("Ошибка удаления старых внешних снимков: %v", err)
+	}
+	return info, nil
+}
+
+func putOffsiteBackup(ctx context.Context) (BlobInfo, error) {
+	keyID, err := offsiteKey(config.OffsiteBackup)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	b := takeBackup()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return BlobInfo{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+	data, err := sealOffsite(keyID, buf.Bytes())
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	name := "backup-" + b.CreatedAt.UTC().Format("20060102-150405.000") + ".json.gz.enc"
+	if err := offsite.Put(ctx, offsitePrefix+name, Blob{Data: data, ContentType: "application/octet-stream"}); err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: name, Size: int64(len(data)), ModTime: b.CreatedAt}, nil
+}
+
+// sealOffsite шифрует сжатый снимок.
+func sealOffsite(keyID string, plain []byte) ([]byte, error) {
+	aead := pii.aeads[keyID]
+	header := []byte(offsiteMagic + keyID + "\n")
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(header)+len(nonce)+len(plain)+aead.Overhead())
+	out = append(append(out, header...), nonce...)
+	return aead.Seal(out, nonce, plain, header), nil
+}
+
+// openOffsiteBackup расшифровывает и разбирает внешний снимок.
+func openOffsiteBackup(data []byte) (Backup, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(offsiteMagic))
+	if !ok {
+		return Backup{}, errors.New("Это не внешний снимок")
+	}
+	keyID, rest, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok {
+		return Backup{}, errors.New("Поврежденный заголовок внешнего снимка")
+	}
+	if pii == nil || pii.aeads[string(keyID)] == nil {
+		return Backup{}, fmt.Errorf("Нет ключа %s для расшифровки снимка", keyID)
+	}
+	aead := pii.aeads[string(keyID)]
+	if len(rest) < aead.NonceSize() {
+		return Backup{}, errors.New("Поврежденный внешний снимок")
+	}
+	header := data[:len(data)-len(rest)]
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return Backup{}, errors.New("Не удалось расшифровать внешний снимок")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return Backup{}, err
+	}
+	return parseBackup(io.LimitReader(zr, maxRestoreSize))
+}
+
+// pruneOffsite удаляет снимки сверх keep и старше maxAge. Последний снимок
+// остается всегда.
+func pruneOffsite(ctx context.Context, cfg OffsiteConfig, now time.Time) error {
+	list, err := offsite.List(ctx, offsitePrefix)
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key > list[j].Key }) // новые первыми
+	for i, info := range list {
+		if i == 0 {
+			continue
+		}
+		tooMany := cfg.Keep > 0 && i >= cfg.Keep
+		tooOld := cfg.MaxAge > 0 && now.Sub(info.ModTime) > time.Duration(cfg.MaxAge)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := offsite.Delete(ctx, info.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offsiteStatus — состояние внешних снимков для /healthz и /debug/vars.
+func offsiteStatus() any {
+	if offsite == nil {
+		return map[string]any{"enabled": false}
+	}
+	offsiteLastMu.Lock()
+	defer offsiteLastMu.Unlock()
+	return struct {
+		Enabled bool `json:"enabled"`
+		offsiteState
+	}{true, offsiteLast}
+}
+
+// probeOffsite падает, если последний внешний снимок старше staleAfter.
+func probeOffsite(context.Context) error {
+	offsiteLastMu.Lock()
+	defer offsiteLastMu.Unlock()
+	stale := time.Duration(config.OffsiteBackup.StaleAfter)
+	switch {
+	case offsiteLast.LastBackup == nil && offsiteLast.LastError != "":
+		return errors.New(offsiteLast.LastError)
+	case offsiteLast.LastBackup == nil:
+		return errors.New("внешних снимков еще нет")
+	case time.Since(*offsiteLast.LastBackup) > stale:
+		return fmt.Errorf("последний внешний снимок старше %s", stale)
+	}
+	return nil
+}
+
+// offsiteBackupsHandler перечисляет внешние снимки (GET /admin/offsite) и
+// загружает новый (POST).
+func offsiteBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := offsite.List(r.Context(), offsitePrefix)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		for i := range list {
+			list[i].Key = strings.TrimPrefix(list[i].Key, offsitePrefix)
+		}
+		if list == nil {
+			list = []BlobInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		info, err := uploadOffsiteBackup(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
+
+// restoreOffsiteBackupHandler восстанавливает хранилище из внешнего
+// снимка: POST /admin/offsite/{name}/restore?mode=replace|merge.
+func restoreOffsiteBackupHandler(w http.ResponseWriter, r *http.Request) {
+	mode, ok := restoreMode(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "Неверное имя снимка", http.StatusBadRequest)
+		return
+	}
+	blob, err := offsite.Get(r.Context(), offsitePrefix+name)
+	if errors.Is(err, errBlobNotFound) {
+		http.Error(w, "Снимок не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	b, err := openOffsiteBackup(blob.Data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRestoreResult(w, b, mode)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// События жизненного цикла клиента, на которые реагирует цепочка писем.
+const (
+	dripEventRegistered = "registered"
+	dripEventFirstOrder = "first_order"
+	dripEventDeleted    = "deleted"
+)
+
+var dripEvents = map[string]bool{
+	dripEventRegistered: true,
+	dripEventFirstOrder: true,
+	dripEventDeleted:    true,
+}
+
+// OnboardingConfig описывает цепочку приветственных сообщений.
+type OnboardingConfig struct {
+	Enabled  bool       `json:"enabled"`
+	Interval Duration   `json:"interval"` // как часто проверять, не пора ли отправить шаг
+	ExitOn   []string   `json:"exitOn"`   // события, завершающие цепочку
+	Steps    []DripStep `json:"steps"`
+}
+
+// DripStep — один шаг цепочки: сообщение отправляется через Delay после
+// события After, если к этому моменту не произошло ни одно из событий SkipIf.
+type DripStep struct {
+	Name    string   `json:"name"`
+	Message string   `json:"message"`
+	After   string   `json:"after"`
+	Delay   Duration `json:"delay"`
+	SkipIf  []string `json:"skipIf"`
+}
+
+func defaultOnboarding() OnboardingConfig {
+	return OnboardingConfig{
+		Interval: Duration(time.Minute),
+		ExitOn:   []string{dripEventDeleted},
+		Steps: []DripStep{
+			{Name: "welcome", Message: "Добро пожаловать, {{name}}!", After: dripEventRegistered},
+			{
+				Name:    "first-discount",
+				Message: "{{name}}, дарим скидку 10% на первый заказ",
+				After:   dripEventRegistered,
+				Delay:   Duration(72 * time.Hour),
+				SkipIf:  []string{dripEventFirstOrder},
+			},
+			{Name: "feedback", Message: "{{name}}, как вам наш кофе?", After: dripEventFirstOrder, Delay: Duration(24 * time.Hour)},
+		},
+	}
+}
+
+// validate проверяет, что шаги ссылаются только на известные события.
+func (c OnboardingConfig) validate() error {
+	for _, e := range c.ExitOn {
+		if !dripEvents[e] {
+			return fmt.Errorf("onboarding.exitOn: неизвестное событие %q", e)
+		}
+	}
+	for _, s := range c.Steps {
+		if s.Name == "" {
+			return fmt.Errorf("onboarding.steps: у шага нет имени")
+		}
+		if !dripEvents[s.After] {
+			return fmt.Errorf("onboarding.steps[%s].after: неизвестное событие %q", s.Name, s.After)
+		}
+		for _, e := range s.SkipIf {
+			if !dripEvents[e] {
+				return fmt.Errorf("onboarding.steps[%s].skipIf: неизвестное событие %q", s.Name, e)
+			}
+		}
+	}
+	return nil
+}
+
+// messageSender доставляет сообщение клиенту; subject — имя шага.
+type messageSender interface {
+	Send(c Client, subject, body string) error
+}
+
+// logSender выводит сообщения в лог, когда отправка писем выключена.
+type logSender struct{}
+
+func (logSender) Send(c Client, subject, body string) error {
+	logf("Сообщение клиенту %d [%s]: %s", c.ID, subject, body)
+	return nil
+}
+
+// dripEnrollment — состояние цепочки для одного клиента.
+type dripEnrollment struct {
+	ClientID int                  `json:"clientId"`
+	Events   map[string]time.Time `json:"events"`
+	Sent     map[string]time.Time `json:"sent"`
+	Skipped  []string             `json:"skipped,omitempty"`
+	Exited   string               `json:"exited,omitempty"`
+}
+
+var (
+	drips      = make(map[int]*dripEnrollment) // Цепочки по ID клиента
+	dripsMu    sync.Mutex                      // Мьютекс для защиты цепочек
+	dripSender messageSender                   = logSender{}
+)
+
+func dripsPath() string {
+	return filepath.Join(config.DataDir, "onboarding.json")
+}
+
+// loadDripsLocked перечитывает цепочки с диска. Вызывается под dripsMu.
+func loadDripsLocked() error {
+	data, err := os.ReadFile(dripsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*dripEnrollment
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("разбор %s: %w", dripsPath(), err)
+	}
+	drips = make(map[int]*dripEnrollment, len(list))
+	for _, e := range list {
+		drips[e.ClientID] = e
+	}
+	return nil
+}
+
+// loadOnboarding читает цепочки при запуске.
+func loadOnboarding() error {
+	dripsMu.Lock()
+	defer dripsMu.Unlock()
+	return loadDripsLocked()
+}
+
+// updateDrips перечитывает цепочки, применяет change и, если он вернул
+// true, сохраняет их. Файл меняют все экземпляры с общим dataDir, а
+// рассылает тот, кто держит аренду onboarding, поэтому цепочки читаются
+// заново под файлом-замком, а не берутся из памяти.
+func updateDrips(change func() bool) error {
+	unlock, err := lockLeaseFile("onboarding-state")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	dripsMu.Lock()
+	defer dripsMu.Unlock()
+	if err := loadDripsLocked(); err != nil {
+		return err
+	}
+	if !change() {
+		return nil
+	}
+	list := slices.Collect(maps.Values(drips))
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return writeJSONFile(dripsPath(), list)
+}
+
+// onboardingOnClientEvent ведет цепочки по событиям клиентов. Клиенты из
+// импорта и возвращенные из архива в цепочку не попадают: это не
+// регистрация.
+func onboardingOnClientEvent(e clientEvent) {
+	switch e.Type {
+	case eventClientCreated:
+		if e.Source != sourceImport && e.Source != sourceRestore {
+			enrollOnboarding(e.Client.ID, e.At)
+		}
+	case eventClientDeleted, eventClientPurged:
+		onboardingEvent(e.Client.ID, dripEventDeleted, e.At)
+	}
+}
+
+// enrollOnboarding запускает цепочку для нового клиента.
+func enrollOnboarding(clientID int, at time.Time) {
+	if !config.Onboarding.Enabled {
+		return
+	}
+	err := updateDrips(func() bool {
+		drips[clientID] = &dripEnrollment{
+			ClientID: clientID,
+			Events:   map[string]time.Time{dripEventRegistered: at},
+			Sent:     make(map[string]time.Time),
+		}
+		return true
+	})
+	if err != nil {
+		logError("Ошибка сохранения цепочки онбординга клиента %d: %v", clientID, err)
+	}
+}
+
+// onboardingEvent фиксирует событие клиента; событие из exitOn завершает цепочку.
+func onboardingEvent(clientID int, event string, at time.Time) {
+	err := updateDrips(func() bool {
+		e, ok := drips[clientID]
+		if !ok || e.Exited != "" {
+			return false
+		}
+		if _, seen := e.Events[event]; !seen {
+			e.Events[event] = at
+		}
+		for _, exit := range config.Onboarding.ExitOn {
+			if exit == event {
+				e.Exited = event
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logError("Ошибка сохранения цепочки онбординга клиента %d: %v", clientID, err)
+	}
+}
+
+type dripDelivery struct {
+	clientID int
+	step     DripStep
+}
+
+// dueDrips возвращает шаги, которые пора отправить, и отмечает пропущенные.
+// Отправленным шаг отмечает markDripSent после успешной отправки.
+func dueDrips(now time.Time) ([]dripDelivery, error) {
+	var due []dripDelivery
+	err := updateDrips(func() bool {
+		due = nil
+		changed := false
+		for id, e := range drips {
+			if e.Exited != "" {
+				continue
+			}
+		steps:
+			for _, s := range config.Onboarding.Steps {
+				if _, sent := e.Sent[s.Name]; sent || slices.Contains(e.Skipped, s.Name) {
+					continue
+				}
+				t, ok := e.Events[s.After]
+				if !ok || now.Before(t.Add(time.Duration(s.Delay))) {
+					continue
+				}
+				for _, skip := range s.SkipIf {
+					if _, happened := e.Events[skip]; happened {
+						e.Skipped = append(e.Skipped, s.Name)
+						changed = true
+						continue steps
+					}
+				}
+				due = append(due, dripDelivery{id, s})
+			}
+		}
+		return changed
+	})
+	return due, err
+}
+
+// markDripSent отмечает шаг отправленным.
+func markDripSent(clientID int, step string, at time.Time) error {
+	return updateDrips(func() bool {
+		e, ok := drips[clientID]
+		if !ok {
+			return false
+		}
+		if e.Sent == nil {
+			e.Sent = make(map[string]time.Time)
+		}
+		e.Sent[step] = at
+		return true
+	})
+}
+
+// runOnboarding периодически отправляет наступившие шаги до отмены ctx.
+func runOnboarding(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(config.Onboarding.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Рассылку за тик выполняет один экземпляр, иначе письма уйдут дважды.
+			err := runExclusive(ctx, "onboarding", func(ctx context.Context) error {
+				return sendDueDrips(now)
+			})
+			if err != nil && !errors.Is(err, errLeaseHeld) {
+				logError("Ошибка рассылки онбординга: %v", err)
+			}
+		}
+	}
+}
+
+// sendDueDrips отправляет шаги, срок которых наступил к now. Шаг, который
+// не удалось отправить, остается неотправленным и повторяется на следующем
+// тике.
+func sendDueDrips(now time.Time) error {
+	due, err := dueDrips(now)
+	if err != nil {
+		return err
+	}
+	for _, d := range due {
+		clientsMu.Lock()
+		c, ok := clients[d.clientID]
+		clientsMu.Unlock()
+		if !ok || c.deleted() {
+			onboardingEvent(d.clientID, dripEventDeleted, now)
+			continue
+		}
+		if err := dripSender.Send(c, d.step.Name, renderDripMessage(d.step.Message, c)); err != nil {
+			logError("Ошибка отправки шага %s клиенту %d: %v", d.step.Name, c.ID, err)
+			continue
+		}
+		if err := markDripSent(d.clientID, d.step.Name, now); err != nil {
+			// Шаг уйдет еще раз: повтор лучше потерянного сообщения.
+			logError("Ошибка сохранения отправки шага %s клиенту %d: %v", d.step.Name, c.ID, err)
+		}
+	}
+	return nil
+}
+
+// renderDripMessage подставляет данные клиента в шаблон сообщения.
+func renderDripMessage(msg string, c Client) string {
+	return strings.NewReplacer(
+		"{{name}}", c.Name,
+		"{{favCoffee}}", c.FavCoffee,
+		"{{city}}", c.Address.City,
+	).Replace(msg)
+}
+
+// onboardingHandler показывает состояние цепочек.
+func onboardingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var list []dripEnrollment
+	err := updateDrips(func() bool {
+		list = make([]dripEnrollment, 0, len(drips))
+		for _, e := range drips {
+			c := *e
+			c.Events = maps.Clone(e.Events)
+			c.Sent = maps.Clone(e.Sent)
+			c.Skipped = slices.Clone(e.Skipped)
+			list = append(list, c)
+		}
+		return false
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
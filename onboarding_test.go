@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testSender запоминает отправленные шаги; пока fail задан, отправка
+// не удается.
+type testSender struct {
+	fail error
+	sent []string
+}
+
+func (s *testSender) Send(c Client, subject, body string) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.sent = append(s.sent, subject)
+	return nil
+}
+
+// TestOnboardingRetriesFailedSend проверяет, что цепочка переживает
+// перезапуск, а шаг, который не удалось отправить, не теряется.
+func TestOnboardingRetriesFailedSend(t *testing.T) {
+	setupTest(t)
+	config.Onboarding = defaultOnboarding()
+	config.Onboarding.Enabled = true
+	sender := &testSender{fail: errors.New("SMTP недоступен")}
+	prev := dripSender
+	dripSender = sender
+	t.Cleanup(func() { dripSender = prev })
+
+	registered := time.Now().Add(-time.Hour)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 1}}
+	enrollOnboarding(1, registered)
+
+	if err := sendDueDrips(registered.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(drips[1].Sent) != 0 {
+		t.Fatalf("шаг отмечен отправленным после сбоя: %v", drips[1].Sent)
+	}
+
+	// Перезапуск: цепочка читается с диска.
+	drips = make(map[int]*dripEnrollment)
+	if err := loadOnboarding(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := drips[1]; !ok {
+		t.Fatal("цепочка потерялась при перезапуске")
+	}
+
+	sender.fail = nil
+	for _, at := range []time.Duration{2 * time.Minute, 3 * time.Minute, 73 * time.Hour} {
+		if err := sendDueDrips(registered.Add(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"welcome", "first-discount"}; !slices.Equal(sender.sent, want) {
+		t.Errorf("отправлено %v, ожидалось %v", sender.sent, want)
+	}
+
+	drips = make(map[int]*dripEnrollment)
+	if err := loadOnboarding(); err != nil {
+		t.Fatal(err)
+	}
+	if len(drips[1].Sent) != 2 {
+		t.Errorf("после перезапуска отправлены %v", drips[1].Sent)
+	}
+}
@@ -0,0 +1,892 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Описание API для OpenAPI 3. Эндпоинты регистрируются через handleAPI:
+// одна запись задает и маршрут, и права, и документацию, поэтому
+// /openapi.json не расходится с обработчиками.
+
+// apiParam — параметр запроса.
+type apiParam struct {
+	Name        string
+	In          string // query, path или header
+	Type        string // string, integer, number или boolean
+	Required    bool
+	Description string
+}
+
+// apiResponse — вариант ответа. Body задает схему значением Go-типа;
+// строка означает text/plain, nil — ответ без тела.
+type apiResponse struct {
+	Status      int
+	Description string
+	Body        any
+	ContentType string // если отличается от выводимого из Body
+}
+
+// apiOperation — эндпоинт API.
+type apiOperation struct {
+	Method  string
+	Path    string // как в ServeMux, параметры пути в фигурных скобках
+	Summary string
+	// Legacy — старый адрес без метода в шаблоне: метод проверяет сам обработчик.
+	Legacy bool
+	// Role — минимальная роль; пустая означает доступ без аутентификации.
+	Role Role
+	// Deployment — только администраторы без привязки к кофейне
+	// (requireDeploymentAdmin); Role при этом RoleAdmin.
+	Deployment bool
+	// Idempotent включает повтор запроса по Idempotency-Key.
+	Idempotent bool
+	// MaxBody — предел тела запроса для Idempotency-Key; 0 — 1 МБ. Должен
+	// быть не меньше предела самого обработчика.
+	MaxBody int64
+	// Negotiated — формат тел выбирается по Accept и Content-Type (codec.go).
+	Negotiated  bool
+	Params      []apiParam
+	Request     any
+	RequestType string // Content-Type тела, по умолчанию application/json
+	Responses   []apiResponse
+}
+
+// apiOperations — зарегистрированные эндпоинты; заполняется в main до
+// запуска сервера.
+var apiOperations []apiOperation
+
+// handleAPI регистрирует обработчик с проверкой прав из op и тела по
+// схеме (schema.go) и добавляет эндпоинт в описание API.
+func handleAPI(op apiOperation, h http.HandlerFunc) {
+	if op.jsonRequest() {
+		h = withSchemaValidation(op, h)
+	}
+	if op.Idempotent {
+		h = withIdempotency(h, op.maxBody())
+	}
+	switch {
+	case op.Deployment:
+		h = requireDeploymentAdmin(h)
+	case op.Role != "":
+		h = requireRole(op.Role, h)
+	}
+	pattern := op.Method + " " + op.Path
+	if op.Legacy {
+		pattern = op.Path
+	}
+	http.HandleFunc(pattern, h)
+	apiOperations = append(apiOperations, op)
+}
+
+// maxBody возвращает предел тела запроса операции.
+func (op apiOperation) maxBody() int64 {
+	if op.MaxBody > 0 {
+		return op.MaxBody
+	}
+	return 1 << 20
+}
+
+var clientFilterParams = []apiParam{
+	{Name: "name", In: "query", Type: "string", Description: "Подстрока имени без учета регистра"},
+	{Name: "city", In: "query", Type: "string", Description: "Город без учета регистра"},
+	{Name: "favCoffee", In: "query", Type: "string", Description: "Любимый кофе; синонимы из справочника допустимы"},
+	{Name: "minAge", In: "query", Type: "integer"},
+	{Name: "maxAge", In: "query", Type: "integer"},
+	{Name: "registeredFrom", In: "query", Type: "string", Description: "ГГГГ-ММ-ДД"},
+	{Name: "registeredTo", In: "query", Type: "string", Description: "ГГГГ-ММ-ДД, включительно"},
+	{Name: "tag", In: "query", Type: "string", Description: "Метка; если повторить параметр, нужны все метки"},
+	includeDeletedParam,
+}
+
+// clientWhereParams — отбор условных операций (clientwhere.go): фильтр
+// списка без includeDeleted.
+var clientWhereParams = append(slices.DeleteFunc(slices.Clone(clientFilterParams), func(p apiParam) bool { return p.Name == includeDeletedParam.Name }),
+	apiParam{Name: "dryRun", In: "query", Type: "boolean", Description: "true — только посчитать затронутых клиентов"})
+
+var includeDeletedParam = apiParam{Name: "includeDeleted", In: "query", Type: "boolean", Description: "Показывать мягко удаленных (только администраторам)"}
+
+// clientShapeParams — форма ответа (fields.go).
+var clientShapeParams = []apiParam{
+	{Name: "fields", In: "query", Type: "string", Description: "Поля клиента через запятую, например id,name,favCoffee; не для XML"},
+	{Name: "expand", In: "query", Type: "string", Description: "Через запятую: notes — заметки, orders — заказы"},
+}
+
+var batchModeParam = apiParam{Name: "mode", In: "query", Type: "string", Description: "atomic (по умолчанию) — все или ничего; partial — каждый элемент отдельно"}
+
+// Часто повторяющиеся ответы.
+var (
+	respBadRequest = apiResponse{Status: http.StatusBadRequest, Description: "Неверный запрос", Body: ""}
+	respNotFound   = apiResponse{Status: http.StatusNotFound, Description: "Клиент не найден", Body: ""}
+	respConflict   = apiResponse{Status: http.StatusConflict, Description: "Конфликт с текущим состоянием", Body: ""}
+	respBatch      = []apiResponse{
+		{Status: http.StatusMultiStatus, Description: "Часть элементов не обработана (mode=partial)", Body: batchResult{}},
+		{Status: http.StatusUnprocessableEntity, Description: "Ни один элемент не обработан", Body: batchResult{}},
+		respBadRequest,
+	}
+)
+
+// clientAPI — эндпоинты клиентов; потоковые регистрируются отдельно, им
+// нужен контекст остановки сервера.
+var clientAPI = []struct {
+	op apiOperation
+	h  http.HandlerFunc
+}{
+	{apiOperation{
+		Method: http.MethodPost, Path: "/addClient", Legacy: true, Role: RoleEditor, Idempotent: true, Negotiated: true,
+		Summary: "Добавить клиента; клиентам кофеен (X-Tenant-ID или кофейня токена) ID назначает сервер", Request: Client{},
+		Responses: []apiResponse{{Status: http.StatusCreated, Description: "Клиент добавлен", Body: Client{}}, respBadRequest, respConflict},
+	}, addClientHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/deleteClient", Legacy: true, Role: RoleAdmin,
+		Summary: "Мягко удалить клиента",
+		Params:  []apiParam{{Name: "id", In: "query", Type: "integer", Required: true}},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиент удален", Body: ""}, respBadRequest, respNotFound,
+		},
+	}, deleteClientHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/getClients", Legacy: true, Role: RoleViewer, Negotiated: true,
+		Summary: "Список клиентов по фильтру, по ID", Params: slices.Concat(clientFilterParams, clientShapeParams),
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиенты по ID", Body: map[string]Client{}},
+			{Status: http.StatusNotModified, Description: "Список не менялся (If-None-Match, If-Modified-Since)"},
+			respBadRequest,
+		},
+	}, getClientsHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}", Role: RoleViewer, Negotiated: true,
+		Summary: "Получить клиента; HEAD — проверить, что клиент есть, без тела ответа",
+		Params:  slices.Concat([]apiParam{includeDeletedParam}, clientShapeParams),
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиент; ETag — для If-Match при изменении", Body: Client{}},
+			{Status: http.StatusNotModified, Description: "Клиент не менялся; с fields и expand не отдается"},
+			respBadRequest, respNotFound,
+			{Status: http.StatusNotAcceptable, Description: "fields или expand в protobuf, fields в XML", Body: ""},
+		},
+	}, getClientHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/count", Role: RoleViewer,
+		Summary: "Число клиентов по фильтру, как у /getClients", Params: clientFilterParams,
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Число клиентов", Body: clientCount{}}, respBadRequest},
+	}, countClientsHandler},
+	{apiOperation{
+		Method: http.MethodPut, Path: "/clients/{id}", Role: RoleEditor, Negotiated: true,
+		Summary: "Заменить данные клиента; с mode=upsert — создать, если его нет",
+		Params: []apiParam{{Name: "If-Match", In: "header", Type: "string",
+			Description: "ETag из GET /clients/{id}; без него нужно поле version в теле"},
+			{Name: "mode", In: "query", Type: "string",
+				Description: "upsert — создать отсутствующего клиента (только в основной кофейне) или заменить существующего без проверки версии"}},
+		Request: Client{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиент изменен", Body: Client{}},
+			{Status: http.StatusCreated, Description: "Клиент создан (mode=upsert)", Body: Client{}},
+			respBadRequest, respNotFound,
+			{Status: http.StatusConflict, Description: "Версия устарела; с mode=upsert — клиент удален или ID занят", Body: ""},
+			{Status: http.StatusPreconditionFailed, Description: "ETag не совпадает", Body: ""},
+			{Status: http.StatusPreconditionRequired, Description: "Нет ни If-Match, ни version", Body: ""},
+		},
+	}, updateClientHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/import", Role: RoleEditor,
+		Summary: "Импорт клиентов из CSV (поле file)",
+		Params: []apiParam{{Name: "delimiter", In: "query", Type: "string",
+			Description: "semicolon, tab или символ; по умолчанию запятая"}},
+		Request: struct {
+			File []byte `json:"file"`
+		}{},
+		RequestType: "multipart/form-data",
+		Responses:   []apiResponse{{Status: http.StatusOK, Description: "Итог импорта", Body: importSummary{}}, respBadRequest},
+	}, importClientsHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/restore", Role: RoleEditor, Negotiated: true,
+		Summary:   "Восстановить мягко удаленного клиента",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Клиент восстановлен", Body: Client{}}, respBadRequest, respNotFound, respConflict},
+	}, restoreClientHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/{id}/purge", Role: RoleAdmin,
+		Summary:   "Окончательно удалить клиента, уже удаленного мягко",
+		Responses: []apiResponse{{Status: http.StatusNoContent, Description: "Клиент удален"}, respBadRequest, respNotFound, respConflict},
+	}, purgeClientHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/tags", Role: RoleEditor, Negotiated: true,
+		Summary: "Добавить метки; метки приводятся к нижнему регистру", Request: tagsRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиент с метками", Body: Client{}}, respBadRequest, respNotFound,
+			{Status: http.StatusUnprocessableEntity, Description: "Слишком много меток", Body: ""},
+		},
+	}, addTagsHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/{id}/tags/{tag}", Role: RoleAdmin, Negotiated: true,
+		Summary:   "Снять метку",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Клиент без метки", Body: Client{}}, respBadRequest, respNotFound},
+	}, removeTagHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/addresses", Role: RoleViewer,
+		Summary:   "Адреса клиента, основной первым",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Адреса", Body: []Address{}}, respBadRequest, respNotFound},
+	}, clientAddressesHandler},
+	{apiOperation{
+		Method: http.MethodPut, Path: "/clients/{id}/addresses/{type}", Role: RoleEditor,
+		Summary: "Добавить или заменить адрес с типом home, work и т. п.; координаты определит геокодер", Request: Address{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Адрес заменен", Body: Address{}},
+			{Status: http.StatusCreated, Description: "Адрес добавлен", Body: Address{}},
+			respBadRequest, respNotFound,
+			{Status: http.StatusUnprocessableEntity, Description: "Слишком много адресов или неверный адрес", Body: ""},
+		},
+	}, putAddressHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/{id}/addresses/{type}", Role: RoleAdmin,
+		Summary:   "Удалить адрес; основным становится следующий",
+		Responses: []apiResponse{{Status: http.StatusNoContent, Description: "Адрес удален"}, respBadRequest, respNotFound},
+	}, deleteAddressHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/nearby", Role: RoleViewer,
+		Summary: "Клиенты с адресом не дальше radiusKm от точки, ближайшие первыми; учитываются адреса с координатами",
+		Params: []apiParam{
+			{Name: "lat", In: "query", Type: "number", Required: true},
+			{Name: "lon", In: "query", Type: "number", Required: true},
+			{Name: "radiusKm", In: "query", Type: "number", Required: true, Description: "До 100 км"},
+		},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Клиенты с расстоянием", Body: []nearbyClient{}}, respBadRequest},
+	}, nearbyHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/notes", Role: RoleViewer,
+		Summary:   "Заметки о клиенте, новые последними",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Заметки", Body: []clientNote{}}, respBadRequest, respNotFound},
+	}, clientNotesHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/notes", Role: RoleEditor, Idempotent: true,
+		Summary: "Добавить заметку; автора и время задает сервер", Request: noteRequest{},
+		Responses: []apiResponse{{Status: http.StatusCreated, Description: "Заметка добавлена", Body: clientNote{}}, respBadRequest, respNotFound},
+	}, addNoteHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/visit", Role: RoleEditor, Idempotent: true,
+		Summary:   "Отметить визит без заказа; новый заказ отмечает визит сам",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Время последнего визита", Body: clientVisit{}}, respBadRequest, respNotFound},
+	}, visitHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/inactive", Role: RoleViewer,
+		Summary:   "Клиенты без визитов за days дней, дольше всех отсутствующие первыми; без визитов — со дня регистрации",
+		Params:    []apiParam{{Name: "days", In: "query", Type: "integer", Description: "От 1 до 3650, по умолчанию 30"}},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Неактивные клиенты", Body: []inactiveClient{}}, respBadRequest},
+	}, inactiveClientsHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/duplicates", Role: RoleEditor,
+		Summary: "Возможные дубли: клиенты из одного города с похожими именами, самые похожие первыми",
+		Params: []apiParam{{Name: "minScore", In: "query", Type: "number",
+			Description: "Сходство имен от 0 до 1, по умолчанию 0.8"}},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Пары клиентов", Body: []duplicatePair{}}, respBadRequest},
+	}, duplicatesHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/merge", Role: RoleAdmin, Idempotent: true,
+		Summary: "Слить sourceId в targetId: заказы и баллы переходят к target, source удаляется; запись — в /admin/merges",
+		Request: mergeRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиенты слиты", Body: mergeResult{}},
+			respBadRequest, respNotFound,
+		},
+	}, mergeClientsHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/export", Role: RoleAdmin,
+		Summary: "Все данные о клиенте: карточка, заказы, баллы, онбординг, запросы из журнала",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Данные клиента", Body: clientDataExport{}},
+			respBadRequest, respNotFound,
+		},
+	}, clientDataExportHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/{id}/gdpr", Role: RoleAdmin,
+		Summary: "Необратимо обезличить клиента; запись об этом — в /admin/erasures",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Клиент обезличен", Body: erasureCertificate{}},
+			respBadRequest, respNotFound,
+			{Status: http.StatusConflict, Description: "Клиент уже обезличен", Body: ""},
+		},
+	}, eraseClientHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/avatar", Role: RoleEditor,
+		Summary: "Загрузить аватар (поле file: JPEG, PNG или GIF до 5 МБ); хранится миниатюра 256×256",
+		Request: struct {
+			File []byte `json:"file"`
+		}{},
+		RequestType: "multipart/form-data",
+		Responses: []apiResponse{
+			{Status: http.StatusNoContent, Description: "Аватар сохранен; Location — его адрес"},
+			respBadRequest, respNotFound,
+			{Status: http.StatusRequestEntityTooLarge, Description: "Файл больше 5 МБ", Body: ""},
+			{Status: http.StatusUnsupportedMediaType, Description: "Не изображение JPEG, PNG или GIF", Body: ""},
+		},
+	}, uploadAvatarHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/avatar", Role: RoleViewer,
+		Summary: "Аватар клиента",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Миниатюра JPEG или PNG", Body: []byte{}, ContentType: "image/*"},
+			{Status: http.StatusNotModified, Description: "Аватар не менялся"},
+			respBadRequest, respNotFound,
+		},
+	}, getAvatarHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/batch", Role: RoleEditor, Idempotent: true, MaxBody: maxImportSize,
+		Summary: "Добавить клиентов пакетом", Params: []apiParam{batchModeParam}, Request: []Client{},
+		Responses: append([]apiResponse{{Status: http.StatusCreated, Description: "Все клиенты добавлены", Body: batchResult{}}}, respBatch...),
+	}, batchCreateHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/batch", Role: RoleAdmin,
+		Summary: "Мягко удалить клиентов пакетом", Params: []apiParam{batchModeParam}, Request: []int{},
+		Responses: append([]apiResponse{{Status: http.StatusOK, Description: "Все клиенты удалены", Body: batchResult{}}}, respBatch...),
+	}, batchDeleteHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/updateWhere", Role: RoleEditor, Idempotent: true,
+		Summary: "Изменить всех клиентов, подходящих под фильтр списка; все или никто",
+		Params:  clientWhereParams, Request: whereUpdate{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Затронутые клиенты", Body: whereResult{}}, respBadRequest,
+			{Status: http.StatusUnprocessableEntity, Description: "Изменение недопустимо хотя бы для одного клиента; никто не изменен", Body: ""},
+		},
+	}, updateWhereHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/clients/where", Role: RoleAdmin,
+		Summary:   "Мягко удалить всех клиентов, подходящих под фильтр списка; нужно хотя бы одно условие",
+		Params:    clientWhereParams,
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Затронутые клиенты", Body: whereResult{}}, respBadRequest},
+	}, deleteWhereHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/birthdates", Role: RoleEditor, Idempotent: true, MaxBody: maxImportSize,
+		Summary: "Задать даты рождения пакетом — перевод старых записей с age на birthDate",
+		Params:  []apiParam{batchModeParam}, Request: []birthDateItem{},
+		Responses: append([]apiResponse{{Status: http.StatusOK, Description: "Все даты заданы", Body: batchResult{}}}, respBatch...),
+	}, birthDatesHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/export", Role: RoleViewer,
+		Summary: "Выгрузка клиентов в CSV или XLSX",
+		Params:  append([]apiParam{{Name: "format", In: "query", Type: "string", Description: "csv (по умолчанию) или xlsx"}}, clientFilterParams...),
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Файл выгрузки", Body: "", ContentType: "text/csv"},
+			respBadRequest,
+		},
+	}, exportClientsHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/export", Role: RoleViewer,
+		Summary: "Заказать выгрузку клиентов в фоне; Location — адрес ее состояния",
+		Params:  append([]apiParam{{Name: "format", In: "query", Type: "string", Description: "csv (по умолчанию) или xlsx"}}, clientFilterParams...),
+		Responses: []apiResponse{
+			{Status: http.StatusAccepted, Description: "Выгрузка поставлена в очередь", Body: exportJob{}},
+			respBadRequest,
+			{Status: http.StatusServiceUnavailable, Description: "Очередь задач переполнена", Body: ""},
+		},
+	}, startExportHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/exports/{id}", Role: RoleViewer,
+		Summary: "Состояние фоновой выгрузки: pending, done или failed",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Выгрузка", Body: exportJob{}},
+			respNotFound,
+		},
+	}, exportJobHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/exports/{id}/file", Role: RoleViewer,
+		Summary: "Файл готовой выгрузки; хранится сутки",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Файл выгрузки", Body: "", ContentType: "text/csv"},
+			respNotFound,
+			{Status: http.StatusConflict, Description: "Выгрузка еще не готова", Body: ""},
+		},
+	}, exportFileHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/stats", Role: RoleViewer,
+		Summary: "Сводка по клиентам: число, средний возраст, регистрации по месяцам, топ-10 любимых кофе, города",
+		Params:  clientFilterParams,
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Сводка по клиентам, подходящим под фильтр", Body: clientStats{}},
+			{Status: http.StatusNotModified, Description: "Клиенты не менялись"},
+			respBadRequest,
+		},
+	}, statsHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/graphql", Legacy: true, Role: RoleViewer,
+		Summary: "GraphQL; GET /graphql?query= — только чтение, схема в описании graphql.go",
+		Request: struct {
+			Query         string         `json:"query"`
+			Variables     map[string]any `json:"variables,omitempty"`
+			OperationName string         `json:"operationName,omitempty"`
+		}{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Данные и ошибки полей", Body: gqlResponse{}},
+			{Status: http.StatusBadRequest, Description: "Ошибка разбора запроса", Body: gqlResponse{}},
+		},
+	}, graphqlHandler},
+}
+
+var respOrderNotFound = apiResponse{Status: http.StatusNotFound, Description: "Заказ не найден", Body: ""}
+
+// orderAPI — эндпоинты заказов (orders.go) и баллов лояльности (loyalty.go).
+var orderAPI = []struct {
+	op apiOperation
+	h  http.HandlerFunc
+}{
+	{apiOperation{
+		Method: http.MethodPost, Path: "/orders", Role: RoleEditor, Idempotent: true,
+		Summary: "Добавить заказ; id, total, createdAt и статус new назначает сервер", Request: Order{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "Заказ добавлен", Body: Order{}}, respBadRequest,
+			{Status: http.StatusUnprocessableEntity, Description: "Клиент не найден", Body: ""},
+		},
+	}, createOrderHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/orders", Role: RoleViewer,
+		Summary: "Список заказов",
+		Params: []apiParam{
+			{Name: "status", In: "query", Type: "string", Description: "new, preparing, ready, completed или cancelled"},
+			{Name: "clientId", In: "query", Type: "integer"},
+		},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Заказы по возрастанию ID", Body: []Order{}}, respBadRequest},
+	}, listOrdersHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/orders/{id}", Role: RoleViewer,
+		Summary:   "Получить заказ",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Заказ", Body: Order{}}, respBadRequest, respOrderNotFound},
+	}, getOrderHandler},
+	{apiOperation{
+		Method: http.MethodPut, Path: "/orders/{id}", Role: RoleEditor,
+		Summary: "Изменить заказ; статус меняется только вперед: new → preparing → ready → completed, или на cancelled",
+		Request: Order{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "Заказ изменен", Body: Order{}}, respBadRequest, respOrderNotFound,
+			{Status: http.StatusConflict, Description: "Версия устарела, недопустимая смена статуса или заказ завершен", Body: ""},
+			{Status: http.StatusPreconditionRequired, Description: "Нет поля version", Body: ""},
+			{Status: http.StatusUnprocessableEntity, Description: "Клиент не найден", Body: ""},
+		},
+	}, updateOrderHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/orders/{id}", Role: RoleAdmin,
+		Summary:   "Удалить заказ",
+		Responses: []apiResponse{{Status: http.StatusNoContent, Description: "Заказ удален"}, respBadRequest, respOrderNotFound},
+	}, deleteOrderHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/orders", Role: RoleViewer,
+		Summary:   "Заказы клиента",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Заказы по возрастанию ID", Body: []Order{}}, respBadRequest, respNotFound},
+	}, clientOrdersHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/clients/{id}/loyalty", Role: RoleViewer,
+		Summary:   "Баланс баллов лояльности и история операций",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Баланс и операции, новые последними", Body: loyaltyAccount{}}, respBadRequest, respNotFound},
+	}, loyaltyHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/loyalty/accrue", Role: RoleEditor, Idempotent: true,
+		Summary: "Начислить баллы вручную; за выполненные заказы баллы начисляются сами (loyalty.percent)",
+		Request: loyaltyRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "Баланс и новая операция", Body: loyaltyAccount{}}, respBadRequest, respNotFound,
+		},
+	}, accrueLoyaltyHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/clients/{id}/loyalty/redeem", Role: RoleEditor, Idempotent: true,
+		Summary: "Списать баллы", Request: loyaltyRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "Баланс и новая операция", Body: loyaltyAccount{}}, respBadRequest, respNotFound,
+			{Status: http.StatusConflict, Description: "Недостаточно баллов", Body: ""},
+			{Status: http.StatusUnprocessableEntity, Description: "Заказ не найден у клиента", Body: ""},
+		},
+	}, redeemLoyaltyHandler},
+}
+
+var respMenuNotFound = apiResponse{Status: http.StatusNotFound, Description: "Позиции нет в меню", Body: ""}
+
+// menuAPI — эндпоинты меню (menu.go). Название в пути можно указать
+// синонимом из справочника кофе. Меню общее для всех кофеен, поэтому
+// менять его могут только администраторы всего развертывания.
+var menuAPI = []struct {
+	op apiOperation
+	h  http.HandlerFunc
+}{
+	{apiOperation{
+		Method: http.MethodGet, Path: "/menu",
+		Summary:   "Меню по алфавиту",
+		Params:    []apiParam{{Name: "available", In: "query", Type: "boolean", Description: "true — только доступные позиции"}},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Позиции меню", Body: []MenuItem{}}},
+	}, listMenuHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/menu", Role: RoleAdmin, Deployment: true,
+		Summary: "Добавить позицию; название приводится к каноническому. Пока меню пусто, favCoffee клиентов не проверяется",
+		Request: MenuItem{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "Позиция добавлена", Body: MenuItem{}}, respBadRequest,
+			{Status: http.StatusConflict, Description: "Позиция уже есть в меню", Body: ""},
+		},
+	}, createMenuItemHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/menu/{name}",
+		Summary:   "Позиция меню",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Позиция", Body: MenuItem{}}, respMenuNotFound},
+	}, getMenuItemHandler},
+	{apiOperation{
+		Method: http.MethodPut, Path: "/menu/{name}", Role: RoleAdmin, Deployment: true,
+		Summary: "Изменить цену и доступность", Request: MenuItem{},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Позиция изменена", Body: MenuItem{}}, respBadRequest, respMenuNotFound},
+	}, updateMenuItemHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/menu/{name}", Role: RoleAdmin, Deployment: true,
+		Summary:   "Убрать позицию; у клиентов любимый кофе сохраняется",
+		Responses: []apiResponse{{Status: http.StatusNoContent, Description: "Позиция убрана"}, respMenuNotFound},
+	}, deleteMenuItemHandler},
+}
+
+var respSegmentNotFound = apiResponse{Status: http.StatusNotFound, Description: "Сегмент не найден", Body: ""}
+
+// segmentAPI — сегменты клиентов по правилам (segments.go).
+var segmentAPI = []struct {
+	op apiOperation
+	h  http.HandlerFunc
+}{
+	{apiOperation{
+		Method: http.MethodGet, Path: "/segments", Role: RoleViewer,
+		Summary:   "Сегменты кофейни",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Сегменты по возрастанию ID", Body: []Segment{}}},
+	}, listSegmentsHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/segments", Role: RoleAdmin,
+		Summary: "Добавить сегмент; правила — поле, оператор и значение, см. segments.go",
+		Request: segmentRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "Сегмент добавлен", Body: Segment{}}, respBadRequest,
+		},
+	}, createSegmentHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/segments/{id}", Role: RoleViewer,
+		Summary:   "Сегмент и итог последнего расчета",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Сегмент", Body: Segment{}}, respBadRequest, respSegmentNotFound},
+	}, getSegmentHandler},
+	{apiOperation{
+		Method: http.MethodPut, Path: "/segments/{id}", Role: RoleAdmin,
+		Summary: "Заменить название и правила; итог расчета сбрасывается", Request: segmentRequest{},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Сегмент изменен", Body: Segment{}}, respBadRequest, respSegmentNotFound},
+	}, updateSegmentHandler},
+	{apiOperation{
+		Method: http.MethodDelete, Path: "/segments/{id}", Role: RoleAdmin,
+		Summary:   "Удалить сегмент",
+		Responses: []apiResponse{{Status: http.StatusNoContent, Description: "Сегмент удален"}, respBadRequest, respSegmentNotFound},
+	}, deleteSegmentHandler},
+	{apiOperation{
+		Method: http.MethodPost, Path: "/segments/{id}/evaluate", Role: RoleEditor,
+		Summary:   "Пересчитать сегмент; все сегменты пересчитывает задача segments",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Сегмент с новым итогом", Body: Segment{}}, respBadRequest, respSegmentNotFound},
+	}, evaluateSegmentHandler},
+	{apiOperation{
+		Method: http.MethodGet, Path: "/segments/{id}/clients", Role: RoleViewer,
+		Summary:   "Клиенты сегмента по последнему расчету; не считавшийся сегмент считается сразу",
+		Params:    []apiParam{{Name: "refresh", In: "query", Type: "boolean", Description: "true — сначала пересчитать"}},
+		Responses: []apiResponse{{Status: http.StatusOK, Description: "Клиенты сегмента", Body: segmentClients{}}, respBadRequest, respSegmentNotFound},
+	}, segmentClientsHandler},
+}
+
+var loginOperation = apiOperation{
+	Method: http.MethodPost, Path: "/auth/login", Legacy: true,
+	Summary: "Получить JWT по логину и паролю",
+	Request: struct {
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		OTP          string `json:"otp,omitempty"`
+		RecoveryCode string `json:"recoveryCode,omitempty"`
+	}{},
+	Responses: []apiResponse{
+		{Status: http.StatusOK, Description: "Токен", Body: struct {
+			Token     string    `json:"token"`
+			TokenType string    `json:"tokenType"`
+			ExpiresAt time.Time `json:"expiresAt"`
+			Scope     string    `json:"scope,omitempty"`
+		}{}},
+		{Status: http.StatusUnauthorized, Description: "Неверный пароль или нужен код 2FA (otp_required, otp_invalid)", Body: apiError{}},
+		{Status: http.StatusTooManyRequests, Description: "Вход заблокирован после серии неверных кодов 2FA (otp_locked) или превышен лимит запросов; Retry-After", Body: apiError{}},
+	},
+}
+
+var clientEventsOperation = apiOperation{
+	Method: http.MethodGet, Path: "/clients/events", Role: RoleViewer,
+	Summary: "Поток изменений клиентов (Server-Sent Events)",
+	Params: []apiParam{
+		{Name: "Last-Event-ID", In: "header", Type: "string", Description: "Продолжить после события"},
+		{Name: "lastEventId", In: "query", Type: "string", Description: "То же для EventSource без заголовков"},
+	},
+	Responses: []apiResponse{{Status: http.StatusOK, Description: "События client.*; reset — загрузить список заново", Body: "", ContentType: "text/event-stream"}},
+}
+
+var liveClientsOperation = apiOperation{
+	Method: http.MethodGet, Path: "/ws", Role: RoleViewer,
+	Summary: "Живой список клиентов по WebSocket: snapshot, затем изменения",
+	Responses: []apiResponse{
+		{Status: http.StatusSwitchingProtocols, Description: "Соединение WebSocket"},
+		respBadRequest,
+	},
+}
+
+// Построение документа
+
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument собирает описание из зарегистрированных эндпоинтов.
+func openAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		item := paths[op.Path]
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = op.document(schemas)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Coffeemen birge API",
+			"version":     "1",
+			"description": "Клиенты кофейни. Ошибки без тела JSON приходят текстом; язык сообщений — из Accept-Language или ?lang=. Кофейня берется из токена или ключа; администратор без привязки выбирает ее заголовком X-Tenant-ID.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+func (op apiOperation) document(schemas map[string]any) map[string]any {
+	doc := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+
+	var params []map[string]any
+	for _, m := range pathParamRe.FindAllStringSubmatch(op.Path, -1) {
+		typ := "integer" // {id}; остальные параметры пути — строки
+		if m[1] != "id" {
+			typ = "string"
+		}
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": typ}})
+	}
+	inputs := slices.Clip(op.Params)
+	if op.Idempotent {
+		inputs = append(inputs, apiParam{Name: idempotencyHeader, In: "header", Type: "string",
+			Description: "Повтор с тем же ключом вернет сохраненный ответ"})
+	}
+	for _, p := range inputs {
+		param := map[string]any{"name": p.Name, "in": p.In, "schema": map[string]any{"type": p.Type}}
+		if p.Required {
+			param["required"] = true
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if params != nil {
+		doc["parameters"] = params
+	}
+
+	if op.Request != nil {
+		ct := op.RequestType
+		if ct == "" {
+			ct = "application/json"
+		}
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content":  op.content(ct, schemaFor(reflect.TypeOf(op.Request), schemas)),
+		}
+	}
+
+	responses := map[string]any{}
+	outcomes := slices.Clip(op.Responses)
+	if op.Role != "" {
+		forbidden := "Роль ниже " + string(op.Role)
+		if op.Deployment {
+			forbidden += " или администратор привязан к кофейне"
+		}
+		doc["security"] = []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+		outcomes = append(outcomes,
+			apiResponse{Status: http.StatusUnauthorized, Description: "Нет или неверный JWT/API-ключ", Body: ""},
+			apiResponse{Status: http.StatusForbidden, Description: forbidden, Body: apiError{}})
+	}
+	if op.Legacy {
+		outcomes = append(outcomes, apiResponse{Status: http.StatusMethodNotAllowed, Description: "Неверный метод запроса", Body: ""})
+	}
+	if op.Negotiated {
+		outcomes = append(outcomes,
+			apiResponse{Status: http.StatusNotAcceptable, Description: "Ни один формат из Accept не поддерживается", Body: ""},
+			apiResponse{Status: http.StatusUnsupportedMediaType, Description: "Неподдерживаемый Content-Type", Body: ""})
+	}
+	if op.Idempotent {
+		outcomes = append(outcomes, apiResponse{Status: http.StatusRequestEntityTooLarge,
+			Description: fmt.Sprintf("Тело запроса больше %d МБ", op.maxBody()>>20), Body: ""})
+	}
+	outcomes = append(outcomes, apiResponse{Status: http.StatusTooManyRequests, Description: "Превышен лимит запросов", Body: ""})
+	for _, r := range outcomes {
+		key := fmt.Sprint(r.Status)
+		if _, dup := responses[key]; dup {
+			continue
+		}
+		resp := map[string]any{"description": r.Description}
+		if r.Body != nil {
+			ct := r.ContentType
+			switch {
+			case ct != "":
+			case reflect.TypeOf(r.Body).Kind() == reflect.String:
+				ct = "text/plain"
+			default:
+				ct = "application/json"
+			}
+			resp["content"] = op.content(ct, schemaFor(reflect.TypeOf(r.Body), schemas))
+		}
+		responses[key] = resp
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+// content описывает тело; у эндпоинтов с выбором формата JSON-схема
+// относится ко всем форматам из codecs.
+func (op apiOperation) content(ct string, schema map[string]any) map[string]any {
+	if !op.Negotiated || ct != jsonCodec.ContentType {
+		return map[string]any{ct: map[string]any{"schema": schema}}
+	}
+	content := map[string]any{}
+	for _, c := range codecs {
+		content[c.ContentType] = map[string]any{"schema": schema}
+	}
+	return content
+}
+
+// operationID строит идентификатор для генераторов клиентов: getClientsId.
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor описывает Go-тип схемой JSON так же, как его кодирует
+// encoding/json. Именованные структуры выносятся в components.schemas.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		s := schemaFor(t.Elem(), schemas)
+		if ref, ok := s["$ref"]; ok {
+			return map[string]any{"allOf": []any{map[string]any{"$ref": ref}}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "binary"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return structSchema(t, schemas)
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = map[string]any{} // заглушка на случай рекурсии
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // any
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+// openAPIHandler отдает описание API: GET /openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument())
+}
+
+// docsPage — Swagger UI поверх /openapi.json; скрипты берутся с CDN, как
+// Bootstrap на главной странице.
+const docsPage = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Coffeemen birge API</title>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+      SwaggerUIBundle({ url: '/openapi.json', dom_id: '#swagger-ui', persistAuthorization: true });
+    </script>
+</body>
+</html>
+`
+
+// docsHandler отдает Swagger UI: GET /docs.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, docsPage)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OrderStatus — этап выполнения заказа.
+type OrderStatus string
+
+// Статусы заказа. Заказ проходит их по порядку; отменить можно любой
+// незавершенный заказ.
+const (
+	OrderNew       OrderStatus = "new"
+	OrderPreparing OrderStatus = "preparing"
+	OrderReady     OrderStatus = "ready"
+	OrderCompleted OrderStatus = "completed"
+	OrderCancelled OrderStatus = "cancelled"
+)
+
+// orderTransitions — допустимые смены статуса.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderNew:       {OrderPreparing, OrderCancelled},
+	OrderPreparing: {OrderReady, OrderCancelled},
+	OrderReady:     {OrderCompleted, OrderCancelled},
+}
+
+// Valid сообщает, что статус известен.
+func (s OrderStatus) Valid() bool {
+	_, ok := orderTransitions[s]
+	return ok || s == OrderCompleted || s == OrderCancelled
+}
+
+// OrderItem — позиция заказа.
+type OrderItem struct {
+	Name     string `json:"name" xml:"name"`
+	Quantity int    `json:"quantity" xml:"quantity"`
+	Price    int    `json:"price" xml:"price"` // за единицу, в минимальных единицах валюты
+}
+
+// Order — заказ клиента.
+type Order struct {
+	ID        int         `json:"id" xml:"id"`
+	ClientID  int         `json:"clientId" xml:"clientId"`
+	Items     []OrderItem `json:"items" xml:"items>item"`
+	Total     int         `json:"total" xml:"total"` // считается сервером по позициям
+	CreatedAt time.Time   `json:"createdAt" xml:"createdAt"`
+	Status    OrderStatus `json:"status" xml:"status"`
+
+	// Version защищает от потерянных обновлений, как у клиента.
+	Version int `json:"version" xml:"version"`
+}
+
+// maxOrderItems ограничивает число позиций в заказе.
+const maxOrderItems = 100
+
+var (
+	orders      = make(map[int]Order) // Хранилище заказов
+	ordersMu    sync.Mutex            // Мьютекс для защиты заказов; берется после clientsMu
+	nextOrderID = 1                   // ID следующего заказа
+)
+
+// ordersState — сохраняемое состояние заказов. NextID хранится отдельно:
+// после удаления последних заказов их ID не должны достаться новым, иначе
+// операции с баллами сослались бы на чужой заказ.
+type ordersState struct {
+	NextID int     `json:"nextId"`
+	Orders []Order `json:"orders"`
+}
+
+func ordersPath() string {
+	return filepath.Join(config.DataDir, "orders.json")
+}
+
+// loadOrders читает заказы и ID следующего заказа.
+func loadOrders() error {
+	data, err := os.ReadFile(ordersPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state ordersState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("разбор %s: %w", ordersPath(), err)
+	}
+
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	orders = make(map[int]Order, len(state.Orders))
+	nextOrderID = max(state.NextID, 1)
+	for _, o := range state.Orders {
+		orders[o.ID] = o
+		nextOrderID = max(nextOrderID, o.ID+1)
+	}
+	return nil
+}
+
+// saveOrdersLocked записывает заказы на диск. Вызывается под ordersMu.
+func saveOrdersLocked() error {
+	state := ordersState{NextID: nextOrderID, Orders: make([]Order, 0, len(orders))}
+	for _, o := range orders {
+		state.Orders = append(state.Orders, o)
+	}
+	slices.SortFunc(state.Orders, func(a, b Order) int { return a.ID - b.ID })
+	return writeJSONFile(ordersPath(), state)
+}
+
+// validateOrder проверяет позиции заказа и считает итог.
+func validateOrder(o *Order) error {
+	if len(o.Items) == 0 {
+		return errors.New("В заказе нет позиций")
+	}
+	if len(o.Items) > maxOrderItems {
+		return fmt.Errorf("В заказе больше %d позиций", maxOrderItems)
+	}
+	o.Total = 0
+	for i, it := range o.Items {
+		if strings.TrimSpace(it.Name) == "" {
+			return fmt.Errorf("Позиция %d: не указано название", i+1)
+		}
+		if it.Quantity < 1 || it.Quantity > 1000 {
+			return fmt.Errorf("Позиция %d: количество должно быть от 1 до 1000", i+1)
+		}
+		if it.Price < 0 || it.Price > 100_000_000 {
+			return fmt.Errorf("Позиция %d: неверная цена", i+1)
+		}
+		o.Total += it.Quantity * it.Price
+	}
+	return nil
+}
+
+// orderClientLocked проверяет, что заказ ссылается на существующего
+// неудаленного клиента кофейни tenant. Вызывается под clientsMu.
+func orderClientLocked(tenant string, clientID int) error {
+	if c, exists := tenantClientLocked(tenant, clientID); !exists || c.deleted() {
+		return fmt.Errorf("Клиент с ID %d не найден", clientID)
+	}
+	return nil
+}
+
+// tenantOrderLocked возвращает заказ, если его клиент из кофейни tenant:
+// своей кофейни у заказа нет. Вызывается под clientsMu и ordersMu.
+func tenantOrderLocked(tenant string, id int) (Order, bool) {
+	o, exists := orders[id]
+	if !exists || clients[o.ClientID].Tenant != tenant {
+		return Order{}, false
+	}
+	return o, true
+}
+
+// decodeOrder читает заказ из тела запроса.
+func decodeOrder(w http.ResponseWriter, r *http.Request) (Order, bool) {
+	var o Order
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&o); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return o, false
+	}
+	if err := validateOrder(&o); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return o, false
+	}
+	return o, true
+}
+
+func writeOrderJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// createOrderHandler добавляет заказ: POST /orders. ID, время и статус new
+// назначает сервер.
+func createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeOrder(w, r)
+	if !ok {
+		return
+	}
+
+	// clientsMu держится до записи заказа, чтобы клиента не удалили между
+	// проверкой и сохранением.
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if err := orderClientLocked(requestTenant(r), o.ClientID); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	ordersMu.Lock()
+	o.ID = nextOrderID
+	nextOrderID++
+	o.CreatedAt = time.Now()
+	o.Status = OrderNew
+	o.Version = 1
+	orders[o.ID] = o
+	if err := saveOrdersLocked(); err != nil {
+		// Без записи на диск заказ потерялся бы при перезапуске.
+		delete(orders, o.ID)
+		nextOrderID--
+		ordersMu.Unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ordersMu.Unlock()
+
+	activityOnOrderCreated(o)
+	onboardingEvent(o.ClientID, dripEventFirstOrder, o.CreatedAt)
+	w.Header().Set("Location", "/orders/"+strconv.Itoa(o.ID))
+	writeOrderJSON(w, http.StatusCreated, o)
+}
+
+// listOrdersHandler возвращает заказы по фильтру ?status= и ?clientId=,
+// от старых к новым.
+func listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := OrderStatus(q.Get("status"))
+	if status != "" && !status.Valid() {
+		http.Error(w, fmt.Sprintf("Неизвестный статус заказа %q", status), http.StatusBadRequest)
+		return
+	}
+	clientID := 0
+	if v := q.Get("clientId"); v != "" {
+		var err error
+		if clientID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "clientId: ожидается число", http.StatusBadRequest)
+			return
+		}
+	}
+	tenant := requestTenant(r)
+	clientsMu.Lock()
+	list := findOrders(func(o Order) bool {
+		return (status == "" || o.Status == status) && (clientID == 0 || o.ClientID == clientID) &&
+			clients[o.ClientID].Tenant == tenant
+	})
+	clientsMu.Unlock()
+	writeOrderJSON(w, http.StatusOK, list)
+}
+
+// findOrders возвращает подходящие заказы по возрастанию ID.
+func findOrders(match func(Order) bool) []Order {
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	list := []Order{}
+	for _, o := range orders {
+		if match(o) {
+			list = append(list, o)
+		}
+	}
+	slices.SortFunc(list, func(a, b Order) int { return a.ID - b.ID })
+	return list
+}
+
+// clientOrdersHandler возвращает заказы клиента: GET /clients/{id}/orders.
+func clientOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	if !activeClient(requestTenant(r), id) {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	writeOrderJSON(w, http.StatusOK, findOrders(func(o Order) bool { return o.ClientID == id }))
+}
+
+// orderID читает ID заказа из пути; при ошибке отвечает 400.
+func orderID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID заказа", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// getOrderHandler возвращает заказ: GET /orders/{id}.
+func getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	clientsMu.Lock()
+	ordersMu.Lock()
+	o, exists := tenantOrderLocked(requestTenant(r), id)
+	ordersMu.Unlock()
+	clientsMu.Unlock()
+	if !exists {
+		http.Error(w, "Заказ не найден", http.StatusNotFound)
+		return
+	}
+	writeOrderJSON(w, http.StatusOK, o)
+}
+
+// updateOrderHandler заменяет позиции, клиента и статус заказа:
+// PUT /orders/{id}. Поле version должно совпадать с текущей версией.
+func updateOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	upd, ok := decodeOrder(w, r)
+	if !ok {
+		return
+	}
+	if upd.ID != 0 && upd.ID != id {
+		http.Error(w, "ID в теле не совпадает с ID в адресе", http.StatusBadRequest)
+		return
+	}
+	if upd.Version == 0 {
+		http.Error(w, "Требуется поле version", http.StatusPreconditionRequired)
+		return
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+
+	cur, exists := tenantOrderLocked(requestTenant(r), id)
+	if !exists {
+		http.Error(w, "Заказ не найден", http.StatusNotFound)
+		return
+	}
+	if upd.Version != cur.Version {
+		http.Error(w, fmt.Sprintf("Версия заказа устарела: текущая %d, передана %d", cur.Version, upd.Version), http.StatusConflict)
+		return
+	}
+	if len(orderTransitions[cur.Status]) == 0 {
+		http.Error(w, "Завершенный или отмененный заказ нельзя изменить", http.StatusConflict)
+		return
+	}
+	if upd.Status == "" {
+		upd.Status = cur.Status
+	}
+	if upd.Status != cur.Status && !slices.Contains(orderTransitions[cur.Status], upd.Status) {
+		http.Error(w, fmt.Sprintf("Нельзя сменить статус заказа с %s на %s", cur.Status, upd.Status), http.StatusConflict)
+		return
+	}
+	if upd.ClientID != cur.ClientID {
+		if err := orderClientLocked(requestTenant(r), upd.ClientID); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	upd.ID = id
+	upd.CreatedAt = cur.CreatedAt
+	upd.Version = cur.Version + 1
+	orders[id] = upd
+	if err := saveOrdersLocked(); err != nil {
+		orders[id] = cur
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if upd.Status == OrderCompleted {
+		loyaltyOnOrderCompleted(upd)
+	}
+	writeOrderJSON(w, http.StatusOK, upd)
+}
+
+// deleteOrderHandler удаляет заказ: DELETE /orders/{id}.
+func deleteOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	cur, exists := tenantOrderLocked(requestTenant(r), id)
+	if !exists {
+		http.Error(w, "Заказ не найден", http.StatusNotFound)
+		return
+	}
+	delete(orders, id)
+	if err := saveOrdersLocked(); err != nil {
+		orders[id] = cur
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ordersOnClientEvent удаляет заказы окончательно удаленного клиента,
+// чтобы они не ссылались на несуществующего клиента.
+func ordersOnClientEvent(e clientEvent) {
+	if e.Type != eventClientPurged {
+		return
+	}
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	removed := 0
+	for id, o := range orders {
+		if o.ClientID == e.Client.ID {
+			delete(orders, id)
+			removed++
+		}
+	}
+	if removed > 0 {
+		if err := saveOrdersLocked(); err != nil {
+			logError("Ошибка сохранения заказов: %v", err)
+		}
+	}
+}
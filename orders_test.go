@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// testOrdersMux — маршруты заказов без проверки ролей.
+func testOrdersMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders", createOrderHandler)
+	mux.HandleFunc("PUT /orders/{id}", updateOrderHandler)
+	mux.HandleFunc("DELETE /orders/{id}", deleteOrderHandler)
+	return mux
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     int
+	}{
+		{OrderNew, OrderPreparing, http.StatusOK},
+		{OrderNew, OrderCancelled, http.StatusOK},
+		{OrderNew, OrderReady, http.StatusConflict},
+		{OrderNew, OrderCompleted, http.StatusConflict},
+		{OrderPreparing, OrderReady, http.StatusOK},
+		{OrderPreparing, OrderNew, http.StatusConflict},
+		{OrderReady, OrderCompleted, http.StatusOK},
+		{OrderReady, OrderCancelled, http.StatusOK},
+		{OrderCompleted, OrderCancelled, http.StatusConflict},
+		{OrderCancelled, OrderNew, http.StatusConflict},
+		{OrderNew, "lost", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s→%s", tt.from, tt.to), func(t *testing.T) {
+			setupTest(t)
+			clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 1}}
+			orders[1] = Order{ID: 1, ClientID: 1, Items: []OrderItem{{Name: "Латте", Quantity: 1, Price: 1500}},
+				Total: 1500, Status: tt.from, Version: 2}
+			nextOrderID = 2
+
+			body := fmt.Sprintf(`{"clientId":1,"items":[{"name":"Латте","quantity":1,"price":1500}],"status":%q,"version":2}`, tt.to)
+			w := testRequest(testOrdersMux(), http.MethodPut, "/orders/1", "", body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+			want := tt.from
+			if tt.want == http.StatusOK {
+				want = tt.to
+			}
+			if got := orders[1].Status; got != want {
+				t.Errorf("статус заказа %s, ожидался %s", got, want)
+			}
+		})
+	}
+}
+
+// TestOrdersPersist проверяет, что заказы и счетчик ID переживают
+// перезапуск, а ID удаленных заказов не выдаются снова.
+func TestOrdersPersist(t *testing.T) {
+	setupTest(t)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 1}}
+	mux := testOrdersMux()
+	body := `{"clientId":1,"items":[{"name":"Латте","quantity":2,"price":1500}]}`
+	for range 2 {
+		if w := testRequest(mux, http.MethodPost, "/orders", "", body, nil); w.Code != http.StatusCreated {
+			t.Fatalf("создание: статус %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := testRequest(mux, http.MethodDelete, "/orders/2", "", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("удаление: статус %d: %s", w.Code, w.Body)
+	}
+
+	orders = make(map[int]Order)
+	nextOrderID = 1
+	if err := loadOrders(); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := orders[1]; !ok || o.Total != 3000 || o.Status != OrderNew || len(orders) != 1 {
+		t.Fatalf("после перезапуска заказы %+v", orders)
+	}
+
+	w := testRequest(mux, http.MethodPost, "/orders", "", body, nil)
+	var o Order
+	if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 3 {
+		t.Errorf("новый заказ получил ID %d, ожидался 3", o.ID)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Исходящие запросы к внешним сервисам — вебхуки, геокодер, Telegram,
+// Sentry, S3 — идут через outboundClient. Он ограничивает время попытки,
+// повторяет неудачные попытки с растущей паузой и размыкает цепь к хосту,
+// который отвечает ошибками подряд: пока цепь разомкнута, запросы к нему
+// сразу завершаются ошибкой, а через breakerCooldown проходит один
+// пробный. Счетчики по интеграциям и состояние цепей — в /debug/vars
+// (outbound). Раздел outbound применяется при перезагрузке настроек.
+//
+// Повторяются только запросы, которые безопасно отправить еще раз:
+// идемпотентные по методу, POST интеграций с защитой от дублей у
+// получателя (outboundOptions.RetryPost) и любой запрос, который сервер
+// заведомо не обработал — не удалось соединиться или ответ 429/503.
+
+// OutboundConfig задает повторы и размыкание цепи исходящих запросов.
+// Время попытки задается в разделе каждой интеграции.
+type OutboundConfig struct {
+	Retries        int      `json:"retries"` // повторов после первой попытки
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"` // и предел Retry-After, который еще ждать
+	// BreakerThreshold — сколько ошибок подряд размыкают цепь; 0 — не размыкать.
+	BreakerThreshold int      `json:"breakerThreshold"`
+	BreakerCooldown  Duration `json:"breakerCooldown"` // сколько цепь остается разомкнутой
+}
+
+func (c OutboundConfig) validate() error {
+	if c.Retries < 0 || c.Retries > 10 {
+		return errors.New("outbound.retries: от 0 до 10")
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return errors.New("outbound: initialBackoff должен быть положительным и не больше maxBackoff")
+	}
+	if c.BreakerThreshold < 0 || c.BreakerThreshold > 0 && c.BreakerCooldown <= 0 {
+		return errors.New("outbound: breakerThreshold не может быть отрицательным, breakerCooldown должен быть положительным")
+	}
+	return nil
+}
+
+// errCircuitOpen — цепь к хосту разомкнута.
+var errCircuitOpen = errors.New("цепь разомкнута после ошибок подряд, запрос не отправлен")
+
+// outboundOptions — настройки клиента интеграции.
+type outboundOptions struct {
+	Timeout   time.Duration // на одну попытку; 0 — без ограничения
+	RetryPost bool          // получатель отбрасывает дубли, POST можно повторять
+}
+
+// outboundClient — HTTP-клиент одной интеграции.
+type outboundClient struct {
+	name string
+	opts outboundOptions
+	http *http.Client
+
+	mu       sync.Mutex
+	stats    outboundCounters
+	breakers map[string]*outboundBreaker // по хосту
+}
+
+// outboundCounters — счетчики интеграции.
+type outboundCounters struct {
+	Attempts int64         `json:"attempts"`
+	Failures int64         `json:"failures"` // ошибки сети и ответы 5xx
+	Retries  int64         `json:"retries"`
+	Rejected int64         `json:"rejected"` // не отправлено: цепь разомкнута
+	Latency  time.Duration `json:"-"`        // сумма по попыткам
+}
+
+// outboundBreaker — цепь к одному хосту.
+type outboundBreaker struct {
+	failures  int       // ошибок подряд
+	openUntil time.Time // цепь разомкнута до этого момента
+	probing   bool      // после openUntil идет пробный запрос
+	opened    int       // сколько раз цепь размыкалась
+}
+
+var (
+	outboundClients   = make(map[string]*outboundClient) // Клиенты по имени интеграции
+	outboundClientsMu sync.Mutex                         // Мьютекс для защиты outboundClients
+)
+
+// newOutboundClient создает клиент интеграции name. Клиент с тем же именем
+// заменяется вместе со счетчиками.
+func newOutboundClient(name string, opts outboundOptions) *outboundClient {
+	c := &outboundClient{name: name, opts: opts, http: &http.Client{}, breakers: make(map[string]*outboundBreaker)}
+	outboundClientsMu.Lock()
+	outboundClients[name] = c
+	outboundClientsMu.Unlock()
+	return c
+}
+
+// Do выполняет запрос с повторами. Ответ с ошибкой 5xx после всех попыток
+// возвращается как есть, чтобы интеграция разобрала его сама.
+func (c *outboundClient) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, c.opts.Timeout)
+}
+
+// do — Do со своим временем попытки.
+func (c *outboundClient) do(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	cfg := liveConfig().Outbound
+	host := req.URL.Host
+	for attempt := 0; ; attempt++ {
+		if !c.allow(host, cfg) {
+			return nil, fmt.Errorf("%s: %w", host, errCircuitOpen)
+		}
+		resp, err := c.attempt(req, timeout, host, cfg)
+		if attempt >= cfg.Retries || req.Context().Err() != nil {
+			return resp, err
+		}
+		wait, retry := c.retryAfter(req, resp, err, attempt, cfg)
+		if !retry {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				return resp, err
+			}
+			next := req.Clone(req.Context())
+			next.Body = body
+			req = next
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		c.mu.Lock()
+		c.stats.Retries++
+		c.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// attempt отправляет запрос один раз и учитывает итог в счетчиках и цепи.
+func (c *outboundClient) attempt(req *http.Request, timeout time.Duration, host string, cfg OutboundConfig) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	start := time.Now()
+	resp, err := c.http.Do(req.WithContext(ctx))
+	elapsed := time.Since(start)
+	if err != nil {
+		cancel()
+	} else {
+		resp.Body = cancelOnClose{resp.Body, cancel}
+	}
+
+	failed := err != nil || resp.StatusCode >= 500
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Attempts++
+	c.stats.Latency += elapsed
+	if failed {
+		c.stats.Failures++
+	}
+	// Отмена со стороны вызывающего ничего не говорит о состоянии хоста.
+	if err != nil && req.Context().Err() != nil {
+		if b := c.breakers[host]; b != nil {
+			b.probing = false
+		}
+		return resp, err
+	}
+	c.recordLocked(host, !failed, cfg)
+	return resp, err
+}
+
+// cancelOnClose освобождает контекст попытки, когда тело ответа прочитано.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryAfter решает, повторять ли попытку, и возвращает паузу перед
+// повтором.
+func (c *outboundClient) retryAfter(req *http.Request, resp *http.Response, err error, attempt int, cfg OutboundConfig) (time.Duration, bool) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false // тело не перечитать
+	}
+	safe := c.opts.RetryPost
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		safe = true
+	}
+	var opErr *net.OpError
+	switch {
+	case err != nil && errors.As(err, &opErr) && opErr.Op == "dial":
+	case err != nil && !safe:
+		return 0, false
+	case err != nil:
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return d, d <= time.Duration(cfg.MaxBackoff)
+		}
+	case resp.StatusCode >= 500 && safe:
+	default:
+		return 0, false
+	}
+	d := min(time.Duration(cfg.InitialBackoff)<<attempt, time.Duration(cfg.MaxBackoff))
+	return d/2 + rand.N(d/2+1), true
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или датой HTTP.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// allow сообщает, можно ли отправить запрос к host. После breakerCooldown
+// пропускается один пробный запрос.
+func (c *outboundClient) allow(host string, cfg OutboundConfig) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breakers[host]
+	now := time.Now()
+	switch {
+	case cfg.BreakerThreshold == 0 || b == nil || b.openUntil.IsZero():
+		return true
+	case now.Before(b.openUntil) || b.probing:
+		c.stats.Rejected++
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// recordLocked учитывает итог попытки в цепи host. Вызывается под c.mu.
+func (c *outboundClient) recordLocked(host string, ok bool, cfg OutboundConfig) {
+	b := c.breakers[host]
+	if b == nil {
+		if ok {
+			return
+		}
+		b = &outboundBreaker{}
+		c.breakers[host] = b
+	}
+	if ok {
+		if !b.openUntil.IsZero() {
+			logf("Внешний сервис %s (%s) снова отвечает, цепь замкнута", host, c.name)
+		}
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+	b.failures++
+	if cfg.BreakerThreshold > 0 && (b.probing || b.failures >= cfg.BreakerThreshold) {
+		if b.openUntil.IsZero() {
+			logf("Внешний сервис %s (%s): %d ошибок подряд, цепь разомкнута на %s", host, c.name, b.failures, time.Duration(cfg.BreakerCooldown))
+		}
+		b.openUntil = time.Now().Add(time.Duration(cfg.BreakerCooldown))
+		b.probing = false
+		b.opened++
+	}
+}
+
+// outboundBreakerStatus — состояние цепи в /debug/vars.
+type outboundBreakerStatus struct {
+	State     string     `json:"state"` // closed, open, half-open
+	Failures  int        `json:"failures"`
+	Opened    int        `json:"opened"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+}
+
+// outboundStatus — счетчики и цепи всех интеграций для /debug/vars.
+func outboundStatus() any {
+	outboundClientsMu.Lock()
+	list := make([]*outboundClient, 0, len(outboundClients))
+	for _, c := range outboundClients {
+		list = append(list, c)
+	}
+	outboundClientsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	type clientStatus struct {
+		outboundCounters
+		AvgLatencyMs float64                          `json:"avgLatencyMs"`
+		Breakers     map[string]outboundBreakerStatus `json:"breakers"`
+	}
+	out := make(map[string]clientStatus, len(list))
+	now := time.Now()
+	for _, c := range list {
+		c.mu.Lock()
+		st := clientStatus{outboundCounters: c.stats, Breakers: make(map[string]outboundBreakerStatus)}
+		if c.stats.Attempts > 0 {
+			st.AvgLatencyMs = float64(c.stats.Latency.Microseconds()) / 1000 / float64(c.stats.Attempts)
+		}
+		for host, b := range c.breakers {
+			bs := outboundBreakerStatus{State: "closed", Failures: b.failures, Opened: b.opened}
+			if !b.openUntil.IsZero() {
+				until := b.openUntil
+				bs.State, bs.OpenUntil = "open", &until
+				if !now.Before(until) {
+					bs.State = "half-open"
+				}
+			}
+			st.Breakers[host] = bs
+		}
+		c.mu.Unlock()
+		out[c.name] = st
+	}
+	return out
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Кодирование protobuf вручную, только для сообщений из proto/client.proto.
+// Поля со значением по умолчанию не записываются, как в proto3.
+
+// Типы значений в ключе поля.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoMalformed = errors.New("неверный формат protobuf")
+
+// protoField — одно прочитанное поле. Varint хранит и fixed32/fixed64.
+type protoField struct {
+	Num    int
+	Type   int
+	Varint uint64
+	Bytes  []byte
+}
+
+func protoAppendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func protoAppendInt(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoAppendTag(b, num, protoVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func protoAppendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return protoAppendInt(b, num, 1)
+}
+
+func protoAppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protoAppendBytes(b, num, []byte(s))
+}
+
+func protoAppendBytes(b []byte, num int, data []byte) []byte {
+	b = protoAppendTag(b, num, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func protoAppendDouble(b []byte, num int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoAppendTag(b, num, protoFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// protoAppendTime записывает google.protobuf.Timestamp; нулевое время пропускается.
+func protoAppendTime(b []byte, num int, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = protoAppendInt(ts, 1, t.Unix())
+	ts = protoAppendInt(ts, 2, int64(t.Nanosecond()))
+	return protoAppendBytes(b, num, ts)
+}
+
+// protoEachField перебирает поля сообщения.
+func protoEachField(b []byte, fn func(f protoField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 {
+			return errProtoMalformed
+		}
+		b = b[n:]
+		f := protoField{Num: int(key >> 3), Type: int(key & 7)}
+		switch f.Type {
+		case protoVarint:
+			if f.Varint, n = binary.Uvarint(b); n <= 0 {
+				return errProtoMalformed
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return errProtoMalformed
+			}
+			f.Varint, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return errProtoMalformed
+			}
+			f.Varint, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errProtoMalformed
+			}
+			f.Bytes, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return fmt.Errorf("%w: тип поля %d", errProtoMalformed, f.Type)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func protoTime(b []byte) (time.Time, error) {
+	var sec, nsec int64
+	err := protoEachField(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			sec = int64(f.Varint)
+		case 2:
+			nsec = int64(f.Varint)
+		}
+		return nil
+	})
+	return time.Unix(sec, nsec).UTC(), err
+}
+
+// marshalClientProto кодирует клиента как clients.v1.Client.
+func marshalClientProto(c Client) []byte {
+	var b []byte
+	b = protoAppendInt(b, 1, int64(c.ID))
+	b = protoAppendString(b, 2, c.Name)
+	b = protoAppendInt(b, 3, int64(c.currentAge()))
+	b = protoAppendTime(b, 4, c.RegisterDate)
+	b = protoAppendString(b, 5, c.FavCoffee)
+	if c.Address != (Address{}) {
+		b = protoAppendBytes(b, 6, marshalAddressProto(c.Address))
+	}
+	b = protoAppendInt(b, 7, int64(c.Version))
+	if c.DeletedAt != nil {
+		b = protoAppendTime(b, 8, *c.DeletedAt)
+	}
+	b = protoAppendString(b, 9, c.Email)
+	for _, t := range c.Tags {
+		b = protoAppendString(b, 10, t)
+	}
+	b = protoAppendString(b, 11, c.BirthDate)
+	for _, a := range c.Addresses {
+		b = protoAppendBytes(b, 12, marshalAddressProto(a))
+	}
+	return b
+}
+
+// marshalAddressProto кодирует clients.v1.Address.
+func marshalAddressProto(a Address) []byte {
+	var b []byte
+	b = protoAppendString(b, 1, a.City)
+	b = protoAppendString(b, 2, a.Street)
+	b = protoAppendString(b, 3, a.Type)
+	if a.Location != nil {
+		var p []byte
+		p = protoAppendDouble(p, 1, a.Location.Lat)
+		p = protoAppendDouble(p, 2, a.Location.Lon)
+		b = protoAppendBytes(b, 4, p)
+	}
+	return b
+}
+
+// unmarshalAddressProto читает clients.v1.Address.
+func unmarshalAddressProto(b []byte) (Address, error) {
+	var a Address
+	err := protoEachField(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			a.City = string(f.Bytes)
+		case 2:
+			a.Street = string(f.Bytes)
+		case 3:
+			a.Type = string(f.Bytes)
+		case 4:
+			a.Location = &GeoPoint{}
+			return protoEachField(f.Bytes, func(f protoField) error {
+				switch f.Num {
+				case 1:
+					a.Location.Lat = math.Float64frombits(f.Varint)
+				case 2:
+					a.Location.Lon = math.Float64frombits(f.Varint)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return a, err
+}
+
+// unmarshalClientProto читает clients.v1.Client. Неизвестные поля пропускаются.
+func unmarshalClientProto(b []byte) (Client, error) {
+	var c Client
+	err := protoEachField(b, func(f protoField) error {
+		var err error
+		switch f.Num {
+		case 1:
+			c.ID = int(int64(f.Varint))
+		case 2:
+			c.Name = string(f.Bytes)
+		case 3:
+			c.Age = int(int32(f.Varint))
+		case 4:
+			c.RegisterDate, err = protoTime(f.Bytes)
+		case 5:
+			c.FavCoffee = string(f.Bytes)
+		case 6:
+			c.Address, err = unmarshalAddressProto(f.Bytes)
+		case 7:
+			c.Version = int(int64(f.Varint))
+		case 8:
+			var t time.Time
+			t, err = protoTime(f.Bytes)
+			c.DeletedAt = &t
+		case 9:
+			c.Email = string(f.Bytes)
+		case 10:
+			c.Tags = append(c.Tags, string(f.Bytes))
+		case 11:
+			c.BirthDate = string(f.Bytes)
+		case 12:
+			var a Address
+			a, err = unmarshalAddressProto(f.Bytes)
+			c.Addresses = append(c.Addresses, a)
+		}
+		return err
+	})
+	return c, err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Адрес клиента за обратным прокси. Если запрос пришел от доверенного
+// прокси (proxy.trusted), адрес клиента берется из Forwarded,
+// X-Forwarded-For или X-Real-IP — в таком порядке. Цепочка адресов
+// читается справа налево, доверенные прокси пропускаются: первый чужой
+// адрес и есть клиент. Заголовки от остальных отправителей игнорируются,
+// иначе любой мог бы подставить чужой адрес и обойти ограничение частоты.
+
+// ProxyConfig задает доверенные прокси.
+type ProxyConfig struct {
+	// Trusted — адреса и подсети прокси, например "127.0.0.1" или
+	// "10.0.0.0/8".
+	Trusted []string `json:"trusted"`
+
+	prefixes []netip.Prefix
+}
+
+// parse разбирает Trusted.
+func (c *ProxyConfig) parse() error {
+	c.prefixes = nil
+	for _, s := range c.Trusted {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			a, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return fmt.Errorf("proxy.trusted: неверный адрес или подсеть %q", s)
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		c.prefixes = append(c.prefixes, p.Masked())
+	}
+	return nil
+}
+
+func (c ProxyConfig) trusted(a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range c.prefixes {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP возвращает адрес отправителя запроса с учетом доверенных прокси.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxy := liveConfig().Proxy
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !proxy.trusted(peer) {
+		return host
+	}
+
+	var chain []string
+	if v := r.Header.Values("Forwarded"); len(v) > 0 {
+		chain = forwardedFor(strings.Join(v, ","))
+	} else if v := r.Header.Values("X-Forwarded-For"); len(v) > 0 {
+		for _, part := range strings.Split(strings.Join(v, ","), ",") {
+			chain = append(chain, strings.TrimSpace(part))
+		}
+	} else if v := r.Header.Get("X-Real-IP"); v != "" {
+		chain = []string{strings.TrimSpace(v)}
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		a, ok := parseForwardedAddr(chain[i])
+		if !ok {
+			break // unknown или скрытый адрес: дальше цепочке верить нельзя
+		}
+		client = a
+		if !proxy.trusted(a) {
+			break
+		}
+	}
+	return client.Unmap().String()
+}
+
+// forwardedFor возвращает значения for= из заголовка Forwarded (RFC 7239)
+// по порядку прокси.
+func forwardedFor(header string) []string {
+	var list []string
+	for _, elem := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(k, "for") {
+				list = append(list, strings.Trim(v, `"`))
+			}
+		}
+	}
+	return list
+}
+
+// parseForwardedAddr разбирает адрес из заголовка: "192.0.2.1",
+// "192.0.2.1:4711", "[2001:db8::1]:4711" или "2001:db8::1".
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a, true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr(), true
+	}
+	a, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	return a, err == nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Проблемы качества данных клиента.
+const (
+	issueMissingAge       = "missing_age"
+	issueAgeWithoutBirth  = "age_without_birth_date"
+	issueMissingFavCoffee = "missing_fav_coffee"
+	issueMissingCity      = "missing_city"
+	issueMissingStreet    = "missing_street"
+	issueShortName        = "short_name"
+	issueStale            = "stale"
+)
+
+// qualityRule — проверка записи и штраф к оценке, если она не пройдена.
+type qualityRule struct {
+	Issue   string
+	Penalty int
+	Hint    string // что исправить сотруднику
+	Failed  func(c Client, now time.Time) bool
+}
+
+// qualityStaleAfter — запись, не менявшаяся с регистрации дольше этого
+// срока, считается устаревшей.
+const qualityStaleAfter = 365 * 24 * time.Hour
+
+// qualityRules оценивают только поля, которые есть у клиента. Оценка
+// записи — 100 минус сумма штрафов.
+var qualityRules = []qualityRule{
+	{issueMissingCity, 25, "Уточните город", func(c Client, _ time.Time) bool {
+		return strings.TrimSpace(c.Address.City) == ""
+	}},
+	{issueMissingStreet, 15, "Уточните улицу", func(c Client, _ time.Time) bool {
+		return strings.TrimSpace(c.Address.Street) == ""
+	}},
+	{issueMissingAge, 20, "Укажите дату рождения", func(c Client, _ time.Time) bool {
+		return c.Age == 0 && c.BirthDate == ""
+	}},
+	// Старые записи с возрастом числом: он устаревает, дату рождения можно
+	// дозаполнить через POST /clients/birthdates.
+	{issueAgeWithoutBirth, 5, "Уточните дату рождения: возраст числом устаревает", func(c Client, _ time.Time) bool {
+		return c.Age != 0 && c.BirthDate == ""
+	}},
+	{issueMissingFavCoffee, 15, "Спросите любимый кофе", func(c Client, _ time.Time) bool {
+		return strings.TrimSpace(c.FavCoffee) == ""
+	}},
+	{issueShortName, 10, "Укажите полное имя", func(c Client, _ time.Time) bool {
+		return len([]rune(strings.TrimSpace(c.Name))) < 2
+	}},
+	{issueStale, 15, "Проверьте, актуальны ли данные", func(c Client, now time.Time) bool {
+		return c.Version <= 1 && !c.RegisterDate.IsZero() && now.Sub(c.RegisterDate) > qualityStaleAfter
+	}},
+}
+
+// clientQuality — оценка одной записи.
+type clientQuality struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Score  int      `json:"score"`
+	Issues []string `json:"issues"`
+}
+
+// scoreClient оценивает запись клиента.
+func scoreClient(c Client, now time.Time) clientQuality {
+	q := clientQuality{ID: c.ID, Name: c.Name, Score: 100, Issues: []string{}}
+	for _, rule := range qualityRules {
+		if rule.Failed(c, now) {
+			q.Score -= rule.Penalty
+			q.Issues = append(q.Issues, rule.Issue)
+		}
+	}
+	q.Score = max(q.Score, 0)
+	return q
+}
+
+// qualityIssueSummary — сколько записей страдает от проблемы.
+type qualityIssueSummary struct {
+	Issue string `json:"issue"`
+	Hint  string `json:"hint"`
+	Count int    `json:"count"`
+}
+
+// qualityReport — сводка по качеству данных для панели администратора.
+type qualityReport struct {
+	Total        int                   `json:"total"`
+	AverageScore float64               `json:"averageScore"`
+	Bands        map[string]int        `json:"bands"`  // good ≥ 80, fair 50–79, poor < 50
+	Issues       []qualityIssueSummary `json:"issues"` // по убыванию числа записей
+	Worst        []clientQuality       `json:"worst"`  // до 10 записей с низшей оценкой
+}
+
+func qualityBand(score int) string {
+	switch {
+	case score >= 80:
+		return "good"
+	case score >= 50:
+		return "fair"
+	default:
+		return "poor"
+	}
+}
+
+// qualityReportHandler отдает сводку качества данных: GET /admin/quality.
+func qualityReportHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := filterClients(clientFilter{Tenant: requestTenant(r)})
+
+	report := qualityReport{Total: len(list), Bands: map[string]int{"good": 0, "fair": 0, "poor": 0}}
+	counts := make(map[string]int)
+	scored := make([]clientQuality, 0, len(list))
+	sum := 0
+	for _, c := range list {
+		q := scoreClient(c, now)
+		sum += q.Score
+		report.Bands[qualityBand(q.Score)]++
+		for _, issue := range q.Issues {
+			counts[issue]++
+		}
+		scored = append(scored, q)
+	}
+	if len(list) > 0 {
+		report.AverageScore = float64(sum) / float64(len(list))
+	}
+	for _, rule := range qualityRules {
+		report.Issues = append(report.Issues, qualityIssueSummary{Issue: rule.Issue, Hint: rule.Hint, Count: counts[rule.Issue]})
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool { return report.Issues[i].Count > report.Issues[j].Count })
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score < scored[j].Score })
+	report.Worst = scored[:min(len(scored), 10)]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// qualityClientsHandler перечисляет записи, которые стоит поправить:
+// GET /admin/quality/clients?maxScore=&issue= плюс фильтры списка клиентов.
+// По умолчанию — все записи с оценкой ниже 80, худшие первыми.
+func qualityClientsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+	maxScore := 79
+	if v := q.Get("maxScore"); v != "" {
+		if maxScore, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "maxScore: ожидается целое число", http.StatusBadRequest)
+			return
+		}
+	}
+	issue := q.Get("issue")
+	if issue != "" && !slices.ContainsFunc(qualityRules, func(rule qualityRule) bool { return rule.Issue == issue }) {
+		http.Error(w, "Неизвестная проблема: "+issue, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	result := []clientQuality{}
+	for _, c := range filterClients(f) {
+		cq := scoreClient(c, now)
+		if cq.Score > maxScore || (issue != "" && !slices.Contains(cq.Issues, issue)) {
+			continue
+		}
+		result = append(result, cq)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Score < result[j].Score })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
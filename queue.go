@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueConfig задает очередь фоновых задач: вебхуков, писем, выгрузок.
+// Неудачная задача повторяется через InitialBackoff, затем интервал
+// удваивается до MaxBackoff. После последней попытки задача попадает в
+// недоставленные, откуда ее можно перезапустить через /admin/queue.
+type QueueConfig struct {
+	Workers        int      `json:"workers"`
+	Size           int      `json:"size"` // задач в очереди, включая ожидающие повтора
+	MaxAttempts    int      `json:"maxAttempts"`
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+	DrainTimeout   Duration `json:"drainTimeout"` // сколько при остановке ждать, пока очередь опустеет
+	DeadLetters    int      `json:"deadLetters"`  // сколько последних недоставленных хранить
+}
+
+// retryPolicy — сколько раз и с какими паузами повторять задачу.
+type retryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// delay возвращает паузу перед попыткой attempt+1.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff)
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		MaxAttempts:    config.Queue.MaxAttempts,
+		InitialBackoff: time.Duration(config.Queue.InitialBackoff),
+		MaxBackoff:     time.Duration(config.Queue.MaxBackoff),
+	}
+}
+
+// taskKind — вид задачи: обработчик и политика повторов.
+type taskKind struct {
+	Name   string
+	Handle func(ctx context.Context, t *queueTask) error
+	Retry  func() retryPolicy // nil — из config.Queue
+	// Client возвращает ID клиента, чьи данные лежат в задаче, или 0.
+	Client func(payload json.RawMessage) int
+}
+
+// queueTask — задача в очереди. Payload — JSON, чтобы невыполненные задачи
+// переживали перезапуск сервера.
+type queueTask struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"` // сделанных попыток
+	CreatedAt time.Time       `json:"createdAt"`
+	RetryAt   *time.Time      `json:"retryAt,omitempty"`
+	LastError string          `json:"lastError,omitempty"`
+	FailedAt  *time.Time      `json:"failedAt,omitempty"` // задано у недоставленной
+}
+
+// permanentError — ошибка, после которой повторять задачу бесполезно.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+var (
+	taskKinds             = make(map[string]*taskKind)   // Виды задач по имени
+	queueReady            []*queueTask                   // Задачи к выполнению по порядку
+	queueDelayed          = make(map[string]*queueTask)  // Задачи, ожидающие повтора, по ID
+	queueTimers           = make(map[string]*time.Timer) // Таймеры повторов по ID задачи
+	deadLetters           []*queueTask                   // Недоставленные, новые последними
+	queueMu               sync.Mutex                     // Мьютекс для защиты очереди
+	queueCond             = sync.NewCond(&queueMu)       // Сигнал обработчикам о новой задаче
+	queueRunning          int                            // Выполняющихся задач
+	queueWorkers          int                            // Запущенных обработчиков
+	queueWorkersWant      int                            // Сколько обработчиков должно быть
+	queueDrain            bool                           // Идет остановка: новые повторы не планируются
+	queueWG               sync.WaitGroup                 // Обработчики очереди
+	queueCtx, cancelQueue = context.WithCancel(context.Background())
+)
+
+// queueStats — счетчики очереди с запуска сервера.
+var queueStats struct {
+	Enqueued  atomic.Int64
+	Completed atomic.Int64
+	Retried   atomic.Int64
+	Dead      atomic.Int64
+}
+
+var errQueueFull = errors.New("очередь задач переполнена")
+
+// registerTaskKind добавляет вид задачи. Вызывается при инициализации.
+func registerTaskKind(k *taskKind) {
+	taskKinds[k.Name] = k
+}
+
+func queuePath() string {
+	return filepath.Join(config.DataDir, "queue.json")
+}
+
+func deadLettersPath() string {
+	return filepath.Join(config.DataDir, "deadletters.json")
+}
+
+// loadQueue читает задачи, не выполненные до прошлой остановки, и
+// недоставленные задачи. Отложенные повторы выполняются сразу.
+func loadQueue() error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	for _, f := range []struct {
+		path string
+		dst  *[]*queueTask
+	}{{queuePath(), &queueReady}, {deadLettersPath(), &deadLetters}} {
+		data, err := os.ReadFile(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, f.dst); err != nil {
+			return fmt.Errorf("разбор %s: %w", f.path, err)
+		}
+	}
+	for _, t := range queueReady {
+		t.RetryAt = nil
+	}
+	return nil
+}
+
+// enqueueTask ставит задачу в очередь. Не блокируется, поэтому годится и
+// для подписчиков событий клиентов.
+func enqueueTask(kind string, payload any) error {
+	if _, ok := taskKinds[kind]; !ok {
+		return fmt.Errorf("неизвестный вид задачи %s", kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if len(queueReady)+len(queueDelayed)+queueRunning >= config.Queue.Size {
+		return errQueueFull
+	}
+	queueReady = append(queueReady, &queueTask{ID: randomHex(8), Kind: kind, Payload: data, CreatedAt: time.Now()})
+	queueStats.Enqueued.Add(1)
+	queueCond.Signal()
+	return nil
+}
+
+// startQueue запускает обработчики очереди.
+func startQueue() {
+	resizeQueue(config.Queue.Workers)
+}
+
+// resizeQueue меняет число обработчиков очереди на n. Лишние обработчики
+// завершаются, доделав текущую задачу.
+func resizeQueue(n int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if queueDrain {
+		return
+	}
+	queueWorkersWant = n
+	for ; queueWorkers < n; queueWorkers++ {
+		queueWG.Add(1)
+		go queueWorker()
+	}
+	queueCond.Broadcast()
+}
+
+func queueWorker() {
+	defer queueWG.Done()
+	for {
+		queueMu.Lock()
+		for len(queueReady) == 0 && !queueDrain && queueWorkers <= queueWorkersWant {
+			queueCond.Wait()
+		}
+		if len(queueReady) == 0 || queueCtx.Err() != nil || queueWorkers > queueWorkersWant {
+			queueWorkers--
+			queueMu.Unlock()
+			return
+		}
+		t := queueReady[0]
+		queueReady[0] = nil
+		queueReady = queueReady[1:]
+		queueRunning++
+		queueMu.Unlock()
+
+		runTask(t)
+	}
+}
+
+// runTask выполняет одну попытку и решает, что делать с задачей дальше.
+func runTask(t *queueTask) {
+	k := taskKinds[t.Kind]
+	t.Attempts++
+	var err error
+	if k == nil {
+		err = permanentError{fmt.Errorf("неизвестный вид задачи %s", t.Kind)}
+	} else {
+		err = k.Handle(queueCtx, t)
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	queueRunning--
+	if err == nil {
+		queueStats.Completed.Add(1)
+		return
+	}
+	t.LastError = err.Error()
+	policy := defaultRetryPolicy()
+	if k != nil && k.Retry != nil {
+		policy = k.Retry()
+	}
+
+	var perm permanentError
+	switch {
+	case errors.As(err, &perm) || t.Attempts >= policy.MaxAttempts:
+		now := time.Now()
+		t.RetryAt, t.FailedAt = nil, &now
+		deadLetters = append(deadLetters, t)
+		if extra := len(deadLetters) - config.Queue.DeadLetters; extra > 0 {
+			deadLetters = slices.Delete(deadLetters, 0, extra)
+		}
+		queueStats.Dead.Add(1)
+		logf("Задача %s (%s) не выполнена за %d попыток: %v", t.ID, t.Kind, t.Attempts, err)
+		if err := writeJSONFile(deadLettersPath(), deadLetters); err != nil {
+			logError("Ошибка сохранения недоставленных задач: %v", err)
+		}
+	default:
+		delay := policy.delay(t.Attempts)
+		at := time.Now().Add(delay)
+		t.RetryAt = &at
+		queueDelayed[t.ID] = t
+		queueStats.Retried.Add(1)
+		// При остановке задача сохранится и повторится после запуска.
+		if !queueDrain {
+			queueTimers[t.ID] = time.AfterFunc(delay, func() { requeueTask(t.ID) })
+		}
+	}
+}
+
+// requeueTask возвращает задачу после паузы в начало очереди.
+func requeueTask(id string) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	t, ok := queueDelayed[id]
+	if !ok || queueDrain {
+		return
+	}
+	delete(queueDelayed, id)
+	delete(queueTimers, id)
+	t.RetryAt = nil
+	queueReady = append(queueReady, t)
+	queueCond.Signal()
+}
+
+// drainQueue останавливает очередь: обработчики доделывают накопленные
+// задачи, но не дольше DrainTimeout. Оставшиеся задачи сохраняются и
+// выполняются после следующего запуска.
+func drainQueue() {
+	queueMu.Lock()
+	queueDrain = true
+	for id, tm := range queueTimers {
+		tm.Stop()
+		delete(queueTimers, id)
+	}
+	queueCond.Broadcast()
+	queueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		queueWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Duration(config.Queue.DrainTimeout)):
+		cancelQueue()
+		<-done
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	pending := append([]*queueTask{}, queueReady...)
+	for _, t := range queueDelayed {
+		pending = append(pending, t)
+	}
+	slices.SortFunc(pending, func(a, b *queueTask) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	if len(pending) > 0 {
+		logf("Задач в очереди при остановке: %d, выполнятся после запуска", len(pending))
+	}
+	if err := writeJSONFile(queuePath(), pending); err != nil {
+		logError("Ошибка сохранения очереди задач: %v", err)
+	}
+}
+
+// dropClientTasks удаляет ожидающие и недоставленные задачи с данными
+// клиента id и возвращает их число. Выполняющиеся задачи не трогает.
+func dropClientTasks(id int) int {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	match := func(t *queueTask) bool {
+		k := taskKinds[t.Kind]
+		return k != nil && k.Client != nil && k.Client(t.Payload) == id
+	}
+	n := len(queueReady) + len(deadLetters)
+	queueReady = slices.DeleteFunc(queueReady, match)
+	dead := len(deadLetters)
+	deadLetters = slices.DeleteFunc(deadLetters, match)
+	n -= len(queueReady) + len(deadLetters)
+	for tid, t := range queueDelayed {
+		if match(t) {
+			if tm, ok := queueTimers[tid]; ok {
+				tm.Stop()
+				delete(queueTimers, tid)
+			}
+			delete(queueDelayed, tid)
+			n++
+		}
+	}
+	if len(deadLetters) != dead {
+		if err := writeJSONFile(deadLettersPath(), deadLetters); err != nil {
+			logError("Ошибка сохранения недоставленных задач: %v", err)
+		}
+	}
+	return n
+}
+
+// queueHandler показывает состояние очереди и недоставленные задачи (GET),
+// перезапускает недоставленную задачу (POST ?retry=<id>) и удаляет ее
+// (DELETE ?id=<id>).
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		list := deadLetters
+		if list == nil {
+			list = []*queueTask{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"workers": queueWorkersWant,
+			"ready":   len(queueReady),
+			"delayed": len(queueDelayed),
+			"running": queueRunning,
+			"stats": map[string]int64{
+				"enqueued":  queueStats.Enqueued.Load(),
+				"completed": queueStats.Completed.Load(),
+				"retried":   queueStats.Retried.Load(),
+				"dead":      queueStats.Dead.Load(),
+			},
+			"deadLetters": list,
+		})
+
+	case http.MethodPost, http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if r.Method == http.MethodPost {
+			id = r.URL.Query().Get("retry")
+		}
+		i := slices.IndexFunc(deadLetters, func(t *queueTask) bool { return t.ID == id })
+		if i < 0 {
+			http.Error(w, "Задача не найдена", http.StatusNotFound)
+			return
+		}
+		t := deadLetters[i]
+		deadLetters = slices.Delete(deadLetters, i, i+1)
+		if err := writeJSONFile(deadLettersPath(), deadLetters); err != nil {
+			deadLetters = slices.Insert(deadLetters, i, t)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		t.Attempts, t.FailedAt = 0, nil
+		queueReady = append(queueReady, t)
+		queueCond.Signal()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(t)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Квоты API-ключей: в отличие от ограничения частоты (ratelimit.go) они
+// считают запросы за сутки и за месяц по UTC и нужны, чтобы выдавать
+// партнерам ограниченный доступ к API. Учет ведется для всех ключей, даже
+// без квот, и виден в GET /admin/keys/{id}/usage. Счетчики сохраняются
+// в файл раз в keyUsageFlushInterval и при остановке, чтобы перезапуск не
+// обнулял квоты.
+
+// QuotaConfig задает квоты API-ключей; 0 — без ограничения.
+type QuotaConfig struct {
+	Daily   int64 `json:"daily"`   // по умолчанию для всех ключей
+	Monthly int64 `json:"monthly"` // по умолчанию для всех ключей
+	// Keys переопределяет квоты отдельных ключей по имени или ID.
+	Keys map[string]KeyQuota `json:"keys"`
+}
+
+// KeyQuota — квоты одного ключа.
+type KeyQuota struct {
+	Daily   int64 `json:"daily"`
+	Monthly int64 `json:"monthly"`
+}
+
+func (c QuotaConfig) validate() error {
+	if c.Daily < 0 || c.Monthly < 0 {
+		return fmt.Errorf("quotas: квоты не могут быть отрицательными")
+	}
+	for name, q := range c.Keys {
+		if q.Daily < 0 || q.Monthly < 0 {
+			return fmt.Errorf("quotas.keys.%s: квоты не могут быть отрицательными", name)
+		}
+	}
+	return nil
+}
+
+// quotaFor возвращает квоты ключа.
+func (c QuotaConfig) quotaFor(k APIKey) KeyQuota {
+	if q, ok := c.Keys[k.ID]; ok {
+		return q
+	}
+	if q, ok := c.Keys[k.Name]; ok {
+		return q
+	}
+	return KeyQuota{Daily: c.Daily, Monthly: c.Monthly}
+}
+
+// keyUsage — учет запросов одного ключа.
+type keyUsage struct {
+	Day        string    `json:"day"` // ГГГГ-ММ-ДД по UTC
+	DayCount   int64     `json:"dayCount"`
+	Month      string    `json:"month"` // ГГГГ-ММ по UTC
+	MonthCount int64     `json:"monthCount"`
+	Total      int64     `json:"total"`
+	Rejected   int64     `json:"rejected"` // отклонено по квоте
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+// keyUsageFlushInterval — как часто учет запросов сохраняется на диск. За
+// это время при аварийной остановке теряется часть счетчиков.
+const keyUsageFlushInterval = time.Minute
+
+var (
+	keyUsages     = make(map[string]*keyUsage) // Учет запросов по ID ключа
+	keyUsagesMu   sync.Mutex                   // Мьютекс для защиты учета запросов; берется после apiKeysMu
+	keyUsageDirty bool                         // Учет изменился после последней записи
+)
+
+func keyUsagesPath() string {
+	return filepath.Join(config.DataDir, "key_usage.json")
+}
+
+// loadKeyUsages читает учет запросов API-ключей.
+func loadKeyUsages() error {
+	data, err := os.ReadFile(keyUsagesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyUsagesMu.Lock()
+	defer keyUsagesMu.Unlock()
+	if err := json.Unmarshal(data, &keyUsages); err != nil {
+		return fmt.Errorf("разбор %s: %w", keyUsagesPath(), err)
+	}
+	return nil
+}
+
+// flushKeyUsages сохраняет учет запросов, если он изменился.
+func flushKeyUsages() error {
+	keyUsagesMu.Lock()
+	defer keyUsagesMu.Unlock()
+	if !keyUsageDirty {
+		return nil
+	}
+	if err := writeJSONFile(keyUsagesPath(), keyUsages); err != nil {
+		return err
+	}
+	keyUsageDirty = false
+	return nil
+}
+
+// runKeyUsages периодически сохраняет учет запросов до отмены ctx.
+func runKeyUsages(ctx context.Context) {
+	ticker := time.NewTicker(keyUsageFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := flushKeyUsages(); err != nil {
+				logError("Ошибка сохранения учета запросов API-ключей: %v", err)
+			}
+		}
+	}
+}
+
+// roll начинает новые сутки и месяц, если они наступили.
+func (u *keyUsage) roll(now time.Time) {
+	if day := now.Format(time.DateOnly); u.Day != day {
+		u.Day, u.DayCount = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthCount = month, 0
+	}
+}
+
+// countKeyRequest учитывает запрос ключа k. Если квота исчерпана, запрос
+// не учитывается, а возвращается время до ее обновления.
+func countKeyRequest(k APIKey, q KeyQuota, now time.Time) (ok bool, retry time.Duration, remaining int64) {
+	now = now.UTC()
+	keyUsagesMu.Lock()
+	defer keyUsagesMu.Unlock()
+	u := keyUsages[k.ID]
+	if u == nil {
+		u = &keyUsage{}
+		keyUsages[k.ID] = u
+	}
+	u.roll(now)
+	keyUsageDirty = true
+
+	remaining = -1
+	if q.Daily > 0 {
+		if u.DayCount >= q.Daily {
+			u.Rejected++
+			return false, time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now), 0
+		}
+		remaining = q.Daily - u.DayCount - 1
+	}
+	if q.Monthly > 0 {
+		if u.MonthCount >= q.Monthly {
+			u.Rejected++
+			return false, time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now), 0
+		}
+		if left := q.Monthly - u.MonthCount - 1; remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+	u.DayCount++
+	u.MonthCount++
+	u.Total++
+	u.LastUsed = now
+	return true, 0, remaining
+}
+
+// forgetKeyUsage удаляет учет отозванного ключа.
+func forgetKeyUsage(id string) {
+	keyUsagesMu.Lock()
+	delete(keyUsages, id)
+	keyUsageDirty = true
+	keyUsagesMu.Unlock()
+}
+
+// withQuota учитывает запросы с действительным API-ключом и отклоняет их,
+// когда квота ключа исчерпана. Оставшееся число запросов за самый тесный
+// период отдается в X-Quota-Remaining.
+func withQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plain := r.Header.Get(apiKeyHeader)
+		if plain == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		k, err := lookupAPIKey(plain)
+		if err != nil {
+			next.ServeHTTP(w, r) // неверный ключ отклонит аутентификация
+			return
+		}
+		ok, retry, remaining := countKeyRequest(k, liveConfig().Quotas.quotaFor(k), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "Квота запросов API-ключа исчерпана", http.StatusTooManyRequests)
+			return
+		}
+		if remaining >= 0 {
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotaPeriod — использование квоты за период.
+type quotaPeriod struct {
+	Period    string `json:"period"`
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit"`               // 0 — без ограничения
+	Remaining *int64 `json:"remaining,omitempty"` // только при ограничении
+}
+
+func newQuotaPeriod(period string, used, limit int64) quotaPeriod {
+	p := quotaPeriod{Period: period, Used: used, Limit: limit}
+	if limit > 0 {
+		left := max(limit-used, 0)
+		p.Remaining = &left
+	}
+	return p
+}
+
+// keyUsageReport — ответ GET /admin/keys/{id}/usage.
+type keyUsageReport struct {
+	KeyID    string      `json:"keyId"`
+	Name     string      `json:"name"`
+	Day      quotaPeriod `json:"day"`
+	Month    quotaPeriod `json:"month"`
+	Total    int64       `json:"total"`
+	Rejected int64       `json:"rejected"`
+	LastUsed *time.Time  `json:"lastUsed,omitempty"`
+}
+
+// keyUsageHandler показывает учет запросов ключа: GET /admin/keys/{id}/usage.
+func keyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	apiKeysMu.Lock()
+	k, exists := apiKeys[id]
+	apiKeysMu.Unlock()
+	if !exists {
+		http.Error(w, "Ключ не найден", http.StatusNotFound)
+		return
+	}
+	q := liveConfig().Quotas.quotaFor(k)
+
+	now := time.Now().UTC()
+	keyUsagesMu.Lock()
+	u := keyUsage{}
+	if cur := keyUsages[id]; cur != nil {
+		cur.roll(now)
+		u = *cur
+	} else {
+		u.roll(now)
+	}
+	keyUsagesMu.Unlock()
+
+	rep := keyUsageReport{
+		KeyID:    k.ID,
+		Name:     k.Name,
+		Day:      newQuotaPeriod(u.Day, u.DayCount, q.Daily),
+		Month:    newQuotaPeriod(u.Month, u.MonthCount, q.Monthly),
+		Total:    u.Total,
+		Rejected: u.Rejected,
+	}
+	if !u.LastUsed.IsZero() {
+		rep.LastUsed = &u.LastUsed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
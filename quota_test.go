@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyQuotaRollover(t *testing.T) {
+	setupTest(t)
+	k := APIKey{ID: "k1", Name: "partner"}
+	q := KeyQuota{Daily: 2, Monthly: 3}
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	steps := []struct {
+		at        string
+		ok        bool
+		remaining int64
+	}{
+		{"2026-01-30 10:00:00", true, 1},
+		{"2026-01-30 23:59:59", true, 0},
+		{"2026-01-30 23:59:59", false, 0}, // суточная квота исчерпана
+		{"2026-01-31 00:00:00", true, 0},  // новые сутки, но в месяце остался один запрос
+		{"2026-01-31 12:00:00", false, 0}, // месячная квота исчерпана
+		{"2026-02-01 00:00:00", true, 1},  // новый месяц
+	}
+	for i, s := range steps {
+		ok, retry, remaining := countKeyRequest(k, q, day(s.at))
+		if ok != s.ok || remaining != s.remaining {
+			t.Fatalf("шаг %d (%s): ok=%v remaining=%d, ожидалось ok=%v remaining=%d", i, s.at, ok, remaining, s.ok, s.remaining)
+		}
+		if !ok && retry <= 0 {
+			t.Errorf("шаг %d: отказ без Retry-After", i)
+		}
+	}
+	if u := keyUsages["k1"]; u.Total != 4 || u.Rejected != 2 || u.Month != "2026-02" || u.MonthCount != 1 {
+		t.Errorf("учет %+v", *u)
+	}
+}
+
+// TestKeyUsagePersist проверяет, что счетчики переживают перезапуск и квота
+// после него не обнуляется.
+func TestKeyUsagePersist(t *testing.T) {
+	setupTest(t)
+	k := APIKey{ID: "k1", Name: "partner"}
+	q := KeyQuota{Daily: 2}
+	now := time.Now()
+	for range 2 {
+		if ok, _, _ := countKeyRequest(k, q, now); !ok {
+			t.Fatal("запрос в пределах квоты отклонен")
+		}
+	}
+	if err := flushKeyUsages(); err != nil {
+		t.Fatal(err)
+	}
+
+	keyUsages = make(map[string]*keyUsage)
+	if err := loadKeyUsages(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := countKeyRequest(k, q, now); ok {
+		t.Error("после перезапуска квота снова доступна")
+	}
+}
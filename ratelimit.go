@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig задает ограничения частоты запросов. Скорость — в запросах
+// в секунду, Burst — сколько запросов можно сделать разом.
+type RateLimitConfig struct {
+	Enabled     bool    `json:"enabled"`
+	RPS         float64 `json:"rps"`   // на IP-адрес
+	Burst       int     `json:"burst"` // на IP-адрес
+	APIKeyRPS   float64 `json:"apiKeyRPS"`
+	APIKeyBurst int     `json:"apiKeyBurst"`
+}
+
+// tokenBucket — корзина токенов одного клиента.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter хранит корзины по ключу ("ip:..." или "key:...").
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// bucketIdle — через сколько простоя корзина удаляется: к этому моменту она
+// гарантированно снова полна.
+const bucketIdle = 10 * time.Minute
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow списывает токен из корзины key. Если токенов нет, возвращает время,
+// через которое появится следующий.
+func (l *rateLimiter) allow(key string, rps float64, burst int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > bucketIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+var limiter = newRateLimiter()
+
+// rateLimit ограничивает частоту запросов: для запросов с действительным
+// API-ключом — по ключу, для остальных — по IP-адресу.
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := liveConfig().RateLimit
+		if !rl.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, rps, burst := "ip:"+clientIP(r), rl.RPS, rl.Burst
+		if plain := r.Header.Get(apiKeyHeader); plain != "" {
+			// Неверный ключ не дает отдельной корзины, иначе случайными
+			// ключами можно было бы обойти ограничение по IP.
+			if k, err := lookupAPIKey(plain); err == nil {
+				key, rps, burst = "key:"+k.ID, rl.APIKeyRPS, rl.APIKeyBurst
+			}
+		}
+
+		ok, wait := limiter.allow(key, rps, burst, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Слишком много запросов", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
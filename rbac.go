@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Role — роль, которая выдается пользователю или API-ключу.
+type Role string
+
+// Роли упорядочены по возрастанию прав: каждая следующая включает
+// права предыдущей.
+const (
+	RoleViewer Role = "viewer" // только чтение
+	RoleEditor Role = "editor" // создание и изменение
+	RoleAdmin  Role = "admin"  // удаление и администрирование
+)
+
+var roleLevels = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Valid сообщает, известна ли роль.
+func (r Role) Valid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// Allows сообщает, достаточно ли прав роли r для действия, требующего роль need.
+func (r Role) Allows(need Role) bool {
+	return roleLevels[r] >= roleLevels[need]
+}
+
+// roleForMethod возвращает минимальную роль для HTTP-метода: GET — просмотр,
+// POST/PUT/PATCH — редактирование, DELETE — администрирование.
+func roleForMethod(method string) Role {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleViewer
+	case http.MethodDelete:
+		return RoleAdmin
+	default:
+		return RoleEditor
+	}
+}
+
+// apiError — структурированный ответ об ошибке.
+type apiError struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	Role     Role   `json:"role,omitempty"`
+	Required Role   `json:"required,omitempty"`
+}
+
+// writeAPIError отвечает ошибкой на языке запроса.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, e apiError) {
+	e.Message = translate(localeFrom(r), e.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// requireRole пропускает только отправителей с ролью не ниже need.
+func requireRole(need Role, next http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r)
+		if !p.Role.Allows(need) {
+			writeAPIError(w, r, http.StatusForbidden, apiError{
+				Error:    "forbidden",
+				Message:  "Недостаточно прав для выполнения операции",
+				Role:     p.Role,
+				Required: need,
+			})
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireMethodRole определяет нужную роль по методу запроса.
+func requireMethodRole(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requireRole(roleForMethod(r.Method), next)(w, r)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestDeleteRequiresAdmin проверяет правило ролей: удалять может только
+// администратор, в том числе метки и адреса клиента.
+func TestDeleteRequiresAdmin(t *testing.T) {
+	var ops []apiOperation
+	for _, e := range clientAPI {
+		ops = append(ops, e.op)
+	}
+	for _, e := range orderAPI {
+		ops = append(ops, e.op)
+	}
+	for _, e := range menuAPI {
+		ops = append(ops, e.op)
+	}
+	for _, e := range segmentAPI {
+		ops = append(ops, e.op)
+	}
+	for _, op := range ops {
+		if op.Method == http.MethodDelete && op.Role != roleForMethod(op.Method) {
+			t.Errorf("DELETE %s: роль %s, ожидалась %s", op.Path, op.Role, roleForMethod(op.Method))
+		}
+	}
+
+	setupTest(t)
+	clients = map[int]Client{1: {ID: 1, Name: "Айгерим", Version: 1, Tags: []string{"vip"},
+		Addresses: []Address{{Type: "home", City: "Алматы"}}}}
+	mux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+	t.Cleanup(func() { http.DefaultServeMux = mux; apiOperations = nil })
+	for _, e := range clientAPI {
+		handleAPI(e.op, e.h)
+	}
+	h := withTenant(http.DefaultServeMux)
+	editor := testToken(t, "editor", RoleEditor, "")
+	for _, target := range []string{"/clients/1/tags/vip", "/clients/1/addresses/home"} {
+		if w := testRequest(h, http.MethodDelete, target, editor, "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("редактор: DELETE %s: статус %d", target, w.Code)
+		}
+	}
+	if c := clients[1]; len(c.Tags) != 1 || len(c.Addresses) != 1 {
+		t.Errorf("клиент изменен: %+v", c)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Перезагрузка настроек без перезапуска: по SIGHUP или POST /admin/reload
+// файл конфигурации читается заново, и разделы из reloadableSections
+// применяются сразу, не разрывая соединений. Остальные разделы меняются
+// только перезапуском; перезагрузка сообщает, какие из них отличаются от
+// работающих. Настройки, измененные через /admin/settings, накладываются
+// на файл и при перезагрузке (settings.go). Заодно перечитывается список
+// вебхуков из webhooks.json.
+
+// reloadableSections — разделы конфигурации (по имени в JSON), которые
+// применяются при перезагрузке. Из раздела log применяется только уровень:
+// файл журнала открыт при запуске, и его настройки ждут перезапуска.
+var reloadableSections = []string{"rateLimit", "quotas", "proxy", "cors", "webhooks", "outbound", "log"}
+
+var (
+	configPath string       // Файл конфигурации, из которого запущен сервер
+	configMu   sync.RWMutex // Мьютекс для защиты разделов config, меняющихся при перезагрузке
+	reloadMu   sync.Mutex   // Перезагрузки идут по одной
+)
+
+// liveConfig возвращает текущие настройки. Разделы из reloadableSections
+// читаются только через нее.
+func liveConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// reloadResult — итог перезагрузки.
+type reloadResult struct {
+	Applied         []string `json:"applied"`         // измененные разделы, которые уже действуют
+	RestartRequired []string `json:"restartRequired"` // измененные разделы, которым нужен перезапуск
+	Webhooks        int      `json:"webhooks"`        // вебхуков после перечитывания
+}
+
+// reloadConfig перечитывает конфигурацию и вебхуки. При ошибке в файле
+// работающие настройки не меняются.
+func reloadConfig() (reloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	res := reloadResult{Applied: []string{}, RestartRequired: []string{}}
+	next, err := loadConfig(configPath)
+	if err != nil {
+		return res, err
+	}
+
+	configMu.Lock()
+	logFile := next.Log
+	logFile.Level = config.Log.Level
+	if !reflect.DeepEqual(logFile, config.Log) {
+		res.RestartRequired = append(res.RestartRequired, "log")
+		level := next.Log.Level
+		next.Log = config.Log
+		next.Log.Level = level
+	}
+	cur := reflect.ValueOf(&config).Elem()
+	upd := reflect.ValueOf(next)
+	for i := range cur.NumField() {
+		name, _, _ := strings.Cut(cur.Type().Field(i).Tag.Get("json"), ",")
+		if reflect.DeepEqual(cur.Field(i).Interface(), upd.Field(i).Interface()) {
+			continue
+		}
+		if !slices.Contains(reloadableSections, name) {
+			res.RestartRequired = append(res.RestartRequired, name)
+			continue
+		}
+		cur.Field(i).Set(upd.Field(i))
+		res.Applied = append(res.Applied, name)
+	}
+	level := config.Log.level()
+	configMu.Unlock()
+	logLevel.Set(level)
+
+	if res.Webhooks, err = reloadWebhooks(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// reloadWebhooks заменяет вебхуки содержимым webhooks.json и возвращает их
+// число.
+func reloadWebhooks() (int, error) {
+	next := make(map[string]*Webhook)
+	data, err := os.ReadFile(webhooksPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &next); err != nil {
+			return 0, fmt.Errorf("разбор %s: %w", webhooksPath(), err)
+		}
+	}
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	webhooks = next
+	return len(next), nil
+}
+
+// logReload печатает итог перезагрузки по SIGHUP.
+func logReload(res reloadResult, err error) {
+	if err != nil {
+		logError("Ошибка перезагрузки конфигурации: %v", err)
+		return
+	}
+	logf("Конфигурация перезагружена: применено %v, нужен перезапуск %v, вебхуков %d",
+		res.Applied, res.RestartRequired, res.Webhooks)
+}
+
+// reloadHandler перезагружает конфигурацию: POST /admin/reload.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := reloadConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Реплика только для чтения. Ведущий сервер отдает поток репликации
+// GET /admin/replication/stream (Server-Sent Events): сначала snapshot со
+// всеми клиентами и кофейнями, затем каждое изменение клиента. Реплика
+// (replica.primary) держит этот поток открытым, применяет изменения к
+// своему хранилищу в памяти и отвечает на чтение, а изменения отклоняет.
+// После разрыва реплика продолжает с Last-Event-ID; если ведущий
+// перезапустился, восстановил хранилище из снимка или добавил кофейню
+// (сменилась эпоха) либо нужные события уже вытеснены из буфера, приходит
+// новый snapshot.
+//
+// Реплика подписывает запросы к ведущему своим JWT, поэтому auth.jwtSecret
+// у них должен быть общим — он нужен и для того, чтобы токены с ведущего
+// принимались репликами. Заказы, баллы и заметки не реплицируются.
+
+// ReplicaConfig включает режим реплики.
+type ReplicaConfig struct {
+	// Primary — адрес ведущего сервера, например http://10.0.0.5:8090;
+	// пусто — сервер сам ведущий.
+	Primary string   `json:"primary"`
+	Retry   Duration `json:"retry"` // пауза перед переподключением
+}
+
+func (c ReplicaConfig) enabled() bool { return c.Primary != "" }
+
+func (c ReplicaConfig) validate(auth AuthConfig) error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.Primary)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("replica.primary должен быть адресом http(s)://хост:порт")
+	}
+	if auth.JWTSecret == "" {
+		return errors.New("replica: нужен auth.jwtSecret, общий с ведущим сервером")
+	}
+	if c.Retry <= 0 {
+		return errors.New("replica.retry должен быть положительным")
+	}
+	return nil
+}
+
+// replicationSnapshot — первое сообщение потока и сообщение после смены
+// эпохи.
+type replicationSnapshot struct {
+	Epoch   string   `json:"epoch"`
+	Clients []Client `json:"clients"`
+	Tenants []Tenant `json:"tenants"`
+}
+
+// replicationEpoch меняется при каждом запуске ведущего и при изменениях,
+// которые не проходят через события клиентов. Защищено streamMu.
+var replicationEpoch = randomHex(8)
+
+// resyncReplicas заставляет реплики заново загрузить snapshot.
+func resyncReplicas() {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	replicationEpoch = randomHex(8)
+	for ch := range streamListeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func currentReplicationEpoch() string {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	return replicationEpoch
+}
+
+// replicationSnapshotAndListen снимает хранилище и подписывается на
+// события атомарно, как snapshotAndListen, но для всех кофеен.
+func replicationSnapshotAndListen() (replicationSnapshot, chan struct{}, uint64) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	// Эпоха читается до кофеен: кофейня, добавленная после чтения
+	// списка, сменит эпоху и вызовет новый snapshot.
+	snap := replicationSnapshot{Epoch: currentReplicationEpoch(), Clients: make([]Client, 0, len(clients))}
+	for _, c := range clients {
+		snap.Clients = append(snap.Clients, c)
+	}
+	sort.Slice(snap.Clients, func(i, j int) bool { return snap.Clients[i].ID < snap.Clients[j].ID })
+	tenantsMu.Lock()
+	for _, t := range tenants {
+		snap.Tenants = append(snap.Tenants, t)
+	}
+	tenantsMu.Unlock()
+	sort.Slice(snap.Tenants, func(i, j int) bool { return snap.Tenants[i].ID < snap.Tenants[j].ID })
+
+	wake, last := listenStream()
+	return snap, wake, last
+}
+
+// replicaConn — реплика, подключенная к потоку.
+type replicaConn struct {
+	Addr        string    `json:"addr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+var (
+	replicaConns   = make(map[*replicaConn]bool) // Подключенные реплики
+	replicaConnsMu sync.Mutex                    // Мьютекс для защиты списка реплик
+)
+
+// replicationStreamHandler отдает поток репликации:
+// GET /admin/replication/stream. Параметр epoch и Last-Event-ID
+// передаются при переподключении.
+func replicationStreamHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+			return
+		}
+		var after uint64
+		resume := false
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			var err error
+			if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "Неверный Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			resume = r.URL.Query().Get("epoch") == currentReplicationEpoch()
+		}
+
+		conn := &replicaConn{Addr: clientIP(r), ConnectedAt: time.Now()}
+		replicaConnsMu.Lock()
+		replicaConns[conn] = true
+		replicaConnsMu.Unlock()
+		defer func() {
+			replicaConnsMu.Lock()
+			delete(replicaConns, conn)
+			replicaConnsMu.Unlock()
+		}()
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		var wake chan struct{}
+		var epoch string
+		sendSnapshot := func() error {
+			if wake != nil {
+				unlistenStream(wake)
+			}
+			var snap replicationSnapshot
+			snap, wake, after = replicationSnapshotAndListen()
+			epoch = snap.Epoch
+			data, err := json.Marshal(snap)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: snapshot\ndata: %s\n\n", after, data)
+			return err
+		}
+		defer func() { unlistenStream(wake) }()
+		if resume {
+			wake, _ = listenStream()
+			epoch = r.URL.Query().Get("epoch")
+			wake <- struct{}{}
+		} else if err := sendSnapshot(); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case <-wake:
+				events, last, reset := streamMatching(after, nil)
+				if reset || currentReplicationEpoch() != epoch {
+					if err := sendSnapshot(); err != nil {
+						return
+					}
+					break
+				}
+				for _, e := range events {
+					if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data); err != nil {
+						return
+					}
+				}
+				after = last
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// replicaState — состояние реплики для GET /admin/replication и проверки
+// replica на /status.
+type replicaState struct {
+	Primary     string     `json:"primary"`
+	Connected   bool       `json:"connected"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	Epoch       string     `json:"epoch,omitempty"`
+	LastEventID uint64     `json:"lastEventId"`
+	LastEventAt *time.Time `json:"lastEventAt,omitempty"` // последнее примененное изменение
+	SyncedAt    *time.Time `json:"syncedAt,omitempty"`    // последний snapshot
+	LastError   string     `json:"lastError,omitempty"`
+
+	lastSeen time.Time // последнее сообщение или ping от ведущего
+}
+
+var (
+	replica   replicaState // Состояние реплики
+	replicaMu sync.Mutex   // Мьютекс для защиты состояния реплики
+)
+
+// replicationEvent — изменение клиента в потоке, см. streamOnClientEvent.
+type replicationEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Client Client    `json:"client"`
+}
+
+// runReplica держит поток репликации открытым до остановки сервера.
+func runReplica(ctx context.Context) {
+	replicaMu.Lock()
+	replica.Primary = config.Replica.Primary
+	replicaMu.Unlock()
+	for {
+		err := replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		replicaMu.Lock()
+		if replica.Connected {
+			logError("Ошибка репликации с %s: %v", config.Replica.Primary, err)
+		}
+		replica.Connected, replica.ConnectedAt = false, nil
+		replica.LastError = err.Error()
+		replicaMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(config.Replica.Retry)):
+		}
+	}
+}
+
+// replicate читает один поток репликации до его разрыва.
+func replicate(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	replicaMu.Lock()
+	epoch, after := replica.Epoch, replica.LastEventID
+	replicaMu.Unlock()
+
+	target := strings.TrimSuffix(config.Replica.Primary, "/") + "/admin/replication/stream"
+	if epoch != "" {
+		target += "?epoch=" + url.QueryEscape(epoch)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	token, err := signJWT(jwtClaims{Subject: "replica", Role: RoleAdmin, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}, jwtSecret)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	if epoch != "" {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(after, 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ведущий сервер ответил %s", resp.Status)
+	}
+
+	// Ведущий шлет ping каждые streamHeartbeat; тишина втрое дольше
+	// означает, что соединение потеряно.
+	var stalled atomic.Bool
+	idle := time.AfterFunc(3*streamHeartbeat, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer idle.Stop()
+
+	connected := time.Now()
+	replicaMu.Lock()
+	replica.Connected, replica.ConnectedAt, replica.LastError = true, &connected, ""
+	replica.lastSeen = connected
+	replicaMu.Unlock()
+	logf("Реплика подключена к %s", config.Replica.Primary)
+
+	var id uint64
+	var event string
+	var data []byte
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		switch {
+		case stalled.Load():
+			return errors.New("нет данных от ведущего сервера")
+		case errors.Is(err, io.EOF):
+			return errors.New("ведущий сервер закрыл поток")
+		case err != nil:
+			return err
+		}
+		idle.Reset(3 * streamHeartbeat)
+		replicaMu.Lock()
+		replica.lastSeen = time.Now()
+		replicaMu.Unlock()
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			field, value, _ := bytes.Cut(line, []byte(":"))
+			value = bytes.TrimPrefix(value, []byte(" "))
+			switch string(field) {
+			case "id":
+				id, _ = strconv.ParseUint(string(value), 10, 64)
+			case "event":
+				event = string(value)
+			case "data":
+				data = append(data, value...)
+			}
+			continue
+		}
+		if event != "" {
+			if err := applyReplication(event, id, data); err != nil {
+				return err
+			}
+		}
+		event, data = "", data[:0]
+	}
+}
+
+// applyReplication применяет сообщение потока к хранилищу.
+func applyReplication(event string, id uint64, data []byte) error {
+	now := time.Now()
+	if event == "snapshot" {
+		var snap replicationSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("разбор snapshot: %w", err)
+		}
+		applyReplicaSnapshot(snap)
+		replicaMu.Lock()
+		replica.Epoch, replica.LastEventID, replica.SyncedAt = snap.Epoch, id, &now
+		replicaMu.Unlock()
+		logf("Реплика загрузила snapshot: клиентов %d", len(snap.Clients))
+		return nil
+	}
+
+	var e replicationEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("разбор события %s: %w", event, err)
+	}
+	clientsMu.Lock()
+	if e.Type == eventClientPurged {
+		delete(clients, e.Client.ID)
+	} else {
+		clients[e.Client.ID] = e.Client
+	}
+	publishClientEvent(e.Type, e.Client, sourceReplica)
+	clientsMu.Unlock()
+
+	replicaMu.Lock()
+	replica.LastEventID, replica.LastEventAt = id, &e.Time
+	replicaMu.Unlock()
+	return nil
+}
+
+// applyReplicaSnapshot заменяет хранилище снимком ведущего. Для каждого
+// отличающегося клиента публикуется событие, чтобы индексы, кэш и потоки
+// реплики увидели изменения.
+func applyReplicaSnapshot(snap replicationSnapshot) {
+	next := make(map[string]Tenant, len(snap.Tenants))
+	for _, t := range snap.Tenants {
+		next[t.ID] = t
+	}
+	tenantsMu.Lock()
+	tenants = next
+	tenantsMu.Unlock()
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	incoming := make(map[int]bool, len(snap.Clients))
+	for _, c := range snap.Clients {
+		incoming[c.ID] = true
+		cur, exists := clients[c.ID]
+		if exists && cur.Version == c.Version && cur.deleted() == c.deleted() {
+			continue
+		}
+		clients[c.ID] = c
+		switch {
+		case !exists:
+			publishClientEvent(eventClientCreated, c, sourceReplica)
+		case c.deleted() && !cur.deleted():
+			publishClientEvent(eventClientDeleted, c, sourceReplica)
+		default:
+			publishClientEvent(eventClientUpdated, c, sourceReplica)
+		}
+	}
+	for id, c := range clients {
+		if !incoming[id] {
+			delete(clients, id)
+			publishClientEvent(eventClientPurged, c, sourceReplica)
+		}
+	}
+}
+
+// probeReplica проверяет, что реплика получает поток от ведущего.
+func probeReplica(context.Context) error {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+	switch {
+	case !replica.Connected && replica.LastError != "":
+		return errors.New(replica.LastError)
+	case !replica.Connected:
+		return errors.New("нет соединения с ведущим сервером")
+	case time.Since(replica.lastSeen) > 3*streamHeartbeat:
+		return errors.New("нет данных от ведущего сервера")
+	}
+	return nil
+}
+
+// withReplica отклоняет изменения на реплике: они выполняются на ведущем
+// сервере. Перечитать настройки и сбросить кэш можно и на реплике.
+func withReplica(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Replica.enabled() && mutatingRequest(r) &&
+			r.URL.Path != "/admin/reload" && r.URL.Path != "/admin/cache" && r.URL.Path != "/admin/settings" {
+			writeAPIError(w, r, http.StatusMisdirectedRequest, apiError{Error: "read_only_replica", Message: replicaMessage})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+const replicaMessage = "Реплика только для чтения: изменения выполняются на ведущем сервере"
+
+// replicationHandler показывает состояние репликации: GET
+// /admin/replication. На ведущем — эпоху и подключенные реплики, на
+// реплике — состояние потока.
+func replicationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if config.Replica.enabled() {
+		replicaMu.Lock()
+		st := replica
+		replicaMu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"role": "replica", "replica": st})
+		return
+	}
+
+	streamMu.Lock()
+	epoch, last := replicationEpoch, streamLastID
+	streamMu.Unlock()
+	replicaConnsMu.Lock()
+	list := make([]replicaConn, 0, len(replicaConns))
+	for c := range replicaConns {
+		list = append(list, *c)
+	}
+	replicaConnsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedAt.Before(list[j].ConnectedAt) })
+	json.NewEncoder(w).Encode(map[string]any{"role": "primary", "epoch": epoch, "lastEventId": last, "replicas": list})
+}
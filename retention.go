@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetentionConfig задает срок хранения клиентов. Задача retention по
+// расписанию окончательно удаляет клиентов, у которых давно не было
+// активности, и мягко удаленных по истечении срока. 0 отключает правило.
+type RetentionConfig struct {
+	Enabled      bool `json:"enabled"`
+	InactiveDays int  `json:"inactiveDays"` // дней без регистрации, заказов, операций с баллами и визитов
+	DeletedDays  int  `json:"deletedDays"`  // дней после мягкого удаления
+}
+
+func (c RetentionConfig) validate() error {
+	if c.InactiveDays < 0 || c.DeletedDays < 0 {
+		return errors.New("retention: сроки не могут быть отрицательными")
+	}
+	if c.Enabled && c.InactiveDays == 0 && c.DeletedDays == 0 {
+		return errors.New("retention: укажите inactiveDays или deletedDays")
+	}
+	return nil
+}
+
+// Правила, по которым клиент попадает под удаление.
+const (
+	retentionInactive = "inactive"
+	retentionDeleted  = "deleted"
+)
+
+// retentionCandidate — клиент, которого удалит политика хранения.
+type retentionCandidate struct {
+	ClientID     int        `json:"clientId"`
+	Tenant       string     `json:"tenant,omitempty"`
+	Rule         string     `json:"rule"`
+	LastActivity time.Time  `json:"lastActivity"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+}
+
+// retentionRecord — запись журнала удалений по сроку хранения.
+type retentionRecord struct {
+	retentionCandidate
+	PurgedAt time.Time `json:"purgedAt"`
+}
+
+var (
+	retentionLog   []retentionRecord // Журнал удалений по сроку хранения, новые последними
+	retentionLogMu sync.Mutex        // Мьютекс для защиты журнала; берется после clientsMu
+)
+
+func retentionLogPath() string {
+	return filepath.Join(config.DataDir, "retention.json")
+}
+
+// loadRetentionLog читает журнал удалений по сроку хранения.
+func loadRetentionLog() error {
+	data, err := os.ReadFile(retentionLogPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	retentionLogMu.Lock()
+	defer retentionLogMu.Unlock()
+	if err := json.Unmarshal(data, &retentionLog); err != nil {
+		return fmt.Errorf("разбор %s: %w", retentionLogPath(), err)
+	}
+	return nil
+}
+
+// retentionCandidatesLocked возвращает клиентов, которых политика cfg
+// удалила бы в момент now, по возрастанию ID. Активность — самое позднее из
+// регистрации, заказов, операций с баллами и визитов; клиентов без известной
+// активности правило inactive не трогает. Вызывается под clientsMu.
+func retentionCandidatesLocked(cfg RetentionConfig, now time.Time) []retentionCandidate {
+	last := make(map[int]time.Time, len(clients))
+	for id, c := range clients {
+		last[id] = c.RegisterDate
+	}
+	touch := func(id int, t time.Time) {
+		if cur, ok := last[id]; ok && t.After(cur) {
+			last[id] = t
+		}
+	}
+	ordersMu.Lock()
+	for _, o := range orders {
+		touch(o.ClientID, o.CreatedAt)
+	}
+	ordersMu.Unlock()
+	loyaltyMu.Lock()
+	for _, t := range loyalty.Transactions {
+		touch(t.ClientID, t.At)
+	}
+	loyaltyMu.Unlock()
+	lastSeenMu.Lock()
+	for id, t := range lastSeen {
+		touch(id, t)
+	}
+	lastSeenMu.Unlock()
+
+	list := []retentionCandidate{}
+	for id, c := range clients {
+		cand := retentionCandidate{ClientID: id, Tenant: c.Tenant, LastActivity: last[id], DeletedAt: c.DeletedAt}
+		switch {
+		case cfg.DeletedDays > 0 && c.deleted() && c.DeletedAt.Before(now.AddDate(0, 0, -cfg.DeletedDays)):
+			cand.Rule = retentionDeleted
+		case cfg.InactiveDays > 0 && !cand.LastActivity.IsZero() && cand.LastActivity.Before(now.AddDate(0, 0, -cfg.InactiveDays)):
+			cand.Rule = retentionInactive
+		default:
+			continue
+		}
+		list = append(list, cand)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return list
+}
+
+// applyRetention окончательно удаляет клиентов по политике хранения и
+// записывает каждое удаление в журнал.
+func applyRetention(cfg RetentionConfig, now time.Time) ([]retentionRecord, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	var records []retentionRecord
+	var archiveErr error
+	for _, cand := range retentionCandidatesLocked(cfg, now) {
+		c := clients[cand.ClientID]
+		if err := archiveClientLocked(c, sourceJob); err != nil {
+			// Клиент останется до следующего запуска.
+			archiveErr = errors.Join(archiveErr, err)
+			continue
+		}
+		delete(clients, cand.ClientID)
+		publishClientEvent(eventClientPurged, c, sourceJob)
+		records = append(records, retentionRecord{cand, now})
+	}
+	if len(records) == 0 {
+		return nil, archiveErr
+	}
+
+	retentionLogMu.Lock()
+	defer retentionLogMu.Unlock()
+	retentionLog = append(retentionLog, records...)
+	if err := writeJSONFile(retentionLogPath(), retentionLog); err != nil {
+		// Клиенты уже удалены; записи остаются в памяти и сохранятся со следующими.
+		return records, errors.Join(archiveErr, fmt.Errorf("сохранение журнала удалений: %w", err))
+	}
+	return records, archiveErr
+}
+
+// retentionJob применяет политику хранения; регистрируется, только если
+// retention.enabled.
+var retentionJob = &scheduledJob{
+	Name:     "retention",
+	Schedule: "0 4 * * *",
+	Run: func(ctx context.Context) error {
+		records, err := applyRetention(config.Retention, time.Now())
+		if len(records) > 0 {
+			logf("Политика хранения: удалено клиентов: %d", len(records))
+		}
+		return err
+	},
+}
+
+// retentionPreviewHandler показывает, кого удалит политика хранения, ничего
+// не удаляя: GET /admin/retention. Параметры ?inactiveDays= и ?deletedDays=
+// заменяют сроки из настроек, чтобы оценить политику до включения.
+func retentionPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := config.Retention
+	q := r.URL.Query()
+	params := []struct {
+		name string
+		p    *int
+	}{{"inactiveDays", &cfg.InactiveDays}, {"deletedDays", &cfg.DeletedDays}}
+	for _, param := range params {
+		name, p := param.name, param.p
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("%s: ожидается неотрицательное число", name), http.StatusBadRequest)
+			return
+		}
+		*p = n
+	}
+
+	clientsMu.Lock()
+	list := retentionCandidatesLocked(cfg, time.Now())
+	clientsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"enabled":      cfg.Enabled,
+		"inactiveDays": cfg.InactiveDays,
+		"deletedDays":  cfg.DeletedDays,
+		"candidates":   list,
+	})
+}
+
+// retentionLogHandler возвращает журнал удалений по сроку хранения:
+// GET /admin/retention/log, ?clientId= — записи одного клиента.
+func retentionLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := 0
+	if v := r.URL.Query().Get("clientId"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "clientId: ожидается число", http.StatusBadRequest)
+			return
+		}
+		clientID = n
+	}
+
+	retentionLogMu.Lock()
+	defer retentionLogMu.Unlock()
+	list := []retentionRecord{}
+	for _, rec := range retentionLog {
+		if clientID == 0 || rec.ClientID == clientID {
+			list = append(list, rec)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
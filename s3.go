@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Config задает S3-совместимое хранилище: AWS, MinIO, Yandex Object
+// Storage и т. п. Пустые ключи доступа берутся из AWS_ACCESS_KEY_ID и
+// AWS_SECRET_ACCESS_KEY.
+type S3Config struct {
+	Endpoint        string   `json:"endpoint"` // по умолчанию https://s3.<region>.amazonaws.com
+	Region          string   `json:"region"`
+	Bucket          string   `json:"bucket"`
+	Prefix          string   `json:"prefix"` // добавляется к ключам, например "coffee/"
+	AccessKeyID     string   `json:"accessKeyId"`
+	SecretAccessKey string   `json:"secretAccessKey"`
+	PathStyle       bool     `json:"pathStyle"` // адрес <endpoint>/<bucket> вместо <bucket>.<host>; нужен MinIO
+	Timeout         Duration `json:"timeout"`
+}
+
+// validate проверяет настройки до запуска сервера.
+func (c S3Config) validate() error {
+	if c.Bucket == "" || c.Region == "" {
+		return fmt.Errorf("blobs.s3: bucket и region обязательны")
+	}
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("blobs.s3: endpoint должен быть адресом http(s)://")
+		}
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("blobs.s3: timeout должен быть положительным")
+	}
+	return nil
+}
+
+// s3BlobStore работает с бакетом по REST API S3 с подписью Signature V4.
+type s3BlobStore struct {
+	cfg    S3Config
+	base   *url.URL // адрес бакета
+	client *outboundClient
+}
+
+// newS3BlobStore подключает бакет; name — имя в счетчиках исходящих
+// запросов.
+func newS3BlobStore(name string, cfg S3Config) (*s3BlobStore, error) {
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if cfg.SecretAccessKey == "" {
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("blobs.s3: не заданы ключи доступа")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	base, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PathStyle {
+		base.Path += "/" + cfg.Bucket
+	} else {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+	// PUT и DELETE идемпотентны, повторять их безопасно и без RetryPost.
+	client := newOutboundClient(name, outboundOptions{Timeout: time.Duration(cfg.Timeout)})
+	return &s3BlobStore{cfg: cfg, base: base, client: client}, nil
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, b Blob) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+	h := http.Header{}
+	if b.ContentType != "" {
+		h.Set("Content-Type", b.ContentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+key, nil, h, b.Data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string) (Blob, error) {
+	if err := checkBlobKey(key); err != nil {
+		return Blob{}, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+key, nil, nil, nil)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Blob{}, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Blob{Data: data, ContentType: resp.Header.Get("Content-Type"), ModTime: modTime}, nil
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+	// S3 отвечает 204 и на удаление несуществующего объекта.
+	resp, err := s.do(ctx, http.MethodDelete, s.cfg.Prefix+key, nil, nil, nil)
+	if errors.Is(err, errBlobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ListResult — ответ ListObjectsV2.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3BlobStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var list []BlobInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("S3: разбор списка объектов: %w", err)
+		}
+		for _, o := range res.Contents {
+			list = append(list, BlobInfo{Key: strings.TrimPrefix(o.Key, s.cfg.Prefix), Size: o.Size, ModTime: o.LastModified})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return list, nil
+		}
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+// s3Error — тело ответа S3 с ошибкой.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do подписывает и выполняет запрос к объекту key (пустой key — бакет).
+// Ответ 404 возвращается как errBlobNotFound, остальные ответы не из 2xx —
+// как ошибка с кодом S3.
+func (s *s3BlobStore) do(ctx context.Context, method, key string, query url.Values, h http.Header, body []byte) (*http.Response, error) {
+	u := *s.base
+	if key != "" {
+		u.Path += "/" + key
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	// Подпись считается по пути, закодированному строже, чем это делает net/url.
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, errBlobNotFound
+	}
+	var e s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("S3 %s %s: %s %s", method, key, e.Code, e.Message)
+}
+
+// sign добавляет к запросу заголовки подписи AWS Signature V4.
+func (s *s3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Подписываются host, content-type и все x-amz-* в алфавитном порядке.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3Escape кодирует строку по правилам S3: без изменений остаются только
+// буквы, цифры и -_.~ (и "/" в пути при keepSlash).
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// s3CanonicalQuery собирает строку запроса в каноническом для подписи виде:
+// параметры по алфавиту, значения закодированы по правилам S3.
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchedulerConfig задает запуск задач по расписанию. Jobs переопределяет
+// расписание задачи по имени; "off" отключает задачу. При Enabled = false
+// задачи запускаются только вручную через /admin/jobs.
+type SchedulerConfig struct {
+	Enabled bool              `json:"enabled"`
+	Jobs    map[string]string `json:"jobs"`
+}
+
+// scheduleOff в scheduler.jobs отключает задачу.
+const scheduleOff = "off"
+
+// scheduledJob — периодическая задача. Schedule — выражение cron из пяти
+// полей (минута, час, день месяца, месяц, день недели) в местном времени,
+// сокращение @hourly, @daily, @weekly, @monthly или интервал "@every 1h".
+type scheduledJob struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) error
+
+	sched   schedule // nil — задача отключена
+	next    time.Time
+	running bool
+	state   jobState
+}
+
+// Итоги запуска задачи.
+const (
+	jobOK      = "ok"
+	jobFailed  = "failed"
+	jobSkipped = "skipped" // задачу выполнил другой экземпляр
+)
+
+// jobRun — итог одного запуска.
+type jobRun struct {
+	StartedAt time.Time `json:"startedAt"`
+	Duration  Duration  `json:"duration"`
+	Trigger   string    `json:"trigger"` // schedule или manual
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// jobState — сохраняемая история задачи.
+type jobState struct {
+	LastRun     *jobRun    `json:"lastRun,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+var (
+	scheduledJobs = make(map[string]*scheduledJob) // Задачи по расписанию
+	schedulerMu   sync.Mutex                       // Мьютекс для защиты задач и их состояния
+	schedulerCtx  = context.Background()           // Родительский контекст запусков
+	schedulerWG   sync.WaitGroup
+)
+
+var errJobBusy = errors.New("задача уже выполняется")
+
+// registerScheduledJob добавляет задачу с учетом scheduler.jobs.
+// Вызывается при инициализации; неверное расписание в коде — ошибка
+// программиста.
+func registerScheduledJob(j *scheduledJob) {
+	if spec, ok := config.Scheduler.Jobs[j.Name]; ok {
+		j.Schedule = spec
+	}
+	if j.Schedule != scheduleOff {
+		s, err := parseSchedule(j.Schedule)
+		if err != nil {
+			panic(fmt.Sprintf("задача %s: %v", j.Name, err))
+		}
+		j.sched = s
+	}
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	scheduledJobs[j.Name] = j
+}
+
+func jobsPath() string {
+	return filepath.Join(config.DataDir, "jobs.json")
+}
+
+// loadJobStates читает историю запусков, чтобы после перезапуска было видно
+// итог последнего запуска.
+func loadJobStates() error {
+	data, err := os.ReadFile(jobsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var states map[string]jobState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("разбор %s: %w", jobsPath(), err)
+	}
+
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	for name, st := range states {
+		if j, ok := scheduledJobs[name]; ok {
+			j.state = st
+		}
+	}
+	return nil
+}
+
+func saveJobStatesLocked() {
+	states := make(map[string]jobState, len(scheduledJobs))
+	for name, j := range scheduledJobs {
+		states[name] = j.state
+	}
+	if err := writeJSONFile(jobsPath(), states); err != nil {
+		logError("Ошибка сохранения истории задач: %v", err)
+	}
+}
+
+// runScheduler запускает задачи по расписанию до отмены ctx. Запуски,
+// пропущенные, пока сервер не работал, не наверстываются.
+func runScheduler(ctx context.Context) {
+	schedulerMu.Lock()
+	schedulerCtx = ctx
+	for name := range config.Scheduler.Jobs {
+		if _, ok := scheduledJobs[name]; !ok {
+			logf("scheduler.jobs: неизвестная задача %s", name)
+		}
+	}
+	schedulerMu.Unlock()
+	if !config.Scheduler.Enabled {
+		return
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// Пробуждение не реже раза в минуту, чтобы перевод часов не сдвигал
+		// запуски надолго.
+		now := time.Now()
+		wake := now.Add(time.Minute)
+		schedulerMu.Lock()
+		for _, j := range scheduledJobs {
+			if j.sched == nil {
+				continue
+			}
+			if !j.next.IsZero() && !j.next.After(now) {
+				if err := startJobLocked(j, "schedule"); err != nil {
+					logf("Задача %s пропущена: %v", j.Name, err)
+				}
+				j.next = time.Time{}
+			}
+			if j.next.IsZero() {
+				j.next = j.sched.next(now)
+			}
+			if !j.next.IsZero() && j.next.Before(wake) {
+				wake = j.next
+			}
+		}
+		schedulerMu.Unlock()
+		timer.Reset(time.Until(wake))
+	}
+}
+
+// startJobLocked запускает задачу в фоне под арендой "job-<имя>", чтобы
+// при нескольких экземплярах она выполнилась один раз. Вызывается под
+// schedulerMu.
+func startJobLocked(j *scheduledJob, trigger string) error {
+	if j.running {
+		return errJobBusy
+	}
+	if err := schedulerCtx.Err(); err != nil {
+		return err
+	}
+	j.running = true
+	ctx := schedulerCtx
+
+	schedulerWG.Add(1)
+	go func() {
+		defer schedulerWG.Done()
+		run := jobRun{StartedAt: time.Now(), Trigger: trigger, Status: jobOK}
+		err := runExclusive(ctx, "job-"+j.Name, j.Run)
+		run.Duration = Duration(time.Since(run.StartedAt).Round(time.Millisecond))
+		switch {
+		case errors.Is(err, errLeaseHeld):
+			run.Status = jobSkipped
+		case err != nil:
+			run.Status, run.Error = jobFailed, err.Error()
+			logError("Ошибка задачи %s: %v", j.Name, err)
+		}
+
+		schedulerMu.Lock()
+		defer schedulerMu.Unlock()
+		j.running = false
+		j.state.LastRun = &run
+		if run.Status == jobOK {
+			at := run.StartedAt
+			j.state.LastSuccess = &at
+		}
+		saveJobStatesLocked()
+	}()
+	return nil
+}
+
+// jobStatus — задача в ответе /admin/jobs.
+type jobStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"nextRun,omitempty"` // нет, если запуски по расписанию отключены
+	jobState
+}
+
+func jobStatusLocked(j *scheduledJob) jobStatus {
+	st := jobStatus{Name: j.Name, Schedule: j.Schedule, Running: j.running, jobState: j.state}
+	if !j.next.IsZero() {
+		next := j.next
+		st.NextRun = &next
+	}
+	return st
+}
+
+// jobsHandler показывает задачи по расписанию (GET) и запускает задачу
+// вне расписания (POST ?job=<имя>).
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		schedulerMu.Lock()
+		list := make([]jobStatus, 0, len(scheduledJobs))
+		for _, j := range scheduledJobs {
+			list = append(list, jobStatusLocked(j))
+		}
+		schedulerMu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		schedulerMu.Lock()
+		defer schedulerMu.Unlock()
+		j, ok := scheduledJobs[r.URL.Query().Get("job")]
+		if !ok {
+			http.Error(w, "Задача не найдена", http.StatusNotFound)
+			return
+		}
+		if err := startJobLocked(j, "manual"); errors.Is(err, errJobBusy) {
+			http.Error(w, "Задача уже выполняется", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Сервер останавливается", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(jobStatusLocked(j))
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
+
+// schedule вычисляет время следующего запуска.
+type schedule interface {
+	// next возвращает первое время запуска позже after; нулевое время —
+	// запусков больше не будет.
+	next(after time.Time) time.Time
+}
+
+// everySchedule — запуск через равные интервалы.
+type everySchedule time.Duration
+
+func (s everySchedule) next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule — расписание cron; поля — битовые маски допустимых значений.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Как в cron: если ограничены и день месяца, и день недели, подходит
+	// любой из них.
+	domAny, dowAny bool
+}
+
+// cronAliases — сокращения расписаний.
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// parseSchedule разбирает расписание задачи.
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if v, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("интервал %q должен быть длительностью не меньше 1s", v)
+		}
+		return everySchedule(d), nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("расписание %q: ожидается 5 полей cron или @every <интервал>", spec)
+	}
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("минуты: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("часы: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("день месяца: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("месяц: %w", err)
+	}
+	// Воскресенье можно указать и как 0, и как 7.
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("день недели: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField разбирает поле cron: "*", число, диапазон "a-b", список
+// через запятую, у "*" и диапазона — шаг "/n".
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("неверный шаг %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("неверное значение %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("неверное значение %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("значение %q вне диапазона %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c cronSchedule) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// forward переходит к началу следующего месяца, дня или часа; при
+	// переходе на летнее время time.Date может вернуть время не позже t.
+	forward := func(n time.Time) time.Time {
+		if !n.After(t) {
+			return t.Add(time.Hour)
+		}
+		return n
+	}
+	// Невыполнимое расписание вроде "0 0 31 2 *" ищется не дальше пяти лет.
+	for t.Year() <= after.Year()+5 {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = forward(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !c.dayMatches(t):
+			t = forward(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = forward(t.Add(time.Duration(60-t.Minute()) * time.Minute))
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
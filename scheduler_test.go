@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 1, 12, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"*/20", 0, 59, []int{0, 20, 40}},
+		{"7", 0, 59, []int{7}},
+		{"1-5/2", 0, 7, []int{1, 3, 5}},
+		{"10/25", 0, 59, []int{10, 35}},
+		{"3,1,1-2", 0, 59, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.min, tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want uint64
+			for _, v := range tt.want {
+				want |= 1 << uint(v)
+			}
+			if got != want {
+				t.Errorf("маска %b, ожидалась %b", got, want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"1-2-3 * * * *",
+		"a * * * *",
+		"@yearly",
+		"@every 500ms",
+		"@every час",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: расписание принято", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Четверг.
+	after := time.Date(2026, 10, 15, 10, 30, 20, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		spec string
+		want time.Time
+	}{
+		{"каждые 15 минут", "*/15 * * * *", at(10, 15, 10, 45)},
+		{"каждый час", "@hourly", at(10, 15, 11, 0)},
+		{"текущая минута уже прошла", "30 10 * * *", at(10, 16, 10, 30)},
+		{"ночью", "0 3 * * *", at(10, 16, 3, 0)},
+		{"список и диапазон", "5,10 8-9 * * *", at(10, 16, 8, 5)},
+		{"будни", "0 9 * * 1-5", at(10, 16, 9, 0)},
+		{"воскресенье как 0", "@weekly", at(10, 18, 0, 0)},
+		{"воскресенье как 7", "0 0 * * 7", at(10, 18, 0, 0)},
+		{"начало месяца", "@monthly", at(11, 1, 0, 0)},
+		{"31-е число", "0 0 31 * *", at(10, 31, 0, 0)},
+		{"день месяца или день недели", "0 12 13 * 5", at(10, 16, 12, 0)},
+		{"месяц", "0 0 * 12 *", at(12, 1, 0, 0)},
+		{"следующий год", "0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"29 февраля", "0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"невыполнимое", "0 0 31 2 *", time.Time{}},
+		{"интервал", "@every 90m", after.Add(90 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.next(after); !got.Equal(tt.want) {
+				t.Errorf("next = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
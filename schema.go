@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Схемы тел запросов. Для каждого эндпоинта с телом JSON из handleAPI
+// строится схема по тому же Go-типу, что и в /openapi.json, и тело
+// проверяется по ней до обработчика: ошибки приходят списком с путями
+// полей, а не одним «Ошибка парсинга тела запроса». Строгий режим
+// (validation.strict или заголовок Prefer: handling=strict) вдобавок
+// отклоняет неизвестные поля и null в полях, где схема его не допускает;
+// без него они, как и раньше, молча игнорируются. Схемы публикуются в
+// формате JSON Schema 2020-12 на /schemas/ и описывают строгий режим.
+// Обязательность полей схемы не задают: у многих полей есть значения по
+// умолчанию, проверяет их обработчик.
+
+// ValidationConfig задает проверку тел запросов по схемам.
+type ValidationConfig struct {
+	Strict bool `json:"strict"` // строгий режим для всех запросов
+}
+
+const (
+	jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	schemasPrefix     = "/schemas/"
+	schemaRefPrefix   = "#/components/schemas/"
+)
+
+// maxSchemaViolations ограничивает список ошибок в ответе.
+const maxSchemaViolations = 50
+
+// requestSchemas — именованные схемы тел запросов в виде
+// components.schemas OpenAPI; заполняется в handleAPI до запуска сервера.
+var requestSchemas = map[string]any{}
+
+// requestBodies — эндпоинты с проверяемым телом, для оглавления /schemas/.
+var requestBodies []requestBody
+
+type requestBody struct {
+	op     apiOperation
+	schema map[string]any
+}
+
+// schemaViolation — несоответствие тела схеме.
+type schemaViolation struct {
+	Path    string `json:"path"` // JSON Pointer; пусто — тело целиком
+	Message string `json:"message"`
+}
+
+// schemaError — ответ 400 на тело, не прошедшее проверку.
+type schemaError struct {
+	Error      string            `json:"error"`
+	Message    string            `json:"message"`
+	Schema     string            `json:"schema,omitempty"` // адрес схемы тела
+	Strict     bool              `json:"strict"`
+	Violations []schemaViolation `json:"violations"`
+}
+
+// jsonRequest сообщает, что тело эндпоинта в JSON и его можно проверить.
+func (op apiOperation) jsonRequest() bool {
+	return op.Request != nil && (op.RequestType == "" || op.RequestType == "application/json")
+}
+
+// withSchemaValidation проверяет тело запроса по схеме op.Request.
+// Тела не в JSON (XML, msgpack) и неразбираемый JSON передаются обработчику
+// как есть: ему и отвечать на них.
+func withSchemaValidation(op apiOperation, next http.HandlerFunc) http.HandlerFunc {
+	schema := schemaFor(reflect.TypeOf(op.Request), requestSchemas)
+	requestBodies = append(requestBodies, requestBody{op, schema})
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Эндпоинты без выбора формата читают тело как JSON при любом
+		// Content-Type, старые — почти при любом (см. requestCodec).
+		if c, ok := requestCodec(r.Header.Get("Content-Type"), op.Legacy || !op.Negotiated); !ok || c.ContentType != jsonCodec.ContentType {
+			next(w, r)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Тело запроса больше %d МБ", maxImportSize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if len(bytes.TrimSpace(data)) == 0 || dec.Decode(&body) != nil {
+			next(w, r)
+			return
+		}
+		strict := config.Validation.Strict
+		if preferStrict(r) {
+			strict = true
+			w.Header().Set("Preference-Applied", "handling=strict")
+		}
+		v := schemaValidator{strict: strict}
+		v.check(body, schema, "")
+		if len(v.violations) == 0 {
+			next(w, r)
+			return
+		}
+		loc := localeFrom(r)
+		for i := range v.violations {
+			v.violations[i].Message = translate(loc, v.violations[i].Message)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(schemaError{
+			Error:      "schema_violation",
+			Message:    translate(loc, "Тело запроса не соответствует схеме"),
+			Schema:     schemaURL(schema),
+			Strict:     strict,
+			Violations: v.violations,
+		})
+	}
+}
+
+// preferStrict сообщает, что клиент попросил строгую проверку заголовком
+// Prefer: handling=strict (RFC 7240).
+func preferStrict(r *http.Request) bool {
+	for _, h := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(h, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "handling") && strings.EqualFold(strings.Trim(strings.TrimSpace(value), `"`), "strict") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaValidator проверяет значение, разобранное с UseNumber, по схеме
+// из schemaFor.
+type schemaValidator struct {
+	strict     bool
+	violations []schemaViolation
+}
+
+func (v *schemaValidator) fail(path, msg string) {
+	if len(v.violations) < maxSchemaViolations {
+		v.violations = append(v.violations, schemaViolation{Path: path, Message: msg})
+	}
+}
+
+func (v *schemaValidator) check(value any, schema map[string]any, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := requestSchemas[strings.TrimPrefix(ref, schemaRefPrefix)].(map[string]any)
+		v.check(value, def, path)
+		return
+	}
+	if value == nil {
+		// encoding/json пропускает null в любом поле, кроме строгого режима.
+		if v.strict && schema["nullable"] != true {
+			v.fail(path, "null не допускается")
+		}
+		return
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, s := range all {
+			v.check(value, s.(map[string]any), path)
+		}
+		return
+	}
+
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(path, "ожидается string")
+			return
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				v.fail(path, "ожидается время RFC 3339")
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "ожидается boolean")
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := strconv.ParseInt(string(n), 10, 64); !ok || err != nil {
+			v.fail(path, "ожидается integer")
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			v.fail(path, "ожидается number")
+		}
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			v.fail(path, "ожидается array")
+			return
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range list {
+			v.check(item, items, path+"/"+strconv.Itoa(i))
+		}
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			v.fail(path, "ожидается object")
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			switch prop, ok := props[k].(map[string]any); {
+			case ok:
+				v.check(obj[k], prop, p)
+			case extra != nil:
+				v.check(obj[k], extra, p)
+			case v.strict:
+				v.fail(p, "неизвестное поле")
+			}
+		}
+	}
+}
+
+// escapePointer экранирует имя поля для JSON Pointer (RFC 6901).
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// schemaURL — адрес опубликованной схемы тела: самой структуры или
+// элемента массива.
+func schemaURL(schema map[string]any) string {
+	if items, ok := schema["items"].(map[string]any); ok {
+		schema = items
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		return schemasPrefix + strings.TrimPrefix(ref, schemaRefPrefix) + ".json"
+	}
+	return ""
+}
+
+// jsonSchema переводит схему OpenAPI 3.0 из schemaFor в JSON Schema
+// 2020-12: nullable — в тип null, ссылки — через ref, у структур
+// запрещены неизвестные поля.
+func jsonSchema(s map[string]any, ref func(name string) string) map[string]any {
+	out := make(map[string]any, len(s))
+	for k, val := range s {
+		switch k {
+		case "$ref":
+			out[k] = ref(strings.TrimPrefix(val.(string), schemaRefPrefix))
+		case "nullable", "required":
+		case "properties":
+			props := make(map[string]any)
+			for name, p := range val.(map[string]any) {
+				props[name] = jsonSchema(p.(map[string]any), ref)
+			}
+			out[k] = props
+		case "items", "additionalProperties":
+			out[k] = jsonSchema(val.(map[string]any), ref)
+		case "allOf":
+			var list []any
+			for _, item := range val.([]any) {
+				list = append(list, jsonSchema(item.(map[string]any), ref))
+			}
+			out[k] = list
+		default:
+			out[k] = val
+		}
+	}
+	if _, ok := s["properties"]; ok {
+		out["additionalProperties"] = false
+	}
+	if s["format"] == "binary" {
+		delete(out, "format")
+		out["contentEncoding"] = "base64"
+	}
+	if s["nullable"] == true {
+		if typ, ok := out["type"].(string); ok {
+			out["type"] = []string{typ, "null"}
+		} else {
+			if all, ok := out["allOf"].([]any); ok && len(all) == 1 {
+				out = all[0].(map[string]any) // allOf нужен OpenAPI 3.0 только ради nullable
+			}
+			out = map[string]any{"anyOf": []any{out, map[string]any{"type": "null"}}}
+		}
+	}
+	return out
+}
+
+// schemaDocument — опубликованная схема name вместе со всеми схемами, на
+// которые она ссылается, в $defs.
+func schemaDocument(name string) (map[string]any, bool) {
+	root, ok := requestSchemas[name].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	defs := map[string]any{}
+	var ref func(string) string
+	ref = func(n string) string {
+		if n == name {
+			return "#"
+		}
+		if _, done := defs[n]; !done {
+			defs[n] = map[string]any{} // заглушка на случай рекурсии
+			defs[n] = jsonSchema(requestSchemas[n].(map[string]any), ref)
+		}
+		return "#/$defs/" + n
+	}
+	doc := jsonSchema(root, ref)
+	doc["$schema"] = jsonSchemaDialect
+	doc["$id"] = schemasPrefix + name + ".json"
+	doc["title"] = name
+	if len(defs) > 0 {
+		doc["$defs"] = defs
+	}
+	return doc, true
+}
+
+// schemaIndexEntry — строка оглавления /schemas/.
+type schemaIndexEntry struct {
+	Method string         `json:"method"`
+	Path   string         `json:"path"`
+	Schema string         `json:"schema,omitempty"` // адрес схемы тела или элемента массива
+	Body   map[string]any `json:"body"`             // схема тела целиком
+}
+
+// schemasIndexHandler перечисляет опубликованные схемы и эндпоинты, тела
+// которых по ним проверяются: GET /schemas/.
+func schemasIndexHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(requestSchemas))
+	for name := range requestSchemas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	urls := make([]string, len(names))
+	for i, name := range names {
+		urls[i] = schemasPrefix + name + ".json"
+	}
+	external := func(n string) string { return schemasPrefix + n + ".json" }
+	requests := make([]schemaIndexEntry, 0, len(requestBodies))
+	for _, b := range requestBodies {
+		requests = append(requests, schemaIndexEntry{
+			Method: b.op.Method,
+			Path:   b.op.Path,
+			Schema: schemaURL(b.schema),
+			Body:   jsonSchema(b.schema, external),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"schemas":  urls,
+		"requests": requests,
+		"strict":   config.Validation.Strict,
+	})
+}
+
+// schemaHandler отдает схему: GET /schemas/{name}, например
+// /schemas/Client.json.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	doc, ok := schemaDocument(strings.TrimSuffix(r.PathValue("name"), ".json"))
+	if !ok {
+		http.Error(w, "Схема не найдена", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"time"
+)
+
+// Тестовые данные для разработки и нагрузочных проверок: правдоподобные
+// клиенты с именами, адресами с координатами, любимым кофе из меню или
+// справочника и датами регистрации за последние три года. У всех метка
+// seed и адрес почты в example.com, чтобы их было легко найти и никто не
+// получил писем. Имена, адреса и кофе зависят только от числа клиентов и
+// -rand, так что повторный запуск дает тех же людей.
+
+// seedTag — метка тестовых клиентов.
+const seedTag = "seed"
+
+var (
+	seedWomen     = []string{"Анна", "Мария", "Елена", "Ольга", "Дарья", "Алиса", "Вера", "Ксения"}
+	seedMen       = []string{"Иван", "Алексей", "Дмитрий", "Сергей", "Михаил", "Никита", "Павел", "Артем"}
+	seedLastNames = []string{"Иванов", "Смирнов", "Кузнецов", "Попов", "Соколов", "Лебедев", "Козлов",
+		"Новиков", "Морозов", "Волков", "Соловьев", "Васильев", "Зайцев", "Павлов"}
+	seedStreets = []string{"ул. Ленина", "ул. Гагарина", "Садовая ул.", "Центральная ул.", "ул. Мира",
+		"Лесная ул.", "Школьная ул.", "Набережная ул.", "ул. Пушкина", "Зеленая ул."}
+	// seedCities — города с координатами центра; адреса разбросаны в
+	// пределах 10 км от него.
+	seedCities = []struct {
+		Name   string
+		Center GeoPoint
+	}{
+		{"Москва", GeoPoint{Lat: 55.7558, Lon: 37.6173}},
+		{"Санкт-Петербург", GeoPoint{Lat: 59.9386, Lon: 30.3141}},
+		{"Казань", GeoPoint{Lat: 55.7963, Lon: 49.1088}},
+		{"Екатеринбург", GeoPoint{Lat: 56.8389, Lon: 60.6057}},
+		{"Новосибирск", GeoPoint{Lat: 55.0302, Lon: 82.9204}},
+	}
+)
+
+// seedCoffees — названия для любимого кофе: из меню, а пока оно пусто — из
+// справочника.
+func seedCoffees() []string {
+	var names []string
+	menuMu.Lock()
+	for name := range menu {
+		names = append(names, name)
+	}
+	menuMu.Unlock()
+	if len(names) == 0 {
+		coffeeTermsMu.Lock()
+		for name := range coffeeTerms {
+			names = append(names, name)
+		}
+		coffeeTermsMu.Unlock()
+	}
+	sort.Strings(names) // порядок карты не должен менять набор
+	return names
+}
+
+// seedClients создает count тестовых клиентов кофейни tenant с ID начиная
+// с firstID.
+func seedClients(count, firstID int, tenant string, seed uint64) []Client {
+	rng := rand.New(rand.NewPCG(seed, uint64(count)))
+	pick := func(list []string) string { return list[rng.IntN(len(list))] }
+	coffees := seedCoffees()
+	now := time.Now()
+
+	list := make([]Client, 0, count)
+	for i := range count {
+		id := firstID + i
+		first, last := pick(seedMen), pick(seedLastNames)
+		if rng.IntN(2) == 0 {
+			first, last = pick(seedWomen), last+"а" // Иванова, Смирнова
+		}
+		city := seedCities[rng.IntN(len(seedCities))]
+		// До 10 км от центра: градус широты — около 111 км.
+		loc := GeoPoint{
+			Lat: city.Center.Lat + (rng.Float64()*2-1)*0.09,
+			Lon: city.Center.Lon + (rng.Float64()*2-1)*0.15,
+		}
+		home := Address{
+			Type:     addressHome,
+			City:     city.Name,
+			Street:   fmt.Sprintf("%s, %d", pick(seedStreets), 1+rng.IntN(120)),
+			Location: &loc,
+		}
+		birth := now.AddDate(-18-rng.IntN(52), 0, -rng.IntN(365))
+		c := Client{
+			ID:           id,
+			Name:         first + " " + last,
+			BirthDate:    birth.Format(time.DateOnly),
+			RegisterDate: now.Add(-time.Duration(rng.Int64N(int64(3 * 365 * 24 * time.Hour)))).Truncate(time.Second),
+			Addresses:    []Address{home},
+			Email:        fmt.Sprintf("client%d@example.com", id),
+			Tags:         []string{seedTag},
+			Tenant:       tenant,
+		}
+		if len(coffees) > 0 && rng.IntN(5) > 0 {
+			c.FavCoffee = pick(coffees)
+		}
+		c.Age = c.currentAge()
+		list = append(list, c)
+	}
+	return list
+}
+
+// seedStoreLocked добавляет count тестовых клиентов после наибольшего
+// занятого ID и возвращает их число. Вызывается под clientsMu.
+func seedStoreLocked(count int, tenant string, seed uint64) (int, error) {
+	for _, c := range seedClients(count, nextClientIDLocked(), tenant, seed) {
+		if _, err := createClientLocked(c, sourceSeed); err != nil {
+			return 0, fmt.Errorf("клиент %d: %w", c.ID, err)
+		}
+	}
+	rebuildGeoIndexLocked()
+	return count, nil
+}
+
+// seedCommand добавляет тестовых клиентов к последнему снимку и сохраняет
+// новый снимок, как import.
+func seedCommand(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := fs.Int("count", 1000, "сколько клиентов создать")
+	tenant := fs.String("tenant", "", "кофейня клиентов; по умолчанию основная")
+	seed := fs.Uint64("rand", 1, "начальное значение генератора; другое значение — другие клиенты")
+	snapshot := fs.String("snapshot", "", "снимок, к которому добавить клиентов; по умолчанию последний")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return errors.New("count должен быть положительным")
+	}
+	if err := openOffline(); err != nil {
+		return err
+	}
+	if !tenantExists(*tenant) {
+		return fmt.Errorf("неизвестная кофейня %s", *tenant)
+	}
+	ctx := context.Background()
+	if _, err := loadSnapshot(ctx, *snapshot); err != nil {
+		return err
+	}
+	clientsMu.Lock()
+	n, err := seedStoreLocked(*count, *tenant, *seed)
+	clientsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	info, err := storeBackup(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Добавлено клиентов: %d; снимок %s, восстановить: POST /admin/backups/%s/restore?mode=replace\n", n, info.Key, info.Key)
+	return nil
+}
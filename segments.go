@@ -0,0 +1,670 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Сегменты клиентов для маркетинга: набор правил вида «поле, оператор,
+// значение», например «VIP: заказов больше 10 и зарегистрирован больше года
+// назад». Сегмент пересчитывается по запросу POST /segments/{id}/evaluate и
+// задачей segments по расписанию; GET /segments/{id}/clients отдает клиентов
+// последнего расчета. Сегменты принадлежат кофейне, в которой созданы, и
+// включают только ее активных клиентов.
+//
+// Поля клиента:
+//
+//	name, email, city, favCoffee — строки без учета регистра; операторы eq,
+//	                 ne, contains, in (значение — список строк)
+//	age, registeredDays, inactiveDays, orders, spent, loyaltyBalance —
+//	                 числа; операторы eq, ne, gt, gte, lt, lte
+//	registerDate   — дата ГГГГ-ММ-ДД; те же операторы, что у чисел
+//	tags           — метки; операторы has и lacks
+//
+// orders — заказы, кроме отмененных; spent — сумма выполненных заказов;
+// inactiveDays — дней с последнего визита, а без визитов — с регистрации,
+// как в GET /clients/inactive.
+
+// maxSegmentRules ограничивает число правил в сегменте.
+const maxSegmentRules = 20
+
+// Сочетание правил сегмента.
+const (
+	segmentMatchAll = "all" // все правила
+	segmentMatchAny = "any" // хотя бы одно
+)
+
+// segmentRule — правило сегмента.
+type segmentRule struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value any    `json:"value"` // строка, число, дата ГГГГ-ММ-ДД или список строк для in
+}
+
+// Segment — сегмент клиентов.
+type Segment struct {
+	ID        int           `json:"id"`
+	Name      string        `json:"name"`
+	Match     string        `json:"match"` // all (по умолчанию) или any
+	Rules     []segmentRule `json:"rules"`
+	Tenant    string        `json:"tenant,omitempty"` // задается сервером по запросу
+	CreatedAt time.Time     `json:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+
+	// EvaluatedAt и Size — время и итог последнего расчета; нет — сегмент
+	// еще не считался или правила изменились после расчета.
+	EvaluatedAt *time.Time `json:"evaluatedAt,omitempty"`
+	Size        int        `json:"size"`
+}
+
+// segmentsState — сохраняемые сегменты.
+type segmentsState struct {
+	NextID   int           `json:"nextId"`
+	Segments []Segment     `json:"segments"`
+	Members  map[int][]int `json:"members"` // ID клиентов последнего расчета по возрастанию, по ID сегмента
+}
+
+var (
+	segments   = segmentsState{NextID: 1, Members: make(map[int][]int)} // Сегменты всех кофеен по возрастанию ID
+	segmentsMu sync.Mutex                                               // Мьютекс для защиты сегментов
+)
+
+var errSegmentNotFound = errors.New("Сегмент не найден")
+
+func segmentsPath() string {
+	return filepath.Join(config.DataDir, "segments.json")
+}
+
+// loadSegments читает сегменты.
+func loadSegments() error {
+	data, err := os.ReadFile(segmentsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	if err := json.Unmarshal(data, &segments); err != nil {
+		return fmt.Errorf("разбор %s: %w", segmentsPath(), err)
+	}
+	if segments.Members == nil {
+		segments.Members = make(map[int][]int)
+	}
+	return nil
+}
+
+// segmentIndexLocked возвращает индекс сегмента id кофейни tenant или -1.
+// Вызывается под segmentsMu.
+func segmentIndexLocked(tenant string, id int) int {
+	return slices.IndexFunc(segments.Segments, func(s Segment) bool { return s.ID == id && s.Tenant == tenant })
+}
+
+// segmentFacts — данные клиента, по которым проверяются правила.
+type segmentFacts struct {
+	client   Client
+	orders   int
+	spent    int
+	balance  int
+	lastSeen time.Time // или дата регистрации, если визитов не было
+	now      time.Time
+}
+
+func daysSince(t, now time.Time) int {
+	return int(now.Sub(t).Hours() / 24)
+}
+
+// segmentField — поле, доступное в правилах; задана одна из функций.
+type segmentField struct {
+	text func(f *segmentFacts) string
+	num  func(f *segmentFacts) int
+	date func(f *segmentFacts) time.Time
+	tags bool
+}
+
+var segmentFields = map[string]segmentField{
+	"name":      {text: func(f *segmentFacts) string { return f.client.Name }},
+	"email":     {text: func(f *segmentFacts) string { return f.client.Email }},
+	"city":      {text: func(f *segmentFacts) string { return f.client.Address.City }},
+	"favCoffee": {text: func(f *segmentFacts) string { return f.client.FavCoffee }},
+
+	"age":            {num: func(f *segmentFacts) int { return f.client.currentAge() }},
+	"registeredDays": {num: func(f *segmentFacts) int { return daysSince(f.client.RegisterDate, f.now) }},
+	"inactiveDays":   {num: func(f *segmentFacts) int { return daysSince(f.lastSeen, f.now) }},
+	"orders":         {num: func(f *segmentFacts) int { return f.orders }},
+	"spent":          {num: func(f *segmentFacts) int { return f.spent }},
+	"loyaltyBalance": {num: func(f *segmentFacts) int { return f.balance }},
+
+	"registerDate": {date: func(f *segmentFacts) time.Time { return f.client.RegisterDate }},
+	"tags":         {tags: true},
+}
+
+// segmentPredicate проверяет клиента на соответствие правилу.
+type segmentPredicate func(f *segmentFacts) bool
+
+// compareOp применяет оператор сравнения к итогу cmp.Compare.
+func compareOp(op string, c int) (bool, error) {
+	switch op {
+	case "eq":
+		return c == 0, nil
+	case "ne":
+		return c != 0, nil
+	case "gt":
+		return c > 0, nil
+	case "gte":
+		return c >= 0, nil
+	case "lt":
+		return c < 0, nil
+	case "lte":
+		return c <= 0, nil
+	}
+	return false, errSegmentOp
+}
+
+var errSegmentOp = errors.New("оператор не подходит к полю")
+
+// compileRule проверяет правило и строит по нему проверку.
+func compileRule(r segmentRule) (segmentPredicate, error) {
+	field, ok := segmentFields[r.Field]
+	if !ok {
+		return nil, fmt.Errorf("Неизвестное поле %q", r.Field)
+	}
+	if _, err := compareOp(r.Op, 0); err != nil && !slices.Contains([]string{"contains", "in", "has", "lacks"}, r.Op) {
+		return nil, fmt.Errorf("Неизвестный оператор %q", r.Op)
+	}
+	badOp := fmt.Errorf("%s: оператор %s не подходит к полю", r.Field, r.Op)
+
+	switch {
+	case field.text != nil:
+		norm := strings.ToLower
+		if r.Field == "favCoffee" {
+			norm = func(s string) string { return strings.ToLower(canonicalCoffee(s)) }
+		}
+		if r.Op == "in" {
+			raw, _ := r.Value.([]any)
+			set := make([]string, 0, len(raw))
+			for _, v := range raw {
+				s, ok := v.(string)
+				if !ok {
+					break
+				}
+				set = append(set, norm(strings.TrimSpace(s)))
+			}
+			if len(set) == 0 || len(set) != len(raw) {
+				return nil, fmt.Errorf("%s: для in ожидается непустой список строк", r.Field)
+			}
+			return func(f *segmentFacts) bool { return slices.Contains(set, norm(field.text(f))) }, nil
+		}
+		s, ok := r.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: ожидается строка", r.Field)
+		}
+		want := norm(strings.TrimSpace(s))
+		switch r.Op {
+		case "eq":
+			return func(f *segmentFacts) bool { return norm(field.text(f)) == want }, nil
+		case "ne":
+			return func(f *segmentFacts) bool { return norm(field.text(f)) != want }, nil
+		case "contains":
+			return func(f *segmentFacts) bool { return strings.Contains(norm(field.text(f)), want) }, nil
+		}
+		return nil, badOp
+
+	case field.num != nil:
+		v, ok := r.Value.(float64)
+		if !ok || v != float64(int(v)) {
+			return nil, fmt.Errorf("%s: ожидается целое число", r.Field)
+		}
+		if _, err := compareOp(r.Op, 0); err != nil {
+			return nil, badOp
+		}
+		want := int(v)
+		return func(f *segmentFacts) bool {
+			ok, _ := compareOp(r.Op, cmp.Compare(field.num(f), want))
+			return ok
+		}, nil
+
+	case field.date != nil:
+		s, _ := r.Value.(string)
+		want, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: ожидается дата ГГГГ-ММ-ДД", r.Field)
+		}
+		if _, err := compareOp(r.Op, 0); err != nil {
+			return nil, badOp
+		}
+		// Сравниваются дни, как в registeredFrom и registeredTo фильтра.
+		day := want.Format(time.DateOnly)
+		return func(f *segmentFacts) bool {
+			ok, _ := compareOp(r.Op, strings.Compare(field.date(f).UTC().Format(time.DateOnly), day))
+			return ok
+		}, nil
+
+	default: // tags
+		s, _ := r.Value.(string)
+		tags, err := normalizeTags([]string{s})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Field, err)
+		}
+		switch r.Op {
+		case "has":
+			return func(f *segmentFacts) bool { return f.client.hasTags(tags) }, nil
+		case "lacks":
+			return func(f *segmentFacts) bool { return !f.client.hasTags(tags) }, nil
+		}
+		return nil, badOp
+	}
+}
+
+// compileSegment проверяет сегмент и строит проверку всех его правил.
+func compileSegment(s Segment) (segmentPredicate, error) {
+	if len(s.Rules) == 0 || len(s.Rules) > maxSegmentRules {
+		return nil, fmt.Errorf("В сегменте должно быть от 1 до %d правил", maxSegmentRules)
+	}
+	preds := make([]segmentPredicate, len(s.Rules))
+	for i, r := range s.Rules {
+		p, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		preds[i] = p
+	}
+	if s.Match == segmentMatchAny {
+		return func(f *segmentFacts) bool {
+			return slices.ContainsFunc(preds, func(p segmentPredicate) bool { return p(f) })
+		}, nil
+	}
+	return func(f *segmentFacts) bool {
+		return !slices.ContainsFunc(preds, func(p segmentPredicate) bool { return !p(f) })
+	}, nil
+}
+
+// collectSegmentFacts собирает данные активных клиентов кофейни tenant по
+// возрастанию ID. Мьютексы заказов, баллов и визитов берутся по очереди.
+func collectSegmentFacts(tenant string) []segmentFacts {
+	list := filterClients(clientFilter{Tenant: tenant})
+	now := time.Now()
+	facts := make([]segmentFacts, len(list))
+	byID := make(map[int]*segmentFacts, len(list))
+	for i, c := range list {
+		facts[i] = segmentFacts{client: c, lastSeen: c.RegisterDate, now: now}
+		byID[c.ID] = &facts[i]
+	}
+
+	ordersMu.Lock()
+	for _, o := range orders {
+		f := byID[o.ClientID]
+		if f == nil || o.Status == OrderCancelled {
+			continue
+		}
+		f.orders++
+		if o.Status == OrderCompleted {
+			f.spent += o.Total
+		}
+	}
+	ordersMu.Unlock()
+
+	loyaltyMu.Lock()
+	for id, f := range byID {
+		f.balance = balances[id]
+	}
+	loyaltyMu.Unlock()
+
+	lastSeenMu.Lock()
+	for id, f := range byID {
+		if t, ok := lastSeen[id]; ok {
+			f.lastSeen = t
+		}
+	}
+	lastSeenMu.Unlock()
+	return facts
+}
+
+// evaluateSegments пересчитывает сегменты list и сохраняет итоги. Сегмент,
+// который удалили или изменили во время расчета, не обновляется.
+func evaluateSegments(list []Segment) error {
+	type result struct {
+		id        int
+		updatedAt time.Time
+		members   []int
+	}
+	results := make([]result, 0, len(list))
+	facts := make(map[string][]segmentFacts)
+	for _, s := range list {
+		match, err := compileSegment(s)
+		if err != nil {
+			logf("Сегмент %d не считается: %v", s.ID, err)
+			continue
+		}
+		tf, ok := facts[s.Tenant]
+		if !ok {
+			tf = collectSegmentFacts(s.Tenant)
+			facts[s.Tenant] = tf
+		}
+		members := []int{}
+		for i := range tf {
+			if match(&tf[i]) {
+				members = append(members, tf[i].client.ID)
+			}
+		}
+		results = append(results, result{s.ID, s.UpdatedAt, members})
+	}
+
+	now := time.Now()
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	changed := false
+	for _, res := range results {
+		i := slices.IndexFunc(segments.Segments, func(s Segment) bool { return s.ID == res.id })
+		if i < 0 || !segments.Segments[i].UpdatedAt.Equal(res.updatedAt) {
+			continue
+		}
+		s := &segments.Segments[i]
+		s.EvaluatedAt, s.Size = &now, len(res.members)
+		segments.Members[s.ID] = res.members
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return writeJSONFile(segmentsPath(), segments)
+}
+
+// segmentsJob пересчитывает все сегменты.
+var segmentsJob = &scheduledJob{
+	Name:     "segments",
+	Schedule: "@every 1h",
+	Run: func(ctx context.Context) error {
+		segmentsMu.Lock()
+		list := slices.Clone(segments.Segments)
+		segmentsMu.Unlock()
+		if len(list) == 0 {
+			return nil
+		}
+		return evaluateSegments(list)
+	},
+}
+
+// segmentRequest — тело POST /segments и PUT /segments/{id}.
+type segmentRequest struct {
+	Name  string        `json:"name"`
+	Match string        `json:"match,omitempty"` // all (по умолчанию) или any
+	Rules []segmentRule `json:"rules"`
+}
+
+// decodeSegment читает и проверяет сегмент из тела запроса.
+func decodeSegment(w http.ResponseWriter, r *http.Request) (Segment, bool) {
+	var req segmentRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return Segment{}, false
+	}
+	s := Segment{Name: strings.TrimSpace(req.Name), Match: req.Match, Rules: req.Rules}
+	if s.Match == "" {
+		s.Match = segmentMatchAll
+	}
+	switch {
+	case s.Name == "":
+		http.Error(w, "Не указано название", http.StatusBadRequest)
+		return s, false
+	case s.Match != segmentMatchAll && s.Match != segmentMatchAny:
+		http.Error(w, "match: ожидается all или any", http.StatusBadRequest)
+		return s, false
+	}
+	if _, err := compileSegment(s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+	return s, true
+}
+
+// segmentID читает ID сегмента из пути; при ошибке отвечает 400.
+func segmentID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// listSegmentsHandler возвращает сегменты кофейни по возрастанию ID:
+// GET /segments.
+func listSegmentsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	segmentsMu.Lock()
+	list := []Segment{}
+	for _, s := range segments.Segments {
+		if s.Tenant == tenant {
+			list = append(list, s)
+		}
+	}
+	segmentsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// createSegmentHandler добавляет сегмент: POST /segments. Считается он
+// при первом запросе клиентов, пересчете или запуске задачи segments.
+func createSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := decodeSegment(w, r)
+	if !ok {
+		return
+	}
+	s.Tenant = requestTenant(r)
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = s.CreatedAt
+
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	s.ID = segments.NextID
+	segments.NextID++
+	segments.Segments = append(segments.Segments, s)
+	if err := writeJSONFile(segmentsPath(), segments); err != nil {
+		segments.NextID--
+		segments.Segments = segments.Segments[:len(segments.Segments)-1]
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// getSegmentHandler возвращает сегмент: GET /segments/{id}.
+func getSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+	segmentsMu.Lock()
+	i := segmentIndexLocked(requestTenant(r), id)
+	var s Segment
+	if i >= 0 {
+		s = segments.Segments[i]
+	}
+	segmentsMu.Unlock()
+	if i < 0 {
+		http.Error(w, errSegmentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// updateSegmentHandler заменяет название и правила: PUT /segments/{id}.
+// Итог прежнего расчета сбрасывается.
+func updateSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+	upd, ok := decodeSegment(w, r)
+	if !ok {
+		return
+	}
+
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	i := segmentIndexLocked(requestTenant(r), id)
+	if i < 0 {
+		http.Error(w, errSegmentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	prev, members := segments.Segments[i], segments.Members[id]
+	s := &segments.Segments[i]
+	s.Name, s.Match, s.Rules = upd.Name, upd.Match, upd.Rules
+	s.UpdatedAt = time.Now()
+	s.EvaluatedAt, s.Size = nil, 0
+	delete(segments.Members, id)
+	if err := writeJSONFile(segmentsPath(), segments); err != nil {
+		*s = prev
+		if members != nil {
+			segments.Members[id] = members
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// deleteSegmentHandler удаляет сегмент: DELETE /segments/{id}.
+func deleteSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	i := segmentIndexLocked(requestTenant(r), id)
+	if i < 0 {
+		http.Error(w, errSegmentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	prev, members := segments.Segments, segments.Members[id]
+	segments.Segments = slices.Delete(slices.Clone(prev), i, i+1)
+	delete(segments.Members, id)
+	if err := writeJSONFile(segmentsPath(), segments); err != nil {
+		segments.Segments = prev
+		if members != nil {
+			segments.Members[id] = members
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshSegment пересчитывает сегмент id кофейни tenant и возвращает его
+// со списком клиентов.
+func refreshSegment(tenant string, id int) (Segment, error) {
+	segmentsMu.Lock()
+	i := segmentIndexLocked(tenant, id)
+	var s Segment
+	if i >= 0 {
+		s = segments.Segments[i]
+	}
+	segmentsMu.Unlock()
+	if i < 0 {
+		return s, errSegmentNotFound
+	}
+	if err := evaluateSegments([]Segment{s}); err != nil {
+		return s, err
+	}
+
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	if i = segmentIndexLocked(tenant, id); i < 0 {
+		return s, errSegmentNotFound
+	}
+	return segments.Segments[i], nil
+}
+
+// evaluateSegmentHandler пересчитывает сегмент: POST /segments/{id}/evaluate.
+func evaluateSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+	s, err := refreshSegment(requestTenant(r), id)
+	switch {
+	case errors.Is(err, errSegmentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// segmentClients — ответ GET /segments/{id}/clients.
+type segmentClients struct {
+	SegmentID   int       `json:"segmentId"`
+	EvaluatedAt time.Time `json:"evaluatedAt"`
+	Clients     []Client  `json:"clients"` // по возрастанию ID
+}
+
+// segmentClientsHandler возвращает клиентов сегмента по последнему
+// расчету: GET /segments/{id}/clients. Сегмент, который еще не считался,
+// или с ?refresh=true сначала пересчитывается. Удаленные после расчета
+// клиенты пропускаются.
+func segmentClientsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+	tenant := requestTenant(r)
+	segmentsMu.Lock()
+	i := segmentIndexLocked(tenant, id)
+	evaluated := i >= 0 && segments.Segments[i].EvaluatedAt != nil
+	segmentsMu.Unlock()
+	if i >= 0 && (!evaluated || r.URL.Query().Get("refresh") == "true") {
+		if _, err := refreshSegment(tenant, id); err != nil && !errors.Is(err, errSegmentNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	out := segmentClients{SegmentID: id, Clients: []Client{}}
+	segmentsMu.Lock()
+	i = segmentIndexLocked(tenant, id)
+	var members []int
+	if i >= 0 {
+		if at := segments.Segments[i].EvaluatedAt; at != nil {
+			out.EvaluatedAt = *at
+		}
+		members = segments.Members[id]
+	}
+	segmentsMu.Unlock()
+	if i < 0 {
+		http.Error(w, errSegmentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	clientsMu.Lock()
+	for _, cid := range members {
+		if c, exists := tenantClientLocked(tenant, cid); exists && !c.deleted() {
+			out.Clients = append(out.Clients, c)
+		}
+	}
+	clientsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
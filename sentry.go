@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Отправка отчетов об ошибках в Sentry через store API. DSN проекта имеет
+// вид https://<ключ>@<хост>/<проект>; клиентская библиотека Sentry не
+// нужна.
+
+// sentryReporter — errorReporter для Sentry.
+type sentryReporter struct {
+	cfg      ErrorReportingConfig
+	endpoint string // .../api/<проект>/store/
+	key      string
+	host     string // server_name в отчетах
+	client   *outboundClient
+}
+
+func newSentryReporter(cfg ErrorReportingConfig) (*sentryReporter, error) {
+	if cfg.DSN == "" {
+		cfg.DSN = os.Getenv("SENTRY_DSN")
+	}
+	if cfg.DSN == "" {
+		return nil, errors.New("errorReporting: не задан dsn")
+	}
+	u, err := url.Parse(cfg.DSN)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil {
+		return nil, errors.New("errorReporting: dsn должен иметь вид https://ключ@хост/проект")
+	}
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("errorReporting: в dsn нет номера проекта")
+	}
+	host, _ := os.Hostname()
+	return &sentryReporter{
+		cfg:      cfg,
+		endpoint: u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/store/",
+		key:      u.User.Username(),
+		host:     host,
+		// Sentry отбрасывает повторы по event_id.
+		client: newOutboundClient("errorReporting", outboundOptions{Timeout: time.Duration(cfg.Timeout), RetryPost: true}),
+	}, nil
+}
+
+// sentryEvent — событие store API.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Exception   *sentryExceptions `json:"exception,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"` // от внешнего вызова к месту ошибки
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// sentryEventFor переводит ошибку в событие Sentry.
+func (s *sentryReporter) sentryEventFor(e errorEvent) sentryEvent {
+	ev := sentryEvent{
+		EventID:     randomHex(16),
+		Timestamp:   e.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      e.Kind,
+		ServerName:  s.host,
+		Environment: s.cfg.Environment,
+		Release:     s.cfg.Release,
+		Message:     e.Message,
+		Tags:        map[string]string{"kind": e.Kind},
+	}
+	if e.Method != "" {
+		ev.Request = &sentryRequest{Method: e.Method, URL: e.Path}
+		ev.Tags["request_id"] = e.RequestID
+		ev.Tags["status"] = fmt.Sprint(e.Status)
+	}
+	if e.Kind == errorKindPanic {
+		ev.Level, ev.Message = "fatal", ""
+		ex := sentryException{Type: "panic", Value: e.Message}
+		frames := runtime.CallersFrames(e.Stack)
+		for {
+			f, more := frames.Next()
+			ex.Stacktrace.Frames = append(ex.Stacktrace.Frames, sentryFrame{
+				Function: f.Function, Filename: f.File, Lineno: f.Line,
+				InApp: strings.HasPrefix(f.Function, "main."),
+			})
+			if !more {
+				break
+			}
+		}
+		// runtime отдает кадры от места паники, Sentry ждет обратного порядка.
+		for i, j := 0, len(ex.Stacktrace.Frames)-1; i < j; i, j = i+1, j-1 {
+			ex.Stacktrace.Frames[i], ex.Stacktrace.Frames[j] = ex.Stacktrace.Frames[j], ex.Stacktrace.Frames[i]
+		}
+		ev.Exception = &sentryExceptions{Values: []sentryException{ex}}
+	}
+	return ev
+}
+
+// Report отправляет событие в Sentry.
+func (s *sentryReporter) Report(ctx context.Context, e errorEvent) error {
+	body, err := json.Marshal(s.sentryEventFor(e))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=adv-prog/1.0, sentry_key="+s.key)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Sentry: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Sentry: %s", resp.Status)
+	}
+	return nil
+}
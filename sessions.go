@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SessionConfig задает сессии посетителей сайта.
+type SessionConfig struct {
+	Store string   `json:"store"` // "memory" или "file" — <dataDir>/sessions.json
+	TTL   Duration `json:"ttl"`   // срок жизни сессии без обращений
+}
+
+// Хранилища сессий.
+const (
+	sessionStoreMemory = "memory"
+	sessionStoreFile   = "file"
+)
+
+// sessionCookie — cookie с идентификатором сессии.
+const sessionCookie = "session"
+
+// session — данные одного посетителя.
+type session struct {
+	Values    map[string]string `json:"values"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// sessionStore хранит сессии по идентификатору. Get не возвращает
+// истекшие сессии.
+type sessionStore interface {
+	Get(id string) (session, bool)
+	Save(id string, s session) error
+	Delete(id string) error
+}
+
+// sessions — хранилище сессий, выбирается в main по config.Sessions.Store.
+var sessions sessionStore
+
+// memorySessionStore держит сессии в памяти; они теряются при перезапуске.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]session)}
+}
+
+func (m *memorySessionStore) Get(id string) (session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || !time.Now().Before(s.ExpiresAt) {
+		return session{}, false
+	}
+	s.Values = maps.Clone(s.Values)
+	return s, true
+}
+
+func (m *memorySessionStore) Save(id string, s session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveLocked(id, s)
+	return nil
+}
+
+// saveLocked сохраняет сессию и заодно убирает истекшие.
+func (m *memorySessionStore) saveLocked(id string, s session) {
+	now := time.Now()
+	maps.DeleteFunc(m.sessions, func(_ string, s session) bool { return !now.Before(s.ExpiresAt) })
+	s.Values = maps.Clone(s.Values)
+	m.sessions[id] = s
+}
+
+func (m *memorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// fileSessionStore — сессии в памяти, которые после каждого изменения
+// записываются в JSON-файл и переживают перезапуск.
+type fileSessionStore struct {
+	*memorySessionStore
+	path string
+}
+
+func newFileSessionStore(path string) (*fileSessionStore, error) {
+	f := &fileSessionStore{memorySessionStore: newMemorySessionStore(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &f.sessions); err != nil {
+		return nil, fmt.Errorf("разбор %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *fileSessionStore) Save(id string, s session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saveLocked(id, s)
+	return writeJSONFile(f.path, f.sessions)
+}
+
+func (f *fileSessionStore) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, id)
+	return writeJSONFile(f.path, f.sessions)
+}
+
+// newSessionStore создает хранилище по настройкам.
+func newSessionStore(cfg SessionConfig) (sessionStore, error) {
+	if cfg.Store == sessionStoreMemory {
+		return newMemorySessionStore(), nil
+	}
+	return newFileSessionStore(filepath.Join(config.DataDir, "sessions.json"))
+}
+
+// loadSession возвращает сессию посетителя. Если ее нет или она истекла,
+// возвращается пустая сессия с id "" — сохранять ее стоит, только когда
+// в ней появились данные, чтобы не заводить сессию каждому посетителю.
+func loadSession(r *http.Request) (string, session) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if s, ok := sessions.Get(c.Value); ok {
+			return c.Value, s
+		}
+	}
+	return "", session{Values: make(map[string]string)}
+}
+
+// saveSession сохраняет сессию, продлевает ее срок и выставляет cookie.
+// Возвращает идентификатор, новый для сессии с id "".
+func saveSession(w http.ResponseWriter, r *http.Request, id string, s session) (string, error) {
+	if id == "" {
+		id = randomHex(16)
+	}
+	ttl := time.Duration(config.Sessions.TTL)
+	s.ExpiresAt = time.Now().Add(ttl)
+	if err := sessions.Save(id, s); err != nil {
+		return id, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, nil
+}
+
+// touchSession продлевает сессию, если прошло больше половины ее срока,
+// чтобы не перезаписывать хранилище при каждом просмотре страницы.
+func touchSession(w http.ResponseWriter, r *http.Request, id string, s session) error {
+	if id == "" || time.Until(s.ExpiresAt) > time.Duration(config.Sessions.TTL)/2 {
+		return nil
+	}
+	_, err := saveSession(w, r, id, s)
+	return err
+}
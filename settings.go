@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Настройки для реакции на инциденты без перезапуска: GET /admin/settings
+// показывает ограничения частоты запросов, срок кэша выборок, число
+// обработчиков очереди и уровень журнала, PUT меняет переданные из них
+// сразу. Вложенный объект rateLimit можно передать частично, null
+// возвращает значение из файла конфигурации, DELETE — все значения.
+//
+// Измененные значения хранятся в settings.json в dataDir и накладываются
+// на файл конфигурации при запуске и перезагрузке, так что правка файла не
+// отменяет их, пока их не сбросят. Проверяются они вместе с остальной
+// конфигурацией, как при перезагрузке.
+
+// runtimeSettings — настройки, которые меняются через /admin/settings. В
+// settings.json nil — значение из файла конфигурации.
+type runtimeSettings struct {
+	RateLimit *RateLimitConfig `json:"rateLimit,omitempty"`
+	CacheTTL  *Duration        `json:"cacheTTL,omitempty"`
+	Workers   *int             `json:"workers,omitempty"` // обработчиков очереди
+	LogLevel  *string          `json:"logLevel,omitempty"`
+}
+
+// settingsView — ответ /admin/settings.
+type settingsView struct {
+	runtimeSettings
+	Overridden []string `json:"overridden"` // заданы через /admin/settings, а не файлом
+}
+
+func settingsPath(dataDir string) string {
+	return filepath.Join(dataDir, "settings.json")
+}
+
+// readSettings читает измененные настройки из dataDir.
+func readSettings(dataDir string) (runtimeSettings, error) {
+	var s runtimeSettings
+	data, err := os.ReadFile(settingsPath(dataDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("разбор %s: %w", settingsPath(dataDir), err)
+	}
+	return s, nil
+}
+
+// apply накладывает измененные настройки на конфигурацию.
+func (s runtimeSettings) apply(cfg *Config) {
+	if s.RateLimit != nil {
+		cfg.RateLimit = *s.RateLimit
+	}
+	if s.CacheTTL != nil {
+		cfg.Cache.TTL = *s.CacheTTL
+	}
+	if s.Workers != nil {
+		cfg.Queue.Workers = *s.Workers
+	}
+	if s.LogLevel != nil {
+		cfg.Log.Level = *s.LogLevel
+	}
+}
+
+// names перечисляет заданные настройки по имени в JSON.
+func (s runtimeSettings) names() []string {
+	names := []string{}
+	if s.RateLimit != nil {
+		names = append(names, "rateLimit")
+	}
+	if s.CacheTTL != nil {
+		names = append(names, "cacheTTL")
+	}
+	if s.Workers != nil {
+		names = append(names, "workers")
+	}
+	if s.LogLevel != nil {
+		names = append(names, "logLevel")
+	}
+	return names
+}
+
+// currentSettings — действующие значения всех настроек.
+func currentSettings(cfg Config) runtimeSettings {
+	return runtimeSettings{
+		RateLimit: &cfg.RateLimit,
+		CacheTTL:  &cfg.Cache.TTL,
+		Workers:   &cfg.Queue.Workers,
+		LogLevel:  &cfg.Log.Level,
+	}
+}
+
+// saveSettings сохраняет измененные настройки и применяет их вместе с
+// файлом конфигурации. Если конфигурация не прошла проверку, прежние
+// настройки остаются. Вызывается под reloadMu.
+func saveSettings(s runtimeSettings) error {
+	path := settingsPath(config.DataDir)
+	prev, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(s.names()) == 0 {
+		err = os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = writeJSONFile(path, s)
+	}
+	if err != nil {
+		return err
+	}
+
+	next, err := loadConfig(configPath)
+	if err != nil {
+		if prev == nil {
+			os.Remove(path)
+		} else if werr := os.WriteFile(path, prev, 0o600); werr != nil {
+			logError("Ошибка восстановления %s: %v", path, werr)
+		}
+		return err
+	}
+
+	configMu.Lock()
+	ttlChanged := config.Cache.TTL != next.Cache.TTL
+	config.RateLimit = next.RateLimit
+	config.Cache.TTL = next.Cache.TTL
+	config.Queue.Workers = next.Queue.Workers
+	config.Log.Level = next.Log.Level
+	configMu.Unlock()
+
+	logLevel.Set(next.Log.level())
+	resizeQueue(next.Queue.Workers)
+	if ttlChanged {
+		// Выборки с прежним сроком не должны жить дольше нового.
+		invalidateListCache()
+	}
+	logf("Настройки изменены, заданы через /admin/settings: %v", s.names())
+	return nil
+}
+
+// settingsHandler показывает (GET), меняет (PUT) и сбрасывает к файлу
+// конфигурации (DELETE) настройки: /admin/settings.
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	saved, err := readSettings(config.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+			return
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		for name := range fields {
+			switch name {
+			case "rateLimit", "cacheTTL", "workers", "logLevel":
+			default:
+				http.Error(w, fmt.Sprintf("Неизвестная настройка %s", name), http.StatusBadRequest)
+				return
+			}
+		}
+		// Поверх действующих значений: частичный rateLimit дополняется ими.
+		next := currentSettings(liveConfig())
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&next); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		for name := range fields {
+			switch name {
+			case "rateLimit":
+				saved.RateLimit = next.RateLimit
+			case "cacheTTL":
+				saved.CacheTTL = next.CacheTTL
+			case "workers":
+				saved.Workers = next.Workers
+			case "logLevel":
+				saved.LogLevel = next.LogLevel
+			}
+		}
+		if err := saveSettings(saved); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	case http.MethodDelete:
+		saved = runtimeSettings{}
+		if err := saveSettings(saved); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settingsView{runtimeSettings: currentSettings(liveConfig()), Overridden: saved.names()})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Остановка сервера по шагам: прием запросов, фоновые задачи, очередь с
+// вебхуками, журнал, снимок хранилища. Шаги выполняются по порядку в
+// пределах общего shutdown.timeout; время каждого пишется в лог, чтобы было
+// видно, что задерживает остановку.
+
+// ShutdownConfig задает остановку сервера.
+type ShutdownConfig struct {
+	Timeout Duration `json:"timeout"` // на все шаги вместе
+	// Snapshot сохраняет снимок хранилища в хранилище файлов, если клиенты
+	// менялись после последнего сохраненного снимка. При запуске сервер
+	// загружает последний снимок.
+	Snapshot bool `json:"snapshot"`
+}
+
+// shutdownStep — шаг остановки.
+type shutdownStep struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// shutdownSteps — шаги по порядку; заполняется в main.
+var shutdownSteps []shutdownStep
+
+// onShutdown добавляет шаг остановки.
+func onShutdown(name string, run func(ctx context.Context) error) {
+	shutdownSteps = append(shutdownSteps, shutdownStep{name, run})
+}
+
+// shutdown выполняет шаги остановки. Ошибка шага не прерывает остальные:
+// даже если запросы не завершились вовремя, очередь и журнал стоит
+// сохранить.
+func shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	for _, s := range shutdownSteps {
+		t := time.Now()
+		err := s.Run(ctx)
+		took := time.Since(t).Round(time.Millisecond)
+		if err != nil {
+			logError("Остановка: %s — ошибка через %v: %v", s.Name, took, err)
+			continue
+		}
+		logf("Остановка: %s — %v", s.Name, took)
+	}
+	logf("Сервер остановлен за %v", time.Since(start).Round(time.Millisecond))
+}
+
+// waitGroup ждет wg, но не дольше, чем живет ctx.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// storeShutdownSnapshot сохраняет снимок хранилища, если клиенты менялись
+// после последнего сохраненного снимка.
+func storeShutdownSnapshot(ctx context.Context) error {
+	clientsMu.Lock()
+	changed := clientsModified.After(backupStoredModified)
+	clientsMu.Unlock()
+	if !changed {
+		return nil
+	}
+	info, err := storeBackup(ctx)
+	if err == nil {
+		logf("Снимок хранилища сохранен: %s", info.Key)
+	}
+	return err
+}
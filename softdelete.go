@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// deleted сообщает, что клиент удален мягко и скрыт из списков.
+func (c Client) deleted() bool {
+	return c.DeletedAt != nil
+}
+
+// softDeleteLocked помечает клиента кофейни tenant удаленным. Вызывается
+// под clientsMu; удаленный ранее клиент считается ненайденным.
+func softDeleteLocked(tenant string, id int, now time.Time, source string) bool {
+	c, exists := tenantClientLocked(tenant, id)
+	if !exists || c.deleted() {
+		return false
+	}
+	c.DeletedAt = &now
+	c.Version++
+	clients[id] = c
+	publishClientEvent(eventClientDeleted, c, source)
+	return true
+}
+
+// parseIncludeDeleted читает параметр ?includeDeleted=.
+func parseIncludeDeleted(q url.Values) (bool, error) {
+	v := q.Get("includeDeleted")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("includeDeleted: ожидается true или false")
+	}
+	return include, nil
+}
+
+// allowIncludeDeleted пропускает запрос удаленных клиентов только от
+// администратора; иначе отвечает 403 и возвращает false.
+func allowIncludeDeleted(w http.ResponseWriter, r *http.Request, include bool) bool {
+	if !include {
+		return true
+	}
+	p, err := authenticate(r)
+	if err != nil || p.Scope != "" || !p.Role.Allows(RoleAdmin) {
+		writeAPIError(w, r, http.StatusForbidden, apiError{
+			Error:    "forbidden",
+			Message:  "Удаленных клиентов видят только администраторы",
+			Role:     p.Role,
+			Required: RoleAdmin,
+		})
+		return false
+	}
+	return true
+}
+
+// restoreClientHandler возвращает мягко удаленного клиента:
+// POST /clients/{id}/restore.
+func restoreClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	if !c.deleted() {
+		http.Error(w, "Клиент не удален", http.StatusConflict)
+		return
+	}
+	c.DeletedAt = nil
+	c.Version++
+	clients[id] = c
+	publishClientEvent(eventClientUpdated, c, sourceAPI)
+	writeResponse(w, r, http.StatusOK, c)
+}
+
+// purgeClientHandler окончательно удаляет клиента: DELETE /clients/{id}/purge.
+// Стереть можно только уже мягко удаленного клиента.
+func purgeClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	if !c.deleted() {
+		http.Error(w, "Сначала удалите клиента через DELETE /deleteClient", http.StatusConflict)
+		return
+	}
+	if err := archiveClientLocked(c, sourceAPI); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(clients, id)
+	publishClientEvent(eventClientPurged, c, sourceAPI)
+	w.WriteHeader(http.StatusNoContent)
+}
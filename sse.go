@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Поток изменений клиентов для живых панелей.
+const (
+	streamBufferSize = 1000             // событий хранится для переподключения
+	streamHeartbeat  = 15 * time.Second // комментарий-пинг держит соединение открытым
+	streamRetryMS    = 3000             // подсказка браузеру, через сколько переподключаться
+)
+
+// streamEvent — событие потока с порядковым номером.
+type streamEvent struct {
+	ID     uint64
+	Type   string
+	Tenant string // кофейня клиента: поток видит только события своей
+	Data   []byte
+}
+
+var (
+	streamBuf       []streamEvent                  // последние события по возрастанию ID
+	streamLastID    uint64                         // ID последнего события
+	streamListeners = make(map[chan struct{}]bool) // Открытые потоки, ждущие новых событий
+	streamMu        sync.Mutex                     // Мьютекс для защиты буфера и слушателей
+)
+
+// streamOnClientEvent добавляет событие в буфер и будит открытые потоки.
+func streamOnClientEvent(e clientEvent) {
+	data, err := json.Marshal(map[string]any{"type": e.Type, "time": e.At, "client": e.Client})
+	if err != nil {
+		return
+	}
+
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	streamLastID++
+	streamBuf = append(streamBuf, streamEvent{ID: streamLastID, Type: e.Type, Tenant: e.Client.Tenant, Data: data})
+	if len(streamBuf) > streamBufferSize {
+		streamBuf = streamBuf[len(streamBuf)-streamBufferSize:]
+	}
+	for ch := range streamListeners {
+		select {
+		case ch <- struct{}{}:
+		default: // поток уже разбужен
+		}
+	}
+}
+
+// listenStream подписывает поток на пробуждения и возвращает ID последнего
+// события: все более новые события разбудят канал.
+func listenStream() (wake chan struct{}, last uint64) {
+	wake = make(chan struct{}, 1)
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	streamListeners[wake] = true
+	return wake, streamLastID
+}
+
+func unlistenStream(wake chan struct{}) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	delete(streamListeners, wake)
+}
+
+// streamSince возвращает события кофейни tenant после after. reset
+// означает, что часть событий уже вытеснена из буфера или сервер
+// перезапускался: клиенту нужно заново загрузить список целиком.
+func streamSince(tenant string, after uint64) (events []streamEvent, last uint64, reset bool) {
+	return streamMatching(after, func(e streamEvent) bool { return e.Tenant == tenant })
+}
+
+// streamMatching — как streamSince, но по условию match; nil — события
+// всех кофеен.
+func streamMatching(after uint64, match func(streamEvent) bool) (events []streamEvent, last uint64, reset bool) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+
+	if after > streamLastID {
+		return nil, streamLastID, true
+	}
+	if len(streamBuf) > 0 && after+1 < streamBuf[0].ID {
+		return nil, streamLastID, true
+	}
+	for i := len(streamBuf) - 1; i >= 0 && streamBuf[i].ID > after; i-- {
+		if match == nil || match(streamBuf[i]) {
+			events = append(events, streamBuf[i])
+		}
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, streamLastID, false
+}
+
+// clientEventsHandler отдает поток изменений клиентов в формате
+// Server-Sent Events: GET /clients/events. После разрыва браузер
+// присылает Last-Event-ID, и пропущенные события досылаются из буфера.
+// Если пропущенное уже не восстановить, приходит событие reset. Поток
+// закрывается при остановке сервера (ctx).
+func clientEventsHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+			return
+		}
+
+		lastHeader := r.Header.Get("Last-Event-ID")
+		if lastHeader == "" {
+			lastHeader = r.URL.Query().Get("lastEventId")
+		}
+		var after uint64
+		resume := lastHeader != ""
+		if resume {
+			var err error
+			if after, err = strconv.ParseUint(lastHeader, 10, 64); err != nil {
+				http.Error(w, "Неверный Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+		}
+
+		wake, current := listenStream()
+		defer unlistenStream(wake)
+		if !resume {
+			after = current
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", streamRetryMS)
+		if resume {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case <-wake:
+				events, last, reset := streamSince(requestTenant(r), after)
+				if reset {
+					fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", last)
+				}
+				for _, e := range events {
+					fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+				}
+				after = last
+			}
+			flusher.Flush()
+		}
+	}
+}
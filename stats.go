@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statsTopCoffees — сколько любимых кофе попадает в рейтинг.
+const statsTopCoffees = 10
+
+// statsCount — значение и число клиентов с ним.
+type statsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// statsMonth — регистрации за месяц (ГГГГ-ММ, UTC).
+type statsMonth struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// clientStats — сводка по клиентам, подходящим под фильтр.
+type clientStats struct {
+	TotalClients int `json:"totalClients"`
+	// AverageAge считается по клиентам с указанным возрастом (age > 0);
+	// null, если таких нет.
+	AverageAge            *float64     `json:"averageAge"`
+	RegistrationsPerMonth []statsMonth `json:"registrationsPerMonth"` // по возрастанию месяца
+	TopCoffees            []statsCount `json:"topCoffees"`
+	Cities                []statsCount `json:"cities"`
+}
+
+// statsCounter считает значения без учета регистра и показывает каждое
+// в самом частом написании.
+type statsCounter map[string]map[string]int
+
+func (sc statsCounter) add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	key := strings.ToLower(v)
+	if sc[key] == nil {
+		sc[key] = make(map[string]int)
+	}
+	sc[key][v]++
+}
+
+// sorted возвращает значения по убыванию числа клиентов, при равенстве — по
+// алфавиту; limit > 0 оставляет первые limit.
+func (sc statsCounter) sorted(limit int) []statsCount {
+	list := make([]statsCount, 0, len(sc))
+	for _, spellings := range sc {
+		var c statsCount
+		best := 0
+		for s, n := range spellings {
+			c.Count += n
+			if n > best || n == best && s < c.Name {
+				c.Name, best = s, n
+			}
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// computeClientStats считает сводку за один проход по хранилищу.
+func computeClientStats(f clientFilter) (clientStats, time.Time) {
+	var (
+		st              clientStats
+		ageSum, withAge int
+		months          = make(map[string]int)
+		coffees         = make(statsCounter)
+		cities          = make(statsCounter)
+	)
+
+	clientsMu.Lock()
+	for _, c := range clients {
+		if !f.match(c) {
+			continue
+		}
+		st.TotalClients++
+		if age := c.currentAge(); age > 0 {
+			ageSum += age
+			withAge++
+		}
+		if !c.RegisterDate.IsZero() {
+			months[c.RegisterDate.UTC().Format("2006-01")]++
+		}
+		coffees.add(c.FavCoffee)
+		cities.add(c.Address.City)
+	}
+	modified := clientsModified
+	clientsMu.Unlock()
+
+	if withAge > 0 {
+		avg := float64(ageSum) / float64(withAge)
+		st.AverageAge = &avg
+	}
+	st.RegistrationsPerMonth = make([]statsMonth, 0, len(months))
+	for m, n := range months {
+		st.RegistrationsPerMonth = append(st.RegistrationsPerMonth, statsMonth{m, n})
+	}
+	sort.Slice(st.RegistrationsPerMonth, func(i, j int) bool {
+		return st.RegistrationsPerMonth[i].Month < st.RegistrationsPerMonth[j].Month
+	})
+	st.TopCoffees = coffees.sorted(statsTopCoffees)
+	st.Cities = cities.sorted(0)
+	return st, modified
+}
+
+// statsHandler отдает сводку по клиентам: GET /stats. Принимает те же
+// фильтры, что /getClients.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowIncludeDeleted(w, r, f.IncludeDeleted) {
+		return
+	}
+
+	st, modified := computeClientStats(f)
+	etag, err := jsonETag(st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatusConfig задает самопроверку компонентов для страницы /status.
+type StatusConfig struct {
+	ProbeInterval Duration `json:"probeInterval"`
+	HistoryDays   int      `json:"historyDays"`
+}
+
+// probeResult — результат последней проверки компонента.
+type probeResult struct {
+	OK        bool          `json:"ok"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	CheckedAt time.Time     `json:"checkedAt"`
+}
+
+// uptimeBucket агрегирует проверки компонента за один час.
+type uptimeBucket struct {
+	Hour  time.Time `json:"hour"`
+	OK    int       `json:"ok"`
+	Total int       `json:"total"`
+}
+
+// Incident — заметка об инциденте, которую ведут администраторы.
+type Incident struct {
+	ID         int        `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Resolved   bool       `json:"resolved"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// statusState сохраняется в dataDir, чтобы аптайм переживал перезапуски.
+type statusState struct {
+	History   map[string][]uptimeBucket `json:"history"`
+	Incidents []Incident                `json:"incidents"`
+	NextID    int                       `json:"nextId"`
+}
+
+var (
+	status        = statusState{History: make(map[string][]uptimeBucket), NextID: 1}
+	statusCurrent = make(map[string]probeResult) // Последние результаты проверок
+	statusMu      sync.Mutex                     // Мьютекс для защиты состояния
+)
+
+// statusProbes — проверяемые компоненты.
+var statusProbes = map[string]func(ctx context.Context) error{
+	"api":     probeAPI,
+	"storage": probeStorage,
+	"disk":    probeDisk,
+}
+
+// probeAPI обращается к собственному HTTP-интерфейсу, как внешний клиент.
+func probeAPI(ctx context.Context) error {
+	client, target := http.DefaultClient, ""
+	switch addr := apiAddr.(type) {
+	case *net.TCPAddr:
+		ip := addr.IP
+		if ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		target = net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port))
+	default:
+		// Unix-сокет: имя хоста в адресе ни на что не влияет.
+		target = "localhost"
+		client = &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, addr.Network(), addr.String())
+			},
+		}}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target+"/getClients", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("статус %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeStorage проверяет, что хранилище клиентов не заблокировано надолго.
+func probeStorage(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		clientsMu.Lock()
+		clientsMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return errors.New("хранилище не отвечает")
+	}
+}
+
+// probeDisk проверяет, что в каталог данных можно писать.
+func probeDisk(ctx context.Context) error {
+	if err := os.MkdirAll(config.DataDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.DataDir, ".probe")
+	if err := os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)), 0o600); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func statusStatePath() string {
+	return filepath.Join(config.DataDir, "status.json")
+}
+
+// loadStatus читает историю проверок и инциденты.
+func loadStatus() error {
+	data, err := os.ReadFile(statusStatePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("разбор %s: %w", statusStatePath(), err)
+	}
+	if status.History == nil {
+		status.History = make(map[string][]uptimeBucket)
+	}
+	return nil
+}
+
+func saveStatusLocked() {
+	if err := writeJSONFile(statusStatePath(), status); err != nil {
+		logError("Ошибка сохранения состояния /status: %v", err)
+	}
+}
+
+// runProbes выполняет проверки сразу и затем с интервалом ProbeInterval.
+func runProbes(ctx context.Context) {
+	interval := time.Duration(config.Status.ProbeInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		probeAll(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probeAll(ctx context.Context, timeout time.Duration) {
+	results := make(map[string]probeResult, len(statusProbes))
+	for name, probe := range statusProbes {
+		pctx, cancel := context.WithTimeout(ctx, min(timeout, 5*time.Second))
+		start := time.Now()
+		err := probe(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		res := probeResult{OK: err == nil, Latency: time.Since(start), CheckedAt: start}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results[name] = res
+	}
+
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+	horizon := now.AddDate(0, 0, -config.Status.HistoryDays)
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for name, res := range results {
+		statusCurrent[name] = res
+
+		h := status.History[name]
+		if len(h) == 0 || !h[len(h)-1].Hour.Equal(hour) {
+			h = append(h, uptimeBucket{Hour: hour})
+		}
+		h[len(h)-1].Total++
+		if res.OK {
+			h[len(h)-1].OK++
+		}
+		for len(h) > 0 && h[0].Hour.Before(horizon) {
+			h = h[1:]
+		}
+		status.History[name] = h
+	}
+	saveStatusLocked()
+}
+
+// uptime возвращает долю успешных проверок за окно window или -1, если
+// проверок не было.
+func uptime(buckets []uptimeBucket, now time.Time, window time.Duration) float64 {
+	var ok, total int
+	since := now.Add(-window).Truncate(time.Hour)
+	for _, b := range buckets {
+		if !b.Hour.Before(since) {
+			ok += b.OK
+			total += b.Total
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+	return float64(ok) / float64(total) * 100
+}
+
+// statusPage — данные шаблона status.html.
+type statusPage struct {
+	AllOK      bool
+	Components []statusComponent
+	Incidents  []Incident
+	Windows    []string
+}
+
+type statusComponent struct {
+	Name   string
+	Result probeResult
+	Uptime []string
+}
+
+// statusWindows — окна, за которые показывается аптайм.
+var statusWindows = []struct {
+	Label  string
+	Window time.Duration
+}{
+	{"24 ч", 24 * time.Hour},
+	{"7 дн", 7 * 24 * time.Hour},
+	{"30 дн", 30 * 24 * time.Hour},
+}
+
+// statusHandler отрисовывает страницу состояния сервиса.
+func statusHandler(templates *templateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		page := statusPage{AllOK: true}
+		for _, sw := range statusWindows {
+			page.Windows = append(page.Windows, sw.Label)
+		}
+
+		statusMu.Lock()
+		for name := range statusProbes {
+			res, checked := statusCurrent[name]
+			if !checked || !res.OK {
+				page.AllOK = false
+			}
+			c := statusComponent{Name: name, Result: res}
+			for _, sw := range statusWindows {
+				u := uptime(status.History[name], now, sw.Window)
+				if u < 0 {
+					c.Uptime = append(c.Uptime, "—")
+				} else {
+					c.Uptime = append(c.Uptime, strconv.FormatFloat(u, 'f', 2, 64)+"%")
+				}
+			}
+			page.Components = append(page.Components, c)
+		}
+		page.Incidents = append([]Incident(nil), status.Incidents...)
+		statusMu.Unlock()
+
+		sort.Slice(page.Components, func(i, j int) bool { return page.Components[i].Name < page.Components[j].Name })
+		// Сначала открытые инциденты, затем свежие.
+		sort.SliceStable(page.Incidents, func(i, j int) bool {
+			a, b := page.Incidents[i], page.Incidents[j]
+			if a.Resolved != b.Resolved {
+				return !a.Resolved
+			}
+			return a.CreatedAt.After(b.CreatedAt)
+		})
+		if len(page.Incidents) > 10 {
+			page.Incidents = page.Incidents[:10]
+		}
+
+		if err := templates.render(w, r, "status.html", page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// incidentsHandler управляет заметками об инцидентах: GET — список,
+// POST — создание, PUT ?id= — изменение (в том числе resolved), DELETE ?id= — удаление.
+func incidentsHandler(w http.ResponseWriter, r *http.Request) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status.Incidents)
+		return
+	}
+
+	var in Incident
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		if in.Title == "" {
+			http.Error(w, "Не указан заголовок инцидента", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		in.ID = status.NextID
+		status.NextID++
+		in.CreatedAt = time.Now()
+		in.ResolvedAt = nil
+		if in.Resolved {
+			in.ResolvedAt = &in.CreatedAt
+		}
+		status.Incidents = append(status.Incidents, in)
+		saveStatusLocked()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(in)
+
+	case http.MethodPut, http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Неверный или отсутствующий ID", http.StatusBadRequest)
+			return
+		}
+		i := slices.IndexFunc(status.Incidents, func(in Incident) bool { return in.ID == id })
+		if i < 0 {
+			http.Error(w, "Инцидент не найден", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			status.Incidents = append(status.Incidents[:i], status.Incidents[i+1:]...)
+			saveStatusLocked()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		cur := &status.Incidents[i]
+		cur.Title, cur.Body = in.Title, in.Body
+		if in.Resolved && !cur.Resolved {
+			now := time.Now()
+			cur.ResolvedAt = &now
+		} else if !in.Resolved {
+			cur.ResolvedAt = nil
+		}
+		cur.Resolved = in.Resolved
+		saveStatusLocked()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cur)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
+
+// healthzHandler — проверка живости для балансировщика и оркестратора:
+// GET /healthz. Отвечает 200, пока сервер принимает запросы, и показывает
+// время последнего внешнего снимка; устаревший снимок отмечается проверкой
+// offsite на /status, а не здесь, чтобы из-за него не перезапускали сервер.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":        "ok",
+		"time":          time.Now(),
+		"offsiteBackup": offsiteStatus(),
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Метки клиентов — произвольные ярлыки вроде vip или wholesale. Метки
+// хранятся нормализованными: в нижнем регистре, без повторов и по
+// алфавиту, поэтому одинаковые наборы всегда записаны одинаково, а
+// хранилище может построить индекс прямо по списку.
+
+const (
+	maxClientTags = 20 // меток у одного клиента
+	maxTagLength  = 32 // символов в метке
+)
+
+// normalizeTags проверяет и нормализует метки. nil остается nil: для PUT
+// это значит «метки не менять».
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if err := checkTag(t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	slices.Sort(out)
+	out = slices.Compact(out)
+	if len(out) > maxClientTags {
+		return nil, fmt.Errorf("У клиента может быть не больше %d меток", maxClientTags)
+	}
+	return out, nil
+}
+
+// checkTag допускает в метке буквы, цифры, дефис и подчеркивание.
+func checkTag(t string) error {
+	if t == "" || utf8.RuneCountInString(t) > maxTagLength {
+		return fmt.Errorf("Неверная метка %q: от 1 до %d символов", t, maxTagLength)
+	}
+	for _, r := range t {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return fmt.Errorf("Неверная метка %q: допустимы буквы, цифры, дефис и подчеркивание", t)
+		}
+	}
+	return nil
+}
+
+// hasTags сообщает, что у клиента есть все метки want. Метки клиента
+// отсортированы, поэтому поиск двоичный.
+func (c Client) hasTags(want []string) bool {
+	for _, t := range want {
+		if _, ok := slices.BinarySearch(c.Tags, t); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsRequest — тело POST /clients/{id}/tags.
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// addTagsHandler добавляет метки клиенту: POST /clients/{id}/tags.
+func addTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	var req tagsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	add, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changeTags(w, r, id, func(tags []string) []string { return append(tags, add...) })
+}
+
+// removeTagHandler снимает метку с клиента: DELETE /clients/{id}/tags/{tag}.
+// Снять метку, которой нет, не ошибка.
+func removeTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неверный ID", http.StatusBadRequest)
+		return
+	}
+	tag := strings.ToLower(r.PathValue("tag"))
+	changeTags(w, r, id, func(tags []string) []string {
+		return slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+	})
+}
+
+// changeTags применяет change к меткам клиента и отвечает клиентом.
+// Версия растет, только если метки изменились.
+func changeTags(w http.ResponseWriter, r *http.Request, id int, change func([]string) []string) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	c, exists := tenantClientLocked(requestTenant(r), id)
+	if !exists || c.deleted() {
+		http.Error(w, "Клиент не найден", http.StatusNotFound)
+		return
+	}
+	tags, err := normalizeTags(change(slices.Clone(c.Tags)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if !slices.Equal(tags, c.Tags) {
+		c.Tags = tags
+		c.Version++
+		clients[id] = c
+		publishClientEvent(eventClientUpdated, c, sourceAPI)
+	}
+	writeResponse(w, r, http.StatusOK, c)
+}
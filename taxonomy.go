@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// coffeeTerm — название кофе в справочнике и его синонимы. Canonical
+// записывается в FavCoffee вместо любого синонима.
+type coffeeTerm struct {
+	Canonical string   `json:"canonical"`
+	Synonyms  []string `json:"synonyms"`
+}
+
+// defaultCoffeeTaxonomy — справочник, с которого начинается новая установка.
+func defaultCoffeeTaxonomy() []coffeeTerm {
+	return []coffeeTerm{
+		{"espresso", []string{"эспрессо", "экспрессо"}},
+		{"doppio", []string{"доппио", "двойной эспрессо"}},
+		{"americano", []string{"американо"}},
+		{"cappuccino", []string{"капучино", "капуччино", "cappucino"}},
+		{"latte", []string{"латте", "латте макиато", "caffe latte"}},
+		{"flat white", []string{"флэт уайт", "флет уайт", "flatwhite"}},
+		{"raf", []string{"раф"}},
+		{"mocha", []string{"мокко", "мокка", "mochaccino"}},
+		{"macchiato", []string{"макиато", "маккиато"}},
+		{"cortado", []string{"кортадо"}},
+	}
+}
+
+var (
+	coffeeTerms   map[string]coffeeTerm // Справочник по каноническому названию
+	coffeeIndex   map[string]string     // Синоним или название → каноническое название
+	coffeeTermsMu sync.Mutex            // Мьютекс для защиты справочника
+)
+
+var errCoffeeSynonymTaken = errors.New("синоним уже относится к другому названию")
+
+func coffeeTaxonomyPath() string {
+	return filepath.Join(config.DataDir, "coffee_taxonomy.json")
+}
+
+func coffeeKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// loadCoffeeTaxonomy читает справочник; без файла берется справочник по умолчанию.
+func loadCoffeeTaxonomy() error {
+	terms := defaultCoffeeTaxonomy()
+	data, err := os.ReadFile(coffeeTaxonomyPath())
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		terms = nil
+		if err := json.Unmarshal(data, &terms); err != nil {
+			return fmt.Errorf("разбор %s: %w", coffeeTaxonomyPath(), err)
+		}
+	}
+
+	coffeeTermsMu.Lock()
+	defer coffeeTermsMu.Unlock()
+	coffeeTerms = make(map[string]coffeeTerm)
+	coffeeIndex = make(map[string]string)
+	for _, t := range terms {
+		if err := putCoffeeTermLocked(t); err != nil {
+			return fmt.Errorf("справочник кофе, %q: %w", t.Canonical, err)
+		}
+	}
+	return nil
+}
+
+// putCoffeeTermLocked добавляет или заменяет название в справочнике.
+func putCoffeeTermLocked(t coffeeTerm) error {
+	t.Canonical = coffeeKey(t.Canonical)
+	if t.Canonical == "" {
+		return errors.New("не указано каноническое название")
+	}
+	synonyms := make([]string, 0, len(t.Synonyms))
+	for _, s := range t.Synonyms {
+		s = coffeeKey(s)
+		if s == "" || s == t.Canonical {
+			continue
+		}
+		if owner, ok := coffeeIndex[s]; ok && owner != t.Canonical {
+			return fmt.Errorf("%w: %q → %q", errCoffeeSynonymTaken, s, owner)
+		}
+		synonyms = append(synonyms, s)
+	}
+	if owner, ok := coffeeIndex[t.Canonical]; ok && owner != t.Canonical {
+		return fmt.Errorf("%w: %q → %q", errCoffeeSynonymTaken, t.Canonical, owner)
+	}
+
+	removeCoffeeTermLocked(t.Canonical)
+	t.Synonyms = synonyms
+	coffeeTerms[t.Canonical] = t
+	coffeeIndex[t.Canonical] = t.Canonical
+	for _, s := range synonyms {
+		coffeeIndex[s] = t.Canonical
+	}
+	return nil
+}
+
+func removeCoffeeTermLocked(canonical string) bool {
+	old, ok := coffeeTerms[canonical]
+	if !ok {
+		return false
+	}
+	delete(coffeeTerms, canonical)
+	delete(coffeeIndex, canonical)
+	for _, s := range old.Synonyms {
+		delete(coffeeIndex, s)
+	}
+	return true
+}
+
+func saveCoffeeTaxonomyLocked() error {
+	return writeJSONFile(coffeeTaxonomyPath(), sortedCoffeeTermsLocked())
+}
+
+func sortedCoffeeTermsLocked() []coffeeTerm {
+	list := make([]coffeeTerm, 0, len(coffeeTerms))
+	for _, t := range coffeeTerms {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Canonical < list[j].Canonical })
+	return list
+}
+
+// canonicalCoffee приводит название кофе к каноническому. Названия, которых
+// нет в справочнике, сохраняются как есть, без лишних пробелов.
+func canonicalCoffee(name string) string {
+	coffeeTermsMu.Lock()
+	defer coffeeTermsMu.Unlock()
+	if canonical, ok := coffeeIndex[coffeeKey(name)]; ok {
+		return canonical
+	}
+	return strings.TrimSpace(name)
+}
+
+// coffeeTaxonomyHandler ведет справочник: GET — список, POST — добавление
+// или замена названия с синонимами, DELETE ?canonical= — удаление.
+// Уже сохраненных клиентов изменения не касаются до запуска пересчета
+// recanonicalize-coffee в /admin/batch.
+func coffeeTaxonomyHandler(w http.ResponseWriter, r *http.Request) {
+	coffeeTermsMu.Lock()
+	defer coffeeTermsMu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sortedCoffeeTermsLocked())
+
+	case http.MethodPost:
+		var t coffeeTerm
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		if err := putCoffeeTermLocked(t); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errCoffeeSynonymTaken) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err := saveCoffeeTaxonomyLocked(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coffeeTerms[coffeeKey(t.Canonical)])
+
+	case http.MethodDelete:
+		if !removeCoffeeTermLocked(coffeeKey(r.URL.Query().Get("canonical"))) {
+			http.Error(w, "Название не найдено", http.StatusNotFound)
+			return
+		}
+		if err := saveCoffeeTaxonomyLocked(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
+
+// recanonicalizeCoffeeJob приводит FavCoffee сохраненных клиентов к
+// текущему справочнику.
+var recanonicalizeCoffeeJob = &batchJob{
+	Name: "recanonicalize-coffee",
+	Process: func(ctx context.Context, chunk []Client) error {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+
+		for _, c := range chunk {
+			cur, exists := clients[c.ID]
+			if !exists {
+				continue
+			}
+			if canonical := canonicalCoffee(cur.FavCoffee); canonical != cur.FavCoffee {
+				cur.FavCoffee = canonical
+				cur.Version++
+				clients[c.ID] = cur
+				publishClientEvent(eventClientUpdated, cur, sourceJob)
+			}
+		}
+		return ctx.Err()
+	},
+}
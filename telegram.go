@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TelegramConfig задает бота Telegram: он пишет в чаты ChatIDs о новых и
+// удаленных клиентах и отвечает там на команды /client и /stats. Команды из
+// других чатов игнорируются: бот отдает персональные данные. Пустой токен
+// берется из TELEGRAM_BOT_TOKEN.
+type TelegramConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Token       string   `json:"token"`
+	ChatIDs     []int64  `json:"chatIds"`
+	APIURL      string   `json:"apiUrl"`      // по умолчанию https://api.telegram.org
+	PollTimeout Duration `json:"pollTimeout"` // длинный опрос getUpdates
+}
+
+// validate проверяет настройки до запуска сервера.
+func (c TelegramConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.ChatIDs) == 0 {
+		return fmt.Errorf("telegram: укажите chatIds")
+	}
+	if u, err := url.Parse(c.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("telegram: apiUrl должен быть адресом http(s)://")
+	}
+	if c.PollTimeout < Duration(time.Second) {
+		return fmt.Errorf("telegram: pollTimeout должен быть не меньше 1s")
+	}
+	return nil
+}
+
+// telegramBot вызывает Bot API.
+type telegramBot struct {
+	cfg    TelegramConfig
+	client *outboundClient
+	notify chan string // уведомления по порядку событий
+}
+
+// telegram — запущенный бот; nil, если интеграция выключена.
+var telegram *telegramBot
+
+func newTelegramBot(cfg TelegramConfig) (*telegramBot, error) {
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("TELEGRAM_BOT_TOKEN")
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("telegram: не задан токен бота")
+	}
+	return &telegramBot{
+		cfg: cfg,
+		// Ответ на длинный опрос приходит не раньше PollTimeout.
+		client: newOutboundClient("telegram", outboundOptions{Timeout: time.Duration(cfg.PollTimeout) + 10*time.Second}),
+		notify: make(chan string, 100),
+	}, nil
+}
+
+// telegramResponse — ответ Bot API.
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// call выполняет метод Bot API и разбирает result в out.
+func (b *telegramBot) call(ctx context.Context, method string, params, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(b.cfg.APIURL, "/")+"/bot"+b.cfg.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// В тексте ошибки net/http есть адрес, а в нем токен.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("Telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	var res telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("Telegram %s: %s", method, resp.Status)
+	}
+	if !res.OK {
+		return fmt.Errorf("Telegram %s: %s", method, res.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
+
+func (b *telegramBot) send(ctx context.Context, chatID int64, text string) error {
+	return b.call(ctx, "sendMessage", map[string]any{"chat_id": chatID, "text": text}, nil)
+}
+
+// telegramOnClientEvent ставит в очередь уведомление о новом или удаленном
+// клиенте. Если очередь заполнена, уведомление пропускается: событие под
+// clientsMu ждать не может.
+func telegramOnClientEvent(e clientEvent) {
+	var text string
+	switch e.Type {
+	case eventClientCreated:
+		text = fmt.Sprintf("Новый клиент: %s (ID %d)", e.Client.Name, e.Client.ID)
+	case eventClientDeleted:
+		text = fmt.Sprintf("Клиент удален: %s (ID %d)", e.Client.Name, e.Client.ID)
+	case eventClientPurged:
+		text = fmt.Sprintf("Клиент удален окончательно: %s (ID %d)", e.Client.Name, e.Client.ID)
+	default:
+		return
+	}
+	// Импорт добавляет тысячи клиентов разом; о нем уведомлять по одному
+	// незачем. Клиент из архива не новый, а о его удалении уже сообщили.
+	if e.Source == sourceImport || e.Source == sourceRestore {
+		return
+	}
+	select {
+	case telegram.notify <- text:
+	default:
+		logf("Очередь уведомлений Telegram заполнена, пропущено: %s", text)
+	}
+}
+
+// runTelegram рассылает уведомления и отвечает на команды до отмены ctx.
+// Команды читает один экземпляр: Bot API не дает опрашивать бота
+// одновременно нескольким получателям.
+func runTelegram(ctx context.Context) {
+	b := telegram
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case text := <-b.notify:
+				for _, chat := range b.cfg.ChatIDs {
+					if err := b.send(ctx, chat, text); err != nil && ctx.Err() == nil {
+						logError("Ошибка уведомления Telegram: %v", err)
+					}
+				}
+			}
+		}
+	}()
+
+	for {
+		err := runExclusive(ctx, "telegram", b.poll)
+		if err != nil && !errors.Is(err, errLeaseHeld) && ctx.Err() == nil {
+			logError("Ошибка опроса Telegram: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// telegramUpdate — входящее обновление; нужны только текстовые сообщения.
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// poll читает команды длинным опросом, пока нет ошибки.
+func (b *telegramBot) poll(ctx context.Context) error {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := map[string]any{
+			"offset":          offset,
+			"timeout":         int(time.Duration(b.cfg.PollTimeout).Seconds()),
+			"allowed_updates": []string{"message"},
+		}
+		if err := b.call(ctx, "getUpdates", params, &updates); err != nil {
+			return err
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || !slices.Contains(b.cfg.ChatIDs, u.Message.Chat.ID) {
+				continue
+			}
+			reply := telegramCommand(u.Message.Text)
+			if reply == "" {
+				continue
+			}
+			if err := b.send(ctx, u.Message.Chat.ID, reply); err != nil {
+				logError("Ошибка ответа Telegram: %v", err)
+			}
+		}
+	}
+}
+
+// telegramCommand возвращает ответ на команду; пустая строка — не команда.
+func telegramCommand(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+	// В группах к команде добавляется имя бота: /stats@coffee_bot.
+	cmd, _, _ := strings.Cut(fields[0], "@")
+	switch cmd {
+	case "/client":
+		if len(fields) != 2 {
+			return "Использование: /client <ID>"
+		}
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return "Неверный ID"
+		}
+		clientsMu.Lock()
+		c, exists := clients[id]
+		clientsMu.Unlock()
+		if !exists || c.deleted() {
+			return "Клиент не найден"
+		}
+		return telegramClientText(c)
+	case "/stats":
+		st, _ := computeClientStats(clientFilter{AllTenants: true})
+		return telegramStatsText(st)
+	case "/start", "/help":
+		return "Команды:\n/client <ID> — карточка клиента\n/stats — сводка по клиентам"
+	}
+	return "Неизвестная команда. /help — список команд"
+}
+
+func telegramClientText(c Client) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Клиент %d: %s\n", c.ID, c.Name)
+	if age := c.currentAge(); age > 0 {
+		fmt.Fprintf(&b, "Возраст: %d\n", age)
+	}
+	if c.FavCoffee != "" {
+		fmt.Fprintf(&b, "Любимый кофе: %s\n", c.FavCoffee)
+	}
+	if addr := strings.Trim(c.Address.City+", "+c.Address.Street, ", "); addr != "" {
+		fmt.Fprintf(&b, "Адрес: %s\n", addr)
+	}
+	if c.Email != "" {
+		fmt.Fprintf(&b, "Email: %s\n", c.Email)
+	}
+	if !c.RegisterDate.IsZero() {
+		fmt.Fprintf(&b, "Зарегистрирован: %s\n", c.RegisterDate.Format("02.01.2006"))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func telegramStatsText(st clientStats) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Клиентов: %d\n", st.TotalClients)
+	if st.AverageAge != nil {
+		fmt.Fprintf(&b, "Средний возраст: %.1f\n", *st.AverageAge)
+	}
+	for i, c := range st.TopCoffees[:min(3, len(st.TopCoffees))] {
+		if i == 0 {
+			b.WriteString("Любимый кофе:")
+		}
+		fmt.Fprintf(&b, " %s (%d)", c.Name, c.Count)
+	}
+	if len(st.TopCoffees) > 0 {
+		b.WriteString("\n")
+	}
+	for i, c := range st.Cities[:min(3, len(st.Cities))] {
+		if i == 0 {
+			b.WriteString("Города:")
+		}
+		fmt.Fprintf(&b, " %s (%d)", c.Name, c.Count)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplatesConfig задает каталог HTML-шаблонов. С Reload шаблоны
+// перечитываются при изменении файлов — удобно при разработке.
+type TemplatesConfig struct {
+	Dir    string `json:"dir"`
+	Reload bool   `json:"reload"`
+}
+
+// Общие шаблоны, доступные каждой странице: каркасы и фрагменты.
+var sharedTemplateDirs = []string{"layouts", "partials"}
+
+// templateManager хранит страницы из каталога шаблонов. Каждая страница
+// разбирается вместе с общими шаблонами в отдельный набор, поэтому
+// блоки {{define}} разных страниц не мешают друг другу. Имя страницы —
+// путь относительно каталога, например "main.html" или "admin/index.html".
+//
+// Наборы собираются для каждого языка: в шаблонах доступны функции
+// {{t "сообщение" аргументы...}}, переводящая текст, и {{lang}}.
+type templateManager struct {
+	dir    string
+	reload bool
+
+	mu    sync.Mutex
+	pages map[string]map[string]*template.Template // язык → страница → набор
+	stamp templateStamp
+}
+
+// templateStamp отличает одно состояние каталога от другого: правка
+// меняет время изменения, удаление файла — их число.
+type templateStamp struct {
+	latest time.Time
+	files  int
+}
+
+func newTemplateManager(cfg TemplatesConfig) (*templateManager, error) {
+	tm := &templateManager{dir: cfg.Dir, reload: cfg.Reload}
+	files, stamp, err := tm.scan()
+	if err != nil {
+		return nil, err
+	}
+	if err := tm.load(files, stamp); err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
+// scan находит все *.html в каталоге шаблонов, включая подкаталоги.
+func (tm *templateManager) scan() ([]string, templateStamp, error) {
+	var files []string
+	var stamp templateStamp
+	err := filepath.WalkDir(tm.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".html" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+		stamp.files++
+		if info.ModTime().After(stamp.latest) {
+			stamp.latest = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, stamp, fmt.Errorf("шаблоны: %w", err)
+	}
+	return files, stamp, nil
+}
+
+// load разбирает файлы и заменяет набор страниц. При ошибке разбора
+// остаются прежние страницы.
+func (tm *templateManager) load(files []string, stamp templateStamp) error {
+	set := make(map[string]map[string]*template.Template, len(catalogs))
+	for locale := range catalogs {
+		pages, err := tm.parse(files, templateFuncs(locale))
+		if err != nil {
+			return err
+		}
+		set[locale] = pages
+	}
+
+	tm.mu.Lock()
+	tm.pages, tm.stamp = set, stamp
+	tm.mu.Unlock()
+	return nil
+}
+
+// templateFuncs — функции шаблонов для языка locale.
+func templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"lang":  func() string { return locale },
+		"asset": assetURL,
+		"t": func(msg string, args ...any) string {
+			msg = translate(locale, msg)
+			if len(args) > 0 {
+				msg = fmt.Sprintf(msg, args...)
+			}
+			return msg
+		},
+	}
+}
+
+// parse разбирает страницы с общими шаблонами и функциями funcs.
+func (tm *templateManager) parse(files []string, funcs template.FuncMap) (map[string]*template.Template, error) {
+	shared := template.New("").Funcs(funcs)
+	var pages []string
+	for _, path := range files {
+		name, err := filepath.Rel(tm.dir, path)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		if dir, _, nested := strings.Cut(name, "/"); !nested || !slices.Contains(sharedTemplateDirs, dir) {
+			pages = append(pages, name)
+			continue
+		}
+		if err := parseTemplateFile(shared.New(name), path); err != nil {
+			return nil, err
+		}
+	}
+
+	set := make(map[string]*template.Template, len(pages))
+	for _, name := range pages {
+		t, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if err := parseTemplateFile(t.New(name), filepath.Join(tm.dir, filepath.FromSlash(name))); err != nil {
+			return nil, err
+		}
+		set[name] = t
+	}
+	return set, nil
+}
+
+func parseTemplateFile(t *template.Template, path string) error {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("шаблоны: %w", err)
+	}
+	if _, err := t.Parse(string(text)); err != nil {
+		return fmt.Errorf("шаблоны: %w", err)
+	}
+	return nil
+}
+
+// refresh перечитывает шаблоны, если файлы изменились с прошлой загрузки.
+func (tm *templateManager) refresh() error {
+	files, stamp, err := tm.scan()
+	if err != nil {
+		return err
+	}
+	tm.mu.Lock()
+	changed := stamp != tm.stamp
+	tm.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	if err := tm.load(files, stamp); err != nil {
+		return err
+	}
+	logf("Шаблоны перечитаны")
+	return nil
+}
+
+// render отрисовывает страницу с кодом 200.
+func (tm *templateManager) render(w http.ResponseWriter, r *http.Request, name string, data any) error {
+	return tm.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderStatus отрисовывает страницу на языке запроса. Результат сначала
+// собирается в буфер, чтобы при ошибке в шаблоне можно было ответить 500,
+// а не половиной страницы.
+func (tm *templateManager) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) error {
+	if tm.reload {
+		if err := tm.refresh(); err != nil {
+			return err
+		}
+	}
+	tm.mu.Lock()
+	t, ok := tm.pages[localeFrom(r)][name]
+	tm.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("шаблон %s не найден", name)
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+	// Страницы не кэшируются: иначе браузер не узнает о новых отпечатках
+	// статических файлов (assets.go).
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Несколько кофеен в одном развертывании. Каждый клиент принадлежит одной
+// кофейне (Client.Tenant), и запрос видит только клиентов своей кофейни.
+// Кофейня запроса берется из токена или API-ключа, привязанного к кофейне;
+// администраторы без привязки выбирают ее заголовком X-Tenant-ID. Без
+// заголовка запрос относится к основной кофейне с пустым ID — в ней
+// остаются клиенты, заведенные до появления кофеен.
+//
+// Настройки сервера, резервные копии, журналы, вебхуки и прочие /admin/*
+// общие для всего развертывания; их меняют только администраторы без
+// привязки к кофейне (см. requireDeploymentAdmin).
+
+// tenantHeader — заголовок, которым выбирается кофейня.
+const tenantHeader = "X-Tenant-ID"
+
+const ctxTenant ctxKey = ctxLocale + 1
+
+// Tenant — кофейня.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var (
+	tenants   = make(map[string]Tenant) // Кофейни по ID, без основной
+	tenantsMu sync.Mutex                // Мьютекс для защиты кофеен
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+var (
+	errTenantUnknown   = errors.New("Неизвестная кофейня")
+	errTenantForbidden = errors.New("Нет доступа к другой кофейне")
+)
+
+func tenantsPath() string {
+	return filepath.Join(config.DataDir, "tenants.json")
+}
+
+// loadTenants читает список кофеен.
+func loadTenants() error {
+	data, err := os.ReadFile(tenantsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return fmt.Errorf("разбор %s: %w", tenantsPath(), err)
+	}
+	return nil
+}
+
+// tenantExists сообщает, есть ли кофейня; основная есть всегда.
+func tenantExists(id string) bool {
+	if id == "" {
+		return true
+	}
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	_, ok := tenants[id]
+	return ok
+}
+
+// resolveTenant определяет кофейню запроса. Отправитель, привязанный к
+// кофейне, не может выбрать другую заголовком. Выбрать кофейню заголовком
+// может только администратор без привязки; остальные, в том числе запросы
+// без аутентификации, работают с основной.
+func resolveTenant(r *http.Request) (string, error) {
+	p, err := authenticate(r)
+	if errors.Is(err, errNoCredentials) {
+		p, _ = adminPrincipal(r)
+	}
+	header := strings.TrimSpace(r.Header.Get(tenantHeader))
+	if p.Tenant != "" {
+		if header != "" && header != p.Tenant {
+			return "", errTenantForbidden
+		}
+		return p.Tenant, nil
+	}
+	if header == "" {
+		return "", nil
+	}
+	if !p.Role.Allows(RoleAdmin) || p.Scope != "" {
+		return "", errTenantForbidden
+	}
+	if !tenantExists(header) {
+		return "", errTenantUnknown
+	}
+	return header, nil
+}
+
+// withTenant запоминает кофейню запроса в контексте.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := resolveTenant(r)
+		if errors.Is(err, errTenantForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxTenant, tenant)))
+	})
+}
+
+// requestTenant возвращает кофейню запроса; пустая — основная.
+func requestTenant(r *http.Request) string {
+	t, _ := r.Context().Value(ctxTenant).(string)
+	return t
+}
+
+// tenantClientLocked возвращает клиента кофейни tenant: клиентов других
+// кофеен для запроса нет. Вызывается под clientsMu.
+func tenantClientLocked(tenant string, id int) (Client, bool) {
+	c, exists := clients[id]
+	if !exists || c.Tenant != tenant {
+		return Client{}, false
+	}
+	return c, true
+}
+
+// parseRequestFilter читает фильтр из параметров запроса и ограничивает
+// его кофейней запроса.
+func parseRequestFilter(r *http.Request) (clientFilter, error) {
+	f, err := parseClientFilter(r.URL.Query())
+	f.Tenant = requestTenant(r)
+	return f, err
+}
+
+// requireDeploymentAdmin пропускает администраторов, не привязанных к
+// кофейне: настройки /admin/* общие для всех кофеен.
+func requireDeploymentAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if p, _ := principalFrom(r); p.Tenant != "" {
+			writeAPIError(w, r, http.StatusForbidden, apiError{
+				Error:    "forbidden",
+				Message:  "Доступно только администраторам всего развертывания",
+				Role:     p.Role,
+				Required: RoleAdmin,
+			})
+			return
+		}
+		next(w, r)
+	})
+}
+
+// tenantInfo — кофейня в списке.
+type tenantInfo struct {
+	Tenant
+	Clients int `json:"clients"` // без мягко удаленных
+}
+
+// tenantsHandler перечисляет кофейни (GET) и добавляет новую (POST
+// /admin/tenants с телом {"id": "...", "name": "..."}). Основная кофейня
+// идет в списке первой с пустым ID.
+func tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		counts := make(map[string]int)
+		clientsMu.Lock()
+		for _, c := range clients {
+			if !c.deleted() {
+				counts[c.Tenant]++
+			}
+		}
+		clientsMu.Unlock()
+
+		tenantsMu.Lock()
+		list := make([]tenantInfo, 0, len(tenants)+1)
+		for _, t := range tenants {
+			list = append(list, tenantInfo{t, counts[t.ID]})
+		}
+		tenantsMu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		list = append([]tenantInfo{{Tenant{Name: "Основная кофейня"}, counts[""]}}, list...)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var t Tenant
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&t); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		t.Name = strings.TrimSpace(t.Name)
+		if !tenantIDPattern.MatchString(t.ID) {
+			http.Error(w, "id: до 32 строчных латинских букв, цифр и дефисов", http.StatusBadRequest)
+			return
+		}
+		if t.Name == "" {
+			http.Error(w, "Не указано название кофейни", http.StatusBadRequest)
+			return
+		}
+		t.CreatedAt = time.Now()
+
+		tenantsMu.Lock()
+		defer tenantsMu.Unlock()
+		if _, exists := tenants[t.ID]; exists {
+			http.Error(w, "Кофейня с таким ID уже существует", http.StatusConflict)
+			return
+		}
+		tenants[t.ID] = t
+		if err := writeJSONFile(tenantsPath(), tenants); err != nil {
+			delete(tenants, t.ID)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resyncReplicas() // кофейни приходят репликам только в snapshot
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResolveTenant(t *testing.T) {
+	setupTest(t)
+	tenants["north"] = Tenant{ID: "north"}
+	tenants["south"] = Tenant{ID: "south"}
+
+	admin := testToken(t, "root", RoleAdmin, "")
+	editor := testToken(t, "barista", RoleEditor, "")
+	northAdmin := testToken(t, "north-admin", RoleAdmin, "north")
+	northViewer := testToken(t, "north-viewer", RoleViewer, "north")
+
+	tests := []struct {
+		name    string
+		token   string
+		cookie  string // токен в cookie /admin/
+		header  string
+		want    string
+		wantErr error
+	}{
+		{"гость без заголовка", "", "", "", "", nil},
+		{"гость выбирает кофейню", "", "", "north", "", errTenantForbidden},
+		{"редактор выбирает кофейню", editor, "", "north", "", errTenantForbidden},
+		{"администратор выбирает кофейню", admin, "", "north", "north", nil},
+		{"администратор без заголовка", admin, "", "", "", nil},
+		{"неизвестная кофейня", admin, "", "west", "", errTenantUnknown},
+		{"привязанный без заголовка", northViewer, "", "", "north", nil},
+		{"привязанный со своей кофейней", northViewer, "", "north", "north", nil},
+		{"привязанный с чужой кофейней", northAdmin, "", "south", "", errTenantForbidden},
+		{"администратор через cookie", "", admin, "south", "south", nil},
+		{"привязанный через cookie", "", northViewer, "south", "", errTenantForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/getClients", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: adminCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(tenantHeader, tt.header)
+			}
+			got, err := resolveTenant(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("кофейня %q, ожидалась %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTenantIsolation проверяет, что клиента другой кофейни нельзя ни
+// прочитать, ни изменить.
+func TestTenantIsolation(t *testing.T) {
+	setupTest(t)
+	tenants["north"] = Tenant{ID: "north"}
+	clients = map[int]Client{
+		1: {ID: 1, Name: "Айгерим", Version: 1},
+		2: {ID: 2, Name: "Нурлан", Version: 1, Tenant: "north"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /clients/{id}", requireRole(RoleViewer, getClientHandler))
+	mux.HandleFunc("PUT /clients/{id}", requireRole(RoleEditor, updateClientHandler))
+	mux.HandleFunc("DELETE /clients/where", requireRole(RoleAdmin, deleteWhereHandler))
+	h := withTenant(mux)
+
+	admin := testToken(t, "root", RoleAdmin, "")
+	north := testToken(t, "north-admin", RoleAdmin, "north")
+	viewer := testToken(t, "viewer", RoleViewer, "")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		header string
+		body   string
+		want   int
+	}{
+		{"гость", http.MethodGet, "/clients/2", "", "north", "", http.StatusForbidden},
+		{"гость без заголовка", http.MethodGet, "/clients/1", "", "", "", http.StatusUnauthorized},
+		{"просмотр чужой кофейни", http.MethodGet, "/clients/2", viewer, "north", "", http.StatusForbidden},
+		{"основная не видит north", http.MethodGet, "/clients/2", viewer, "", "", http.StatusNotFound},
+		{"своя кофейня", http.MethodGet, "/clients/2", north, "", "", http.StatusOK},
+		{"north не видит основную", http.MethodGet, "/clients/1", north, "", "", http.StatusNotFound},
+		{"администратор с заголовком", http.MethodGet, "/clients/2", admin, "north", "", http.StatusOK},
+		{"изменение из другой кофейни", http.MethodPut, "/clients/1", north, "", `{"name":"x","version":1}`, http.StatusNotFound},
+		{"удаление из другой кофейни", http.MethodDelete, "/clients/where?name=айгерим", north, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers[tenantHeader] = tt.header
+			}
+			w := testRequest(h, tt.method, tt.target, tt.token, tt.body, headers)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if c := clients[1]; c.Name != "Айгерим" || c.deleted() {
+		t.Errorf("клиент основной кофейни изменен: %+v", c)
+	}
+}
+
+// TestMenuDeploymentAdmin проверяет, что общее меню меняют только
+// администраторы без привязки к кофейне.
+func TestMenuDeploymentAdmin(t *testing.T) {
+	setupTest(t)
+	tenants["north"] = Tenant{ID: "north"}
+	apiOperations = nil
+	mux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+	t.Cleanup(func() { http.DefaultServeMux = mux; apiOperations = nil })
+	for _, e := range menuAPI {
+		handleAPI(e.op, e.h)
+	}
+	h := withTenant(http.DefaultServeMux)
+
+	admin := testToken(t, "root", RoleAdmin, "")
+	north := testToken(t, "north-admin", RoleAdmin, "north")
+	editor := testToken(t, "editor", RoleEditor, "")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		body   string
+		want   int
+	}{
+		{"редактор добавляет", http.MethodPost, "/menu", editor, `{"name":"Латте","price":900,"available":true}`, http.StatusForbidden},
+		{"администратор кофейни добавляет", http.MethodPost, "/menu", north, `{"name":"Латте","price":900,"available":true}`, http.StatusForbidden},
+		{"администратор развертывания добавляет", http.MethodPost, "/menu", admin, `{"name":"Латте","price":900,"available":true}`, http.StatusCreated},
+		{"администратор кофейни меняет", http.MethodPut, "/menu/латте", north, `{"price":1,"available":true}`, http.StatusForbidden},
+		{"администратор кофейни убирает", http.MethodDelete, "/menu/латте", north, "", http.StatusForbidden},
+		{"кофейня читает", http.MethodGet, "/menu", north, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := testRequest(h, tt.method, tt.target, tt.token, tt.body, map[string]string{"Content-Type": "application/json"})
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if it := menu[coffeeKey("Латте")]; it.Price != 900 {
+		t.Errorf("позиция %+v", it)
+	}
+}
+
+// TestTenantClientIDs проверяет, что по ответу на создание клиента нельзя
+// узнать, занят ли ID клиентом другой кофейни.
+func TestTenantClientIDs(t *testing.T) {
+	setupTest(t)
+	tenants["north"] = Tenant{ID: "north"}
+	clients = map[int]Client{7: {ID: 7, Name: "Айгерим", Version: 1}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /clients", requireRole(RoleEditor, addClientHandler))
+	mux.HandleFunc("PUT /clients/{id}", requireRole(RoleEditor, updateClientHandler))
+	h := withTenant(mux)
+	north := testToken(t, "north-editor", RoleEditor, "north")
+
+	for _, id := range []int{7, 100} {
+		w := testRequest(h, http.MethodPost, "/clients", north, fmt.Sprintf(`{"id":%d,"name":"Нурлан"}`, id), nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("id %d: статус %d: %s", id, w.Code, w.Body)
+		}
+	}
+	if c := clients[7]; c.Name != "Айгерим" || c.Tenant != "" {
+		t.Errorf("клиент основной кофейни изменен: %+v", c)
+	}
+	if c := clients[8]; c.Tenant != "north" {
+		t.Errorf("клиент 8: %+v", c)
+	}
+	if c := clients[9]; c.Tenant != "north" {
+		t.Errorf("клиент 9: %+v", c)
+	}
+
+	for _, id := range []int{7, 100} {
+		w := testRequest(h, http.MethodPut, fmt.Sprintf("/clients/%d?mode=upsert", id), north, `{"name":"Нурлан"}`, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("upsert id %d: статус %d: %s", id, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Параметры TOTP (RFC 6238) — значения по умолчанию, которые понимают все
+// приложения-аутентификаторы.
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1 // допустимое расхождение часов, в шагах
+
+	recoveryCodeCount = 10
+	totpIssuer        = "Coffeemen birge"
+
+	// После maxOTPFailures неверных кодов подряд вход пользователя
+	// блокируется на otpLockout: иначе 6-значный код подбирается перебором,
+	// если пароль известен.
+	maxOTPFailures = 5
+	otpLockout     = 15 * time.Minute
+)
+
+// scope2FAEnroll — токен, выданный пользователю, который обязан настроить
+// 2FA, но еще не сделал этого. Такой токен годится только для /auth/2fa/*.
+const scope2FAEnroll = "2fa-enroll"
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// twoFactor — состояние 2FA пользователя.
+type twoFactor struct {
+	Secret        string    `json:"secret"`
+	Confirmed     bool      `json:"confirmed"`
+	RecoveryCodes []string  `json:"recoveryCodes"` // SHA-256 неиспользованных кодов
+	LastStep      int64     `json:"lastStep"`      // последний принятый шаг, защита от повтора кода
+	EnrolledAt    time.Time `json:"enrolledAt"`
+	// Failures — неверные коды подряд; LockedUntil — до какого времени вход
+	// заблокирован. Хранятся в файле, чтобы перезапуск не снимал блокировку.
+	Failures    int        `json:"failures,omitempty"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+var (
+	twoFactors   = make(map[string]*twoFactor) // Состояние 2FA по логину
+	twoFactorsMu sync.Mutex                    // Мьютекс для защиты состояния 2FA
+)
+
+var (
+	errOTPRequired = errors.New("Требуется код двухфакторной аутентификации")
+	errOTPInvalid  = errors.New("Неверный код двухфакторной аутентификации")
+)
+
+// otpLockedError — вход заблокирован после серии неверных кодов.
+type otpLockedError struct {
+	Until time.Time
+}
+
+func (e otpLockedError) Error() string {
+	return "Слишком много неверных кодов двухфакторной аутентификации, попробуйте позже"
+}
+
+func twoFactorPath() string {
+	return filepath.Join(config.DataDir, "twofactor.json")
+}
+
+// loadTwoFactor читает состояние 2FA пользователей.
+func loadTwoFactor() error {
+	data, err := os.ReadFile(twoFactorPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	twoFactorsMu.Lock()
+	defer twoFactorsMu.Unlock()
+	if err := json.Unmarshal(data, &twoFactors); err != nil {
+		return fmt.Errorf("разбор %s: %w", twoFactorPath(), err)
+	}
+	return nil
+}
+
+func saveTwoFactorLocked() error {
+	return writeJSONFile(twoFactorPath(), twoFactors)
+}
+
+// totpCode вычисляет код для шага step.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1_000_000)
+}
+
+// verifyTOTP ищет шаг, для которого code верен, с учетом расхождения часов.
+// Шаги не позже lastStep не принимаются: один код нельзя использовать дважды.
+func verifyTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpStep.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes генерирует одноразовые коды восстановления вида xxxxx-xxxxx.
+func newRecoveryCodes() (plain, hashed []string) {
+	for range recoveryCodeCount {
+		c := randomHex(5)
+		plain = append(plain, c[:5]+"-"+c[5:])
+		hashed = append(hashed, hashRecoveryCode(c))
+	}
+	return plain, hashed
+}
+
+// twoFactorRequired сообщает, обязана ли роль использовать 2FA.
+func twoFactorRequired(role Role) bool {
+	return slices.Contains(config.Auth.Require2FA, role)
+}
+
+// checkSecondFactor проверяет код TOTP или код восстановления при входе.
+// Возвращает enrolled=false, если пользователь еще не настроил 2FA. Пока
+// вход заблокирован после серии неверных кодов, возвращает otpLockedError и
+// коды не проверяет.
+func checkSecondFactor(username, otp, recovery string) (enrolled bool, err error) {
+	twoFactorsMu.Lock()
+	defer twoFactorsMu.Unlock()
+
+	tf, ok := twoFactors[username]
+	if !ok || !tf.Confirmed {
+		return false, nil
+	}
+	return true, verifySecondFactorLocked(username, tf, otp, recovery)
+}
+
+// verifySecondFactorLocked проверяет код TOTP или код восстановления
+// пользователя с настроенной 2FA и считает неверные коды: после
+// maxOTPFailures подряд проверка блокируется на otpLockout. Общая для входа
+// и отключения 2FA, чтобы код нельзя было подобрать ни там, ни там.
+// Вызывается под twoFactorsMu.
+func verifySecondFactorLocked(username string, tf *twoFactor, otp, recovery string) error {
+	now := time.Now()
+	if tf.LockedUntil != nil && now.Before(*tf.LockedUntil) {
+		return otpLockedError{Until: *tf.LockedUntil}
+	}
+	valid := false
+	switch {
+	case otp != "":
+		var step int64
+		if step, valid = verifyTOTP(tf.Secret, otp, tf.LastStep, now); valid {
+			tf.LastStep = step
+		}
+	case recovery != "":
+		if i := slices.Index(tf.RecoveryCodes, hashRecoveryCode(recovery)); i >= 0 {
+			tf.RecoveryCodes = slices.Delete(tf.RecoveryCodes, i, i+1)
+			valid = true
+		}
+	default:
+		return errOTPRequired
+	}
+	if valid {
+		tf.Failures, tf.LockedUntil = 0, nil
+		return saveTwoFactorLocked()
+	}
+	tf.Failures++
+	if tf.Failures >= maxOTPFailures {
+		until := now.Add(otpLockout)
+		tf.Failures, tf.LockedUntil = 0, &until
+		logf("Вход пользователя %s заблокирован до %s: неверных кодов 2FA подряд: %d", username, until.Format(time.RFC3339), maxOTPFailures)
+	}
+	if err := saveTwoFactorLocked(); err != nil {
+		return err
+	}
+	return errOTPInvalid
+}
+
+// requireEnrollment пропускает любого пользователя с JWT, в том числе с
+// токеном scope2FAEnroll. API-ключам 2FA не нужна.
+func requireEnrollment(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(r)
+		if err != nil || p.Kind != principalUser {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Требуется вход пользователя", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), ctxPrincipal, p)))
+	}
+}
+
+// twoFactorEnrollHandler начинает настройку 2FA: выдает новый секрет. Он
+// начнет действовать после подтверждения кодом в /auth/2fa/confirm.
+func twoFactorEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	p, _ := principalFrom(r)
+
+	twoFactorsMu.Lock()
+	defer twoFactorsMu.Unlock()
+
+	if tf, ok := twoFactors[p.Name]; ok && tf.Confirmed {
+		http.Error(w, "Двухфакторная аутентификация уже настроена", http.StatusConflict)
+		return
+	}
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	secret := b32.EncodeToString(key)
+	twoFactors[p.Name] = &twoFactor{Secret: secret}
+	if err := saveTwoFactorLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	otpauth := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + p.Name,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {totpIssuer},
+			"digits": {fmt.Sprint(totpDigits)},
+			"period": {fmt.Sprint(int(totpStep.Seconds()))},
+		}.Encode(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":     secret,
+		"otpauthUrl": otpauth.String(),
+	})
+}
+
+// twoFactorConfirmHandler включает 2FA после проверки первого кода и
+// возвращает коды восстановления. Они показываются только один раз.
+func twoFactorConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	p, _ := principalFrom(r)
+
+	twoFactorsMu.Lock()
+	defer twoFactorsMu.Unlock()
+
+	tf, ok := twoFactors[p.Name]
+	if !ok {
+		http.Error(w, "Сначала вызовите /auth/2fa/enroll", http.StatusConflict)
+		return
+	}
+	if tf.Confirmed {
+		http.Error(w, "Двухфакторная аутентификация уже настроена", http.StatusConflict)
+		return
+	}
+	step, valid := verifyTOTP(tf.Secret, req.Code, tf.LastStep, time.Now())
+	if !valid {
+		http.Error(w, errOTPInvalid.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	plain, hashed := newRecoveryCodes()
+	tf.Confirmed = true
+	tf.LastStep = step
+	tf.RecoveryCodes = hashed
+	tf.EnrolledAt = time.Now()
+	if err := saveTwoFactorLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recoveryCodes": plain})
+}
+
+// twoFactorDisableHandler отключает 2FA по действующему коду. Для ролей,
+// которым 2FA обязательна, отключение запрещено. Неверные коды считаются
+// и блокируют проверку так же, как при входе.
+func twoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+		return
+	}
+	p, _ := principalFrom(r)
+	if twoFactorRequired(p.Role) {
+		writeAPIError(w, r, http.StatusForbidden, apiError{
+			Error:   "2fa_required",
+			Message: "Для этой роли двухфакторная аутентификация обязательна",
+			Role:    p.Role,
+		})
+		return
+	}
+
+	twoFactorsMu.Lock()
+	defer twoFactorsMu.Unlock()
+
+	tf, ok := twoFactors[p.Name]
+	if !ok || !tf.Confirmed {
+		http.Error(w, "Двухфакторная аутентификация не настроена", http.StatusConflict)
+		return
+	}
+	err := verifySecondFactorLocked(p.Name, tf, req.Code, "")
+	var locked otpLockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errOTPRequired), errors.Is(err, errOTPInvalid):
+		http.Error(w, errOTPInvalid.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(twoFactors, p.Name)
+	if err := saveTwoFactorLocked(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testTOTPSecret — секрет в base32, как его показывает /auth/2fa/enroll.
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// testTOTP возвращает код для момента now, сдвинутого на offset шагов.
+func testTOTP(t *testing.T, now time.Time, offset int64) string {
+	t.Helper()
+	key, err := b32.DecodeString(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, now.Unix()/int64(totpStep.Seconds())+offset)
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	step := now.Unix() / int64(totpStep.Seconds())
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     bool
+	}{
+		{"текущий шаг", testTOTP(t, now, 0), 0, true},
+		{"предыдущий шаг", testTOTP(t, now, -1), 0, true},
+		{"следующий шаг", testTOTP(t, now, 1), 0, true},
+		{"слишком старый", testTOTP(t, now, -2), 0, false},
+		{"повтор кода", testTOTP(t, now, 0), step, false},
+		{"неверный код", "000000", 0, testTOTP(t, now, 0) == "000000"},
+		{"пустой код", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := verifyTOTP(testTOTPSecret, tt.code, tt.lastStep, now); ok != tt.want {
+				t.Errorf("verifyTOTP = %v, ожидалось %v", ok, tt.want)
+			}
+		})
+	}
+	if _, ok := verifyTOTP("не base32", "123456", 0, now); ok {
+		t.Error("принят код для неверного секрета")
+	}
+}
+
+func TestCheckSecondFactor(t *testing.T) {
+	tests := []struct {
+		name     string
+		otp      func(t *testing.T) string
+		recovery string
+		enrolled bool
+		wantErr  error
+	}{
+		{"без кода", nil, "", true, errOTPRequired},
+		{"верный код", func(t *testing.T) string { return testTOTP(t, time.Now(), 0) }, "", true, nil},
+		{"неверный код", func(t *testing.T) string { return "12345" }, "", true, errOTPInvalid},
+		{"код восстановления", nil, "abcde-12345", true, nil},
+		{"код восстановления в другом виде", nil, "ABCDE12345", true, nil},
+		{"неизвестный код восстановления", nil, "zzzzz-00000", true, errOTPInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			twoFactors["root"] = &twoFactor{
+				Secret:        testTOTPSecret,
+				Confirmed:     true,
+				RecoveryCodes: []string{hashRecoveryCode("abcde-12345")},
+			}
+			otp := ""
+			if tt.otp != nil {
+				otp = tt.otp(t)
+			}
+			enrolled, err := checkSecondFactor("root", otp, tt.recovery)
+			if enrolled != tt.enrolled || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkSecondFactor = %v, %v; ожидалось %v, %v", enrolled, err, tt.enrolled, tt.wantErr)
+			}
+			if tt.recovery != "" && err == nil && len(twoFactors["root"].RecoveryCodes) != 0 {
+				t.Error("код восстановления можно использовать повторно")
+			}
+		})
+	}
+
+	t.Run("не настроена", func(t *testing.T) {
+		setupTest(t)
+		twoFactors["root"] = &twoFactor{Secret: testTOTPSecret}
+		if enrolled, err := checkSecondFactor("root", "", ""); enrolled || err != nil {
+			t.Errorf("checkSecondFactor = %v, %v", enrolled, err)
+		}
+	})
+}
+
+func TestCheckSecondFactorLockout(t *testing.T) {
+	setupTest(t)
+	twoFactors["root"] = &twoFactor{
+		Secret:        testTOTPSecret,
+		Confirmed:     true,
+		RecoveryCodes: []string{hashRecoveryCode("abcde-12345")},
+	}
+
+	for i := 1; i < maxOTPFailures; i++ {
+		if _, err := checkSecondFactor("root", "12345", ""); !errors.Is(err, errOTPInvalid) {
+			t.Fatalf("попытка %d: %v", i, err)
+		}
+	}
+	// Верный код сбрасывает счетчик.
+	if _, err := checkSecondFactor("root", testTOTP(t, time.Now(), 0), ""); err != nil {
+		t.Fatalf("верный код: %v", err)
+	}
+	if got := twoFactors["root"].Failures; got != 0 {
+		t.Fatalf("после верного кода неверных попыток %d", got)
+	}
+
+	for i := 1; i <= maxOTPFailures; i++ {
+		if _, err := checkSecondFactor("root", "12345", ""); !errors.Is(err, errOTPInvalid) {
+			t.Fatalf("попытка %d: %v", i, err)
+		}
+	}
+	// Во время блокировки не принимаются и верные коды.
+	var locked otpLockedError
+	_, err := checkSecondFactor("root", "", "abcde-12345")
+	if !errors.As(err, &locked) {
+		t.Fatalf("после %d неверных кодов: %v", maxOTPFailures, err)
+	}
+	if d := time.Until(locked.Until); d <= otpLockout-time.Minute || d > otpLockout {
+		t.Errorf("блокировка на %v, ожидалось %v", d, otpLockout)
+	}
+	if len(twoFactors["root"].RecoveryCodes) != 1 {
+		t.Error("код восстановления израсходован во время блокировки")
+	}
+
+	// Блокировка сохраняется в файл и переживает перезапуск.
+	twoFactors = make(map[string]*twoFactor)
+	if err := loadTwoFactor(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkSecondFactor("root", "", "abcde-12345"); !errors.As(err, &locked) {
+		t.Errorf("после перезапуска: %v", err)
+	}
+
+	// По истечении блокировки вход снова возможен.
+	past := time.Now().Add(-time.Second)
+	twoFactors["root"].LockedUntil = &past
+	if _, err := checkSecondFactor("root", "", "abcde-12345"); err != nil {
+		t.Errorf("после блокировки: %v", err)
+	}
+}
+
+// TestTwoFactorDisableLockout проверяет, что код для отключения 2FA нельзя
+// подбирать: неверные коды блокируют проверку, как при входе.
+func TestTwoFactorDisableLockout(t *testing.T) {
+	setupTest(t)
+	config.Auth.Users = []User{{Username: "root", Password: "secret", Role: RoleEditor}}
+	twoFactors["root"] = &twoFactor{Secret: testTOTPSecret, Confirmed: true}
+	h := requireEnrollment(twoFactorDisableHandler)
+	token := testToken(t, "root", RoleEditor, "")
+
+	for i := 1; i <= maxOTPFailures; i++ {
+		if w := testRequest(h, http.MethodPost, "/auth/2fa/disable", token, `{"code":"12345"}`, nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("попытка %d: статус %d: %s", i, w.Code, w.Body)
+		}
+	}
+	w := testRequest(h, http.MethodPost, "/auth/2fa/disable", token, `{"code":"`+testTOTP(t, time.Now(), 0)+`"}`, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("верный код во время блокировки: статус %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if tf, ok := twoFactors["root"]; !ok || tf.LockedUntil == nil {
+		t.Error("2FA отключена или блокировка не записана")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webhookEventRestored — клиент возвращен из архива. Внутри сервера это
+// client.created с источником sourceRestore, но вебхукам он приходит
+// отдельным событием, чтобы его не приняли за регистрацию.
+const webhookEventRestored = "client.restored"
+
+// webhookEvents — события клиентов, на которые подписываются вебхуки.
+var webhookEvents = []string{eventClientCreated, eventClientUpdated, eventClientDeleted, webhookEventRestored}
+
+// WebhookConfig задает доставку вебхуков. Неудачная попытка повторяется
+// через InitialBackoff, затем интервал удваивается до MaxBackoff.
+type WebhookConfig struct {
+	MaxAttempts    int      `json:"maxAttempts"`
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+	Timeout        Duration `json:"timeout"` // на одну попытку
+}
+
+// Webhook — адрес, куда отправляются события клиентов.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"` // пустой список — все события
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	LastDelivery *webhookDelivery `json:"lastDelivery,omitempty"`
+}
+
+// webhookDelivery — итог последней доставки.
+type webhookDelivery struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"` // код ответа последней попытки
+	Error    string    `json:"error,omitempty"`
+	OK       bool      `json:"ok"`
+	At       time.Time `json:"at"`
+}
+
+// webhookPayload — тело запроса вебхука.
+type webhookPayload struct {
+	ID     string    `json:"id"` // одинаков во всех попытках, для дедупликации
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Client Client    `json:"client"`
+}
+
+var (
+	webhooks   = make(map[string]*Webhook) // Вебхуки по ID
+	webhooksMu sync.Mutex                  // Мьютекс для защиты вебхуков
+)
+
+func webhooksPath() string {
+	return filepath.Join(config.DataDir, "webhooks.json")
+}
+
+// loadWebhooks читает зарегистрированные вебхуки.
+func loadWebhooks() error {
+	data, err := os.ReadFile(webhooksPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return fmt.Errorf("разбор %s: %w", webhooksPath(), err)
+	}
+	return nil
+}
+
+func saveWebhooksLocked() error {
+	return writeJSONFile(webhooksPath(), webhooks)
+}
+
+// webhookSignature подписывает тело: HMAC-SHA256 от "<timestamp>.<body>".
+// Отметка времени входит в подпись, чтобы перехваченный запрос нельзя было
+// повторить позже.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhooksOnClientEvent ставит событие в доставку всем подписанным
+// вебхукам. Не блокирует: каждая доставка идет в своей горутине.
+func webhooksOnClientEvent(e clientEvent) {
+	if e.Type == eventClientCreated && e.Source == sourceRestore {
+		e.Type = webhookEventRestored
+	}
+	if !slices.Contains(webhookEvents, e.Type) {
+		return
+	}
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	for _, h := range webhooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, e.Type) {
+			continue
+		}
+		p := webhookPayload{ID: randomHex(8), Event: e.Type, Time: e.At, Client: e.Client}
+		if err := enqueueTask(webhookTask.Name, webhookTaskPayload{Hook: h.ID, Payload: p}); err != nil {
+			logf("Вебхук %s: событие %s не поставлено в очередь: %v", h.ID, p.Event, err)
+		}
+	}
+}
+
+// webhookTaskPayload — задача доставки события одному вебхуку.
+type webhookTaskPayload struct {
+	Hook    string         `json:"hook"`
+	Payload webhookPayload `json:"payload"`
+}
+
+// webhookTask доставляет событие; повторы — по настройкам webhooks.
+var webhookTask = &taskKind{
+	Name:   "webhook",
+	Handle: deliverWebhook,
+	Retry: func() retryPolicy {
+		cfg := liveConfig().Webhooks
+		return retryPolicy{
+			MaxAttempts:    cfg.MaxAttempts,
+			InitialBackoff: time.Duration(cfg.InitialBackoff),
+			MaxBackoff:     time.Duration(cfg.MaxBackoff),
+		}
+	},
+	Client: func(payload json.RawMessage) int {
+		var tp webhookTaskPayload
+		json.Unmarshal(payload, &tp)
+		return tp.Payload.Client.ID
+	},
+}
+
+// deliverWebhook делает одну попытку доставки и записывает ее итог.
+// Событие для удаленного вебхука пропускается.
+func deliverWebhook(ctx context.Context, t *queueTask) error {
+	var tp webhookTaskPayload
+	if err := json.Unmarshal(t.Payload, &tp); err != nil {
+		return permanentError{err}
+	}
+	webhooksMu.Lock()
+	h, ok := webhooks[tp.Hook]
+	var hook Webhook
+	if ok {
+		hook = *h
+	}
+	webhooksMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(tp.Payload)
+	if err != nil {
+		return permanentError{err}
+	}
+	d := webhookDelivery{ID: tp.Payload.ID, Event: tp.Payload.Event, Attempts: t.Attempts}
+	d.Status, err = postWebhook(ctx, hook, tp.Payload, body)
+	d.OK = err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+	recordWebhookDelivery(hook.ID, d)
+	return err
+}
+
+// webhookClient отправляет вебхуки. Получатель отбрасывает дубли по
+// X-Webhook-ID, поэтому POST можно повторять.
+var webhookClient = newOutboundClient("webhooks", outboundOptions{RetryPost: true})
+
+func postWebhook(ctx context.Context, h Webhook, p webhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", p.ID)
+	req.Header.Set("X-Webhook-Event", p.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", webhookSignature(h.Secret, timestamp, body))
+
+	resp, err := webhookClient.do(req, time.Duration(liveConfig().Webhooks.Timeout))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("ответ %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func recordWebhookDelivery(id string, d webhookDelivery) {
+	d.At = time.Now()
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	if h, ok := webhooks[id]; ok {
+		h.LastDelivery = &d
+	}
+}
+
+// webhooksHandler управляет вебхуками: GET — список, POST — регистрация,
+// DELETE ?id= — удаление. Секрет подписи показывается только при создании.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		list := make([]Webhook, 0, len(webhooks))
+		for _, h := range webhooks {
+			c := *h
+			c.Secret = ""
+			list = append(list, c)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var in struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Secret string   `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Ошибка парсинга тела запроса", http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(in.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "url: ожидается адрес http или https", http.StatusBadRequest)
+			return
+		}
+		for _, e := range in.Events {
+			if !slices.Contains(webhookEvents, e) {
+				http.Error(w, fmt.Sprintf("Неизвестное событие %q", e), http.StatusBadRequest)
+				return
+			}
+		}
+		if in.Secret == "" {
+			in.Secret = randomHex(24)
+		}
+
+		h := &Webhook{ID: randomHex(6), URL: u.String(), Events: in.Events, Secret: in.Secret, CreatedAt: time.Now()}
+		webhooks[h.ID] = h
+		if err := saveWebhooksLocked(); err != nil {
+			delete(webhooks, h.ID)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if _, ok := webhooks[id]; !ok {
+			http.Error(w, "Вебхук не найден", http.StatusNotFound)
+			return
+		}
+		delete(webhooks, id)
+		if err := saveWebhooksLocked(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Неверный метод запроса", http.StatusMethodNotAllowed)
+	}
+}
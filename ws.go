@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Минимальная серверная реализация WebSocket (RFC 6455): только то, что
+// нужно живому списку клиентов — текстовые кадры от сервера и служебные
+// кадры в обе стороны.
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPingInterval  = 30 * time.Second
+	wsMaxFrame      = 64 << 10 // сообщения от клиента сервер не ждет, только служебные кадры
+	wsWriteDeadline = 10 * time.Second
+)
+
+// Коды операций кадров.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Коды закрытия.
+const (
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseProtocol  = 1002
+	wsCloseTooBig    = 1009
+	wsCloseNoPong    = 1011 // клиент перестал отвечать на ping
+)
+
+var errWSClosed = errors.New("соединение WebSocket закрыто")
+
+// wsConnsWG ждет, пока открытые соединения отправят кадр закрытия:
+// srv.Shutdown не знает о соединениях, забранных через Hijack.
+var wsConnsWG sync.WaitGroup
+
+// wsConn — установленное соединение.
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	writeMu sync.Mutex // кадры пишут и цикл рассылки, и читатель (pong, close)
+}
+
+// writeFrame отправляет кадр без маски, как положено серверу.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteDeadline))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsOpClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame читает один кадр клиента и снимает маску.
+func (c *wsConn) readFrame() (op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return op, nil, errWSUnmasked
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		return op, nil, errWSFrameTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
+
+var (
+	errWSFrameTooBig = errors.New("слишком большой кадр WebSocket")
+	errWSUnmasked    = errors.New("кадр клиента без маски")
+)
+
+// wsOriginAllowed разрешает подключения со своей страницы и с источников
+// из настроек CORS. Без проверки чужой сайт мог бы открыть соединение от
+// имени пользователя.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	return liveConfig().CORS.originAllowed(origin)
+}
+
+// wsUpgrade выполняет рукопожатие и забирает соединение у HTTP-сервера.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerHasToken(r.Header.Get("Connection"), "upgrade") {
+		http.Error(w, "Ожидается запрос WebSocket", http.StatusBadRequest)
+		return nil, errWSClosed
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Поддерживается только WebSocket версии 13", http.StatusUpgradeRequired)
+		return nil, errWSClosed
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Нет заголовка Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errWSClosed
+	}
+	if !wsOriginAllowed(r) {
+		http.Error(w, "Источник не разрешен", http.StatusForbidden)
+		return nil, errWSClosed
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket не поддерживается", http.StatusInternalServerError)
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+func headerHasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsSnapshot — сообщение с текущим списком клиентов.
+type wsSnapshot struct {
+	Type        string   `json:"type"` // snapshot
+	LastEventID uint64   `json:"lastEventId"`
+	Clients     []Client `json:"clients"`
+}
+
+// snapshotAndListen снимает список клиентов кофейни и подписывается на
+// события атомарно: ни одно изменение не потеряется и не придет дважды.
+func snapshotAndListen(tenant string) (wsSnapshot, chan struct{}) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	list := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		if (clientFilter{Tenant: tenant}).match(c) {
+			list = append(list, c)
+		}
+	}
+	wake, last := listenStream()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return wsSnapshot{Type: "snapshot", LastEventID: last, Clients: list}, wake
+}
+
+// liveClientsHandler отдает живой список клиентов по WebSocket: /ws.
+// Сразу после подключения приходит snapshot, затем каждое изменение
+// сообщением {"id", "type", "time", "client"}. Если клиент отстал больше,
+// чем хранит буфер событий, снова приходит snapshot. Сервер шлет ping
+// каждые 30 секунд и закрывает соединение кодом 1001 при остановке (ctx).
+func liveClientsHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := wsUpgrade(w, r)
+		if err != nil {
+			return
+		}
+		wsConnsWG.Add(1)
+		defer wsConnsWG.Done()
+		defer c.conn.Close()
+
+		snap, wake := snapshotAndListen(requestTenant(r))
+		defer func() { unlistenStream(wake) }()
+		if err := c.writeJSON(snap); err != nil {
+			return
+		}
+		after := snap.LastEventID
+
+		// Читатель отвечает на ping, замечает pong и close.
+		done := make(chan struct{})
+		pongs := make(chan struct{}, 1)
+		go func() {
+			defer close(done)
+			for {
+				op, payload, err := c.readFrame()
+				switch {
+				case errors.Is(err, errWSFrameTooBig):
+					c.close(wsCloseTooBig, "")
+					return
+				case errors.Is(err, errWSUnmasked):
+					c.close(wsCloseProtocol, "")
+					return
+				case err != nil:
+					return
+				}
+				switch op {
+				case wsOpPing:
+					c.writeFrame(wsOpPong, payload)
+				case wsOpPong:
+					select {
+					case pongs <- struct{}{}:
+					default:
+					}
+				case wsOpClose:
+					c.close(wsCloseNormal, "")
+					return
+				case wsOpText, wsOpBinary, wsOpContinuation:
+					// Сообщения клиента не предусмотрены и пропускаются.
+				default:
+					c.close(wsCloseProtocol, "")
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		awaitingPong := false
+		for {
+			select {
+			case <-ctx.Done():
+				c.close(wsCloseGoingAway, "сервер останавливается")
+				return
+			case <-done:
+				return
+			case <-pongs:
+				awaitingPong = false
+			case <-ping.C:
+				if awaitingPong {
+					c.close(wsCloseNoPong, "нет ответа на ping")
+					return
+				}
+				awaitingPong = true
+				if err := c.writeFrame(wsOpPing, nil); err != nil {
+					return
+				}
+			case <-wake:
+				events, last, reset := streamSince(requestTenant(r), after)
+				if reset {
+					unlistenStream(wake)
+					snap, wake = snapshotAndListen(requestTenant(r))
+					if err := c.writeJSON(snap); err != nil {
+						return
+					}
+					after = snap.LastEventID
+					continue
+				}
+				for _, e := range events {
+					msg := fmt.Sprintf(`{"id":%d,%s`, e.ID, e.Data[1:])
+					if err := c.writeFrame(wsOpText, []byte(msg)); err != nil {
+						return
+					}
+				}
+				after = last
+			}
+		}
+	}
+}